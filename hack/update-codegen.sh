@@ -62,6 +62,7 @@ function set_tag_pkgs() {
   gv_dirs=()
   gv_dirs+=("coreres/v1")
   gv_dirs+=("rbac/v1")
+  gv_dirs+=("config/v1alpha1")
 
   tag_pkgs=()
   for pkg in "${gv_dirs[@]}"; do
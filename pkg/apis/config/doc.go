@@ -0,0 +1,21 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+// +k8s:deepcopy-gen=package
+
+// Package config is the internal version of the configuration file of apimaster.
+// +groupName=config.apimaster.io
+package config // import "github.com/seanchann/apimaster/pkg/apis/config"
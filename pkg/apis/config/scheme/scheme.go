@@ -0,0 +1,45 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package scheme
+
+import (
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+
+	"github.com/seanchann/apimaster/pkg/apis/config"
+	"github.com/seanchann/apimaster/pkg/apis/config/v1alpha1"
+)
+
+// Scheme is the runtime.Scheme of the configuration file of apimaster, it is
+// not part of the served API and has its own scheme.
+var Scheme = runtime.NewScheme()
+
+// Codecs provides access to encoding and decoding for the scheme, unknown and
+// duplicate fields of a configuration file are errors.
+var Codecs = serializer.NewCodecFactory(Scheme, serializer.EnableStrict)
+
+func init() {
+	AddToScheme(Scheme)
+}
+
+// AddToScheme builds the scheme using all known versions of the config API.
+func AddToScheme(scheme *runtime.Scheme) {
+	utilruntime.Must(config.AddToScheme(scheme))
+	utilruntime.Must(v1alpha1.AddToScheme(scheme))
+	utilruntime.Must(scheme.SetVersionPriority(v1alpha1.SchemeGroupVersion))
+}
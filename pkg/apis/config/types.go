@@ -0,0 +1,210 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package config
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// APIMasterConfiguration is the configuration of apimaster, the flags of the same
+// settings override it.
+type APIMasterConfiguration struct {
+	metav1.TypeMeta
+
+	Serving        ServingConfiguration
+	Storage        StorageConfiguration
+	Authentication AuthenticationConfiguration
+	Authorization  AuthorizationConfiguration
+	Admission      AdmissionConfiguration
+}
+
+// ServingConfiguration configures the secure and the insecure port.
+type ServingConfiguration struct {
+	// BindAddress is the address of the secure port, --bind-address.
+	BindAddress string
+	// SecurePort is --secure-port.
+	SecurePort int32
+	// CertDirectory is --cert-dir.
+	CertDirectory string
+	// TLSCertFile is --tls-cert-file.
+	TLSCertFile string
+	// TLSPrivateKeyFile is --tls-private-key-file.
+	TLSPrivateKeyFile string
+	// InsecurePort is --insecure-port, 0 disables it.
+	InsecurePort int32
+	// MaxRequestsInFlight is --max-requests-inflight.
+	MaxRequestsInFlight int32
+	// MaxMutatingRequestsInFlight is --max-mutating-requests-inflight.
+	MaxMutatingRequestsInFlight int32
+	// RequestTimeout is --request-timeout.
+	RequestTimeout metav1.Duration
+}
+
+// StorageConfiguration configures the storage backends.
+type StorageConfiguration struct {
+	// Backend is --storage-backend, empty keeps the backend the server is built with.
+	Backend string
+	// BackendOverrides is --storage-backend-overrides.
+	BackendOverrides []string
+	// ResourceStorageVersions is --resource-storage-versions.
+	ResourceStorageVersions []string
+	// WatchCache is --watch-cache.
+	WatchCache bool
+	// DefaultWatchCacheSize is --default-watch-cache-size.
+	DefaultWatchCacheSize int32
+	// WatchCacheSizes is --watch-cache-sizes.
+	WatchCacheSizes []string
+	// EncryptionProviderConfig is --encryption-provider-config.
+	EncryptionProviderConfig string
+	// EnableGarbageCollector is --enable-garbage-collector.
+	EnableGarbageCollector bool
+
+	Etcd     EtcdConfiguration
+	Mysql    MysqlConfiguration
+	Sqlite   SqliteConfiguration
+	Postgres PostgresConfiguration
+	MongoDB  MongoDBConfiguration
+	DynamoDB DynamoDBConfiguration
+}
+
+// EtcdConfiguration configures the etcd backend.
+type EtcdConfiguration struct {
+	// Servers is --etcd-servers.
+	Servers []string
+	// Prefix is --etcd-prefix.
+	Prefix string
+	// CAFile is --etcd-cafile.
+	CAFile string
+	// CertFile is --etcd-certfile.
+	CertFile string
+	// KeyFile is --etcd-keyfile.
+	KeyFile string
+}
+
+// MysqlConfiguration configures the mysql backend.
+type MysqlConfiguration struct {
+	// Servers is --mysql-servers.
+	Servers []string
+	// Store is --mysql-store.
+	Store string
+	// CredentialsFile is --mysql-credentials-file.
+	CredentialsFile string
+	// Replicas is --mysql-replicas.
+	Replicas []string
+	// CAFile is --mysql-ca-file.
+	CAFile string
+	// CertFile is --mysql-cert-file.
+	CertFile string
+	// KeyFile is --mysql-key-file.
+	KeyFile string
+}
+
+// SqliteConfiguration configures the sqlite backend.
+type SqliteConfiguration struct {
+	// DSN is --sqlite-dsn.
+	DSN string
+}
+
+// PostgresConfiguration configures the postgres backend.
+type PostgresConfiguration struct {
+	// Servers is --postgres-servers.
+	Servers []string
+	// SSLMode is --postgres-sslmode.
+	SSLMode string
+	// CAFile is --postgres-ca-file.
+	CAFile string
+	// CertFile is --postgres-cert-file.
+	CertFile string
+	// KeyFile is --postgres-key-file.
+	KeyFile string
+}
+
+// MongoDBConfiguration configures the mongodb backend, the credentials are only set by flags.
+type MongoDBConfiguration struct {
+	// Servers is --mongo-servers.
+	Servers []string
+}
+
+// DynamoDBConfiguration configures the dynamodb backend, the credentials are only set by flags.
+type DynamoDBConfiguration struct {
+	// Region is --aws-region.
+	Region string
+	// Table is --aws-table.
+	Table string
+}
+
+// AuthenticationConfiguration configures the authentication.
+type AuthenticationConfiguration struct {
+	// ConfigFile is --authentication-config.
+	ConfigFile string
+	// TokenAuthFile is --token-auth-file.
+	TokenAuthFile string
+	// ClientCAFile is --client-ca-file.
+	ClientCAFile string
+	Webhook      AuthenticationWebhookConfiguration
+}
+
+// AuthenticationWebhookConfiguration configures the token authentication webhook.
+type AuthenticationWebhookConfiguration struct {
+	// Host is --authentication-token-webhook-host.
+	Host string
+	// APIPath is --authentication-token-webhook-apipath.
+	APIPath string
+	// Version is --authentication-token-webhook-version.
+	Version string
+	// CacheTTL is --authentication-token-webhook-cache-ttl.
+	CacheTTL metav1.Duration
+}
+
+// AuthorizationConfiguration configures the authorization.
+type AuthorizationConfiguration struct {
+	// Modes is --authorization-mode.
+	Modes []string
+	// PolicyFile is --authorization-policy-file.
+	PolicyFile string
+	// ConfigFile is --authorization-config.
+	ConfigFile string
+	Webhook    AuthorizationWebhookConfiguration
+}
+
+// AuthorizationWebhookConfiguration configures the authorization webhook.
+type AuthorizationWebhookConfiguration struct {
+	// ConfigFile is --authorization-webhook-config-file.
+	ConfigFile string
+	// Host is --authorization-webhook-host.
+	Host string
+	// APIPath is --authorization-webhook-apipath.
+	APIPath string
+	// Version is --authorization-webhook-version.
+	Version string
+	// CacheAuthorizedTTL is --authorization-webhook-cache-authorized-ttl.
+	CacheAuthorizedTTL metav1.Duration
+	// CacheUnauthorizedTTL is --authorization-webhook-cache-unauthorized-ttl.
+	CacheUnauthorizedTTL metav1.Duration
+}
+
+// AdmissionConfiguration configures the admission plugins.
+type AdmissionConfiguration struct {
+	// EnablePlugins is --enable-admission-plugins.
+	EnablePlugins []string
+	// DisablePlugins is --disable-admission-plugins.
+	DisablePlugins []string
+	// ConfigFile is --admission-control-config-file.
+	ConfigFile string
+}
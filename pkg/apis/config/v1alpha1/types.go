@@ -0,0 +1,210 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// +k8s:deepcopy-gen:interfaces=k8s.io/apimachinery/pkg/runtime.Object
+
+// APIMasterConfiguration is the configuration of apimaster, the flags of the same
+// settings override it.
+type APIMasterConfiguration struct {
+	metav1.TypeMeta `json:",inline"`
+
+	Serving        ServingConfiguration        `json:"serving"`
+	Storage        StorageConfiguration        `json:"storage"`
+	Authentication AuthenticationConfiguration `json:"authentication"`
+	Authorization  AuthorizationConfiguration  `json:"authorization"`
+	Admission      AdmissionConfiguration      `json:"admission"`
+}
+
+// ServingConfiguration configures the secure and the insecure port.
+type ServingConfiguration struct {
+	// BindAddress is the address of the secure port, --bind-address.
+	BindAddress string `json:"bindAddress,omitempty"`
+	// SecurePort is --secure-port.
+	SecurePort int32 `json:"securePort,omitempty"`
+	// CertDirectory is --cert-dir.
+	CertDirectory string `json:"certDirectory,omitempty"`
+	// TLSCertFile is --tls-cert-file.
+	TLSCertFile string `json:"tlsCertFile,omitempty"`
+	// TLSPrivateKeyFile is --tls-private-key-file.
+	TLSPrivateKeyFile string `json:"tlsPrivateKeyFile,omitempty"`
+	// InsecurePort is --insecure-port, 0 disables it.
+	InsecurePort *int32 `json:"insecurePort,omitempty"`
+	// MaxRequestsInFlight is --max-requests-inflight.
+	MaxRequestsInFlight *int32 `json:"maxRequestsInFlight,omitempty"`
+	// MaxMutatingRequestsInFlight is --max-mutating-requests-inflight.
+	MaxMutatingRequestsInFlight *int32 `json:"maxMutatingRequestsInFlight,omitempty"`
+	// RequestTimeout is --request-timeout.
+	RequestTimeout metav1.Duration `json:"requestTimeout,omitempty"`
+}
+
+// StorageConfiguration configures the storage backends.
+type StorageConfiguration struct {
+	// Backend is --storage-backend, empty keeps the backend the server is built with.
+	Backend string `json:"backend,omitempty"`
+	// BackendOverrides is --storage-backend-overrides.
+	BackendOverrides []string `json:"backendOverrides,omitempty"`
+	// ResourceStorageVersions is --resource-storage-versions.
+	ResourceStorageVersions []string `json:"resourceStorageVersions,omitempty"`
+	// WatchCache is --watch-cache.
+	WatchCache *bool `json:"watchCache,omitempty"`
+	// DefaultWatchCacheSize is --default-watch-cache-size.
+	DefaultWatchCacheSize *int32 `json:"defaultWatchCacheSize,omitempty"`
+	// WatchCacheSizes is --watch-cache-sizes.
+	WatchCacheSizes []string `json:"watchCacheSizes,omitempty"`
+	// EncryptionProviderConfig is --encryption-provider-config.
+	EncryptionProviderConfig string `json:"encryptionProviderConfig,omitempty"`
+	// EnableGarbageCollector is --enable-garbage-collector.
+	EnableGarbageCollector *bool `json:"enableGarbageCollector,omitempty"`
+
+	Etcd     EtcdConfiguration     `json:"etcd"`
+	Mysql    MysqlConfiguration    `json:"mysql"`
+	Sqlite   SqliteConfiguration   `json:"sqlite"`
+	Postgres PostgresConfiguration `json:"postgres"`
+	MongoDB  MongoDBConfiguration  `json:"mongodb"`
+	DynamoDB DynamoDBConfiguration `json:"dynamodb"`
+}
+
+// EtcdConfiguration configures the etcd backend.
+type EtcdConfiguration struct {
+	// Servers is --etcd-servers.
+	Servers []string `json:"servers,omitempty"`
+	// Prefix is --etcd-prefix.
+	Prefix string `json:"prefix,omitempty"`
+	// CAFile is --etcd-cafile.
+	CAFile string `json:"caFile,omitempty"`
+	// CertFile is --etcd-certfile.
+	CertFile string `json:"certFile,omitempty"`
+	// KeyFile is --etcd-keyfile.
+	KeyFile string `json:"keyFile,omitempty"`
+}
+
+// MysqlConfiguration configures the mysql backend.
+type MysqlConfiguration struct {
+	// Servers is --mysql-servers.
+	Servers []string `json:"servers,omitempty"`
+	// Store is --mysql-store.
+	Store string `json:"store,omitempty"`
+	// CredentialsFile is --mysql-credentials-file.
+	CredentialsFile string `json:"credentialsFile,omitempty"`
+	// Replicas is --mysql-replicas.
+	Replicas []string `json:"replicas,omitempty"`
+	// CAFile is --mysql-ca-file.
+	CAFile string `json:"caFile,omitempty"`
+	// CertFile is --mysql-cert-file.
+	CertFile string `json:"certFile,omitempty"`
+	// KeyFile is --mysql-key-file.
+	KeyFile string `json:"keyFile,omitempty"`
+}
+
+// SqliteConfiguration configures the sqlite backend.
+type SqliteConfiguration struct {
+	// DSN is --sqlite-dsn.
+	DSN string `json:"dsn,omitempty"`
+}
+
+// PostgresConfiguration configures the postgres backend.
+type PostgresConfiguration struct {
+	// Servers is --postgres-servers.
+	Servers []string `json:"servers,omitempty"`
+	// SSLMode is --postgres-sslmode.
+	SSLMode string `json:"sslMode,omitempty"`
+	// CAFile is --postgres-ca-file.
+	CAFile string `json:"caFile,omitempty"`
+	// CertFile is --postgres-cert-file.
+	CertFile string `json:"certFile,omitempty"`
+	// KeyFile is --postgres-key-file.
+	KeyFile string `json:"keyFile,omitempty"`
+}
+
+// MongoDBConfiguration configures the mongodb backend, the credentials are only set by flags.
+type MongoDBConfiguration struct {
+	// Servers is --mongo-servers.
+	Servers []string `json:"servers,omitempty"`
+}
+
+// DynamoDBConfiguration configures the dynamodb backend, the credentials are only set by flags.
+type DynamoDBConfiguration struct {
+	// Region is --aws-region.
+	Region string `json:"region,omitempty"`
+	// Table is --aws-table.
+	Table string `json:"table,omitempty"`
+}
+
+// AuthenticationConfiguration configures the authentication.
+type AuthenticationConfiguration struct {
+	// ConfigFile is --authentication-config.
+	ConfigFile string `json:"configFile,omitempty"`
+	// TokenAuthFile is --token-auth-file.
+	TokenAuthFile string `json:"tokenAuthFile,omitempty"`
+	// ClientCAFile is --client-ca-file.
+	ClientCAFile string                             `json:"clientCAFile,omitempty"`
+	Webhook      AuthenticationWebhookConfiguration `json:"webhook"`
+}
+
+// AuthenticationWebhookConfiguration configures the token authentication webhook.
+type AuthenticationWebhookConfiguration struct {
+	// Host is --authentication-token-webhook-host.
+	Host string `json:"host,omitempty"`
+	// APIPath is --authentication-token-webhook-apipath.
+	APIPath string `json:"apiPath,omitempty"`
+	// Version is --authentication-token-webhook-version.
+	Version string `json:"version,omitempty"`
+	// CacheTTL is --authentication-token-webhook-cache-ttl.
+	CacheTTL metav1.Duration `json:"cacheTTL,omitempty"`
+}
+
+// AuthorizationConfiguration configures the authorization.
+type AuthorizationConfiguration struct {
+	// Modes is --authorization-mode, AlwaysAllow when neither Modes nor ConfigFile are set.
+	Modes []string `json:"modes,omitempty"`
+	// PolicyFile is --authorization-policy-file.
+	PolicyFile string `json:"policyFile,omitempty"`
+	// ConfigFile is --authorization-config.
+	ConfigFile string                            `json:"configFile,omitempty"`
+	Webhook    AuthorizationWebhookConfiguration `json:"webhook"`
+}
+
+// AuthorizationWebhookConfiguration configures the authorization webhook.
+type AuthorizationWebhookConfiguration struct {
+	// ConfigFile is --authorization-webhook-config-file.
+	ConfigFile string `json:"configFile,omitempty"`
+	// Host is --authorization-webhook-host.
+	Host string `json:"host,omitempty"`
+	// APIPath is --authorization-webhook-apipath.
+	APIPath string `json:"apiPath,omitempty"`
+	// Version is --authorization-webhook-version.
+	Version string `json:"version,omitempty"`
+	// CacheAuthorizedTTL is --authorization-webhook-cache-authorized-ttl.
+	CacheAuthorizedTTL metav1.Duration `json:"cacheAuthorizedTTL,omitempty"`
+	// CacheUnauthorizedTTL is --authorization-webhook-cache-unauthorized-ttl.
+	CacheUnauthorizedTTL metav1.Duration `json:"cacheUnauthorizedTTL,omitempty"`
+}
+
+// AdmissionConfiguration configures the admission plugins.
+type AdmissionConfiguration struct {
+	// EnablePlugins is --enable-admission-plugins.
+	EnablePlugins []string `json:"enablePlugins,omitempty"`
+	// DisablePlugins is --disable-admission-plugins.
+	DisablePlugins []string `json:"disablePlugins,omitempty"`
+	// ConfigFile is --admission-control-config-file.
+	ConfigFile string `json:"configFile,omitempty"`
+}
@@ -29,9 +29,7 @@ import (
 	insecureserver "github.com/seanchann/apimaster/pkg/apiserver/server"
 
 	//k8s dependencies
-	oteltrace "go.opentelemetry.io/otel/trace"
 	"k8s.io/apimachinery/pkg/runtime"
-	"k8s.io/apimachinery/pkg/runtime/schema"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
 	utilnet "k8s.io/apimachinery/pkg/util/net"
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -62,6 +60,9 @@ func APIServerRun(opt *options.APIMasterOptions, apiProvider APIServerProvider,
 	if errs := completedOptions.Validate(); len(errs) != 0 {
 		return utilerrors.NewAggregate(errs)
 	}
+	if len(completedOptions.ConfigFile.WriteConfigTo) > 0 {
+		return completedOptions.WriteConfigFile()
+	}
 
 	if err := applyExternalConfig(completedOptions); err != nil {
 		return err
@@ -182,6 +183,18 @@ func CreateServerChain(completedOptions completedServerRunOptions, stopCh <-chan
 		}
 	}
 
+	var unixSocketServingInfo *insecureserver.UnixSocketServingInfo
+	if err := completedOptions.UnixSocketServing.ApplyTo(&unixSocketServingInfo); err != nil {
+		return nil, err
+	}
+	if unixSocketServingInfo != nil {
+		unixSocketHandlerChain := insecureserver.BuildUnixSocketHandlerChain(apiServer.GenericAPIServer.UnprotectedHandler(),
+			apiServerCfg.GenericConfig, unixSocketServingInfo.Authenticator)
+		if err := unixSocketServingInfo.Serve(unixSocketHandlerChain, apiServerCfg.GenericConfig.RequestTimeout, stopCh); err != nil {
+			return nil, err
+		}
+	}
+
 	return apiServer.GenericAPIServer, nil
 }
 
@@ -264,35 +277,26 @@ func BuildGenericConfig(s completedServerRunOptions,
 
 	genericConfig.Version = s.apiProvider.Version()
 
-	// only for etcd
-	if s.Backend == options.StorageBackendTypeEtcd {
-
-		if genericConfig.EgressSelector != nil {
-			s.Etcd.StorageConfig.Transport.EgressLookup = genericConfig.EgressSelector.Lookup
-		}
-		if utilfeature.DefaultFeatureGate.Enabled(genericfeatures.APIServerTracing) {
-			s.Etcd.StorageConfig.Transport.TracerProvider = genericConfig.TracerProvider
-		} else {
-			s.Etcd.StorageConfig.Transport.TracerProvider = oteltrace.NewNoopTracerProvider()
-		}
+	if lastErr = applyStorageTo(s.APIMasterOptions, genericConfig); lastErr != nil {
+		return
 	}
-	storageFactory, lastErr := BuildStorageFactory(s.APIMasterOptions, genericConfig.MergedResourceConfig)
-	if lastErr != nil {
+	// the watch cache is put in front of the instrumented storage, only storage requests are recorded
+	if lastErr = s.StorageMetrics.ApplyTo(genericConfig); lastErr != nil {
 		return
 	}
-	switch s.Backend {
-	case options.StorageBackendTypeSqlite:
-		if lastErr = s.Sqlite.ApplyWithStorageFactoryTo(storageFactory, genericConfig); lastErr != nil {
-			return
-		}
-	case options.StorageBackendTypeEtcd:
-		if lastErr = s.Etcd.ApplyWithStorageFactoryTo(storageFactory, genericConfig); lastErr != nil {
-			return
-		}
-	case options.StorageBackendTypeMysql:
-		if lastErr = s.Mysql.ApplyWithStorageFactoryTo(storageFactory, genericConfig); lastErr != nil {
-			return
-		}
+	if lastErr = s.WatchCache.ApplyTo(genericConfig); lastErr != nil {
+		return
+	}
+	if lastErr = s.GarbageCollector.ApplyTo(genericConfig); lastErr != nil {
+		return
+	}
+	if lastErr = s.StorageVersionMigration.ApplyTo(genericConfig); lastErr != nil {
+		return
+	}
+
+	// the authentication, authorization and admission config files register their reloads later
+	if lastErr = s.ConfigReload.ApplyTo(genericConfig); lastErr != nil {
+		return
 	}
 
 	klog.Infof("Successfully applied configuration authentication")
@@ -327,6 +331,7 @@ func BuildGenericConfig(s completedServerRunOptions,
 	}
 
 	config.ExtraConfig.ExtendRoutesFunc = s.apiProvider.DefaultInstallExtendRoutes
+	config.ExtraConfig.SupportsBatch = s.APIMasterOptions.SupportsBatch
 	config.ExtraConfig.ControllerConfig.NewFunc = s.apiProvider.NewControllerProvider
 	//append our private parameter for controller
 	config.ExtraConfig.ControllerConfig.NewParameters = append(config.ExtraConfig.ControllerConfig.NewParameters, versionClient)
@@ -345,6 +350,14 @@ type completedServerRunOptions struct {
 // Should be called after server flags parsed.
 func Complete(s *options.APIMasterOptions, provider APIServerProvider) (completedServerRunOptions, error) {
 	var options completedServerRunOptions
+	// the flags set on the command line override the settings of --config
+	if err := s.ApplyConfigFile(); err != nil {
+		return options, err
+	}
+	// the authorization mode defaults to AlwaysAllow, as documented by --authorization-mode
+	if errs := s.Authorization.Complete(); len(errs) != 0 {
+		return options, utilerrors.NewAggregate(errs)
+	}
 	options.APIMasterOptions = s
 	options.apiProvider = provider
 	return options, nil
@@ -377,6 +390,27 @@ func BuildAuthorizer(s *options.APIMasterOptions, egressSelector *egressselector
 	return authorizer, ruleResolver, false, nil
 }
 
+// applyStorageTo sets the rest options getter of c to the storage backend of s, with
+// the encryption at rest and the routing of single resources to other backends.
+func applyStorageTo(s *options.APIMasterOptions, c *genericapiserver.Config) error {
+	if completer, ok := s.Storage.(options.StorageConfigCompleter); ok {
+		if err := completer.CompleteStorageConfig(c); err != nil {
+			return err
+		}
+	}
+	if err := s.Encryption.ApplyTo(c); err != nil {
+		return err
+	}
+	storageFactory, err := BuildStorageFactory(s, c.MergedResourceConfig)
+	if err != nil {
+		return err
+	}
+	if err := s.Storage.ApplyWithStorageFactoryTo(storageFactory, c); err != nil {
+		return err
+	}
+	return s.StorageRouting.ApplyWithStorageFactoryTo(storageFactory, c)
+}
+
 // BuildStorageFactory constructs the storage factory. If encryption at rest is used, it expects
 // all supported KMS plugins to be registered in the KMS plugin registry before being called.
 func BuildStorageFactory(s *options.APIMasterOptions, apiResourceConfig *apiserverstorage.ResourceConfig) (*apiserverstorage.DefaultStorageFactory, error) {
@@ -385,51 +419,28 @@ func BuildStorageFactory(s *options.APIMasterOptions, apiResourceConfig *apiserv
 		return nil, fmt.Errorf("error generating storage version map: %s", err)
 	}
 
-	switch s.Backend {
-	case options.StorageBackendTypeSqlite:
-		storageFactory, err := NewStorageFactory(
-			s.Sqlite.StorageConfig, s.Sqlite.DefaultStorageMediaType, legacyscheme.Codecs,
-			apiserverstorage.NewDefaultResourceEncodingConfig(legacyscheme.Scheme), storageGroupsToEncodingVersion,
-			// The list includes resources that need to be stored in a different
-			// group version than other resources in the groups.
-			// FIXME (soltysh): this GroupVersionResource override should be configurable
-			[]schema.GroupVersionResource{},
-			apiResourceConfig)
-		if err != nil {
-			return nil, fmt.Errorf("error in initializing storage factory: %s", err)
-		}
-		return storageFactory, nil
-	case options.StorageBackendTypeEtcd:
-		storageFactory, err := NewStorageFactory(
-			s.Etcd.StorageConfig, s.Etcd.DefaultStorageMediaType, legacyscheme.Codecs,
-			apiserverstorage.NewDefaultResourceEncodingConfig(legacyscheme.Scheme), storageGroupsToEncodingVersion,
-			// The list includes resources that need to be stored in a different
-			// group version than other resources in the groups.
-			// FIXME (soltysh): this GroupVersionResource override should be configurable
-			[]schema.GroupVersionResource{},
-			apiResourceConfig)
-		if err != nil {
-			return nil, fmt.Errorf("error in initializing storage factory: %s", err)
-		}
+	if s.Storage == nil {
+		return nil, fmt.Errorf("not configure any storage backend")
+	}
 
-		return storageFactory, nil
-	case options.StorageBackendTypeMysql:
-		storageFactory, err := NewStorageFactory(
-			s.Mysql.StorageConfig, s.Mysql.DefaultStorageMediaType, legacyscheme.Codecs,
-			apiserverstorage.NewDefaultResourceEncodingConfig(legacyscheme.Scheme), storageGroupsToEncodingVersion,
-			// The list includes resources that need to be stored in a different
-			// group version than other resources in the groups.
-			// FIXME (soltysh): this GroupVersionResource override should be configurable
-			[]schema.GroupVersionResource{},
-			apiResourceConfig)
-		if err != nil {
-			return nil, fmt.Errorf("error in initializing storage factory: %s", err)
-		}
+	// The list includes resources that need to be stored in a different
+	// group version than other resources in the groups.
+	resourceEncodingOverrides, err := s.StorageSerialization.ResourceEncodingOverrides()
+	if err != nil {
+		return nil, fmt.Errorf("error generating resource storage version overrides: %s", err)
+	}
 
-		return storageFactory, nil
+	storageConfig, defaultMediaType := s.Storage.BackendConfig()
+	storageFactory, err := NewStorageFactory(
+		*storageConfig, defaultMediaType, legacyscheme.Codecs,
+		apiserverstorage.NewDefaultResourceEncodingConfig(legacyscheme.Scheme), storageGroupsToEncodingVersion,
+		resourceEncodingOverrides,
+		apiResourceConfig)
+	if err != nil {
+		return nil, fmt.Errorf("error in initializing storage factory: %s", err)
 	}
 
-	return nil, fmt.Errorf("not configure any storage backend")
+	return storageFactory, nil
 }
 
 func buildServiceResolver(enabledAggregatorRouting bool, hostname string, informer interface{}) webhook.ServiceResolver {
@@ -46,6 +46,8 @@ import (
 	// Initialize all known client auth plugins.
 	_ "k8s.io/client-go/plugin/pkg/client/auth"
 	"k8s.io/client-go/util/keyutil"
+
+	"github.com/seanchann/apimaster/pkg/apiserver/reload"
 )
 
 // Config contains the data on how to authenticate a request to the Kube API Server
@@ -86,6 +88,9 @@ type Config struct {
 
 	// Optional field, custom dial function used to connect to webhook
 	CustomDial utilnet.DialFunc
+
+	// Reloaders reloads TokenAuthFile when it changes, nil disables the reloads.
+	Reloaders *reload.Reloaders
 }
 
 // New returns an authenticator.Request or an error that supports the standard
@@ -121,6 +126,18 @@ func (config Config) New() (authenticator.Request, *spec.SecurityDefinitions, sp
 		if err != nil {
 			return nil, nil, nil, err
 		}
+		if config.Reloaders != nil {
+			dynamicTokenAuth := reload.NewToken(tokenAuth)
+			config.Reloaders.Add("token-auth-file", config.TokenAuthFile, func(path string) error {
+				tokenAuth, err := newAuthenticatorFromTokenFile(path)
+				if err != nil {
+					return err
+				}
+				dynamicTokenAuth.Set(tokenAuth)
+				return nil
+			})
+			tokenAuth = dynamicTokenAuth
+		}
 		tokenAuthenticators = append(tokenAuthenticators, authenticator.WrapAudienceAgnosticToken(config.APIAudiences, tokenAuth))
 	}
 	// if len(config.ServiceAccountKeyFiles) > 0 {
@@ -0,0 +1,96 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package peercred
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+)
+
+const (
+	// ExtraUID, ExtraGID and ExtraPID are the keys of the peer credentials in the user extra.
+	ExtraUID = "peercred.apimaster.io/uid"
+	ExtraGID = "peercred.apimaster.io/gid"
+	ExtraPID = "peercred.apimaster.io/pid"
+)
+
+// Authenticator authenticates the requests on a unix socket by the credentials of the
+// peer process, requests without credentials or with an unmapped uid and gid are not
+// authenticated.
+type Authenticator struct {
+	config atomic.Pointer[Config]
+}
+
+var _ authenticator.Request = &Authenticator{}
+
+// NewAuthenticator creates an Authenticator with config.
+func NewAuthenticator(config *Config) *Authenticator {
+	a := &Authenticator{}
+	a.Set(config)
+	return a
+}
+
+// Set swaps the config atomically.
+func (a *Authenticator) Set(config *Config) {
+	a.config.Store(config)
+}
+
+// AuthenticateRequest implements authenticator.Request.
+func (a *Authenticator) AuthenticateRequest(req *http.Request) (*authenticator.Response, bool, error) {
+	creds, ok := CredentialsFrom(req.Context())
+	if !ok {
+		return nil, false, nil
+	}
+
+	config := a.config.Load()
+	info := &user.DefaultInfo{
+		UID: strconv.FormatUint(uint64(creds.UID), 10),
+		Extra: map[string][]string{
+			ExtraUID: {strconv.FormatUint(uint64(creds.UID), 10)},
+			ExtraGID: {strconv.FormatUint(uint64(creds.GID), 10)},
+			ExtraPID: {strconv.FormatInt(int64(creds.PID), 10)},
+		},
+	}
+	for _, u := range config.Users {
+		if u.UID == creds.UID {
+			info.Name = u.Name
+			info.Groups = append(info.Groups, u.Groups...)
+			break
+		}
+	}
+	groupMapped := false
+	for _, g := range config.Groups {
+		if g.GID == creds.GID {
+			info.Groups = append(info.Groups, g.Groups...)
+			groupMapped = true
+			break
+		}
+	}
+
+	if len(info.Name) == 0 {
+		if !groupMapped {
+			return nil, false, fmt.Errorf("neither uid %d nor gid %d of the peer process are mapped", creds.UID, creds.GID)
+		}
+		info.Name = fmt.Sprintf("system:unix:%d", creds.UID)
+	}
+	return &authenticator.Response{User: info}, true, nil
+}
@@ -0,0 +1,178 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package peercred
+
+import (
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestAuthenticateRequest(t *testing.T) {
+	config := &Config{
+		Users: []UserMapping{
+			{UID: 1000, Name: "sidecar", Groups: []string{"sidecars"}},
+		},
+		Groups: []GroupMapping{
+			{GID: 2000, Groups: []string{"monitoring"}},
+		},
+	}
+
+	testCases := []struct {
+		name         string
+		creds        *Credentials
+		expectOK     bool
+		expectError  bool
+		expectName   string
+		expectGroups []string
+	}{
+		{
+			name: "no peer credentials",
+		},
+		{
+			name:         "mapped uid",
+			creds:        &Credentials{UID: 1000, GID: 1000, PID: 42},
+			expectOK:     true,
+			expectName:   "sidecar",
+			expectGroups: []string{"sidecars"},
+		},
+		{
+			name:         "mapped uid and gid",
+			creds:        &Credentials{UID: 1000, GID: 2000, PID: 42},
+			expectOK:     true,
+			expectName:   "sidecar",
+			expectGroups: []string{"sidecars", "monitoring"},
+		},
+		{
+			name:         "mapped gid",
+			creds:        &Credentials{UID: 1001, GID: 2000, PID: 42},
+			expectOK:     true,
+			expectName:   "system:unix:1001",
+			expectGroups: []string{"monitoring"},
+		},
+		{
+			name:        "unmapped uid and gid",
+			creds:       &Credentials{UID: 1001, GID: 1001, PID: 42},
+			expectError: true,
+		},
+	}
+
+	a := NewAuthenticator(config)
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodGet, "/", nil)
+			if testcase.creds != nil {
+				req = req.WithContext(WithCredentials(req.Context(), testcase.creds))
+			}
+
+			resp, ok, err := a.AuthenticateRequest(req)
+			if (err != nil) != testcase.expectError {
+				t.Fatalf("expected error %v, got %v", testcase.expectError, err)
+			}
+			if ok != testcase.expectOK {
+				t.Fatalf("expected ok %v, got %v", testcase.expectOK, ok)
+			}
+			if !ok {
+				return
+			}
+			if resp.User.GetName() != testcase.expectName {
+				t.Errorf("expected user %q, got %q", testcase.expectName, resp.User.GetName())
+			}
+			if !reflect.DeepEqual(resp.User.GetGroups(), testcase.expectGroups) {
+				t.Errorf("expected groups %v, got %v", testcase.expectGroups, resp.User.GetGroups())
+			}
+			if pid := resp.User.GetExtra()[ExtraPID]; !reflect.DeepEqual(pid, []string{"42"}) {
+				t.Errorf("expected pid 42 in the user extra, got %v", pid)
+			}
+		})
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		config               string
+		expectErrorSubString string
+	}{
+		{
+			name: "users and groups",
+			config: `users:
+- uid: 1000
+  name: sidecar
+  groups: ["sidecars"]
+groups:
+- gid: 2000
+  groups: ["monitoring"]
+`,
+		},
+		{
+			name: "unknown field",
+			config: `users:
+- uid: 1000
+  user: sidecar
+`,
+			expectErrorSubString: `unknown field "user"`,
+		},
+		{
+			name: "missing name",
+			config: `users:
+- uid: 1000
+`,
+			expectErrorSubString: "users[0]: name must be specified",
+		},
+		{
+			name: "duplicate uid",
+			config: `users:
+- uid: 1000
+  name: sidecar
+- uid: 1000
+  name: other
+`,
+			expectErrorSubString: "users[1]: uid 1000 is mapped more than once",
+		},
+		{
+			name: "group without groups",
+			config: `groups:
+- gid: 2000
+`,
+			expectErrorSubString: "groups[0]: groups must be specified",
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "peers.yaml")
+			if err := os.WriteFile(path, []byte(testcase.config), 0600); err != nil {
+				t.Fatal(err)
+			}
+
+			_, err := LoadConfig(path)
+			if len(testcase.expectErrorSubString) == 0 {
+				if err != nil {
+					t.Errorf("expected no error, got %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), testcase.expectErrorSubString) {
+				t.Errorf("expected error containing %q, got %v", testcase.expectErrorSubString, err)
+			}
+		})
+	}
+}
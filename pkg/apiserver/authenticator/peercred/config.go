@@ -0,0 +1,87 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package peercred
+
+import (
+	"fmt"
+	"os"
+
+	"sigs.k8s.io/yaml"
+)
+
+// Config maps the uids and gids of local processes to users and groups.
+type Config struct {
+	// Users maps a uid to a user.
+	Users []UserMapping `json:"users,omitempty"`
+	// Groups adds groups to the processes with a gid. A process whose uid is not
+	// mapped is authenticated as system:unix:<uid> if its gid is mapped.
+	Groups []GroupMapping `json:"groups,omitempty"`
+}
+
+// UserMapping maps the uid of a process to a user name and groups.
+type UserMapping struct {
+	UID    uint32   `json:"uid"`
+	Name   string   `json:"name"`
+	Groups []string `json:"groups,omitempty"`
+}
+
+// GroupMapping maps the gid of a process to groups.
+type GroupMapping struct {
+	GID    uint32   `json:"gid"`
+	Groups []string `json:"groups"`
+}
+
+// LoadConfig reads and validates the config file path, unknown fields are errors.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	if err := yaml.UnmarshalStrict(data, config); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %v", path, err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid %s: %v", path, err)
+	}
+	return config, nil
+}
+
+// Validate checks that every uid and gid is mapped once, and every user has a name.
+func (c *Config) Validate() error {
+	uids := map[uint32]bool{}
+	for i, u := range c.Users {
+		if len(u.Name) == 0 {
+			return fmt.Errorf("users[%d]: name must be specified", i)
+		}
+		if uids[u.UID] {
+			return fmt.Errorf("users[%d]: uid %d is mapped more than once", i, u.UID)
+		}
+		uids[u.UID] = true
+	}
+	gids := map[uint32]bool{}
+	for i, g := range c.Groups {
+		if len(g.Groups) == 0 {
+			return fmt.Errorf("groups[%d]: groups must be specified", i)
+		}
+		if gids[g.GID] {
+			return fmt.Errorf("groups[%d]: gid %d is mapped more than once", i, g.GID)
+		}
+		gids[g.GID] = true
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package peercred
+
+import (
+	"context"
+	"net"
+
+	"k8s.io/klog/v2"
+)
+
+// Credentials of the process connected to a unix socket.
+type Credentials struct {
+	UID uint32
+	GID uint32
+	PID int32
+}
+
+type credentialsKey struct{}
+
+// WithCredentials returns a copy of ctx holding creds.
+func WithCredentials(ctx context.Context, creds *Credentials) context.Context {
+	return context.WithValue(ctx, credentialsKey{}, creds)
+}
+
+// CredentialsFrom returns the credentials of the peer process in ctx, if any.
+func CredentialsFrom(ctx context.Context) (*Credentials, bool) {
+	creds, ok := ctx.Value(credentialsKey{}).(*Credentials)
+	return creds, ok && creds != nil
+}
+
+// ConnContext is the http.Server ConnContext of a unix socket, it reads the credentials
+// of the peer process once per connection.
+func ConnContext(ctx context.Context, conn net.Conn) context.Context {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return ctx
+	}
+	creds, err := peerCredentials(unixConn)
+	if err != nil {
+		klog.Warningf("Failed to read the peer credentials of %s: %v", conn.RemoteAddr(), err)
+		return ctx
+	}
+	return WithCredentials(ctx, creds)
+}
@@ -0,0 +1,31 @@
+//go:build !linux
+
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package peercred
+
+import (
+	"errors"
+	"net"
+)
+
+// Supported is true if the credentials of peer processes can be read on this platform.
+const Supported = false
+
+func peerCredentials(conn *net.UnixConn) (*Credentials, error) {
+	return nil, errors.New("peer credentials are only supported on linux")
+}
@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/seanchann/apimaster/pkg/apiserver/authorizer/modes"
+	"github.com/seanchann/apimaster/pkg/apiserver/reload"
 	"github.com/seanchann/apimaster/pkg/auth/authorizer/abac"
 	utilnet "k8s.io/apimachinery/pkg/util/net"
 	"k8s.io/apimachinery/pkg/util/wait"
@@ -59,6 +60,9 @@ type Config struct {
 	// AuthorizationConfiguration stores the configuration for the Authorizer chain
 	// It will deprecate most of the above flags when GA
 	AuthorizationConfiguration *authzconfig.AuthorizationConfiguration
+
+	// Reloaders reloads PolicyFile when it changes, nil disables the reloads.
+	Reloaders *reload.Reloaders
 }
 
 // New returns the right sort of union of multiple authorizer.Authorizer objects
@@ -96,8 +100,24 @@ func (config Config) New() (authorizer.Authorizer, authorizer.RuleResolver, erro
 			if err != nil {
 				return nil, nil, err
 			}
-			authorizers = append(authorizers, abacAuthorizer)
-			ruleResolvers = append(ruleResolvers, abacAuthorizer)
+			var policyAuthorizer interface {
+				authorizer.Authorizer
+				authorizer.RuleResolver
+			} = abacAuthorizer
+			if config.Reloaders != nil {
+				dynamicAuthorizer := reload.NewAuthorizer(abacAuthorizer, abacAuthorizer)
+				config.Reloaders.Add("authorization-policy-file", config.PolicyFile, func(path string) error {
+					abacAuthorizer, err := abac.NewFromFile(path)
+					if err != nil {
+						return err
+					}
+					dynamicAuthorizer.Set(abacAuthorizer, abacAuthorizer)
+					return nil
+				})
+				policyAuthorizer = dynamicAuthorizer
+			}
+			authorizers = append(authorizers, policyAuthorizer)
+			ruleResolvers = append(ruleResolvers, policyAuthorizer)
 		case authzconfig.AuthorizerType(modes.ModeWebhook):
 			if config.WebhookRetryBackoff == nil {
 				return nil, nil, errors.New("retry backoff parameters for authorization webhook has not been specified")
@@ -0,0 +1,314 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package batch
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/endpoints/filters"
+	"k8s.io/apiserver/pkg/endpoints/handlers/responsewriters"
+	"k8s.io/apiserver/pkg/endpoints/request"
+
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+)
+
+const (
+	// Path is the path the batch handler is installed at.
+	Path = "/batch"
+	// MaxOperations is the maximum number of operations of a batch.
+	MaxOperations = 100
+	// maxRequestBodyBytes is the maximum size of a batch request.
+	maxRequestBodyBytes = 10 * 1024 * 1024
+)
+
+// allowedVerbs are the verbs of the operations of a batch.
+var allowedVerbs = sets.NewString("get", "create", "update", "patch", "delete")
+
+// Request is the body of a batch request.
+type Request struct {
+	Operations []Operation `json:"operations"`
+}
+
+// Operation is a single request of a batch, e.g. a POST to /api/v1/namespaces/default/pods.
+type Operation struct {
+	Method string `json:"method"`
+	// Path is the path of the request, including the query, e.g. ?dryRun=All.
+	Path string `json:"path"`
+	// ContentType is the content type of Body, it defaults to application/json.
+	// Patches set it to the type of the patch, e.g. application/merge-patch+json.
+	ContentType string          `json:"contentType,omitempty"`
+	Body        json.RawMessage `json:"body,omitempty"`
+}
+
+// Response is the body of the response of a committed batch.
+type Response struct {
+	Results []Result `json:"results"`
+}
+
+// Result is the response of a single operation of a batch. The resourceVersion of
+// Body is the one the object was committed at.
+type Result struct {
+	StatusCode int             `json:"statusCode"`
+	Body       json.RawMessage `json:"body,omitempty"`
+}
+
+// Handler runs the operations of a batch request one after another through the
+// handler of the installed APIs, the writes are staged and committed together once
+// every operation succeeded. If an operation fails, nothing is written and the error
+// of the operation is returned.
+type Handler struct {
+	// Director serves the operations, it must not contain the filters that run for
+	// the batch request already, e.g. authentication.
+	Director http.Handler
+	// RequestInfoResolver resolves the request info of the operations.
+	RequestInfoResolver request.RequestInfoResolver
+	// Authorizer authorizes every operation on behalf of the user of the batch request,
+	// a nil Authorizer allows every operation like the authorization filter does.
+	Authorizer authorizer.Authorizer
+	// SupportsBatch reports whether the storage of resource can take part in a batch,
+	// the writes to the other resources are refused with 501 Not Implemented before
+	// any operation runs. A nil SupportsBatch supports every resource.
+	SupportsBatch func(resource schema.GroupResource) bool
+}
+
+// ServeHTTP implements http.Handler.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		writeError(w, apierrors.NewMethodNotSupported(schema.GroupResource{Resource: "batch"}, req.Method))
+		return
+	}
+	batchRequest, err := readRequest(req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	batch := kvstore.NewBatch()
+	ctx := kvstore.WithBatch(req.Context(), batch)
+	// every operation is checked before any runs, a batch that can not be committed
+	// is refused without running the ones before
+	opReqs := make([]*http.Request, 0, len(batchRequest.Operations))
+	for i, op := range batchRequest.Operations {
+		opReq, err := h.newOperationRequest(req.WithContext(ctx), op)
+		if err != nil {
+			writeError(w, operationError(i, err))
+			return
+		}
+		opReqs = append(opReqs, opReq)
+	}
+
+	results := make([]Result, 0, len(opReqs))
+	for i, opReq := range opReqs {
+		result, err := h.serveOperation(opReq)
+		if err != nil {
+			writeError(w, operationError(i, err))
+			return
+		}
+		if result.StatusCode < http.StatusOK || result.StatusCode >= http.StatusMultipleChoices {
+			writeError(w, operationError(i, resultError(result)))
+			return
+		}
+		results = append(results, result)
+	}
+
+	if err := batch.Commit(ctx); err != nil {
+		writeError(w, err)
+		return
+	}
+	for i := range results {
+		results[i].Body = committedResourceVersion(batch, results[i].Body)
+	}
+	responsewriters.WriteRawJSON(http.StatusOK, Response{Results: results}, w)
+}
+
+// newOperationRequest returns the request of op with the context of req, and checks
+// that op may run in a batch.
+func (h *Handler) newOperationRequest(req *http.Request, op Operation) (*http.Request, error) {
+	opReq, err := http.NewRequestWithContext(req.Context(), op.Method, op.Path, bytes.NewReader(op.Body))
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	contentType := op.ContentType
+	if contentType == "" {
+		contentType = "application/json"
+	}
+	opReq.Header.Set("Content-Type", contentType)
+	opReq.Header.Set("Accept", "application/json")
+
+	info, err := h.RequestInfoResolver.NewRequestInfo(opReq)
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	if !info.IsResourceRequest || !allowedVerbs.Has(info.Verb) || (info.Name == "" && info.Verb != "create") {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("%s %s is not allowed in a batch, only %v of single objects are",
+			op.Method, op.Path, allowedVerbs.List()))
+	}
+	resource := schema.GroupResource{Group: info.APIGroup, Resource: info.Resource}
+	if info.Verb != "get" && h.SupportsBatch != nil && !h.SupportsBatch(resource) {
+		return nil, kvstore.NewBatchUnsupportedError(fmt.Sprintf("the storage of %s does not support batches", resource))
+	}
+	return opReq.WithContext(request.WithRequestInfo(opReq.Context(), info)), nil
+}
+
+// serveOperation authorizes the operation opReq and serves it.
+func (h *Handler) serveOperation(opReq *http.Request) (Result, error) {
+	info, _ := request.RequestInfoFrom(opReq.Context())
+	if err := h.authorize(opReq, info); err != nil {
+		return Result{}, err
+	}
+
+	recorder := &responseRecorder{header: http.Header{}, statusCode: http.StatusOK}
+	h.Director.ServeHTTP(recorder, opReq)
+	return Result{StatusCode: recorder.statusCode, Body: recorder.body.Bytes()}, nil
+}
+
+// authorize checks that the user of req may run the operation req.
+func (h *Handler) authorize(req *http.Request, info *request.RequestInfo) error {
+	if h.Authorizer == nil {
+		return nil
+	}
+	attributes, err := filters.GetAuthorizerAttributes(req.Context())
+	if err != nil {
+		return apierrors.NewInternalError(err)
+	}
+	decision, reason, err := h.Authorizer.Authorize(req.Context(), attributes)
+	if decision == authorizer.DecisionAllow {
+		return nil
+	}
+	if err != nil && reason == "" {
+		reason = err.Error()
+	}
+	return apierrors.NewForbidden(schema.GroupResource{Group: info.APIGroup, Resource: info.Resource}, info.Name, errors.New(reason))
+}
+
+// readRequest decodes the batch request of req.
+func readRequest(req *http.Request) (*Request, error) {
+	data, err := io.ReadAll(io.LimitReader(req.Body, maxRequestBodyBytes+1))
+	if err != nil {
+		return nil, apierrors.NewBadRequest(err.Error())
+	}
+	if len(data) > maxRequestBodyBytes {
+		return nil, apierrors.NewRequestEntityTooLargeError(fmt.Sprintf("limit is %d", maxRequestBodyBytes))
+	}
+	batchRequest := &Request{}
+	if err := json.Unmarshal(data, batchRequest); err != nil {
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("invalid batch request: %v", err))
+	}
+	switch {
+	case len(batchRequest.Operations) == 0:
+		return nil, apierrors.NewBadRequest("a batch must contain at least one operation")
+	case len(batchRequest.Operations) > MaxOperations:
+		return nil, apierrors.NewBadRequest(fmt.Sprintf("a batch can contain at most %d operations", MaxOperations))
+	}
+	return batchRequest, nil
+}
+
+// resultError returns the error of a failed operation.
+func resultError(result Result) error {
+	status := &metav1.Status{}
+	if err := json.Unmarshal(result.Body, status); err != nil || status.Kind != "Status" {
+		return &apierrors.StatusError{ErrStatus: metav1.Status{
+			Status:  metav1.StatusFailure,
+			Code:    int32(result.StatusCode),
+			Reason:  metav1.StatusReasonUnknown,
+			Message: string(result.Body),
+		}}
+	}
+	status.Code = int32(result.StatusCode)
+	return &apierrors.StatusError{ErrStatus: *status}
+}
+
+// operationError prefixes the message of err with the index of the operation.
+func operationError(i int, err error) error {
+	status := responsewriters.ErrorToAPIStatus(err)
+	status.Message = fmt.Sprintf("operations[%d]: %s", i, status.Message)
+	return &apierrors.StatusError{ErrStatus: *status}
+}
+
+// writeError writes err as a status.
+func writeError(w http.ResponseWriter, err error) {
+	status := responsewriters.ErrorToAPIStatus(err)
+	responsewriters.WriteRawJSON(int(status.Code), status, w)
+}
+
+// committedResourceVersion replaces the provisional resourceVersion in body, which
+// an object got when its write was staged, with the one it was committed at.
+func committedResourceVersion(batch *kvstore.Batch, body json.RawMessage) json.RawMessage {
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+	obj := map[string]interface{}{}
+	if err := decoder.Decode(&obj); err != nil {
+		return body
+	}
+	metadata, ok := obj["metadata"].(map[string]interface{})
+	if !ok {
+		return body
+	}
+	resourceVersion, _ := metadata["resourceVersion"].(string)
+	rev, err := strconv.ParseUint(resourceVersion, 10, 64)
+	if err != nil {
+		return body
+	}
+	committed, ok := batch.Revision(rev)
+	if !ok {
+		return body
+	}
+	metadata["resourceVersion"] = strconv.FormatUint(committed, 10)
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return body
+	}
+	return data
+}
+
+// responseRecorder buffers the response of an operation.
+type responseRecorder struct {
+	header      http.Header
+	statusCode  int
+	wroteHeader bool
+	body        bytes.Buffer
+}
+
+// Header implements http.ResponseWriter.
+func (r *responseRecorder) Header() http.Header {
+	return r.header
+}
+
+// WriteHeader implements http.ResponseWriter.
+func (r *responseRecorder) WriteHeader(statusCode int) {
+	if r.wroteHeader {
+		return
+	}
+	r.statusCode, r.wroteHeader = statusCode, true
+}
+
+// Write implements http.ResponseWriter.
+func (r *responseRecorder) Write(data []byte) (int, error) {
+	r.wroteHeader = true
+	return r.body.Write(data)
+}
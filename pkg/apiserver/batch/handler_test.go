@@ -0,0 +1,280 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package batch
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/endpoints/handlers/responsewriters"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/storage"
+	storeerr "k8s.io/apiserver/pkg/storage/errors"
+
+	api "github.com/seanchann/apimaster/pkg/apis/coreres"
+	"github.com/seanchann/apimaster/pkg/apis/coreres/install"
+	apiv1 "github.com/seanchann/apimaster/pkg/apis/coreres/v1"
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+	"github.com/seanchann/apimaster/pkg/storage/memory"
+	"github.com/seanchann/apimaster/pkg/storage/mysql"
+	"github.com/seanchann/apimaster/pkg/storage/mysql/mysqltest"
+	"github.com/seanchann/apimaster/pkg/storage/postgres"
+	"github.com/seanchann/apimaster/pkg/storage/postgres/postgrestest"
+)
+
+var namespaces = schema.GroupResource{Group: apiv1.GroupName, Resource: "namespaces"}
+
+// testKVs are the transactional kv stores the batches are committed to.
+var testKVs = []struct {
+	name  string
+	newKV func(t *testing.T) kvstore.KV
+}{
+	{
+		name:  "memory",
+		newKV: func(t *testing.T) kvstore.KV { return memory.NewBackend(0) },
+	},
+	{
+		name: "mysql",
+		newKV: func(t *testing.T) kvstore.KV {
+			config := mysql.NewDefaultConfig()
+			config.ServerList = []string{mysqltest.NewTestServer(t)}
+			config.CompactionInterval = 0
+			b, err := mysql.NewBackend(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { b.Close() })
+			return b
+		},
+	},
+	{
+		name: "postgres",
+		newKV: func(t *testing.T) kvstore.KV {
+			config := postgres.NewDefaultConfig()
+			config.ServerList = []string{postgrestest.NewTestServer(t)}
+			config.CompactionInterval = 0
+			b, err := postgres.NewBackend(config)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { b.Close() })
+			return b
+		},
+	},
+}
+
+// namespaceDirector serves the creates and deletes of namespaces from a kv store.
+type namespaceDirector struct {
+	codec runtime.Codec
+	store storage.Interface
+}
+
+func newNamespaceDirector(kv kvstore.KV) *namespaceDirector {
+	scheme := runtime.NewScheme()
+	install.Install(scheme)
+	codec := serializer.NewCodecFactory(scheme).LegacyCodec(apiv1.SchemeGroupVersion)
+	return &namespaceDirector{
+		codec: codec,
+		store: kvstore.New(kv, codec,
+			func() runtime.Object { return &api.Namespace{} },
+			func() runtime.Object { return &api.NamespaceList{} },
+			"/registry", nil, nil),
+	}
+}
+
+func (d *namespaceDirector) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	info, _ := request.RequestInfoFrom(req.Context())
+	out := &api.Namespace{}
+	var err error
+	switch info.Verb {
+	case "create":
+		var obj runtime.Object
+		data, _ := io.ReadAll(req.Body)
+		if obj, err = runtime.Decode(d.codec, data); err == nil {
+			ns := obj.(*api.Namespace)
+			err = storeerr.InterpretCreateError(d.store.Create(req.Context(), "/namespaces/"+ns.Name, ns, out, 0), namespaces, ns.Name)
+		}
+	case "delete":
+		err = d.store.Delete(req.Context(), "/namespaces/"+info.Name, out, nil, storage.ValidateAllObjectFunc, nil)
+		err = storeerr.InterpretDeleteError(err, namespaces, info.Name)
+	}
+	if err != nil {
+		status := responsewriters.ErrorToAPIStatus(err)
+		responsewriters.WriteRawJSON(int(status.Code), status, w)
+		return
+	}
+	data, _ := runtime.Encode(d.codec, out)
+	w.WriteHeader(http.StatusOK)
+	w.Write(data)
+}
+
+func (d *namespaceDirector) exists(name string) bool {
+	return d.store.Get(context.Background(), "/namespaces/"+name, storage.GetOptions{}, &api.Namespace{}) == nil
+}
+
+func createOp(name string) Operation {
+	return Operation{Method: http.MethodPost, Path: "/apis/coreres/v1/namespaces", Body: json.RawMessage(`{"apiVersion":"coreres/v1","kind":"Namespace","metadata":{"name":"` + name + `"}}`)}
+}
+
+func deleteOp(name string) Operation {
+	return Operation{Method: http.MethodDelete, Path: "/apis/coreres/v1/namespaces/" + name}
+}
+
+func TestHandler(t *testing.T) {
+	testCases := []struct {
+		name              string
+		user              string
+		operations        []Operation
+		expectStatusCode  int
+		expectMessage     string
+		expectNamespaces  []string
+		expectNoNamespace []string
+	}{
+		{
+			name:              "create and delete",
+			operations:        []Operation{createOp("parent"), createOp("child"), deleteOp("existing")},
+			expectStatusCode:  http.StatusOK,
+			expectNamespaces:  []string{"parent", "child"},
+			expectNoNamespace: []string{"existing"},
+		},
+		{
+			name:              "failed operation rolls back the batch",
+			operations:        []Operation{createOp("parent"), deleteOp("existing"), createOp("parent")},
+			expectStatusCode:  http.StatusConflict,
+			expectMessage:     "operations[2]: ",
+			expectNamespaces:  []string{"existing"},
+			expectNoNamespace: []string{"parent"},
+		},
+		{
+			name:              "list is not allowed",
+			operations:        []Operation{createOp("parent"), {Method: http.MethodGet, Path: "/apis/coreres/v1/namespaces"}},
+			expectStatusCode:  http.StatusBadRequest,
+			expectMessage:     "operations[1]: GET /apis/coreres/v1/namespaces is not allowed in a batch",
+			expectNoNamespace: []string{"parent"},
+		},
+		{
+			name:              "forbidden operation",
+			user:              "bob",
+			operations:        []Operation{createOp("parent"), deleteOp("existing")},
+			expectStatusCode:  http.StatusForbidden,
+			expectMessage:     "operations[1]: ",
+			expectNamespaces:  []string{"existing"},
+			expectNoNamespace: []string{"parent"},
+		},
+		{
+			name:              "resource without batch support",
+			operations:        []Operation{createOp("parent"), {Method: http.MethodDelete, Path: "/apis/coreres/v1/namespaces/default/configmaps/foo"}},
+			expectStatusCode:  http.StatusNotImplemented,
+			expectMessage:     "operations[1]: the storage of configmaps.coreres does not support batches",
+			expectNoNamespace: []string{"parent"},
+		},
+		{
+			name:             "no operations",
+			expectStatusCode: http.StatusBadRequest,
+			expectMessage:    "a batch must contain at least one operation",
+		},
+	}
+
+	for _, kv := range testKVs {
+		for _, testcase := range testCases {
+			t.Run(kv.name+"/"+testcase.name, func(t *testing.T) {
+				director := newNamespaceDirector(kv.newKV(t))
+				if err := director.store.Create(context.Background(), "/namespaces/existing",
+					&api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "existing"}}, nil, 0); err != nil {
+					t.Fatal(err)
+				}
+				handler := &Handler{
+					Director: director,
+					RequestInfoResolver: &request.RequestInfoFactory{
+						APIPrefixes:          sets.NewString("api", "apis"),
+						GrouplessAPIPrefixes: sets.NewString("api"),
+					},
+					// bob may only create
+					Authorizer: authorizer.AuthorizerFunc(func(ctx context.Context, a authorizer.Attributes) (authorizer.Decision, string, error) {
+						if a.GetUser().GetName() == "bob" && a.GetVerb() != "create" {
+							return authorizer.DecisionNoOpinion, "bob may only create", nil
+						}
+						return authorizer.DecisionAllow, "", nil
+					}),
+					SupportsBatch: func(resource schema.GroupResource) bool { return resource == namespaces },
+				}
+
+				body, _ := json.Marshal(Request{Operations: testcase.operations})
+				req := httptest.NewRequest(http.MethodPost, Path, strings.NewReader(string(body)))
+				userName := testcase.user
+				if userName == "" {
+					userName = "alice"
+				}
+				req = req.WithContext(request.WithUser(req.Context(), &user.DefaultInfo{Name: userName}))
+				w := httptest.NewRecorder()
+				handler.ServeHTTP(w, req)
+
+				if w.Code != testcase.expectStatusCode {
+					t.Fatalf("expected status code %d, got %d: %s", testcase.expectStatusCode, w.Code, w.Body.String())
+				}
+				if w.Code == http.StatusOK {
+					response := &Response{}
+					if err := json.Unmarshal(w.Body.Bytes(), response); err != nil {
+						t.Fatal(err)
+					}
+					if len(response.Results) != len(testcase.operations) {
+						t.Errorf("expected %d results, got %d", len(testcase.operations), len(response.Results))
+					}
+					for _, result := range response.Results {
+						ns := &apiv1.Namespace{}
+						if err := json.Unmarshal(result.Body, ns); err != nil {
+							t.Fatal(err)
+						}
+						if len(ns.ResourceVersion) > 5 {
+							t.Errorf("expected a committed resourceVersion, got %s", ns.ResourceVersion)
+						}
+					}
+				} else {
+					status := &metav1.Status{}
+					if err := json.Unmarshal(w.Body.Bytes(), status); err != nil {
+						t.Fatal(err)
+					}
+					if !strings.Contains(status.Message, testcase.expectMessage) {
+						t.Errorf("expected message containing %q, got %q", testcase.expectMessage, status.Message)
+					}
+				}
+				for _, name := range testcase.expectNamespaces {
+					if !director.exists(name) {
+						t.Errorf("expected namespace %s to exist", name)
+					}
+				}
+				for _, name := range testcase.expectNoNamespace {
+					if director.exists(name) {
+						t.Errorf("expected namespace %s not to exist", name)
+					}
+				}
+			})
+		}
+	}
+}
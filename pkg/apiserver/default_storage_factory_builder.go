@@ -46,7 +46,29 @@ func NewStorageFactory(
 	resourceEncodingOverrides []schema.GroupVersionResource,
 	apiResourceConfig *serverstorage.ResourceConfig,
 ) (*serverstorage.DefaultStorageFactory, error) {
-	// resourceEncodingConfig := resourceconfig.MergeGroupEncodingConfigs(defaultResourceEncoding, storageEncodingOverrides)
-	resourceEncodingConfig := resourceconfig.MergeResourceEncodingConfigs(defaultResourceEncoding, resourceEncodingOverrides)
+	resourceEncodingConfig := &groupResourceEncodingConfig{
+		DefaultResourceEncodingConfig: resourceconfig.MergeResourceEncodingConfigs(defaultResourceEncoding, resourceEncodingOverrides),
+		groups:                        storageEncodingOverrides,
+		resources:                     map[schema.GroupResource]bool{},
+	}
+	for _, gvr := range resourceEncodingOverrides {
+		resourceEncodingConfig.resources[gvr.GroupResource()] = true
+	}
 	return serverstorage.NewDefaultStorageFactory(storageConfig, defaultMediaType, serializer, resourceEncodingConfig, apiResourceConfig, SpecialDefaultResourcePrefixes), nil
 }
+
+// groupResourceEncodingConfig stores the resources of a group in the version of the group
+// (--storage-versions), unless the resource has a version of its own (--resource-storage-versions).
+type groupResourceEncodingConfig struct {
+	*serverstorage.DefaultResourceEncodingConfig
+	groups    map[string]schema.GroupVersion
+	resources map[schema.GroupResource]bool
+}
+
+// StorageEncodingFor implements serverstorage.ResourceEncodingConfig.
+func (c *groupResourceEncodingConfig) StorageEncodingFor(resource schema.GroupResource) (schema.GroupVersion, error) {
+	if gv, found := c.groups[resource.Group]; found && !c.resources[resource] {
+		return gv, nil
+	}
+	return c.DefaultResourceEncodingConfig.StorageEncodingFor(resource)
+}
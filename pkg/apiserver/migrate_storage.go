@@ -0,0 +1,195 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package apiserver
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/seanchann/apimaster/pkg/api/legacyscheme"
+	"github.com/seanchann/apimaster/pkg/apiserver/options"
+	"github.com/seanchann/apimaster/pkg/storage/resourcestore"
+
+	apiequality "k8s.io/apimachinery/pkg/api/equality"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	genericapiserver "k8s.io/apiserver/pkg/server"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/klog/v2"
+)
+
+// MigrateStorageOptions configures a storage migration.
+type MigrateStorageOptions struct {
+	// Resources limits the migration to these resources, every enabled resource is migrated if empty.
+	Resources []schema.GroupResource
+	// DryRun only reads both backends and reports what would be copied.
+	DryRun bool
+	// Verify compares every object of the source with the target after copying.
+	Verify bool
+}
+
+// MigrateStorageResult is the outcome of migrating one resource.
+type MigrateStorageResult struct {
+	Resource schema.GroupResource
+	// Copied is the number of objects written to the target, or that would be written in dry-run mode.
+	Copied int
+	// Existing is the number of objects the target stored already with the same UID.
+	Existing int
+	// Verified is the number of objects that are equal in the source and the target.
+	Verified int
+}
+
+// MigrateStorage copies the objects of every enabled resource from the storage backend of
+// source to the storage backend of target. The objects are written as they are stored, so
+// UIDs, creationTimestamps and finalizers are kept, only the resourceVersion is assigned by
+// the target. Objects the target stores already with the same UID are skipped, so an
+// interrupted migration can be run again. No apiserver should write the source meanwhile.
+func MigrateStorage(ctx context.Context, source, target *options.APIMasterOptions, apiProvider APIServerProvider,
+	opts MigrateStorageOptions) ([]MigrateStorageResult, error) {
+	sourceConfig, err := buildStorageConfig(source, apiProvider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up source storage: %v", err)
+	}
+	targetConfig, err := buildStorageConfig(target, apiProvider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up target storage: %v", err)
+	}
+
+	resources := opts.Resources
+	if len(resources) == 0 {
+		resources = resourcestore.Resources(legacyscheme.Scheme, sourceConfig.MergedResourceConfig)
+	}
+
+	results := []MigrateStorageResult{}
+	verifyErrs := []error{}
+	for _, resource := range resources {
+		result, errs, err := migrateResource(ctx, sourceConfig, targetConfig, resource, opts)
+		if err != nil {
+			return results, fmt.Errorf("failed to migrate %v: %v", resource, err)
+		}
+		klog.Infof("Migrated %v: %d copied, %d existing, %d verified", resource, result.Copied, result.Existing, result.Verified)
+		results = append(results, result)
+		verifyErrs = append(verifyErrs, errs...)
+	}
+	return results, utilerrors.NewAggregate(verifyErrs)
+}
+
+// buildStorageConfig returns a server config whose rest options getter reads and writes the storage backend of s.
+func buildStorageConfig(s *options.APIMasterOptions, apiProvider APIServerProvider) (*genericapiserver.Config, error) {
+	c := genericapiserver.NewConfig(legacyscheme.Codecs)
+	if err := s.APIEnablement.ApplyTo(c, apiProvider.DefaultAPIResourceConfigSource(), legacyscheme.Scheme); err != nil {
+		return nil, err
+	}
+	if err := applyStorageTo(s, c); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// migrateResource copies the objects of resource and verifies them if requested, the
+// objects that differ in the target are returned as errors.
+func migrateResource(ctx context.Context, sourceConfig, targetConfig *genericapiserver.Config, resource schema.GroupResource,
+	opts MigrateStorageOptions) (MigrateStorageResult, []error, error) {
+	result := MigrateStorageResult{Resource: resource}
+
+	from, err := resourcestore.Open(sourceConfig.RESTOptionsGetter, legacyscheme.Scheme, resource)
+	if err != nil {
+		return result, nil, err
+	}
+	defer from.Destroy()
+	to, err := resourcestore.Open(targetConfig.RESTOptionsGetter, legacyscheme.Scheme, resource)
+	if err != nil {
+		return result, nil, err
+	}
+	defer to.Destroy()
+
+	err = from.Each(ctx, func(obj runtime.Object) error {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return err
+		}
+		key, err := resourcestore.Key(to.Prefix, obj)
+		if err != nil {
+			return err
+		}
+
+		existing := to.NewFunc()
+		err = to.Get(ctx, key, storage.GetOptions{}, existing)
+		switch {
+		case err == nil:
+			existingAccessor, err := meta.Accessor(existing)
+			if err != nil {
+				return err
+			}
+			if existingAccessor.GetUID() != accessor.GetUID() {
+				return fmt.Errorf("%s is stored in the target with uid %s instead of %s", key, existingAccessor.GetUID(), accessor.GetUID())
+			}
+			result.Existing++
+			return nil
+		case !storage.IsNotFound(err):
+			return err
+		}
+
+		result.Copied++
+		if opts.DryRun {
+			return nil
+		}
+		// the resourceVersion of the source means nothing to the target
+		accessor.SetResourceVersion("")
+		accessor.SetSelfLink("")
+		return to.Create(ctx, key, obj, nil, 0)
+	})
+	if err != nil || opts.DryRun || !opts.Verify {
+		return result, nil, err
+	}
+
+	verifyErrs := []error{}
+	err = from.Each(ctx, func(obj runtime.Object) error {
+		key, err := resourcestore.Key(to.Prefix, obj)
+		if err != nil {
+			return err
+		}
+		copied := to.NewFunc()
+		if err := to.Get(ctx, key, storage.GetOptions{}, copied); err != nil {
+			verifyErrs = append(verifyErrs, fmt.Errorf("failed to verify %s: %v", key, err))
+			return nil
+		}
+		if !equalIgnoringResourceVersion(obj, copied) {
+			verifyErrs = append(verifyErrs, fmt.Errorf("%s differs between the source and the target", key))
+			return nil
+		}
+		result.Verified++
+		return nil
+	})
+	return result, verifyErrs, err
+}
+
+// equalIgnoringResourceVersion compares the stored objects a and b, except their resourceVersion and selfLink.
+func equalIgnoringResourceVersion(a, b runtime.Object) bool {
+	a, b = a.DeepCopyObject(), b.DeepCopyObject()
+	for _, obj := range []runtime.Object{a, b} {
+		accessor, err := meta.Accessor(obj)
+		if err != nil {
+			return false
+		}
+		accessor.SetResourceVersion("")
+		accessor.SetSelfLink("")
+	}
+	return apiequality.Semantic.DeepEqual(a, b)
+}
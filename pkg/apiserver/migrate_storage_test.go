@@ -0,0 +1,165 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package apiserver
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/seanchann/apimaster/pkg/api/legacyscheme"
+	api "github.com/seanchann/apimaster/pkg/apis/coreres"
+	_ "github.com/seanchann/apimaster/pkg/apis/coreres/install"
+	apiv1 "github.com/seanchann/apimaster/pkg/apis/coreres/v1"
+	"github.com/seanchann/apimaster/pkg/apiserver/options"
+	"github.com/seanchann/apimaster/pkg/storage/resourcestore"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	genericoptions "k8s.io/apiserver/pkg/server/options"
+	serverstorage "k8s.io/apiserver/pkg/server/storage"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+)
+
+type fakeAPIServerProvider struct {
+	APIServerProvider
+}
+
+func (fakeAPIServerProvider) DefaultAPIResourceConfigSource() *serverstorage.ResourceConfig {
+	resourceConfig := serverstorage.NewResourceConfig()
+	resourceConfig.EnableVersions(apiv1.SchemeGroupVersion)
+	return resourceConfig
+}
+
+func newMemoryAPIMasterOptions() *options.APIMasterOptions {
+	return &options.APIMasterOptions{
+		Backend:              options.StorageBackendTypeMemory,
+		Storage:              options.NewMemoryOptions(storagebackend.NewDefaultConfig(options.DefaultEtcdPathPrefix, nil)),
+		StorageRouting:       options.NewStorageRoutingOptions(),
+		StorageSerialization: options.NewStorageSerializationOptions(),
+		Encryption:           options.NewEncryptionOptions(),
+		APIEnablement:        genericoptions.NewAPIEnablementOptions(),
+	}
+}
+
+func openNamespaces(t *testing.T, s *options.APIMasterOptions) *resourcestore.Store {
+	c, err := buildStorageConfig(s, fakeAPIServerProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := resourcestore.Open(c.RESTOptionsGetter, legacyscheme.Scheme, api.Resource("namespaces"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(store.Destroy)
+	return store
+}
+
+func createNamespace(t *testing.T, store *resourcestore.Store, name string, uid types.UID) {
+	ns := &api.Namespace{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:              name,
+			UID:               uid,
+			CreationTimestamp: metav1.Date(2020, 1, 2, 3, 4, 5, 0, metav1.Now().Location()),
+			Finalizers:        []string{"example.com/cleanup"},
+		},
+		Spec: api.NamespaceSpec{Finalizers: []api.FinalizerName{"kubernetes"}},
+	}
+	if err := store.Create(context.Background(), store.Prefix+"/"+name, ns, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestStoredResources(t *testing.T) {
+	resources := resourcestore.Resources(legacyscheme.Scheme, fakeAPIServerProvider{}.DefaultAPIResourceConfigSource())
+	expected := []schema.GroupResource{api.Resource("namespaces")}
+	if !reflect.DeepEqual(resources, expected) {
+		t.Errorf("expected resources %v, got %v", expected, resources)
+	}
+}
+
+func TestMigrateStorage(t *testing.T) {
+	ctx := context.Background()
+	source, target := newMemoryAPIMasterOptions(), newMemoryAPIMasterOptions()
+	from, to := openNamespaces(t, source), openNamespaces(t, target)
+	createNamespace(t, from, "foo", "uid-foo")
+	createNamespace(t, from, "bar", "uid-bar")
+	createNamespace(t, to, "bar", "uid-bar")
+
+	results, err := MigrateStorage(ctx, source, target, fakeAPIServerProvider{}, MigrateStorageOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []MigrateStorageResult{{Resource: api.Resource("namespaces"), Copied: 1, Existing: 1}}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected dry-run results %v, got %v", expected, results)
+	}
+	if err := to.Get(ctx, to.Prefix+"/foo", storage.GetOptions{}, &api.Namespace{}); !storage.IsNotFound(err) {
+		t.Fatalf("expected dry-run not to write the target, got %v", err)
+	}
+
+	results, err = MigrateStorage(ctx, source, target, fakeAPIServerProvider{}, MigrateStorageOptions{Verify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected = []MigrateStorageResult{{Resource: api.Resource("namespaces"), Copied: 1, Existing: 1, Verified: 2}}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected results %v, got %v", expected, results)
+	}
+	original, copied := &api.Namespace{}, &api.Namespace{}
+	if err := from.Get(ctx, from.Prefix+"/foo", storage.GetOptions{}, original); err != nil {
+		t.Fatal(err)
+	}
+	if err := to.Get(ctx, to.Prefix+"/foo", storage.GetOptions{}, copied); err != nil {
+		t.Fatal(err)
+	}
+	if copied.UID != original.UID || !copied.CreationTimestamp.Equal(&original.CreationTimestamp) ||
+		!reflect.DeepEqual(copied.Finalizers, original.Finalizers) || !reflect.DeepEqual(copied.Spec, original.Spec) {
+		t.Errorf("expected %#v to be copied, got %#v", original.ObjectMeta, copied.ObjectMeta)
+	}
+
+	// an object recreated in the target with another uid is reported
+	createNamespace(t, from, "baz", "uid-baz")
+	createNamespace(t, to, "baz", "uid-other")
+	if _, err := MigrateStorage(ctx, source, target, fakeAPIServerProvider{}, MigrateStorageOptions{}); err == nil {
+		t.Errorf("expected uid conflict error")
+	}
+
+	// an object changed in the target fails the verification
+	if err := to.GuaranteedUpdate(ctx, to.Prefix+"/foo", &api.Namespace{}, false, nil,
+		func(existing runtime.Object, _ storage.ResponseMeta) (runtime.Object, *uint64, error) {
+			ns := existing.(*api.Namespace)
+			ns.Labels = map[string]string{"changed": "true"}
+			return ns, nil, nil
+		}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := to.Delete(ctx, to.Prefix+"/baz", &api.Namespace{}, nil, storage.ValidateAllObjectFunc, nil); err != nil {
+		t.Fatal(err)
+	}
+	results, err = MigrateStorage(ctx, source, target, fakeAPIServerProvider{}, MigrateStorageOptions{Verify: true})
+	if err == nil {
+		t.Errorf("expected verification error")
+	}
+	expected = []MigrateStorageResult{{Resource: api.Resource("namespaces"), Copied: 1, Existing: 2, Verified: 2}}
+	if !reflect.DeepEqual(results, expected) {
+		t.Errorf("expected results %v, got %v", expected, results)
+	}
+}
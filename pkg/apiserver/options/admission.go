@@ -19,8 +19,10 @@ package options
 import (
 	"fmt"
 	"strings"
+	"sync"
 
 	"github.com/seanchann/apimaster/pkg/admission/initializer"
+	"github.com/seanchann/apimaster/pkg/apiserver/reload"
 	"github.com/spf13/pflag"
 	"k8s.io/client-go/dynamic"
 	"k8s.io/klog/v2"
@@ -60,6 +62,8 @@ type AdmissionOptions struct {
 	// DEPRECATED flag, should use EnabledAdmissionPlugins and DisabledAdmissionPlugins.
 	// They are mutually exclusive, specify both will lead to an error.
 	PluginNames []string
+	// Reloaders reloads the admission config file when it changes, nil disables the reloads.
+	Reloaders *reload.Reloaders
 }
 
 // NewAdmissionOptions creates a new instance of AdmissionOptions
@@ -175,22 +179,67 @@ func (a *AdmissionOptions) apiserverApplyTo(
 
 	pluginNames := a.enabledPluginNames()
 
-	pluginsConfigProvider, err := admission.ReadAdmissionConfiguration(pluginNames,
-		a.GenericAdmission.ConfigFile, configScheme)
-	if err != nil {
-		return fmt.Errorf("failed to read plugin config: %v", err)
-	}
+	// newChain creates and initializes the plugins configured by configFile, their goroutines
+	// run until stop is called or the server is drained
+	newChain := func(configFile string) (chain admission.Interface, stop func(), err error) {
+		pluginsConfigProvider, err := admission.ReadAdmissionConfiguration(pluginNames,
+			configFile, configScheme)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read plugin config: %v", err)
+		}
 
-	genericInitializer := initializer.New(normalClient,
-		dynamicClient, informers, c.Authorization.Authorizer, features, c.DrainedNotify())
-	initializersChain := admission.PluginInitializers{genericInitializer}
-	initializersChain = append(initializersChain, pluginInitializers...)
+		stopCh := make(chan struct{})
+		var once sync.Once
+		stop = func() { once.Do(func() { close(stopCh) }) }
+		go func() {
+			select {
+			case <-c.DrainedNotify():
+				stop()
+			case <-stopCh:
+			}
+		}()
+
+		genericInitializer := initializer.New(normalClient,
+			dynamicClient, informers, c.Authorization.Authorizer, features, stopCh)
+		initializersChain := admission.PluginInitializers{genericInitializer}
+		initializersChain = append(initializersChain, pluginInitializers...)
+		chain, err = a.GenericAdmission.Plugins.NewFromPlugins(pluginNames,
+			pluginsConfigProvider, initializersChain, a.GenericAdmission.Decorators)
+		if err != nil {
+			stop()
+			return nil, nil, err
+		}
+		return chain, stop, nil
+	}
 
-	admissionChain, err := a.GenericAdmission.Plugins.NewFromPlugins(pluginNames,
-		pluginsConfigProvider, initializersChain, a.GenericAdmission.Decorators)
+	admissionChain, stopChain, err := newChain(a.GenericAdmission.ConfigFile)
 	if err != nil {
 		return err
 	}
+	if a.Reloaders != nil && len(a.GenericAdmission.ConfigFile) > 0 {
+		dynamicChain := reload.NewAdmission(admissionChain)
+		a.Reloaders.Add("admission-control-config-file", a.GenericAdmission.ConfigFile, func(path string) error {
+			admissionChain, stop, err := newChain(path)
+			if err != nil {
+				return err
+			}
+			// the requests admitted by the replaced chain keep it until they are done, its plugins
+			// stop their goroutines once the last of them is finished
+			drained, stopReplaced := dynamicChain.Set(admissionChain), stopChain
+			select {
+			case <-drained:
+				stopReplaced()
+			default:
+				go func() {
+					<-drained
+					stopReplaced()
+				}()
+			}
+			stopChain = stop
+			return nil
+		})
+		admissionChain = dynamicChain
+	}
 
 	klog.Infof("Admission init success")
 	c.AdmissionControl = admissionmetrics.WithStepMetrics(admissionChain)
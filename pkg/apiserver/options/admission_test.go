@@ -0,0 +1,131 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/endpoints/request"
+	"k8s.io/apiserver/pkg/server"
+
+	"github.com/seanchann/apimaster/pkg/apiserver/reload"
+)
+
+// drainedPlugin records the channel its goroutines stop on.
+type drainedPlugin struct {
+	*admission.Handler
+	stopCh <-chan struct{}
+}
+
+func (p *drainedPlugin) SetDrainedNotification(stopCh <-chan struct{}) { p.stopCh = stopCh }
+func (p *drainedPlugin) ValidateInitialization() error                 { return nil }
+
+// drainedPluginProvider registers a drainedPlugin, which records every created plugin.
+type drainedPluginProvider struct {
+	plugins *[]*drainedPlugin
+}
+
+func (p drainedPluginProvider) RegisterAllAdmissionPlugins(plugins *admission.Plugins) {
+	plugins.Register("Drained", func(io.Reader) (admission.Interface, error) {
+		plugin := &drainedPlugin{Handler: admission.NewHandler(admission.Create)}
+		*p.plugins = append(*p.plugins, plugin)
+		return plugin, nil
+	})
+}
+func (drainedPluginProvider) AllPluginOrder() []string                { return []string{"Drained"} }
+func (drainedPluginProvider) DefaultOffAdmissionPlugins() sets.String { return sets.NewString() }
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestAdmissionReloadStopsReplacedChain(t *testing.T) {
+	testCases := []struct {
+		name string
+		// request is admitted by the chain before the reload and finished after it
+		request bool
+	}{
+		{
+			name: "unused chain",
+		},
+		{
+			name:    "chain of a running request",
+			request: true,
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			configFile := filepath.Join(t.TempDir(), "admission.yaml")
+			if err := os.WriteFile(configFile, []byte("apiVersion: apiserver.config.k8s.io/v1\nkind: AdmissionConfiguration\nplugins: []\n"), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			var plugins []*drainedPlugin
+			a := NewAdmissionOptions(drainedPluginProvider{plugins: &plugins})
+			a.GenericAdmission.ConfigFile = configFile
+			a.Reloaders = reload.NewReloaders()
+			c := server.NewConfig(serializer.NewCodecFactory(runtime.NewScheme()))
+			if err := a.apiserverApplyTo(c, struct{}{}, nil, nil, nil); err != nil {
+				t.Fatal(err)
+			}
+
+			ctx, cancel := context.WithCancel(request.WithRequestInfo(context.Background(), &request.RequestInfo{Verb: "create"}))
+			defer cancel()
+			if testcase.request {
+				attrs := admission.NewAttributesRecord(nil, nil, schema.GroupVersionKind{}, "", "", schema.GroupVersionResource{},
+					"", admission.Create, nil, false, nil)
+				if err := c.AdmissionControl.(admission.MutationInterface).Admit(ctx, attrs, nil); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			a.Reloaders.Reload()
+			if len(plugins) != 2 {
+				t.Fatalf("expected a plugin for the config and one for the reload, got %d", len(plugins))
+			}
+			if isClosed(plugins[0].stopCh) == testcase.request {
+				t.Errorf("expected the replaced plugin to be stopped after the requests using it")
+			}
+			if isClosed(plugins[1].stopCh) {
+				t.Errorf("expected the reloaded plugin to keep running")
+			}
+
+			cancel()
+			select {
+			case <-plugins[0].stopCh:
+			case <-time.After(5 * time.Second):
+				t.Errorf("expected the replaced plugin to be stopped")
+			}
+		})
+	}
+}
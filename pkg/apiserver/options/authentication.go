@@ -28,6 +28,7 @@ import (
 
 	kubeauthenticator "github.com/seanchann/apimaster/pkg/apiserver/authenticator"
 	authzmodes "github.com/seanchann/apimaster/pkg/apiserver/authorizer/modes"
+	"github.com/seanchann/apimaster/pkg/apiserver/reload"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/runtime/serializer"
 	"k8s.io/apimachinery/pkg/util/sets"
@@ -75,6 +76,9 @@ type BuiltInAuthenticationOptions struct {
 
 	TokenSuccessCacheTTL time.Duration
 	TokenFailureCacheTTL time.Duration
+
+	// Reloaders reloads the token auth file when it changes, nil disables the reloads.
+	Reloaders *reload.Reloaders
 }
 
 // AnonymousAuthenticationOptions contains anonymous authentication options for API Server
@@ -440,6 +444,7 @@ func (o *BuiltInAuthenticationOptions) ToAuthenticationConfig() (kubeauthenticat
 	ret := kubeauthenticator.Config{
 		TokenSuccessCacheTTL: o.TokenSuccessCacheTTL,
 		TokenFailureCacheTTL: o.TokenFailureCacheTTL,
+		Reloaders:            o.Reloaders,
 	}
 
 	if o.Anonymous != nil {
@@ -36,6 +36,7 @@ import (
 
 	"github.com/seanchann/apimaster/pkg/apiserver/authorizer"
 	authzmodes "github.com/seanchann/apimaster/pkg/apiserver/authorizer/modes"
+	"github.com/seanchann/apimaster/pkg/apiserver/reload"
 )
 
 const (
@@ -80,6 +81,9 @@ type BuiltInAuthorizationOptions struct {
 	AuthorizationConfigurationFile string
 
 	AreLegacyFlagsSet func() bool
+
+	// Reloaders reloads the ABAC policy file when it changes, nil disables the reloads.
+	Reloaders *reload.Reloaders
 }
 
 // NewBuiltInAuthorizationOptions create a BuiltInAuthorizationOptions with default value
@@ -301,6 +305,7 @@ func (o *BuiltInAuthorizationOptions) ToAuthorizationConfig() (*authorizer.Confi
 		WebhookAPIPath:      o.WebhookAPIPath,
 
 		AuthorizationConfiguration: authorizationConfiguration,
+		Reloaders:                  o.Reloaders,
 	}, nil
 }
 
@@ -0,0 +1,442 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	cliflag "k8s.io/component-base/cli/flag"
+	"sigs.k8s.io/yaml"
+
+	"github.com/seanchann/apimaster/pkg/apis/config"
+	configscheme "github.com/seanchann/apimaster/pkg/apis/config/scheme"
+	"github.com/seanchann/apimaster/pkg/apis/config/v1alpha1"
+)
+
+// ConfigFileOptions loads the settings of the flags from a versioned configuration file.
+type ConfigFileOptions struct {
+	// ConfigFile is an APIMasterConfiguration, the flags set on the command line override it.
+	ConfigFile string
+	// WriteConfigTo writes the effective configuration to a file and exits.
+	WriteConfigTo string
+
+	// flags are the flags of the server, set by APIMasterOptions.AddFlags.
+	flags *cliflag.NamedFlagSets
+}
+
+// NewConfigFileOptions create a ConfigFileOptions with default value
+func NewConfigFileOptions() *ConfigFileOptions {
+	return &ConfigFileOptions{}
+}
+
+// Validate validate config file input options
+func (s *ConfigFileOptions) Validate() []error {
+	if s == nil {
+		return nil
+	}
+
+	allErrors := []error{}
+	if len(s.ConfigFile) > 0 && len(s.WriteConfigTo) > 0 && filepath.Clean(s.ConfigFile) == filepath.Clean(s.WriteConfigTo) {
+		allErrors = append(allErrors, fmt.Errorf("--write-config-to must not overwrite --config"))
+	}
+	return allErrors
+}
+
+// AddFlags adds flags related to the config file to the specified FlagSet
+func (s *ConfigFileOptions) AddFlags(fs *pflag.FlagSet) {
+	if s == nil {
+		return
+	}
+
+	fs.StringVar(&s.ConfigFile, "config", s.ConfigFile, ""+
+		"The path to an APIMasterConfiguration file of version "+v1alpha1.SchemeGroupVersion.String()+
+		". The flags set on the command line override the settings of the file.")
+
+	fs.StringVar(&s.WriteConfigTo, "write-config-to", s.WriteConfigTo, ""+
+		"If set, write the effective configuration of the flags and --config to this file and exit.")
+}
+
+// changed returns true if the flag name was set on the command line.
+func (s *ConfigFileOptions) changed(name string) bool {
+	if s.flags == nil {
+		return false
+	}
+	for _, fs := range s.flags.FlagSets {
+		if fs.Changed(name) {
+			return true
+		}
+	}
+	return false
+}
+
+// ApplyConfigFile sets the options from the settings of --config, except those whose flags were
+// set on the command line. The options of the settings missing from the file are kept.
+func (o *APIMasterOptions) ApplyConfigFile() error {
+	if o.ConfigFile == nil || len(o.ConfigFile.ConfigFile) == 0 {
+		return nil
+	}
+
+	data, err := os.ReadFile(o.ConfigFile.ConfigFile)
+	if err != nil {
+		return fmt.Errorf("--config: %v", err)
+	}
+	cfg, err := LoadConfiguration(data)
+	if err != nil {
+		return fmt.Errorf("--config %s: %v", o.ConfigFile.ConfigFile, err)
+	}
+
+	fields, err := newConfigFields(data)
+	if err != nil {
+		return fmt.Errorf("--config %s: %v", o.ConfigFile.ConfigFile, err)
+	}
+
+	bindings := configBindings(cfg, o)
+	bound := map[string]bool{}
+	for _, binding := range bindings {
+		bound[binding.field] = true
+	}
+	// the settings of the flags this server doesn't register, e.g. the token file of a server
+	// without token authentication, would otherwise be ignored
+	for _, field := range fields.settings() {
+		if !bound[field] {
+			return fmt.Errorf("--config %s: %s is not supported by this server", o.ConfigFile.ConfigFile, field)
+		}
+	}
+
+	for _, binding := range bindings {
+		if !fields.has(binding.field) || o.ConfigFile.changed(binding.flag) {
+			continue
+		}
+		if err := binding.toOptions(); err != nil {
+			return fmt.Errorf("--config %s: %s: %v", o.ConfigFile.ConfigFile, binding.flag, err)
+		}
+	}
+	return nil
+}
+
+// Configuration returns the effective configuration of the options.
+func (o *APIMasterOptions) Configuration() *config.APIMasterConfiguration {
+	cfg := &config.APIMasterConfiguration{}
+	for _, binding := range configBindings(cfg, o) {
+		binding.fromOptions()
+	}
+	return cfg
+}
+
+// WriteConfigFile writes the effective configuration of the options to --write-config-to.
+func (o *APIMasterOptions) WriteConfigFile() error {
+	data, err := EncodeConfiguration(o.Configuration())
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(o.ConfigFile.WriteConfigTo, data, 0644)
+}
+
+// LoadConfiguration decodes a versioned APIMasterConfiguration, unknown fields are errors.
+func LoadConfiguration(data []byte) (*config.APIMasterConfiguration, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("empty config file")
+	}
+	obj, gvk, err := configscheme.Codecs.UniversalDecoder().Decode(data, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	cfg, ok := obj.(*config.APIMasterConfiguration)
+	if !ok {
+		return nil, fmt.Errorf("expected APIMasterConfiguration, got %v", gvk)
+	}
+	return cfg, nil
+}
+
+// EncodeConfiguration encodes an APIMasterConfiguration as YAML of the newest version.
+func EncodeConfiguration(cfg *config.APIMasterConfiguration) ([]byte, error) {
+	info, ok := runtime.SerializerInfoForMediaType(configscheme.Codecs.SupportedMediaTypes(), runtime.ContentTypeYAML)
+	if !ok {
+		return nil, fmt.Errorf("unable to locate encoder for %s", runtime.ContentTypeYAML)
+	}
+	encoder := configscheme.Codecs.EncoderForVersion(info.Serializer, v1alpha1.SchemeGroupVersion)
+	return runtime.Encode(encoder, cfg)
+}
+
+// configFields are the settings of a configuration file, nested by their sections.
+type configFields map[string]interface{}
+
+// newConfigFields returns the settings present in the configuration file data.
+func newConfigFields(data []byte) (configFields, error) {
+	jsonData, err := yaml.YAMLToJSON(data)
+	if err != nil {
+		return nil, err
+	}
+	fields := configFields{}
+	if err := json.Unmarshal(jsonData, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+// has returns true if the setting at path, e.g. serving.securePort, is present and not null.
+func (f configFields) has(path string) bool {
+	var value interface{} = map[string]interface{}(f)
+	for _, name := range strings.Split(path, ".") {
+		section, ok := value.(map[string]interface{})
+		if !ok {
+			return false
+		}
+		if value, ok = section[name]; !ok || value == nil {
+			return false
+		}
+	}
+	return true
+}
+
+// settings returns the paths of the settings present in the file, apiVersion and kind excluded.
+func (f configFields) settings() []string {
+	var paths []string
+	var walk func(prefix string, section map[string]interface{})
+	walk = func(prefix string, section map[string]interface{}) {
+		for name, value := range section {
+			switch value := value.(type) {
+			case nil:
+			case map[string]interface{}:
+				walk(prefix+name+".", value)
+			default:
+				paths = append(paths, prefix+name)
+			}
+		}
+	}
+	for name, value := range f {
+		if section, ok := value.(map[string]interface{}); ok {
+			walk(name+".", section)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// configBinding pairs a setting of the configuration file with the options of its flag.
+type configBinding struct {
+	flag string
+	// field is the path of the setting in the file, e.g. serving.securePort.
+	field       string
+	toOptions   func() error
+	fromOptions func()
+}
+
+// configBindings returns the bindings of every setting of cfg to the options of o.
+func configBindings(cfg *config.APIMasterConfiguration, o *APIMasterOptions) []configBinding {
+	bindings := []configBinding{
+		bindIP("bind-address", "serving.bindAddress", &cfg.Serving.BindAddress, &o.SecureServing.BindAddress),
+		bindInt("secure-port", "serving.securePort", &cfg.Serving.SecurePort, &o.SecureServing.BindPort),
+		bindString("cert-dir", "serving.certDirectory", &cfg.Serving.CertDirectory, &o.SecureServing.ServerCert.CertDirectory),
+		bindString("tls-cert-file", "serving.tlsCertFile", &cfg.Serving.TLSCertFile, &o.SecureServing.ServerCert.CertKey.CertFile),
+		bindString("tls-private-key-file", "serving.tlsPrivateKeyFile", &cfg.Serving.TLSPrivateKeyFile, &o.SecureServing.ServerCert.CertKey.KeyFile),
+		bindInt("insecure-port", "serving.insecurePort", &cfg.Serving.InsecurePort, &o.InsecureServing.BindPort),
+		bindInt("max-requests-inflight", "serving.maxRequestsInFlight", &cfg.Serving.MaxRequestsInFlight, &o.GenericServerRunOptions.MaxRequestsInFlight),
+		bindInt("max-mutating-requests-inflight", "serving.maxMutatingRequestsInFlight", &cfg.Serving.MaxMutatingRequestsInFlight, &o.GenericServerRunOptions.MaxMutatingRequestsInFlight),
+		bindDuration("request-timeout", "serving.requestTimeout", &cfg.Serving.RequestTimeout, &o.GenericServerRunOptions.RequestTimeout),
+
+		{
+			flag:  "storage-backend",
+			field: "storage.backend",
+			toOptions: func() error {
+				if len(cfg.Storage.Backend) == 0 {
+					return nil
+				}
+				return o.SetStorageBackend(StorageBackendType(cfg.Storage.Backend))
+			},
+			fromOptions: func() { cfg.Storage.Backend = string(o.Backend) },
+		},
+		bindStrings("storage-backend-overrides", "storage.backendOverrides", &cfg.Storage.BackendOverrides, &o.StorageRouting.Overrides),
+		bindStrings("resource-storage-versions", "storage.resourceStorageVersions", &cfg.Storage.ResourceStorageVersions, &o.StorageSerialization.ResourceStorageVersions),
+		{
+			flag:  "watch-cache",
+			field: "storage.watchCache",
+			toOptions: func() error {
+				o.WatchCache.SetEnableWatchCache(cfg.Storage.WatchCache)
+				return nil
+			},
+			fromOptions: func() { cfg.Storage.WatchCache = o.WatchCache.EnableWatchCache },
+		},
+		bindInt("default-watch-cache-size", "storage.defaultWatchCacheSize", &cfg.Storage.DefaultWatchCacheSize, &o.WatchCache.DefaultWatchCacheSize),
+		bindStrings("watch-cache-sizes", "storage.watchCacheSizes", &cfg.Storage.WatchCacheSizes, &o.WatchCache.WatchCacheSizes),
+		bindString("encryption-provider-config", "storage.encryptionProviderConfig", &cfg.Storage.EncryptionProviderConfig, &o.Encryption.EncryptionProviderConfigFilepath),
+		bindBool("enable-garbage-collector", "storage.enableGarbageCollector", &cfg.Storage.EnableGarbageCollector, &o.GarbageCollector.EnableGarbageCollection),
+
+		bindString("authentication-config", "authentication.configFile", &cfg.Authentication.ConfigFile, &o.Authentication.AuthenticationConfigFile),
+
+		bindStrings(authorizationModeFlag, "authorization.modes", &cfg.Authorization.Modes, &o.Authorization.Modes),
+		bindString(authorizationPolicyFileFlag, "authorization.policyFile", &cfg.Authorization.PolicyFile, &o.Authorization.PolicyFile),
+		bindString(authorizationConfigFlag, "authorization.configFile", &cfg.Authorization.ConfigFile, &o.Authorization.AuthorizationConfigurationFile),
+		bindString(authorizationWebhookConfigFileFlag, "authorization.webhook.configFile", &cfg.Authorization.Webhook.ConfigFile, &o.Authorization.WebhookConfigFile),
+		bindString(authorizationWebhookHost, "authorization.webhook.host", &cfg.Authorization.Webhook.Host, &o.Authorization.WebhookHost),
+		bindString(authorizationWebhookAPIPath, "authorization.webhook.apiPath", &cfg.Authorization.Webhook.APIPath, &o.Authorization.WebhookAPIPath),
+		bindString(authorizationWebhookVersionFlag, "authorization.webhook.version", &cfg.Authorization.Webhook.Version, &o.Authorization.WebhookVersion),
+		bindDuration(authorizationWebhookAuthorizedTTLFlag, "authorization.webhook.cacheAuthorizedTTL", &cfg.Authorization.Webhook.CacheAuthorizedTTL, &o.Authorization.WebhookCacheAuthorizedTTL),
+		bindDuration(authorizationWebhookUnauthorizedTTLFlag, "authorization.webhook.cacheUnauthorizedTTL", &cfg.Authorization.Webhook.CacheUnauthorizedTTL, &o.Authorization.WebhookCacheUnauthorizedTTL),
+
+		bindStrings("enable-admission-plugins", "admission.enablePlugins", &cfg.Admission.EnablePlugins, &o.Admission.GenericAdmission.EnablePlugins),
+		bindStrings("disable-admission-plugins", "admission.disablePlugins", &cfg.Admission.DisablePlugins, &o.Admission.GenericAdmission.DisablePlugins),
+		bindString("admission-control-config-file", "admission.configFile", &cfg.Admission.ConfigFile, &o.Admission.GenericAdmission.ConfigFile),
+	}
+
+	bindings = append(bindings, storageBackendBindings(cfg, o)...)
+
+	if o.Authentication.TokenFile != nil {
+		bindings = append(bindings,
+			bindString("token-auth-file", "authentication.tokenAuthFile", &cfg.Authentication.TokenAuthFile, &o.Authentication.TokenFile.TokenFile))
+	}
+	if o.Authentication.ClientCert != nil {
+		bindings = append(bindings,
+			bindString("client-ca-file", "authentication.clientCAFile", &cfg.Authentication.ClientCAFile, &o.Authentication.ClientCert.ClientCA))
+	}
+	if o.Authentication.WebHook != nil {
+		bindings = append(bindings,
+			bindString("authentication-token-webhook-host", "authentication.webhook.host", &cfg.Authentication.Webhook.Host, &o.Authentication.WebHook.Host),
+			bindString("authentication-token-webhook-apipath", "authentication.webhook.apiPath", &cfg.Authentication.Webhook.APIPath, &o.Authentication.WebHook.APIPath),
+			bindString("authentication-token-webhook-version", "authentication.webhook.version", &cfg.Authentication.Webhook.Version, &o.Authentication.WebHook.Version),
+			bindDuration("authentication-token-webhook-cache-ttl", "authentication.webhook.cacheTTL", &cfg.Authentication.Webhook.CacheTTL, &o.Authentication.WebHook.CacheTTL),
+		)
+	}
+	return bindings
+}
+
+// storageBackendBindings returns the bindings of the connection settings of the registered
+// storage backends, the settings of a backend that isn't registered are ignored.
+func storageBackendBindings(cfg *config.APIMasterConfiguration, o *APIMasterOptions) []configBinding {
+	var bindings []configBinding
+	if etcd, ok := o.StorageBackends[StorageBackendTypeEtcd].(*EtcdOptions); ok {
+		transport := &etcd.StorageConfig.Transport
+		bindings = append(bindings,
+			bindStrings("etcd-servers", "storage.etcd.servers", &cfg.Storage.Etcd.Servers, &transport.ServerList),
+			bindString("etcd-prefix", "storage.etcd.prefix", &cfg.Storage.Etcd.Prefix, &etcd.StorageConfig.Prefix),
+			bindString("etcd-cafile", "storage.etcd.caFile", &cfg.Storage.Etcd.CAFile, &transport.TrustedCAFile),
+			bindString("etcd-certfile", "storage.etcd.certFile", &cfg.Storage.Etcd.CertFile, &transport.CertFile),
+			bindString("etcd-keyfile", "storage.etcd.keyFile", &cfg.Storage.Etcd.KeyFile, &transport.KeyFile),
+		)
+	}
+	if mysql, ok := o.StorageBackends[StorageBackendTypeMysql].(*MysqlOptions); ok {
+		bindings = append(bindings,
+			bindStrings("mysql-servers", "storage.mysql.servers", &cfg.Storage.Mysql.Servers, &mysql.StorageConfig.Mysql.ServerList),
+			bindString("mysql-store", "storage.mysql.store", &cfg.Storage.Mysql.Store, &mysql.Store),
+			bindString("mysql-credentials-file", "storage.mysql.credentialsFile", &cfg.Storage.Mysql.CredentialsFile, &mysql.Mysql.CredentialsFile),
+			bindStrings("mysql-replicas", "storage.mysql.replicas", &cfg.Storage.Mysql.Replicas, &mysql.Mysql.ReplicaList),
+			bindString("mysql-ca-file", "storage.mysql.caFile", &cfg.Storage.Mysql.CAFile, &mysql.Mysql.TLS.CAFile),
+			bindString("mysql-cert-file", "storage.mysql.certFile", &cfg.Storage.Mysql.CertFile, &mysql.Mysql.TLS.CertFile),
+			bindString("mysql-key-file", "storage.mysql.keyFile", &cfg.Storage.Mysql.KeyFile, &mysql.Mysql.TLS.KeyFile),
+		)
+	}
+	if sqlite, ok := o.StorageBackends[StorageBackendTypeSqlite].(*SqliteOptions); ok {
+		bindings = append(bindings,
+			bindString("sqlite-dsn", "storage.sqlite.dsn", &cfg.Storage.Sqlite.DSN, &sqlite.StorageConfig.Sqlite.DSN))
+	}
+	if postgres, ok := o.StorageBackends[StorageBackendTypePostgres].(*PostgresOptions); ok {
+		bindings = append(bindings,
+			bindStrings("postgres-servers", "storage.postgres.servers", &cfg.Storage.Postgres.Servers, &postgres.Postgres.ServerList),
+			bindString("postgres-sslmode", "storage.postgres.sslMode", &cfg.Storage.Postgres.SSLMode, &postgres.Postgres.TLS.SSLMode),
+			bindString("postgres-ca-file", "storage.postgres.caFile", &cfg.Storage.Postgres.CAFile, &postgres.Postgres.TLS.CAFile),
+			bindString("postgres-cert-file", "storage.postgres.certFile", &cfg.Storage.Postgres.CertFile, &postgres.Postgres.TLS.CertFile),
+			bindString("postgres-key-file", "storage.postgres.keyFile", &cfg.Storage.Postgres.KeyFile, &postgres.Postgres.TLS.KeyFile),
+		)
+	}
+	if mongo, ok := o.StorageBackends[StorageBackendTypeMongoDB].(*MongoDBOptions); ok {
+		bindings = append(bindings,
+			bindStrings("mongo-servers", "storage.mongodb.servers", &cfg.Storage.MongoDB.Servers, &mongo.StorageConfig.Mongodb.ServerList))
+	}
+	if dynamo, ok := o.StorageBackends[StorageBackendTypeDynamoDB].(*DynamoDBOptions); ok {
+		bindings = append(bindings,
+			bindString("aws-region", "storage.dynamodb.region", &cfg.Storage.DynamoDB.Region, &dynamo.StorageConfig.AWSDynamoDB.Region),
+			bindString("aws-table", "storage.dynamodb.table", &cfg.Storage.DynamoDB.Table, &dynamo.StorageConfig.AWSDynamoDB.Table),
+		)
+	}
+	return bindings
+}
+
+func bindString(flag, field string, file, opt *string) configBinding {
+	return configBinding{
+		flag:        flag,
+		field:       field,
+		toOptions:   func() error { *opt = *file; return nil },
+		fromOptions: func() { *file = *opt },
+	}
+}
+
+func bindStrings(flag, field string, file, opt *[]string) configBinding {
+	return configBinding{
+		flag:        flag,
+		field:       field,
+		toOptions:   func() error { *opt = append([]string(nil), *file...); return nil },
+		fromOptions: func() { *file = append([]string(nil), *opt...) },
+	}
+}
+
+func bindBool(flag, field string, file, opt *bool) configBinding {
+	return configBinding{
+		flag:        flag,
+		field:       field,
+		toOptions:   func() error { *opt = *file; return nil },
+		fromOptions: func() { *file = *opt },
+	}
+}
+
+func bindInt(flag, field string, file *int32, opt *int) configBinding {
+	return configBinding{
+		flag:        flag,
+		field:       field,
+		toOptions:   func() error { *opt = int(*file); return nil },
+		fromOptions: func() { *file = int32(*opt) },
+	}
+}
+
+func bindDuration(flag, field string, file *metav1.Duration, opt *time.Duration) configBinding {
+	return configBinding{
+		flag:        flag,
+		field:       field,
+		toOptions:   func() error { *opt = file.Duration; return nil },
+		fromOptions: func() { *file = metav1.Duration{Duration: *opt} },
+	}
+}
+
+func bindIP(flag, field string, file *string, opt *net.IP) configBinding {
+	return configBinding{
+		flag:  flag,
+		field: field,
+		toOptions: func() error {
+			ip := net.ParseIP(*file)
+			if ip == nil {
+				return fmt.Errorf("invalid IP address %q", *file)
+			}
+			*opt = ip
+			return nil
+		},
+		fromOptions: func() {
+			*file = ""
+			if *opt != nil {
+				*file = opt.String()
+			}
+		},
+	}
+}
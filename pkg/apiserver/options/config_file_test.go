@@ -0,0 +1,362 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	cliflag "k8s.io/component-base/cli/flag"
+)
+
+func TestApplyConfigFile(t *testing.T) {
+	testCases := []struct {
+		name    string
+		backend StorageBackendType
+		// modify sets options programmatically before the file is applied
+		modify               func(o *APIMasterOptions)
+		config               string
+		args                 []string
+		check                func(t *testing.T, o *APIMasterOptions)
+		expectErrorSubString string
+	}{
+		{
+			name: "settings of the file",
+			config: `apiVersion: config.apimaster.io/v1alpha1
+kind: APIMasterConfiguration
+serving:
+  securePort: 7443
+  insecurePort: 0
+  requestTimeout: 30s
+storage:
+  backend: sqlite
+  watchCache: true
+  watchCacheSizes: ["events#0"]
+authorization:
+  modes: ["RBAC"]
+admission:
+  disablePlugins: ["NamespaceLifecycle"]
+`,
+			check: func(t *testing.T, o *APIMasterOptions) {
+				if o.SecureServing.BindPort != 7443 || o.InsecureServing.BindPort != 0 {
+					t.Errorf("expected ports 7443 and 0, got %d and %d", o.SecureServing.BindPort, o.InsecureServing.BindPort)
+				}
+				if o.GenericServerRunOptions.RequestTimeout != 30*time.Second {
+					t.Errorf("expected request timeout 30s, got %v", o.GenericServerRunOptions.RequestTimeout)
+				}
+				if o.Backend != StorageBackendTypeSqlite || o.Storage != o.StorageBackends[StorageBackendTypeSqlite] {
+					t.Errorf("expected backend sqlite, got %q", o.Backend)
+				}
+				if !o.WatchCache.EnableWatchCache || !reflect.DeepEqual(o.WatchCache.WatchCacheSizes, []string{"events#0"}) {
+					t.Errorf("unexpected watch cache options %#v", o.WatchCache)
+				}
+				if !reflect.DeepEqual(o.Authorization.Modes, []string{"RBAC"}) {
+					t.Errorf("expected modes [RBAC], got %v", o.Authorization.Modes)
+				}
+				if !reflect.DeepEqual(o.Admission.GenericAdmission.DisablePlugins, []string{"NamespaceLifecycle"}) {
+					t.Errorf("unexpected disabled plugins %v", o.Admission.GenericAdmission.DisablePlugins)
+				}
+			},
+		},
+		{
+			name: "flags override the file",
+			config: `apiVersion: config.apimaster.io/v1alpha1
+kind: APIMasterConfiguration
+serving:
+  securePort: 7443
+storage:
+  backend: sqlite
+  enableGarbageCollector: false
+`,
+			args: []string{"--secure-port=8443", "--storage-backend=memory"},
+			check: func(t *testing.T, o *APIMasterOptions) {
+				if o.SecureServing.BindPort != 8443 {
+					t.Errorf("expected secure port 8443, got %d", o.SecureServing.BindPort)
+				}
+				if o.Backend != StorageBackendTypeMemory {
+					t.Errorf("expected backend memory, got %q", o.Backend)
+				}
+				if o.GarbageCollector.EnableGarbageCollection {
+					t.Errorf("expected the garbage collector to be disabled")
+				}
+			},
+		},
+		{
+			name:   "partial file keeps the options",
+			modify: func(o *APIMasterOptions) { o.SecureServing.BindPort = 9443 },
+			config: `apiVersion: config.apimaster.io/v1alpha1
+kind: APIMasterConfiguration
+authorization:
+  modes: ["RBAC"]
+`,
+			check: func(t *testing.T, o *APIMasterOptions) {
+				if o.SecureServing.BindPort != 9443 {
+					t.Errorf("expected secure port 9443, got %d", o.SecureServing.BindPort)
+				}
+				defaults := newTestAPIMasterOptions(t, StorageBackendTypeMemory)
+				if o.InsecureServing.BindPort != defaults.InsecureServing.BindPort ||
+					o.GenericServerRunOptions.RequestTimeout != defaults.GenericServerRunOptions.RequestTimeout ||
+					o.GarbageCollector.EnableGarbageCollection != defaults.GarbageCollector.EnableGarbageCollection {
+					t.Errorf("expected the options missing from the file to keep their defaults")
+				}
+				if !reflect.DeepEqual(o.Authorization.Modes, []string{"RBAC"}) {
+					t.Errorf("expected modes [RBAC], got %v", o.Authorization.Modes)
+				}
+			},
+		},
+		{
+			name:    "watch cache default of etcd without watchCache",
+			backend: StorageBackendTypeEtcd,
+			config: `apiVersion: config.apimaster.io/v1alpha1
+kind: APIMasterConfiguration
+storage:
+  defaultWatchCacheSize: 50
+`,
+			check: func(t *testing.T, o *APIMasterOptions) {
+				if !o.WatchCache.EnableWatchCache {
+					t.Errorf("expected the watch cache of etcd to stay enabled")
+				}
+			},
+		},
+		{
+			name: "watch cache default of the backend of the file",
+			config: `apiVersion: config.apimaster.io/v1alpha1
+kind: APIMasterConfiguration
+storage:
+  backend: etcd
+`,
+			check: func(t *testing.T, o *APIMasterOptions) {
+				if o.Backend != StorageBackendTypeEtcd || !o.WatchCache.EnableWatchCache {
+					t.Errorf("expected backend etcd with the watch cache enabled, got %q and %v", o.Backend, o.WatchCache.EnableWatchCache)
+				}
+			},
+		},
+		{
+			name: "backend connection settings of the file",
+			config: `apiVersion: config.apimaster.io/v1alpha1
+kind: APIMasterConfiguration
+storage:
+  backend: mysql
+  etcd:
+    servers: ["https://etcd-0:2379"]
+    prefix: /apimaster
+    caFile: /etc/etcd/ca.crt
+  mysql:
+    servers: ["mysql-0:3306"]
+    store: kv
+    credentialsFile: /etc/mysql/credentials
+    replicas: ["mysql-1:3306"]
+    caFile: /etc/mysql/ca.crt
+  sqlite:
+    dsn: /var/lib/apimaster/apimaster.db
+  postgres:
+    servers: ["postgres-0:5432"]
+    sslMode: verify-full
+  mongodb:
+    servers: ["mongo-0:27017"]
+  dynamodb:
+    region: eu-west-1
+    table: apimaster
+`,
+			check: func(t *testing.T, o *APIMasterOptions) {
+				etcd := o.StorageBackends[StorageBackendTypeEtcd].(*EtcdOptions)
+				if !reflect.DeepEqual(etcd.StorageConfig.Transport.ServerList, []string{"https://etcd-0:2379"}) ||
+					etcd.StorageConfig.Prefix != "/apimaster" || etcd.StorageConfig.Transport.TrustedCAFile != "/etc/etcd/ca.crt" {
+					t.Errorf("unexpected etcd options %#v", etcd.StorageConfig.Transport)
+				}
+				mysql := o.StorageBackends[StorageBackendTypeMysql].(*MysqlOptions)
+				if !reflect.DeepEqual(mysql.StorageConfig.Mysql.ServerList, []string{"mysql-0:3306"}) || mysql.Store != MysqlStoreKV ||
+					mysql.Mysql.CredentialsFile != "/etc/mysql/credentials" || !reflect.DeepEqual(mysql.Mysql.ReplicaList, []string{"mysql-1:3306"}) ||
+					mysql.Mysql.TLS.CAFile != "/etc/mysql/ca.crt" {
+					t.Errorf("unexpected mysql options %#v", mysql.Mysql)
+				}
+				if dsn := o.StorageBackends[StorageBackendTypeSqlite].(*SqliteOptions).StorageConfig.Sqlite.DSN; dsn != "/var/lib/apimaster/apimaster.db" {
+					t.Errorf("expected sqlite dsn /var/lib/apimaster/apimaster.db, got %q", dsn)
+				}
+				postgres := o.StorageBackends[StorageBackendTypePostgres].(*PostgresOptions)
+				if !reflect.DeepEqual(postgres.Postgres.ServerList, []string{"postgres-0:5432"}) || postgres.Postgres.TLS.SSLMode != "verify-full" {
+					t.Errorf("unexpected postgres options %#v", postgres.Postgres)
+				}
+				if servers := o.StorageBackends[StorageBackendTypeMongoDB].(*MongoDBOptions).StorageConfig.Mongodb.ServerList; !reflect.DeepEqual(servers, []string{"mongo-0:27017"}) {
+					t.Errorf("expected mongodb servers [mongo-0:27017], got %v", servers)
+				}
+				dynamo := o.StorageBackends[StorageBackendTypeDynamoDB].(*DynamoDBOptions).StorageConfig.AWSDynamoDB
+				if dynamo.Region != "eu-west-1" || dynamo.Table != "apimaster" {
+					t.Errorf("expected dynamodb region eu-west-1 and table apimaster, got %q and %q", dynamo.Region, dynamo.Table)
+				}
+			},
+		},
+		{
+			name: "flags override the backend settings of the file",
+			config: `apiVersion: config.apimaster.io/v1alpha1
+kind: APIMasterConfiguration
+storage:
+  mysql:
+    servers: ["mysql-0:3306"]
+`,
+			args: []string{"--mysql-servers=mysql-1:3306"},
+			check: func(t *testing.T, o *APIMasterOptions) {
+				mysql := o.StorageBackends[StorageBackendTypeMysql].(*MysqlOptions)
+				if !reflect.DeepEqual(mysql.StorageConfig.Mysql.ServerList, []string{"mysql-1:3306"}) {
+					t.Errorf("expected mysql servers [mysql-1:3306], got %v", mysql.StorageConfig.Mysql.ServerList)
+				}
+			},
+		},
+		{
+			name: "authentication files of the file",
+			modify: func(o *APIMasterOptions) {
+				o.Authentication.WithTokenFile().WithClientCert()
+			},
+			config: `apiVersion: config.apimaster.io/v1alpha1
+kind: APIMasterConfiguration
+authentication:
+  tokenAuthFile: /etc/apimaster/tokens.csv
+  clientCAFile: /etc/apimaster/client-ca.crt
+`,
+			check: func(t *testing.T, o *APIMasterOptions) {
+				if o.Authentication.TokenFile.TokenFile != "/etc/apimaster/tokens.csv" {
+					t.Errorf("expected token auth file /etc/apimaster/tokens.csv, got %q", o.Authentication.TokenFile.TokenFile)
+				}
+				if o.Authentication.ClientCert.ClientCA != "/etc/apimaster/client-ca.crt" {
+					t.Errorf("expected client ca file /etc/apimaster/client-ca.crt, got %q", o.Authentication.ClientCert.ClientCA)
+				}
+			},
+		},
+		{
+			name: "authentication file of an authenticator the server doesn't enable",
+			config: `apiVersion: config.apimaster.io/v1alpha1
+kind: APIMasterConfiguration
+authentication:
+  tokenAuthFile: /etc/apimaster/tokens.csv
+`,
+			expectErrorSubString: "authentication.tokenAuthFile is not supported by this server",
+		},
+		{
+			name: "unknown field",
+			config: `apiVersion: config.apimaster.io/v1alpha1
+kind: APIMasterConfiguration
+serving:
+  port: 7443
+`,
+			expectErrorSubString: `unknown field "serving.port"`,
+		},
+		{
+			name: "unknown version",
+			config: `apiVersion: config.apimaster.io/v1
+kind: APIMasterConfiguration
+`,
+			expectErrorSubString: "no kind \"APIMasterConfiguration\" is registered",
+		},
+		{
+			name: "invalid bind address",
+			config: `apiVersion: config.apimaster.io/v1alpha1
+kind: APIMasterConfiguration
+serving:
+  bindAddress: localhost
+`,
+			expectErrorSubString: "bind-address: invalid IP address",
+		},
+		{
+			name: "unknown storage backend",
+			config: `apiVersion: config.apimaster.io/v1alpha1
+kind: APIMasterConfiguration
+storage:
+  backend: unknown
+`,
+			expectErrorSubString: `unknown storage backend "unknown"`,
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, []byte(testcase.config), 0644); err != nil {
+				t.Fatal(err)
+			}
+
+			backend := testcase.backend
+			if backend == "" {
+				backend = StorageBackendTypeMemory
+			}
+			o := newTestAPIMasterOptions(t, backend)
+			if testcase.modify != nil {
+				testcase.modify(o)
+			}
+			fss := cliflag.NamedFlagSets{}
+			o.AddFlags(&fss)
+			fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			for _, f := range fss.FlagSets {
+				fs.AddFlagSet(f)
+			}
+			if err := fs.Parse(append([]string{"--config=" + path}, testcase.args...)); err != nil {
+				t.Fatal(err)
+			}
+
+			err := o.ApplyConfigFile()
+			if len(testcase.expectErrorSubString) == 0 {
+				if err != nil {
+					t.Fatalf("expected no error, got %v", err)
+				}
+				testcase.check(t, o)
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), testcase.expectErrorSubString) {
+				t.Errorf("expected error containing %q, got %v", testcase.expectErrorSubString, err)
+			}
+		})
+	}
+}
+
+func TestWriteConfigFile(t *testing.T) {
+	o := newTestAPIMasterOptions(t, StorageBackendTypeMemory)
+	o.Authentication.WithTokenFile().WithClientCert()
+	o.WatchCache.EnableWatchCache = true
+	o.Authorization.Modes = []string{"RBAC", "Webhook"}
+	o.Authentication.TokenFile.TokenFile = "/etc/apimaster/tokens.csv"
+	o.Authentication.ClientCert.ClientCA = "/etc/apimaster/client-ca.crt"
+	o.StorageBackends[StorageBackendTypeMysql].(*MysqlOptions).StorageConfig.Mysql.ServerList = []string{"mysql-0:3306"}
+	o.StorageBackends[StorageBackendTypePostgres].(*PostgresOptions).Postgres.TLS.SSLMode = "verify-full"
+	o.StorageBackends[StorageBackendTypeSqlite].(*SqliteOptions).StorageConfig.Sqlite.DSN = "/var/lib/apimaster/apimaster.db"
+	o.ConfigFile.WriteConfigTo = filepath.Join(t.TempDir(), "config.yaml")
+	if err := o.WriteConfigFile(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(o.ConfigFile.WriteConfigTo)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "admission:") || !strings.Contains(string(data), "apiVersion: config.apimaster.io/v1alpha1") {
+		t.Errorf("unexpected config file:\n%s", data)
+	}
+
+	// the written file configures the default options like the written ones
+	restored := newTestAPIMasterOptions(t, StorageBackendTypeSqlite)
+	restored.Authentication.WithTokenFile().WithClientCert()
+	restored.ConfigFile.ConfigFile = o.ConfigFile.WriteConfigTo
+	if err := restored.ApplyConfigFile(); err != nil {
+		t.Fatal(err)
+	}
+	if expected, got := o.Configuration(), restored.Configuration(); !reflect.DeepEqual(expected, got) {
+		t.Errorf("expected %#v, got %#v", expected, got)
+	}
+}
@@ -0,0 +1,129 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"fmt"
+
+	"github.com/spf13/pflag"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/server"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	typedcorev1 "k8s.io/client-go/kubernetes/typed/core/v1"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+
+	"github.com/seanchann/apimaster/pkg/apiserver/reload"
+)
+
+// ConfigReloadOptions reloads the ABAC policy file, the token auth file, the admission
+// config file and the unix socket peer config on SIGHUP, and when they change if WatchFiles is set.
+type ConfigReloadOptions struct {
+	// WatchFiles reloads the config files when they change.
+	WatchFiles bool
+	// Reloaders are shared with the authentication, authorization, admission and unix socket
+	// options, which register their config files while the server is built.
+	Reloaders *reload.Reloaders
+}
+
+// NewConfigReloadOptions create a ConfigReloadOptions with default value
+func NewConfigReloadOptions() *ConfigReloadOptions {
+	return &ConfigReloadOptions{
+		WatchFiles: false,
+		Reloaders:  reload.NewReloaders(),
+	}
+}
+
+// Validate validate config reload input options
+func (s *ConfigReloadOptions) Validate() []error {
+	return nil
+}
+
+// AddFlags adds flags related to config reload to the specified FlagSet
+func (s *ConfigReloadOptions) AddFlags(fs *pflag.FlagSet) {
+	if s == nil {
+		return
+	}
+
+	fs.BoolVar(&s.WatchFiles, "watch-config-files", s.WatchFiles, ""+
+		"Reload --authorization-policy-file, --token-auth-file, --admission-control-config-file and "+
+		"--unix-socket-peer-config when they change. "+
+		"They are always reloaded on SIGHUP, a reload that fails validation keeps the previous config.")
+}
+
+// ApplyTo starts the reloads after the server started. The events of the reloads are
+// logged and created through the loopback client if the server serves core/v1 events,
+// unless Reloaders.Recorder is set.
+func (s *ConfigReloadOptions) ApplyTo(c *server.Config) error {
+	if s == nil || s.Reloaders == nil {
+		return nil
+	}
+
+	reload.RegisterMetrics()
+	s.Reloaders.Watch = s.WatchFiles
+
+	var broadcaster record.EventBroadcaster
+	if s.Reloaders.Recorder == nil {
+		broadcaster = record.NewBroadcaster()
+		s.Reloaders.Recorder = broadcaster.NewRecorder(scheme.Scheme, corev1.EventSource{Component: "apimaster"})
+	}
+	return c.AddPostStartHook("start-config-file-reload", func(hookContext server.PostStartHookContext) error {
+		if broadcaster != nil {
+			broadcaster.StartStructuredLogging(0)
+			if err := startRecordingEvents(broadcaster, hookContext.LoopbackClientConfig); err != nil {
+				klog.Warningf("The config file reloads are only logged: %v", err)
+			}
+			go func() {
+				<-hookContext.StopCh
+				broadcaster.Shutdown()
+			}()
+		}
+		return s.Reloaders.Run(wait.ContextForChannel(hookContext.StopCh))
+	})
+}
+
+// startRecordingEvents creates the events of broadcaster through the loopback client, if
+// the server serves core/v1 events.
+func startRecordingEvents(broadcaster record.EventBroadcaster, loopbackClientConfig *rest.Config) error {
+	client, err := kubernetes.NewForConfig(loopbackClientConfig)
+	if err != nil {
+		return err
+	}
+	resources, err := client.Discovery().ServerResourcesForGroupVersion(corev1.SchemeGroupVersion.String())
+	if err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	if resources == nil || !servesResource(resources.APIResources, "events") {
+		return fmt.Errorf("the server does not serve %s events", corev1.SchemeGroupVersion)
+	}
+	broadcaster.StartRecordingToSink(&typedcorev1.EventSinkImpl{Interface: client.CoreV1().Events("")})
+	return nil
+}
+
+func servesResource(resources []metav1.APIResource, name string) bool {
+	for _, resource := range resources {
+		if resource.Name == name {
+			return true
+		}
+	}
+	return false
+}
@@ -1,48 +1,105 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
 package options
 
 import (
 	"fmt"
 
-	"k8s.io/apiserver/pkg/storage/storagebackend"
-
 	"github.com/spf13/pflag"
+	"k8s.io/apiserver/pkg/server"
+	serverstorage "k8s.io/apiserver/pkg/server/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
 )
 
+// DynamoDBOptions aws dynamodb as a backend
 type DynamoDBOptions struct {
-	StorageConfig *storagebackend.AWSDynamoDBConfig
+	StorageConfig           storagebackend.Config
+	DefaultStorageMediaType string
 }
 
-// func NewDynamoDBOptions() *DynamoDBOptions {
-// 	dynamo := &DynamoDBOptions{
-// 		StorageConfig: &s.StorageConfig.AWSDynamoDB,
-// 	}
-// 	s.Dynamodb = dynamo
+// NewDynamoDBOptions create dynamodb options
+func NewDynamoDBOptions(backendConfig *storagebackend.Config) *DynamoDBOptions {
+	dynamo := &DynamoDBOptions{
+		StorageConfig:           *backendConfig,
+		DefaultStorageMediaType: "application/json",
+	}
+	dynamo.StorageConfig.Type = storagebackend.StorageTypeDynamoDB
 
-// 	return dynamo
-// }
+	return dynamo
+}
 
+// Validate validate dynamodb input options
 func (s *DynamoDBOptions) Validate() []error {
 	allErrors := []error{}
-	if len(s.StorageConfig.Region) == 0 {
+	if len(s.StorageConfig.AWSDynamoDB.Region) == 0 {
 		allErrors = append(allErrors, fmt.Errorf("--aws-region must be specified"))
 	}
+	if len(s.StorageConfig.AWSDynamoDB.AccessID) != 0 && len(s.StorageConfig.AWSDynamoDB.AccessKey) == 0 {
+		allErrors = append(allErrors, fmt.Errorf("--aws-cred-accesskey must be specified with --aws-cred-accessid"))
+	}
+	if len(s.StorageConfig.AWSDynamoDB.AccessKey) != 0 && len(s.StorageConfig.AWSDynamoDB.AccessID) == 0 {
+		allErrors = append(allErrors, fmt.Errorf("--aws-cred-accessid must be specified with --aws-cred-accesskey"))
+	}
 	return allErrors
 }
 
-// AddMysqlStorageFlags adds flags related to mysql storage for a specific APIServer to the specified FlagSet
+// AddFlags adds flags related to dynamodb storage for a specific APIServer to the specified FlagSet
+// you must set storage-backend flag with dynamodb.
 func (s *DynamoDBOptions) AddFlags(fs *pflag.FlagSet) {
-	fs.StringVar(&s.StorageConfig.Region, "aws-region", s.StorageConfig.Region, ""+
+	fs.StringVar(&s.StorageConfig.AWSDynamoDB.Region, "aws-region", s.StorageConfig.AWSDynamoDB.Region, ""+
 		"specify the region where the session to connection.")
 
-	fs.StringVar(&s.StorageConfig.Table, "aws-table", s.StorageConfig.Table, ""+
+	fs.StringVar(&s.StorageConfig.AWSDynamoDB.Table, "aws-table", s.StorageConfig.AWSDynamoDB.Table, ""+
 		"specify the table name. default(program name)")
 
-	fs.StringVar(&s.StorageConfig.Token, "aws-cred-token", s.StorageConfig.Table, ""+
+	fs.StringVar(&s.StorageConfig.AWSDynamoDB.Token, "aws-cred-token", s.StorageConfig.AWSDynamoDB.Token, ""+
 		"specify the token for credentials.")
 
-	fs.StringVar(&s.StorageConfig.AccessID, "aws-cred-accessid", s.StorageConfig.AccessID, ""+
+	fs.StringVar(&s.StorageConfig.AWSDynamoDB.AccessID, "aws-cred-accessid", s.StorageConfig.AWSDynamoDB.AccessID, ""+
 		"specify the access id for credentials.")
 
-	fs.StringVar(&s.StorageConfig.AccessKey, "aws-cred-accesskey", s.StorageConfig.AccessKey, ""+
+	fs.StringVar(&s.StorageConfig.AWSDynamoDB.AccessKey, "aws-cred-accesskey", s.StorageConfig.AWSDynamoDB.AccessKey, ""+
 		"specify the access key for credentials.")
 }
+
+// BackendConfig returns the storage config and default media type of dynamodb
+func (s *DynamoDBOptions) BackendConfig() (*storagebackend.Config, string) {
+	return &s.StorageConfig, s.DefaultStorageMediaType
+}
+
+// CopyStorageBackend implements StorageBackendCopier
+func (s *DynamoDBOptions) CopyStorageBackend() StorageBackend {
+	return &DynamoDBOptions{
+		StorageConfig:           copyStorageConfig(s.StorageConfig),
+		DefaultStorageMediaType: s.DefaultStorageMediaType,
+	}
+}
+
+// ApplyTo apply to server
+func (s *DynamoDBOptions) ApplyTo(c *server.Config) error {
+	if s == nil {
+		return nil
+	}
+	c.RESTOptionsGetter = &StorageConfigRestOptionsFactory{StorageConfig: s.StorageConfig, ResourceTransformers: c.ResourceTransformers}
+	return nil
+}
+
+// ApplyWithStorageFactoryTo apply to storage factory
+func (s *DynamoDBOptions) ApplyWithStorageFactoryTo(factory serverstorage.StorageFactory, c *server.Config) error {
+	c.RESTOptionsGetter = &StorageFactoryRestOptionsFactory{StorageFactory: factory, ResourceTransformers: c.ResourceTransformers}
+	return nil
+}
@@ -0,0 +1,122 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+)
+
+func TestDynamoDBOptionsValidate(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		config               storagebackend.AWSDynamoDBConfig
+		expectErrorSubString string
+	}{
+		{
+			name:   "region only",
+			config: storagebackend.AWSDynamoDBConfig{Region: "us-west-2"},
+		},
+		{
+			name: "access id and key",
+			config: storagebackend.AWSDynamoDBConfig{
+				Region:    "us-west-2",
+				AccessID:  "fake",
+				AccessKey: "fake",
+			},
+		},
+		{
+			name:                 "missing region",
+			expectErrorSubString: "--aws-region must be specified",
+		},
+		{
+			name:                 "access id without access key",
+			config:               storagebackend.AWSDynamoDBConfig{Region: "us-west-2", AccessID: "fake"},
+			expectErrorSubString: "--aws-cred-accesskey must be specified",
+		},
+		{
+			name:                 "access key without access id",
+			config:               storagebackend.AWSDynamoDBConfig{Region: "us-west-2", AccessKey: "fake"},
+			expectErrorSubString: "--aws-cred-accessid must be specified",
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			o := NewDynamoDBOptions(storagebackend.NewDefaultConfig("dynamodb", nil))
+			o.StorageConfig.AWSDynamoDB = testcase.config
+
+			errs := o.Validate()
+			if len(testcase.expectErrorSubString) == 0 {
+				if len(errs) != 0 {
+					t.Errorf("expected no errors, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), testcase.expectErrorSubString) {
+				t.Errorf("expected error containing %q, got %v", testcase.expectErrorSubString, errs)
+			}
+		})
+	}
+}
+
+func TestDynamoDBOptionsAddFlags(t *testing.T) {
+	var args = []string{
+		"--aws-region=us-west-2",
+		"--aws-table=apimaster",
+		"--aws-cred-token=token",
+		"--aws-cred-accessid=id",
+		"--aws-cred-accesskey=key",
+	}
+
+	opts := NewDynamoDBOptions(storagebackend.NewDefaultConfig("dynamodb", nil))
+	pf := pflag.NewFlagSet("test-dynamodb-opts", pflag.ContinueOnError)
+	opts.AddFlags(pf)
+
+	if err := pf.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := storagebackend.AWSDynamoDBConfig{
+		Region:    "us-west-2",
+		Table:     "apimaster",
+		Token:     "token",
+		AccessID:  "id",
+		AccessKey: "key",
+	}
+	if !reflect.DeepEqual(opts.StorageConfig.AWSDynamoDB, expected) {
+		t.Errorf("expected %#v, got %#v", expected, opts.StorageConfig.AWSDynamoDB)
+	}
+}
+
+func TestDynamoDBOptionsTokenDefault(t *testing.T) {
+	opts := NewDynamoDBOptions(storagebackend.NewDefaultConfig("dynamodb", nil))
+	opts.StorageConfig.AWSDynamoDB.Table = "apimaster"
+	pf := pflag.NewFlagSet("test-dynamodb-opts", pflag.ContinueOnError)
+	opts.AddFlags(pf)
+
+	if err := pf.Parse([]string{"--aws-region=us-west-2"}); err != nil {
+		t.Fatal(err)
+	}
+	if opts.StorageConfig.AWSDynamoDB.Token != "" {
+		t.Errorf("expected empty token by default, got %q", opts.StorageConfig.AWSDynamoDB.Token)
+	}
+}
@@ -0,0 +1,377 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/api/meta"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/server"
+	"k8s.io/apiserver/pkg/server/healthz"
+	"k8s.io/apiserver/pkg/server/options/encryptionconfig"
+	encryptionconfigcontroller "k8s.io/apiserver/pkg/server/options/encryptionconfig/controller"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+	"k8s.io/apiserver/pkg/storage/storagebackend/factory"
+	storagevalue "k8s.io/apiserver/pkg/storage/value"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/seanchann/apimaster/pkg/api/legacyscheme"
+	"github.com/seanchann/apimaster/pkg/storage/resourcestore"
+)
+
+// EncryptionOptions encryption at rest for every storage backend
+type EncryptionOptions struct {
+	EncryptionProviderConfigFilepath        string
+	EncryptionProviderConfigAutomaticReload bool
+	// RewriteResources are written back with the current write key after start,
+	// "group/resource" or "resource" for the legacy group.
+	RewriteResources []string
+}
+
+// NewEncryptionOptions create encryption options
+func NewEncryptionOptions() *EncryptionOptions {
+	return &EncryptionOptions{}
+}
+
+// Validate validate encryption input options
+func (s *EncryptionOptions) Validate() []error {
+	if s == nil {
+		return nil
+	}
+
+	allErrors := []error{}
+	if s.EncryptionProviderConfigAutomaticReload && len(s.EncryptionProviderConfigFilepath) == 0 {
+		allErrors = append(allErrors, fmt.Errorf("--encryption-provider-config-automatic-reload must be set with --encryption-provider-config"))
+	}
+	if len(s.RewriteResources) > 0 && len(s.EncryptionProviderConfigFilepath) == 0 {
+		allErrors = append(allErrors, fmt.Errorf("--encryption-provider-rewrite-resources must be set with --encryption-provider-config"))
+	}
+	if _, err := s.Resources(); err != nil {
+		allErrors = append(allErrors, err)
+	}
+	return allErrors
+}
+
+// Resources returns the parsed rewrite resources.
+func (s *EncryptionOptions) Resources() ([]schema.GroupResource, error) {
+	resources := []schema.GroupResource{}
+	for _, resource := range s.RewriteResources {
+		parts := strings.Split(strings.TrimSpace(resource), "/")
+		switch {
+		case len(parts) == 1 && len(parts[0]) > 0:
+			resources = append(resources, schema.GroupResource{Resource: parts[0]})
+		case len(parts) == 2 && len(parts[1]) > 0:
+			resources = append(resources, schema.GroupResource{Group: parts[0], Resource: parts[1]})
+		default:
+			return nil, fmt.Errorf("--encryption-provider-rewrite-resources %q must be group/resource or resource", resource)
+		}
+	}
+	return resources, nil
+}
+
+// AddFlags adds flags related to encryption at rest for a specific APIServer to the specified FlagSet
+func (s *EncryptionOptions) AddFlags(fs *pflag.FlagSet) {
+	if s == nil {
+		return
+	}
+
+	fs.StringVar(&s.EncryptionProviderConfigFilepath, "encryption-provider-config", s.EncryptionProviderConfigFilepath,
+		"The file containing configuration for encryption providers (aescbc, aesgcm, secretbox or kms) "+
+			"to be used for storing resources in any storage backend.")
+
+	fs.BoolVar(&s.EncryptionProviderConfigAutomaticReload, "encryption-provider-config-automatic-reload", s.EncryptionProviderConfigAutomaticReload,
+		"Determines if the file set by --encryption-provider-config should be automatically reloaded if the disk contents change.")
+
+	fs.StringSliceVar(&s.RewriteResources, "encryption-provider-rewrite-resources", s.RewriteResources, ""+
+		"A list of group/resource (resource for the legacy group) whose objects are written back after start, "+
+		"so that objects stored with an old key are encrypted with the current write key.")
+}
+
+// ApplyTo sets the resource transformers of the server config, they are used by
+// the rest options getter of every storage backend.
+func (s *EncryptionOptions) ApplyTo(c *server.Config) (err error) {
+	if s == nil || len(s.EncryptionProviderConfigFilepath) == 0 {
+		return nil
+	}
+
+	ctxServer := wait.ContextForChannel(c.DrainedNotify())
+	ctxTransformers, closeTransformers := context.WithCancel(ctxServer)
+	defer func() {
+		// in case of error, we want to close partially initialized (if any) transformers
+		if err != nil {
+			closeTransformers()
+		}
+	}()
+
+	encryptionConfiguration, err := encryptionconfig.LoadEncryptionConfig(ctxTransformers, s.EncryptionProviderConfigFilepath, s.EncryptionProviderConfigAutomaticReload, c.APIServerID)
+	if err != nil {
+		return err
+	}
+
+	if s.EncryptionProviderConfigAutomaticReload {
+		// with reload=true we will always have 1 health check
+		if len(encryptionConfiguration.HealthChecks) != 1 {
+			return fmt.Errorf("failed to start kms encryption config hot reload controller. only 1 health check should be available when reload is enabled")
+		}
+
+		// the dynamic transformers take ownership of the transformers and their cancellation
+		dynamicTransformers := encryptionconfig.NewDynamicTransformers(encryptionConfiguration.Transformers, encryptionConfiguration.HealthChecks[0], closeTransformers, encryptionConfiguration.KMSCloseGracePeriod)
+		err = c.AddPostStartHook("start-encryption-provider-config-automatic-reload", func(_ server.PostStartHookContext) error {
+			controller := encryptionconfigcontroller.NewDynamicEncryptionConfiguration(
+				"encryption-provider-config-automatic-reload-controller",
+				s.EncryptionProviderConfigFilepath,
+				dynamicTransformers,
+				encryptionConfiguration.EncryptionFileContentHash,
+				c.APIServerID,
+			)
+			go controller.Run(ctxServer)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("failed to add post start hook for kms encryption config hot reload controller: %w", err)
+		}
+
+		c.ResourceTransformers = dynamicTransformers
+		addHealthChecksWithoutLivez(c, dynamicTransformers)
+	} else {
+		c.ResourceTransformers = encryptionconfig.StaticTransformers(encryptionConfiguration.Transformers)
+		addHealthChecksWithoutLivez(c, encryptionConfiguration.HealthChecks...)
+	}
+
+	resources, err := s.Resources()
+	if err != nil || len(resources) == 0 {
+		return err
+	}
+	return c.AddPostStartHook("encryption-provider-rewrite-resources", func(hookContext server.PostStartHookContext) error {
+		// the storage backend has set the rest options getter by now
+		go rewriteResources(wait.ContextForChannel(hookContext.StopCh), c, resources)
+		return nil
+	})
+}
+
+// rewriteResources writes back the objects of resources, so those stored with
+// an old key are encrypted with the current write key.
+func rewriteResources(ctx context.Context, c *server.Config, resources []schema.GroupResource) {
+	for _, gr := range resources {
+		store, err := resourcestore.Open(c.RESTOptionsGetter, legacyscheme.Scheme, gr)
+		if err != nil {
+			klog.Errorf("Failed to open storage of %v for rewriting: %v", gr, err)
+			continue
+		}
+		count, err := store.Rewrite(ctx)
+		store.Destroy()
+		if err != nil {
+			klog.Errorf("Failed to rewrite %v: %v", gr, err)
+			continue
+		}
+		klog.Infof("Rewrote %d %v with the current encryption key", count, gr)
+	}
+}
+
+func addHealthChecksWithoutLivez(c *server.Config, healthChecks ...healthz.HealthChecker) {
+	c.HealthzChecks = append(c.HealthzChecks, healthChecks...)
+	c.ReadyzChecks = append(c.ReadyzChecks, healthChecks...)
+}
+
+// codecTransformingRestOptionsFactory rest options factory for the stores of the apiserver that store
+// the encoded objects as is, e.g. sqlite and the legacy mysql store. The transformer of a resource
+// is applied by its codec, so the stored rows are encrypted whether the store applies it or not.
+type codecTransformingRestOptionsFactory struct {
+	delegate generic.RESTOptionsGetter
+}
+
+// GetRESTOptions impl generic.RESTOptions
+func (f *codecTransformingRestOptionsFactory) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	ret, err := f.delegate.GetRESTOptions(resource)
+	if err != nil || ret.StorageConfig.Transformer == nil || ret.StorageConfig.Codec == nil {
+		return ret, err
+	}
+	ret.Decorator = keyBoundTransformingStorage(ret.Decorator, ret.StorageConfig.Transformer, resource)
+	return ret, nil
+}
+
+// keyBoundTransformingStorage returns a decorator, whose storage is created with a codec that
+// transforms the objects with their key as authenticated data, and whose reads fail for an
+// object that is not stored at its own key.
+func keyBoundTransformingStorage(decorator generic.StorageDecorator, transformer storagevalue.Transformer, resource schema.GroupResource) generic.StorageDecorator {
+	return func(
+		config *storagebackend.ConfigForResource,
+		resourcePrefix string,
+		keyFunc func(obj runtime.Object) (string, error),
+		newFunc func() runtime.Object,
+		newListFunc func() runtime.Object,
+		getAttrsFunc storage.AttrFunc,
+		trigger storage.IndexerFuncs,
+		indexers *cache.Indexers) (storage.Interface, factory.DestroyFunc, error) {
+		transformed := *config
+		transformed.Codec = &transformingCodec{
+			Codec:       config.Codec,
+			transformer: transformer,
+			keyFunc:     keyFunc,
+			resourceCtx: storagevalue.DefaultContext(resource.String()),
+		}
+		// the codec transforms the objects already, a store applying the transformer must not do it twice
+		transformed.Transformer = identityTransformer{}
+		s, d, err := decorator(&transformed, resourcePrefix, keyFunc, newFunc, newListFunc, getAttrsFunc, trigger, indexers)
+		if err != nil {
+			return s, d, err
+		}
+		return &keyCheckingStorage{Interface: s, keyFunc: keyFunc}, d, nil
+	}
+}
+
+// keyBoundPrefix starts the data of a transformingCodec, it is followed by the length and
+// the key of the object, which is the authenticated data of the transformed object.
+const keyBoundPrefix = "k8s:apimaster:key:v1:"
+
+// transformingCodec transforms the encoded objects to and from storage. A codec does not know
+// the key it reads, so the key is stored in front of the transformed object, and an object
+// decoded from the data of another key is rejected by keyCheckingStorage.
+type transformingCodec struct {
+	runtime.Codec
+	transformer storagevalue.Transformer
+	keyFunc     func(obj runtime.Object) (string, error)
+	// resourceCtx authenticates the data written before the key was bound, which has no prefix.
+	resourceCtx storagevalue.Context
+}
+
+// Encode implements runtime.Encoder.
+func (c *transformingCodec) Encode(obj runtime.Object, w io.Writer) error {
+	key, err := c.keyFunc(obj)
+	if err != nil {
+		return err
+	}
+	buf := &bytes.Buffer{}
+	if err := c.Codec.Encode(obj, buf); err != nil {
+		return err
+	}
+	data, err := c.transformer.TransformToStorage(context.TODO(), buf.Bytes(), storagevalue.DefaultContext(key))
+	if err != nil {
+		return err
+	}
+	header := binary.AppendUvarint([]byte(keyBoundPrefix), uint64(len(key)))
+	header = append(header, key...)
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Decode implements runtime.Decoder.
+func (c *transformingCodec) Decode(data []byte, defaults *schema.GroupVersionKind, into runtime.Object) (runtime.Object, *schema.GroupVersionKind, error) {
+	if !bytes.HasPrefix(data, []byte(keyBoundPrefix)) {
+		data, _, err := c.transformer.TransformFromStorage(context.TODO(), data, c.resourceCtx)
+		if err != nil {
+			return nil, nil, err
+		}
+		return c.Codec.Decode(data, defaults, into)
+	}
+
+	data = data[len(keyBoundPrefix):]
+	length, n := binary.Uvarint(data)
+	if n <= 0 || uint64(len(data)-n) < length {
+		return nil, nil, fmt.Errorf("invalid key of the stored object")
+	}
+	key := string(data[n : n+int(length)])
+	data, _, err := c.transformer.TransformFromStorage(context.TODO(), data[n+int(length):], storagevalue.DefaultContext(key))
+	if err != nil {
+		return nil, nil, err
+	}
+	obj, gvk, err := c.Codec.Decode(data, defaults, into)
+	if err != nil {
+		return nil, nil, err
+	}
+	if objKey, err := c.keyFunc(obj); err != nil || objKey != key {
+		return nil, nil, fmt.Errorf("object stored with key %q does not belong to it", key)
+	}
+	return obj, gvk, nil
+}
+
+// Identifier implements runtime.Encoder, the transformed encoding must not be
+// shared with the plain one by objects caching their serializations.
+func (c *transformingCodec) Identifier() runtime.Identifier {
+	return runtime.Identifier("transformed-" + string(c.Codec.Identifier()))
+}
+
+// keyCheckingStorage fails the reads of a key that returned the object of another key, e.g.
+// a row copied from another key. The codec already checked the object was written at its
+// own key, the items of a list are not checked further since their keys are not known.
+type keyCheckingStorage struct {
+	storage.Interface
+	keyFunc func(obj runtime.Object) (string, error)
+}
+
+// Get implements storage.Interface.
+func (s *keyCheckingStorage) Get(ctx context.Context, key string, opts storage.GetOptions, out runtime.Object) error {
+	if err := s.Interface.Get(ctx, key, opts, out); err != nil {
+		return err
+	}
+	return s.checkKey(key, out)
+}
+
+// GuaranteedUpdate implements storage.Interface.
+func (s *keyCheckingStorage) GuaranteedUpdate(
+	ctx context.Context, key string, destination runtime.Object, ignoreNotFound bool,
+	preconditions *storage.Preconditions, tryUpdate storage.UpdateFunc, cachedExistingObject runtime.Object) error {
+	checkedUpdate := func(input runtime.Object, res storage.ResponseMeta) (runtime.Object, *uint64, error) {
+		// the empty object of a missing key has no name
+		if accessor, err := meta.Accessor(input); err == nil && len(accessor.GetName()) > 0 {
+			if err := s.checkKey(key, input); err != nil {
+				return nil, nil, err
+			}
+		}
+		return tryUpdate(input, res)
+	}
+	return s.Interface.GuaranteedUpdate(ctx, key, destination, ignoreNotFound, preconditions, checkedUpdate, cachedExistingObject)
+}
+
+func (s *keyCheckingStorage) checkKey(key string, obj runtime.Object) error {
+	objKey, err := s.keyFunc(obj)
+	if err != nil {
+		return err
+	}
+	if objKey != key {
+		return storage.NewInternalErrorf("the object stored at %q belongs to %q", key, objKey)
+	}
+	return nil
+}
+
+// identityTransformer stores the data as is, the data of a transformingCodec is encrypted already.
+type identityTransformer struct{}
+
+// TransformFromStorage implements value.Transformer.
+func (identityTransformer) TransformFromStorage(ctx context.Context, data []byte, dataCtx storagevalue.Context) ([]byte, bool, error) {
+	return data, false, nil
+}
+
+// TransformToStorage implements value.Transformer.
+func (identityTransformer) TransformToStorage(ctx context.Context, data []byte, dataCtx storagevalue.Context) ([]byte, error) {
+	return data, nil
+}
@@ -0,0 +1,345 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/seanchann/apimaster/pkg/api/legacyscheme"
+	api "github.com/seanchann/apimaster/pkg/apis/coreres"
+	_ "github.com/seanchann/apimaster/pkg/apis/coreres/install"
+	"github.com/seanchann/apimaster/pkg/storage/resourcestore"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/server"
+	serverstorage "k8s.io/apiserver/pkg/server/storage"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+	"k8s.io/apiserver/pkg/storage/storagebackend/factory"
+	storagevalue "k8s.io/apiserver/pkg/storage/value"
+	"k8s.io/client-go/tools/cache"
+)
+
+const encryptionConfigTemplate = `
+apiVersion: apiserver.config.k8s.io/v1
+kind: EncryptionConfiguration
+resources:
+- resources:
+  - namespaces.coreres
+  providers:
+  - aescbc:
+      keys:
+KEYS
+  - identity: {}
+`
+
+const (
+	encryptionKey1 = "      - name: key1\n        secret: c2VjcmV0IGlzIHNlY3VyZQ==\n"
+	encryptionKey2 = "      - name: key2\n        secret: dGhpcyBpcyBwYXNzd29yZA==\n"
+)
+
+func writeEncryptionConfig(t *testing.T, keys string) string {
+	file := filepath.Join(t.TempDir(), "encryption.yaml")
+	content := bytes.Replace([]byte(encryptionConfigTemplate), []byte("KEYS\n"), []byte(keys), 1)
+	if err := os.WriteFile(file, content, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestEncryptionOptionsValidate(t *testing.T) {
+	testCases := []struct {
+		name           string
+		options        *EncryptionOptions
+		expectErrorNum int
+	}{
+		{
+			name:    "no encryption",
+			options: &EncryptionOptions{},
+		},
+		{
+			name: "encryption with rewrite resources",
+			options: &EncryptionOptions{
+				EncryptionProviderConfigFilepath: "/etc/encryption.yaml",
+				RewriteResources:                 []string{"secrets", "coreres/namespaces"},
+			},
+		},
+		{
+			name:           "reload without config",
+			options:        &EncryptionOptions{EncryptionProviderConfigAutomaticReload: true},
+			expectErrorNum: 1,
+		},
+		{
+			name:           "rewrite without config",
+			options:        &EncryptionOptions{RewriteResources: []string{"secrets"}},
+			expectErrorNum: 1,
+		},
+		{
+			name: "invalid rewrite resource",
+			options: &EncryptionOptions{
+				EncryptionProviderConfigFilepath: "/etc/encryption.yaml",
+				RewriteResources:                 []string{"coreres/v1/namespaces"},
+			},
+			expectErrorNum: 1,
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			errs := testcase.options.Validate()
+			if len(errs) != testcase.expectErrorNum {
+				t.Errorf("expected %d errors, got %v", testcase.expectErrorNum, errs)
+			}
+		})
+	}
+}
+
+func TestEncryptionOptionsKeyRotation(t *testing.T) {
+	ctx := context.Background()
+	mem := NewMemoryOptions(storagebackend.NewDefaultConfig("memory", nil))
+	storageConfig, mediaType := mem.BackendConfig()
+	storageFactory := serverstorage.NewDefaultStorageFactory(*storageConfig, mediaType, legacyscheme.Codecs,
+		serverstorage.NewDefaultResourceEncodingConfig(legacyscheme.Scheme), serverstorage.NewResourceConfig(), nil)
+
+	apply := func(keys string) *server.Config {
+		c := server.NewConfig(legacyscheme.Codecs)
+		encryption := &EncryptionOptions{EncryptionProviderConfigFilepath: writeEncryptionConfig(t, keys)}
+		if err := encryption.ApplyTo(c); err != nil {
+			t.Fatal(err)
+		}
+		if err := mem.ApplyWithStorageFactoryTo(storageFactory, c); err != nil {
+			t.Fatal(err)
+		}
+		return c
+	}
+	storedWith := func(key string) bool {
+		kvs, _, _, err := mem.backend.List(ctx, "/", "", 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return len(kvs) == 1 && bytes.HasPrefix(kvs[0].Value, []byte("k8s:enc:aescbc:v1:"+key+":"))
+	}
+
+	c := apply(encryptionKey1)
+	store, err := resourcestore.Open(c.RESTOptionsGetter, legacyscheme.Scheme, api.Resource("namespaces"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Destroy()
+	if err := store.Create(ctx, store.Prefix+"/foo", &api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "foo"}}, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if !storedWith("key1") {
+		t.Fatalf("expected namespace to be encrypted with key1")
+	}
+
+	c = apply(encryptionKey2 + encryptionKey1)
+	rotated, err := resourcestore.Open(c.RESTOptionsGetter, legacyscheme.Scheme, api.Resource("namespaces"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rotated.Destroy()
+	count, err := rotated.Rewrite(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 rewritten namespace, got %d", count)
+	}
+	if !storedWith("key2") {
+		t.Errorf("expected namespace to be encrypted with key2")
+	}
+}
+
+func TestEncryptionOptionsLegacyStores(t *testing.T) {
+	storageFactory := func(backend StorageBackend) serverstorage.StorageFactory {
+		storageConfig, mediaType := backend.BackendConfig()
+		return serverstorage.NewDefaultStorageFactory(*storageConfig, mediaType, legacyscheme.Codecs,
+			serverstorage.NewDefaultResourceEncodingConfig(legacyscheme.Scheme), serverstorage.NewResourceConfig(), nil)
+	}
+	sqlite := NewSqliteOptions(storagebackend.NewDefaultConfig("sqlite", nil))
+	sqlite.StorageConfig.Sqlite.DSN = filepath.Join(t.TempDir(), "apimaster.db")
+	mysql := NewMysqlOptions(storagebackend.NewDefaultConfig("mysql", nil))
+	mysql.StorageConfig.Mysql.ServerList = []string{"user:pass@tcp(db:3306)/apimaster"}
+
+	testCases := []struct {
+		name    string
+		backend StorageBackend
+	}{
+		{name: "sqlite", backend: sqlite},
+		{name: "legacy mysql", backend: mysql},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			c := server.NewConfig(legacyscheme.Codecs)
+			encryption := &EncryptionOptions{EncryptionProviderConfigFilepath: writeEncryptionConfig(t, encryptionKey1)}
+			if err := encryption.ApplyTo(c); err != nil {
+				t.Fatal(err)
+			}
+			if err := testcase.backend.ApplyWithStorageFactoryTo(storageFactory(testcase.backend), c); err != nil {
+				t.Fatal(err)
+			}
+
+			getter, ok := c.RESTOptionsGetter.(*codecTransformingRestOptionsFactory)
+			if !ok {
+				t.Fatalf("expected the rows to be transformed by the codec, got %T", c.RESTOptionsGetter)
+			}
+			rows := map[string][]byte{}
+			getter.delegate = &rowRestOptionsFactory{delegate: getter.delegate, rows: rows}
+			store := newRowStorage(t, c.RESTOptionsGetter, api.Resource("namespaces"))
+			ctx := context.Background()
+
+			for _, name := range []string{"foo", "bar"} {
+				if err := store.Create(ctx, "/namespaces/"+name, &api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}, nil, 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			row := rows["/namespaces/foo"]
+			if !bytes.Contains(row, []byte("k8s:enc:aescbc:v1:key1:")) || bytes.Contains(row, []byte(`"foo"`)) {
+				t.Errorf("expected the namespace to be encrypted with key1, got %q", row)
+			}
+			got := &api.Namespace{}
+			if err := store.Get(ctx, "/namespaces/foo", storage.GetOptions{}, got); err != nil || got.Name != "foo" {
+				t.Errorf("expected to get foo, got %q: %v", got.Name, err)
+			}
+
+			// a row swapped with the one of another key is not served for either key
+			rows["/namespaces/foo"], rows["/namespaces/bar"] = rows["/namespaces/bar"], rows["/namespaces/foo"]
+			for _, key := range []string{"/namespaces/foo", "/namespaces/bar"} {
+				if err := store.Get(ctx, key, storage.GetOptions{}, &api.Namespace{}); err == nil {
+					t.Errorf("expected the swapped row of %s to fail", key)
+				}
+			}
+			// the key in front of a row must be the key of its object
+			rows["/namespaces/foo"] = bytes.Replace(rows["/namespaces/foo"], []byte("/namespaces/bar"), []byte("/namespaces/foo"), 1)
+			if err := store.Get(ctx, "/namespaces/foo", storage.GetOptions{}, &api.Namespace{}); err == nil {
+				t.Errorf("expected the row with a replaced key to fail")
+			}
+		})
+	}
+}
+
+func TestTransformingCodecResourceBoundRows(t *testing.T) {
+	c := server.NewConfig(legacyscheme.Codecs)
+	encryption := &EncryptionOptions{EncryptionProviderConfigFilepath: writeEncryptionConfig(t, encryptionKey1)}
+	if err := encryption.ApplyTo(c); err != nil {
+		t.Fatal(err)
+	}
+	sqlite := NewSqliteOptions(storagebackend.NewDefaultConfig("sqlite", nil))
+	sqlite.StorageConfig.Sqlite.DSN = filepath.Join(t.TempDir(), "apimaster.db")
+	storageConfig, mediaType := sqlite.BackendConfig()
+	storageFactory := serverstorage.NewDefaultStorageFactory(*storageConfig, mediaType, legacyscheme.Codecs,
+		serverstorage.NewDefaultResourceEncodingConfig(legacyscheme.Scheme), serverstorage.NewResourceConfig(), nil)
+	if err := sqlite.ApplyWithStorageFactoryTo(storageFactory, c); err != nil {
+		t.Fatal(err)
+	}
+	rows := map[string][]byte{}
+	getter := c.RESTOptionsGetter.(*codecTransformingRestOptionsFactory)
+	getter.delegate = &rowRestOptionsFactory{delegate: getter.delegate, rows: rows}
+	store := newRowStorage(t, c.RESTOptionsGetter, api.Resource("namespaces"))
+
+	// the rows written before the key was bound are authenticated with the resource
+	restOptions, err := c.RESTOptionsGetter.GetRESTOptions(api.Resource("namespaces"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := runtime.Encode(restOptions.StorageConfig.Codec, &api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "foo"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	transformer := c.ResourceTransformers.TransformerForResource(api.Resource("namespaces"))
+	rows["/namespaces/foo"], err = transformer.TransformToStorage(context.Background(), data, storagevalue.DefaultContext(api.Resource("namespaces").String()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := &api.Namespace{}
+	if err := store.Get(context.Background(), "/namespaces/foo", storage.GetOptions{}, got); err != nil || got.Name != "foo" {
+		t.Errorf("expected to get foo, got %q: %v", got.Name, err)
+	}
+}
+
+// rowRestOptionsFactory serves the resources from rows, like the sqlite and legacy mysql
+// stores of the apiserver, which write the output of the codec as is.
+type rowRestOptionsFactory struct {
+	delegate generic.RESTOptionsGetter
+	rows     map[string][]byte
+}
+
+func (f *rowRestOptionsFactory) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	ret, err := f.delegate.GetRESTOptions(resource)
+	if err != nil {
+		return ret, err
+	}
+	ret.Decorator = func(config *storagebackend.ConfigForResource, resourcePrefix string, keyFunc func(obj runtime.Object) (string, error),
+		newFunc func() runtime.Object, newListFunc func() runtime.Object, getAttrsFunc storage.AttrFunc,
+		trigger storage.IndexerFuncs, indexers *cache.Indexers) (storage.Interface, factory.DestroyFunc, error) {
+		return &rowStorage{codec: config.Codec, rows: f.rows}, func() {}, nil
+	}
+	return ret, nil
+}
+
+// newRowStorage creates the storage of the cluster scoped resource.
+func newRowStorage(t *testing.T, getter generic.RESTOptionsGetter, resource schema.GroupResource) storage.Interface {
+	restOptions, err := getter.GetRESTOptions(resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	prefix := "/" + resource.Resource
+	keyFunc := func(obj runtime.Object) (string, error) {
+		return storage.NoNamespaceKeyFunc(prefix, obj)
+	}
+	s, _, err := restOptions.Decorator(restOptions.StorageConfig, prefix, keyFunc,
+		func() runtime.Object { return &api.Namespace{} }, func() runtime.Object { return &api.NamespaceList{} },
+		storage.DefaultClusterScopedAttr, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+type rowStorage struct {
+	storage.Interface
+	codec runtime.Codec
+	rows  map[string][]byte
+}
+
+func (s *rowStorage) Create(ctx context.Context, key string, obj, out runtime.Object, ttl uint64) error {
+	data, err := runtime.Encode(s.codec, obj)
+	if err != nil {
+		return err
+	}
+	s.rows[key] = data
+	return nil
+}
+
+func (s *rowStorage) Get(ctx context.Context, key string, opts storage.GetOptions, out runtime.Object) error {
+	data, found := s.rows[key]
+	if !found {
+		return storage.NewKeyNotFoundError(key, 0)
+	}
+	_, _, err := s.codec.Decode(data, nil, out)
+	return err
+}
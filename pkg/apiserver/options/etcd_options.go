@@ -0,0 +1,108 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"github.com/spf13/pflag"
+	oteltrace "go.opentelemetry.io/otel/trace"
+	genericfeatures "k8s.io/apiserver/pkg/features"
+	"k8s.io/apiserver/pkg/server"
+	genericoptions "k8s.io/apiserver/pkg/server/options"
+	serverstorage "k8s.io/apiserver/pkg/server/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+	utilfeature "k8s.io/apiserver/pkg/util/feature"
+)
+
+// EtcdOptions etcd as a backend
+type EtcdOptions struct {
+	*genericoptions.EtcdOptions
+}
+
+// NewEtcdOptions create etcd options
+func NewEtcdOptions(backendConfig *storagebackend.Config) *EtcdOptions {
+	etcd := &EtcdOptions{
+		EtcdOptions: genericoptions.NewEtcdOptions(backendConfig),
+	}
+	// the watch cache is set up for every backend by WatchCacheOptions, which enables it for etcd
+	etcd.EnableWatchCache = false
+	return etcd
+}
+
+// sharedEtcdFlags are shared by every backend and added by their own options.
+var sharedEtcdFlags = map[string]bool{
+	"encryption-provider-config":                  true,
+	"encryption-provider-config-automatic-reload": true,
+	"watch-cache":              true,
+	"default-watch-cache-size": true,
+	"watch-cache-sizes":        true,
+	// GarbageCollectorOptions sets it for the registries of every backend, etcd included
+	"enable-garbage-collector": true,
+	// etcd uses it to select the etcd3 storage, it selects the backend of apimaster
+	"storage-backend": true,
+}
+
+// AddFlags adds the etcd flags, except the flags shared by every backend.
+func (s *EtcdOptions) AddFlags(fs *pflag.FlagSet) {
+	etcdFlags := pflag.NewFlagSet("etcd", pflag.ContinueOnError)
+	s.EtcdOptions.AddFlags(etcdFlags)
+	etcdFlags.VisitAll(func(f *pflag.Flag) {
+		if !sharedEtcdFlags[f.Name] {
+			fs.AddFlag(f)
+		}
+	})
+}
+
+// BackendConfig returns the storage config and default media type of etcd
+func (s *EtcdOptions) BackendConfig() (*storagebackend.Config, string) {
+	return &s.StorageConfig, s.DefaultStorageMediaType
+}
+
+// CopyStorageBackend implements StorageBackendCopier
+func (s *EtcdOptions) CopyStorageBackend() StorageBackend {
+	etcd := *s.EtcdOptions
+	etcd.StorageConfig = copyStorageConfig(s.StorageConfig)
+	etcd.EtcdServersOverrides = copyStrings(s.EtcdServersOverrides)
+	etcd.WatchCacheSizes = copyStrings(s.WatchCacheSizes)
+	return &EtcdOptions{EtcdOptions: &etcd}
+}
+
+// SetServers overrides the etcd servers
+func (s *EtcdOptions) SetServers(servers []string) {
+	s.StorageConfig.Transport.ServerList = servers
+}
+
+// CompleteStorageConfig sets up the egress dialer and tracer of the etcd transport
+func (s *EtcdOptions) CompleteStorageConfig(c *server.Config) error {
+	if c.EgressSelector != nil {
+		s.StorageConfig.Transport.EgressLookup = c.EgressSelector.Lookup
+	}
+	if utilfeature.DefaultFeatureGate.Enabled(genericfeatures.APIServerTracing) {
+		s.StorageConfig.Transport.TracerProvider = c.TracerProvider
+	} else {
+		s.StorageConfig.Transport.TracerProvider = oteltrace.NewNoopTracerProvider()
+	}
+	return nil
+}
+
+// ApplyWithStorageFactoryTo sets the RESTOptionsGetter of etcd, whose writes can not take part in a batch
+func (s *EtcdOptions) ApplyWithStorageFactoryTo(factory serverstorage.StorageFactory, c *server.Config) error {
+	if err := s.EtcdOptions.ApplyWithStorageFactoryTo(factory, c); err != nil {
+		return err
+	}
+	c.RESTOptionsGetter = &batchRejectingRestOptionsFactory{delegate: c.RESTOptionsGetter}
+	return nil
+}
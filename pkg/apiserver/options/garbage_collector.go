@@ -0,0 +1,151 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/server"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/metadata"
+	"k8s.io/klog/v2"
+
+	"github.com/seanchann/apimaster/pkg/controller/garbagecollector"
+)
+
+// GarbageCollectorOptions garbage collection of dependents by ownerReferences
+type GarbageCollectorOptions struct {
+	EnableGarbageCollection bool
+	ConcurrentGCSyncs       int
+	ResyncPeriod            time.Duration
+}
+
+// NewGarbageCollectorOptions create garbage collector options
+func NewGarbageCollectorOptions() *GarbageCollectorOptions {
+	return &GarbageCollectorOptions{
+		EnableGarbageCollection: true,
+		ConcurrentGCSyncs:       20,
+		ResyncPeriod:            12 * time.Hour,
+	}
+}
+
+// Validate validate garbage collector input options
+func (s *GarbageCollectorOptions) Validate() []error {
+	if s == nil || !s.EnableGarbageCollection {
+		return nil
+	}
+
+	allErrors := []error{}
+	if s.ConcurrentGCSyncs <= 0 {
+		allErrors = append(allErrors, fmt.Errorf("--concurrent-gc-syncs must be greater than 0"))
+	}
+	if s.ResyncPeriod < 0 {
+		allErrors = append(allErrors, fmt.Errorf("--garbage-collector-resync-period must not be negative"))
+	}
+	return allErrors
+}
+
+// AddFlags adds flags related to garbage collection for a specific APIServer to the specified FlagSet
+func (s *GarbageCollectorOptions) AddFlags(fs *pflag.FlagSet) {
+	if s == nil {
+		return
+	}
+
+	fs.BoolVar(&s.EnableGarbageCollection, "enable-garbage-collector", s.EnableGarbageCollection, ""+
+		"Enables the built-in garbage collector, which deletes dependents by their ownerReferences "+
+		"and honors the Foreground, Background and Orphan propagation policies.")
+
+	fs.IntVar(&s.ConcurrentGCSyncs, "concurrent-gc-syncs", s.ConcurrentGCSyncs,
+		"The number of garbage collector workers that are allowed to sync concurrently.")
+
+	fs.DurationVar(&s.ResyncPeriod, "garbage-collector-resync-period", s.ResyncPeriod,
+		"The period all objects are checked again for owners that are gone.")
+}
+
+// ApplyTo enables or disables garbage collection in the registries of every backend,
+// including etcd, and starts the garbage collector after the server started. It must be
+// called after the storage backend set the RESTOptionsGetter.
+func (s *GarbageCollectorOptions) ApplyTo(c *server.Config) error {
+	if s == nil {
+		return nil
+	}
+
+	c.RESTOptionsGetter = &garbageCollectionRestOptionsFactory{delegate: c.RESTOptionsGetter, enabled: s.EnableGarbageCollection}
+	if !s.EnableGarbageCollection {
+		return nil
+	}
+	return c.AddPostStartHook("start-garbage-collector", func(hookContext server.PostStartHookContext) error {
+		go s.run(wait.ContextForChannel(hookContext.StopCh), hookContext)
+		return nil
+	})
+}
+
+// run starts the garbage collector for every deletable resource of the server.
+func (s *GarbageCollectorOptions) run(ctx context.Context, hookContext server.PostStartHookContext) {
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(hookContext.LoopbackClientConfig)
+	if err != nil {
+		klog.Errorf("Failed to create the discovery client of the garbage collector: %v", err)
+		return
+	}
+	metadataClient, err := metadata.NewForConfig(hookContext.LoopbackClientConfig)
+	if err != nil {
+		klog.Errorf("Failed to create the metadata client of the garbage collector: %v", err)
+		return
+	}
+
+	var resources []garbagecollector.Resource
+	err = wait.PollUntilContextCancel(ctx, time.Second, true, func(context.Context) (bool, error) {
+		resources, err = garbagecollector.DeletableResources(discoveryClient)
+		if err != nil {
+			klog.V(2).Infof("Waiting for the resources to collect garbage of: %v", err)
+			return false, nil
+		}
+		return true, nil
+	})
+	if err != nil {
+		return
+	}
+
+	gc, err := garbagecollector.NewGarbageCollector(metadataClient, resources, s.ResyncPeriod)
+	if err != nil {
+		klog.Errorf("Failed to create the garbage collector: %v", err)
+		return
+	}
+	gc.Run(ctx, s.ConcurrentGCSyncs)
+}
+
+// garbageCollectionRestOptionsFactory rest options factory that enables or disables garbage collection
+type garbageCollectionRestOptionsFactory struct {
+	delegate generic.RESTOptionsGetter
+	enabled  bool
+}
+
+// GetRESTOptions impl generic.RESTOptions
+func (f *garbageCollectionRestOptionsFactory) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	ret, err := f.delegate.GetRESTOptions(resource)
+	if err != nil {
+		return generic.RESTOptions{}, err
+	}
+	ret.EnableGarbageCollection = f.enabled
+	return ret, nil
+}
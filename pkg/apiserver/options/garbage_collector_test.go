@@ -0,0 +1,153 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"testing"
+
+	"github.com/seanchann/apimaster/pkg/api/legacyscheme"
+	api "github.com/seanchann/apimaster/pkg/apis/coreres"
+	_ "github.com/seanchann/apimaster/pkg/apis/coreres/install"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apiserver/pkg/server"
+	serverstorage "k8s.io/apiserver/pkg/server/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+	cliflag "k8s.io/component-base/cli/flag"
+)
+
+func TestGarbageCollectorOptionsValidate(t *testing.T) {
+	testCases := []struct {
+		name           string
+		options        *GarbageCollectorOptions
+		expectErrorNum int
+	}{
+		{
+			name:    "default",
+			options: NewGarbageCollectorOptions(),
+		},
+		{
+			name:    "disabled",
+			options: &GarbageCollectorOptions{},
+		},
+		{
+			name:           "no workers",
+			options:        &GarbageCollectorOptions{EnableGarbageCollection: true},
+			expectErrorNum: 1,
+		},
+		{
+			name:           "negative resync period",
+			options:        &GarbageCollectorOptions{EnableGarbageCollection: true, ConcurrentGCSyncs: 1, ResyncPeriod: -1},
+			expectErrorNum: 1,
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			errs := testcase.options.Validate()
+			if len(errs) != testcase.expectErrorNum {
+				t.Errorf("expected %d errors, got %v", testcase.expectErrorNum, errs)
+			}
+		})
+	}
+}
+
+func TestGarbageCollectorOptionsApplyTo(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		mem := NewMemoryOptions(storagebackend.NewDefaultConfig("memory", nil))
+		storageConfig, mediaType := mem.BackendConfig()
+		storageFactory := serverstorage.NewDefaultStorageFactory(*storageConfig, mediaType, legacyscheme.Codecs,
+			serverstorage.NewDefaultResourceEncodingConfig(legacyscheme.Scheme), serverstorage.NewResourceConfig(), nil)
+
+		c := server.NewConfig(legacyscheme.Codecs)
+		if err := mem.ApplyWithStorageFactoryTo(storageFactory, c); err != nil {
+			t.Fatal(err)
+		}
+		opts := NewGarbageCollectorOptions()
+		opts.EnableGarbageCollection = enabled
+		if err := opts.ApplyTo(c); err != nil {
+			t.Fatal(err)
+		}
+
+		restOptions, err := c.RESTOptionsGetter.GetRESTOptions(api.Resource("namespaces"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if restOptions.EnableGarbageCollection != enabled {
+			t.Errorf("expected EnableGarbageCollection %v, got %v", enabled, restOptions.EnableGarbageCollection)
+		}
+		if _, found := c.PostStartHooks["start-garbage-collector"]; found != enabled {
+			t.Errorf("expected garbage collector post start hook %v, got %v", enabled, found)
+		}
+	}
+}
+
+func TestGarbageCollectorOptionsEtcd(t *testing.T) {
+	testCases := []struct {
+		name     string
+		args     []string
+		expectGC bool
+	}{
+		{
+			name:     "default",
+			expectGC: true,
+		},
+		{
+			name: "disabled",
+			args: []string{"--enable-garbage-collector=false"},
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			o := newTestAPIMasterOptions(t, StorageBackendTypeEtcd)
+			fss := cliflag.NamedFlagSets{}
+			o.AddFlags(&fss)
+			fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			for _, f := range fss.FlagSets {
+				fs.AddFlagSet(f)
+			}
+			if err := fs.Parse(testcase.args); err != nil {
+				t.Fatal(err)
+			}
+			// etcd keeps garbage collection of its registries enabled by default
+			if !o.Etcd.EnableGarbageCollection {
+				t.Errorf("expected the etcd options to enable garbage collection")
+			}
+
+			storageConfig, mediaType := o.Storage.BackendConfig()
+			storageFactory := serverstorage.NewDefaultStorageFactory(*storageConfig, mediaType, legacyscheme.Codecs,
+				serverstorage.NewDefaultResourceEncodingConfig(legacyscheme.Scheme), serverstorage.NewResourceConfig(), nil)
+			c := server.NewConfig(legacyscheme.Codecs)
+			o.Etcd.SkipHealthEndpoints = true
+			if err := o.Storage.ApplyWithStorageFactoryTo(storageFactory, c); err != nil {
+				t.Fatal(err)
+			}
+			if err := o.GarbageCollector.ApplyTo(c); err != nil {
+				t.Fatal(err)
+			}
+
+			restOptions, err := c.RESTOptionsGetter.GetRESTOptions(api.Resource("namespaces"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if restOptions.EnableGarbageCollection != testcase.expectGC {
+				t.Errorf("expected EnableGarbageCollection %v, got %v", testcase.expectGC, restOptions.EnableGarbageCollection)
+			}
+		})
+	}
+}
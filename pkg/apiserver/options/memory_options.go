@@ -0,0 +1,99 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"fmt"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apiserver/pkg/server"
+	serverstorage "k8s.io/apiserver/pkg/server/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+	"github.com/seanchann/apimaster/pkg/storage/memory"
+)
+
+// MemoryOptions in process memory as a backend, for development and tests only.
+type MemoryOptions struct {
+	StorageConfig           storagebackend.Config
+	DefaultStorageMediaType string
+	// HistorySize is the number of events kept to resume watches.
+	HistorySize int
+
+	backend *memory.Backend
+}
+
+// NewMemoryOptions create memory options
+func NewMemoryOptions(backendConfig *storagebackend.Config) *MemoryOptions {
+	mem := &MemoryOptions{
+		StorageConfig:           *backendConfig,
+		DefaultStorageMediaType: "application/json",
+		HistorySize:             memory.DefaultHistorySize,
+	}
+	mem.StorageConfig.Type = memory.StorageTypeMemory
+
+	return mem
+}
+
+// Validate validate memory input options
+func (s *MemoryOptions) Validate() []error {
+	allErrors := []error{}
+	if s.HistorySize <= 0 {
+		allErrors = append(allErrors, fmt.Errorf("--memory-history-size must be greater than 0"))
+	}
+	return allErrors
+}
+
+// AddFlags adds flags related to memory storage for a specific APIServer to the specified FlagSet
+// you must set storage-backend flag with memory.
+func (s *MemoryOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.IntVar(&s.HistorySize, "memory-history-size", s.HistorySize, ""+
+		"the number of events kept in memory to resume watches from an older resourceVersion.")
+}
+
+// SupportsBatch implements BatchStorageBackend, memory commits a batch in one transaction.
+func (s *MemoryOptions) SupportsBatch() bool {
+	return true
+}
+
+// BackendConfig returns the storage config and default media type of memory
+func (s *MemoryOptions) BackendConfig() (*storagebackend.Config, string) {
+	return &s.StorageConfig, s.DefaultStorageMediaType
+}
+
+// CopyStorageBackend implements StorageBackendCopier, the copy stores its own resources.
+func (s *MemoryOptions) CopyStorageBackend() StorageBackend {
+	return &MemoryOptions{
+		StorageConfig:           copyStorageConfig(s.StorageConfig),
+		DefaultStorageMediaType: s.DefaultStorageMediaType,
+		HistorySize:             s.HistorySize,
+	}
+}
+
+// ApplyWithStorageFactoryTo apply to storage factory
+func (s *MemoryOptions) ApplyWithStorageFactoryTo(factory serverstorage.StorageFactory, c *server.Config) error {
+	if s.backend == nil {
+		s.backend = memory.NewBackend(s.HistorySize)
+	}
+	backend := s.backend
+	c.RESTOptionsGetter = &kvRestOptionsFactory{
+		delegate: &StorageFactoryRestOptionsFactory{StorageFactory: factory, ResourceTransformers: c.ResourceTransformers},
+		kv:       func() (kvstore.KV, error) { return backend, nil },
+	}
+	return nil
+}
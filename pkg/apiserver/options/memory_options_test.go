@@ -0,0 +1,82 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"context"
+	"testing"
+
+	"github.com/seanchann/apimaster/pkg/api/legacyscheme"
+	api "github.com/seanchann/apimaster/pkg/apis/coreres"
+	_ "github.com/seanchann/apimaster/pkg/apis/coreres/install"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/server"
+	serverstorage "k8s.io/apiserver/pkg/server/storage"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+)
+
+func TestMemoryOptionsValidate(t *testing.T) {
+	opts := NewMemoryOptions(storagebackend.NewDefaultConfig("memory", nil))
+	if errs := opts.Validate(); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	opts.HistorySize = 0
+	if errs := opts.Validate(); len(errs) != 1 {
+		t.Errorf("expected one error, got %v", errs)
+	}
+}
+
+func TestMemoryOptionsApplyWithStorageFactoryTo(t *testing.T) {
+	opts := NewMemoryOptions(storagebackend.NewDefaultConfig("memory", nil))
+	storageConfig, mediaType := opts.BackendConfig()
+	storageFactory := serverstorage.NewDefaultStorageFactory(*storageConfig, mediaType, legacyscheme.Codecs,
+		serverstorage.NewDefaultResourceEncodingConfig(legacyscheme.Scheme), serverstorage.NewResourceConfig(), nil)
+
+	c := &server.Config{}
+	if err := opts.ApplyWithStorageFactoryTo(storageFactory, c); err != nil {
+		t.Fatal(err)
+	}
+
+	restOptions, err := c.RESTOptionsGetter.GetRESTOptions(api.Resource("namespaces"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, destroy, err := restOptions.Decorator(restOptions.StorageConfig, restOptions.ResourcePrefix, nil,
+		func() runtime.Object { return &api.Namespace{} },
+		func() runtime.Object { return &api.NamespaceList{} },
+		nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer destroy()
+
+	ctx := context.Background()
+	key := restOptions.ResourcePrefix + "/foo"
+	if err := s.Create(ctx, key, &api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "foo"}}, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	got := &api.Namespace{}
+	if err := s.Get(ctx, key, storage.GetOptions{}, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "foo" {
+		t.Errorf("unexpected object %#v", got)
+	}
+}
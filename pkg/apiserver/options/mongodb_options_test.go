@@ -0,0 +1,141 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/server"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+)
+
+func TestMongoDBOptionsValidate(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		servers              []string
+		adminCred            []string
+		userCred             []string
+		expectErrorSubString string
+	}{
+		{
+			name:    "servers only",
+			servers: []string{"mongodb://localhost:27017/apimaster"},
+		},
+		{
+			name:      "servers with credentials",
+			servers:   []string{"mongodb://localhost:27017/apimaster"},
+			adminCred: []string{"admin", "root", "123456"},
+			userCred:  []string{"apimaster", "apimaster", "123456"},
+		},
+		{
+			name:                 "missing servers",
+			expectErrorSubString: "--mongo-servers must be specified",
+		},
+		{
+			name:                 "malformed admin credential",
+			servers:              []string{"mongodb://localhost:27017/apimaster"},
+			adminCred:            []string{"admin", "root"},
+			expectErrorSubString: "--mongo-admin must be in the form of db,user,password",
+		},
+		{
+			name:                 "malformed user credential",
+			servers:              []string{"mongodb://localhost:27017/apimaster"},
+			userCred:             []string{"apimaster"},
+			expectErrorSubString: "--mongo-user must be in the form of db,user,password",
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			o := NewMongoDBOptions(storagebackend.NewDefaultConfig("mongodb", nil))
+			o.StorageConfig.Mongodb.ServerList = testcase.servers
+			o.StorageConfig.Mongodb.AdminCred = testcase.adminCred
+			o.StorageConfig.Mongodb.GeneralCred = testcase.userCred
+
+			errs := o.Validate()
+			if len(testcase.expectErrorSubString) == 0 {
+				if len(errs) != 0 {
+					t.Errorf("expected no errors, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), testcase.expectErrorSubString) {
+				t.Errorf("expected error containing %q, got %v", testcase.expectErrorSubString, errs)
+			}
+		})
+	}
+}
+
+func TestMongoDBOptionsAddFlags(t *testing.T) {
+	var args = []string{
+		"--mongo-servers=mongodb://localhost:27017/apimaster,mongodb://otherhost:27017/apimaster",
+		"--mongo-admin=admin,root,123456",
+		"--mongo-user=apimaster,apimaster,123456",
+	}
+
+	opts := NewMongoDBOptions(storagebackend.NewDefaultConfig("mongodb", nil))
+	pf := pflag.NewFlagSet("test-mongodb-opts", pflag.ContinueOnError)
+	opts.AddFlags(pf)
+
+	if err := pf.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := storagebackend.MongoExtendConfig{
+		ServerList:  []string{"mongodb://localhost:27017/apimaster", "mongodb://otherhost:27017/apimaster"},
+		AdminCred:   []string{"admin", "root", "123456"},
+		GeneralCred: []string{"apimaster", "apimaster", "123456"},
+	}
+	if !reflect.DeepEqual(opts.StorageConfig.Mongodb, expected) {
+		t.Errorf("expected %#v, got %#v", expected, opts.StorageConfig.Mongodb)
+	}
+	if opts.StorageConfig.Type != storagebackend.StorageTypeMongoDB {
+		t.Errorf("expected storage type %q, got %q", storagebackend.StorageTypeMongoDB, opts.StorageConfig.Type)
+	}
+}
+
+func TestMongoDBOptionsApplyTo(t *testing.T) {
+	opts := NewMongoDBOptions(storagebackend.NewDefaultConfig("mongodb", nil))
+	opts.StorageConfig.Mongodb.ServerList = []string{"mongodb://localhost:27017/apimaster"}
+
+	c := &server.Config{}
+	if err := opts.ApplyTo(c); err != nil {
+		t.Fatal(err)
+	}
+
+	resource := schema.GroupResource{Group: "coreres", Resource: "namespaces"}
+	restOptions, err := c.RESTOptionsGetter.GetRESTOptions(resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restOptions.ResourcePrefix != "coreres/namespaces" {
+		t.Errorf("unexpected resource prefix %q", restOptions.ResourcePrefix)
+	}
+	if restOptions.StorageConfig.Type != storagebackend.StorageTypeMongoDB {
+		t.Errorf("expected storage type %q, got %q", storagebackend.StorageTypeMongoDB, restOptions.StorageConfig.Type)
+	}
+	if restOptions.StorageConfig.GroupResource != resource {
+		t.Errorf("expected group resource %v, got %v", resource, restOptions.StorageConfig.GroupResource)
+	}
+	if restOptions.Decorator == nil {
+		t.Errorf("expected a storage decorator")
+	}
+}
@@ -1,39 +1,143 @@
 package options
 
 import (
+	"context"
 	"fmt"
+	"sync"
 
 	"github.com/spf13/pflag"
 	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
 	"k8s.io/apiserver/pkg/registry/generic"
 	"k8s.io/apiserver/pkg/server"
 	serverstorage "k8s.io/apiserver/pkg/server/storage"
 	"k8s.io/apiserver/pkg/storage/storagebackend"
+
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+	"github.com/seanchann/apimaster/pkg/storage/mysql"
+	"github.com/seanchann/apimaster/pkg/storage/storagemetrics"
+)
+
+const (
+	// MysqlStoreLegacy is the mysql store of the apiserver, it only uses the servers,
+	// the credentials, the timeouts and the TLS settings.
+	MysqlStoreLegacy = "legacy"
+	// MysqlStoreKV is the revisioned key value store of apimaster, which uses every mysql option.
+	MysqlStoreKV = "kv"
 )
 
-//MysqlOptions mysql as a backend
+// MysqlStores are the supported values of --mysql-store.
+var MysqlStores = []string{MysqlStoreLegacy, MysqlStoreKV}
+
+// MysqlOptions mysql as a backend
 type MysqlOptions struct {
 	StorageConfig           storagebackend.Config
 	DefaultStorageMediaType string
+	// Store is one of MysqlStores.
+	Store string
+	// Mysql is the connection config, its servers and default limit are taken from StorageConfig.
+	Mysql mysql.Config
+
+	lock    sync.Mutex
+	backend *mysql.Backend
 }
 
-//NewMysqlOptions create  mysql options
+// NewMysqlOptions create  mysql options
 func NewMysqlOptions(backendConfig *storagebackend.Config) *MysqlOptions {
 	mysql := &MysqlOptions{
 		StorageConfig:           *backendConfig,
 		DefaultStorageMediaType: "application/json",
+		Store:                   MysqlStoreLegacy,
+		Mysql:                   mysql.NewDefaultConfig(),
 	}
 	mysql.StorageConfig.Type = storagebackend.StorageTypeMysql
 
 	return mysql
 }
 
-//Validate validate mysql input options
+// Validate validate mysql input options
 func (s *MysqlOptions) Validate() []error {
 	allErrors := []error{}
 	if len(s.StorageConfig.Mysql.ServerList) == 0 {
 		allErrors = append(allErrors, fmt.Errorf("--mysql-servers must be specified"))
 	}
+	for _, server := range s.StorageConfig.Mysql.ServerList {
+		hasCredentials, err := mysql.HasCredentials(server)
+		if err != nil {
+			allErrors = append(allErrors, fmt.Errorf("--mysql-servers %v", err))
+			continue
+		}
+		if hasCredentials && s.Mysql.CredentialsFile != "" {
+			allErrors = append(allErrors, fmt.Errorf("--mysql-servers must not contain credentials when --mysql-credentials-file is specified"))
+		}
+	}
+	for _, server := range s.Mysql.ReplicaList {
+		hasCredentials, err := mysql.HasCredentials(server)
+		if err != nil {
+			allErrors = append(allErrors, fmt.Errorf("--mysql-replicas %v", err))
+			continue
+		}
+		if hasCredentials && s.Mysql.CredentialsFile != "" {
+			allErrors = append(allErrors, fmt.Errorf("--mysql-replicas must not contain credentials when --mysql-credentials-file is specified"))
+		}
+	}
+	if len(s.Mysql.ReplicaList) > 0 && s.Store != MysqlStoreKV {
+		allErrors = append(allErrors, fmt.Errorf("--mysql-replicas is only supported by the kv store"))
+	}
+	// the legacy store opens its connections inside the apiserver, which doesn't take pool settings,
+	// refuse them instead of ignoring them
+	defaults := mysql.NewDefaultConfig()
+	if s.Store != MysqlStoreKV && s.Mysql.MaxOpenConns != defaults.MaxOpenConns {
+		allErrors = append(allErrors, fmt.Errorf("--mysql-max-open-conns is only supported by the kv store"))
+	}
+	if s.Store != MysqlStoreKV && s.Mysql.MaxIdleConns != defaults.MaxIdleConns {
+		allErrors = append(allErrors, fmt.Errorf("--mysql-max-idle-conns is only supported by the kv store"))
+	}
+	if s.Store != MysqlStoreKV && s.Mysql.ConnMaxLifetime != defaults.ConnMaxLifetime {
+		allErrors = append(allErrors, fmt.Errorf("--mysql-conn-max-lifetime is only supported by the kv store"))
+	}
+	if s.Store != MysqlStoreKV && s.Mysql.CompactionInterval != defaults.CompactionInterval {
+		allErrors = append(allErrors, fmt.Errorf("--mysql-compaction-interval is only supported by the kv store"))
+	}
+	if s.Store != MysqlStoreKV && s.Mysql.CompactionRetention != defaults.CompactionRetention {
+		allErrors = append(allErrors, fmt.Errorf("--mysql-compaction-retention is only supported by the kv store"))
+	}
+	if len(s.Mysql.ReplicaList) > 0 && s.Mysql.MaxReplicaLag <= 0 {
+		allErrors = append(allErrors, fmt.Errorf("--mysql-max-replica-lag must be greater than 0"))
+	}
+	if !sets.NewString(MysqlStores...).Has(s.Store) {
+		allErrors = append(allErrors, fmt.Errorf("--mysql-store must be one of %v", MysqlStores))
+	}
+	if s.Mysql.WatchPollInterval <= 0 {
+		allErrors = append(allErrors, fmt.Errorf("--mysql-watch-poll-interval must be greater than 0"))
+	}
+	if s.Mysql.CompactionInterval < 0 {
+		allErrors = append(allErrors, fmt.Errorf("--mysql-compaction-interval can not be negative"))
+	}
+	if s.Mysql.CompactionInterval > 0 && s.Mysql.CompactionRetention <= 0 {
+		allErrors = append(allErrors, fmt.Errorf("--mysql-compaction-retention must be greater than 0"))
+	}
+	if s.Mysql.MaxOpenConns < 0 {
+		allErrors = append(allErrors, fmt.Errorf("--mysql-max-open-conns can not be negative"))
+	}
+	if s.Mysql.MaxIdleConns < 0 {
+		allErrors = append(allErrors, fmt.Errorf("--mysql-max-idle-conns can not be negative"))
+	}
+	if s.Mysql.ConnMaxLifetime < 0 {
+		allErrors = append(allErrors, fmt.Errorf("--mysql-conn-max-lifetime can not be negative"))
+	}
+	if s.Mysql.DialTimeout < 0 {
+		allErrors = append(allErrors, fmt.Errorf("--mysql-dial-timeout can not be negative"))
+	}
+	if s.Mysql.ReadTimeout < 0 {
+		allErrors = append(allErrors, fmt.Errorf("--mysql-read-timeout can not be negative"))
+	}
+	if s.Mysql.WriteTimeout < 0 {
+		allErrors = append(allErrors, fmt.Errorf("--mysql-write-timeout can not be negative"))
+	}
+	if (s.Mysql.TLS.CertFile == "") != (s.Mysql.TLS.KeyFile == "") {
+		allErrors = append(allErrors, fmt.Errorf("--mysql-cert-file and --mysql-key-file must be specified together"))
+	}
 	return allErrors
 }
 
@@ -47,62 +151,193 @@ func (s *MysqlOptions) AddFlags(fs *pflag.FlagSet) {
 		"enable mysql debug mode.")
 	fs.IntVar(&s.StorageConfig.Mysql.ListDefaultLimit, "mysql-default-limit", s.StorageConfig.Mysql.ListDefaultLimit, ""+
 		"the default limit for mysql query.")
+
+	fs.StringVar(&s.Store, "mysql-store", s.Store, ""+
+		"the mysql store, one of legacy or kv. The legacy store is the mysql store of the apiserver, the kv store "+
+		"keeps the objects with their revisions and supports every mysql option. The legacy store opens its "+
+		"connections with the fixed pool settings of the apiserver, so the connection pool flags and "+
+		"--mysql-replicas are refused with it. The objects of one store are not visible to the other one.")
+	fs.StringVar(&s.Mysql.CredentialsFile, "mysql-credentials-file", s.Mysql.CredentialsFile, ""+
+		"a file holding the user and password of every mysql server as user:password, "+
+		"--mysql-servers must not contain credentials then.")
+
+	fs.StringSliceVar(&s.Mysql.ReplicaList, "mysql-replicas", s.Mysql.ReplicaList, ""+
+		"the read replicas of --mysql-servers, comma separated. Gets and lists with a resourceVersion are "+
+		"read from a healthy replica that has caught up with it, writes and reads of the latest state go "+
+		"to the primary. Only used by the kv store.")
+	fs.DurationVar(&s.Mysql.MaxReplicaLag, "mysql-max-replica-lag", s.Mysql.MaxReplicaLag, ""+
+		"how far a replica may fall behind the primary, replicas that are unreachable or lag further "+
+		"are not read until they caught up.")
+
+	fs.DurationVar(&s.Mysql.WatchPollInterval, "mysql-watch-poll-interval", s.Mysql.WatchPollInterval, ""+
+		"the period the kv store polls for the writes of other apimaster instances sharing the database, "+
+		"which bounds the delay of their watch events.")
+	fs.DurationVar(&s.Mysql.CompactionInterval, "mysql-compaction-interval", s.Mysql.CompactionInterval, ""+
+		"the period the kv store removes the events older than --mysql-compaction-retention, "+
+		"0 disables the compaction and keeps every event. Only supported by the kv store, the legacy "+
+		"store is not compacted by apimaster.")
+	fs.DurationVar(&s.Mysql.CompactionRetention, "mysql-compaction-retention", s.Mysql.CompactionRetention, ""+
+		"how long the kv store keeps the events, watches from an older resourceVersion fail with 410 Gone "+
+		"and the clients list again.")
+
+	fs.IntVar(&s.Mysql.MaxOpenConns, "mysql-max-open-conns", s.Mysql.MaxOpenConns, ""+
+		"the maximum number of open connections to mysql, 0 means unlimited. Only supported by the kv store.")
+	fs.IntVar(&s.Mysql.MaxIdleConns, "mysql-max-idle-conns", s.Mysql.MaxIdleConns, ""+
+		"the maximum number of idle connections to mysql. Only supported by the kv store.")
+	fs.DurationVar(&s.Mysql.ConnMaxLifetime, "mysql-conn-max-lifetime", s.Mysql.ConnMaxLifetime, ""+
+		"the maximum amount of time a mysql connection may be reused, 0 means forever. Only supported by the kv store.")
+
+	fs.DurationVar(&s.Mysql.DialTimeout, "mysql-dial-timeout", s.Mysql.DialTimeout, ""+
+		"the timeout for establishing a mysql connection, 0 means the timeout of the server is used.")
+	fs.DurationVar(&s.Mysql.ReadTimeout, "mysql-read-timeout", s.Mysql.ReadTimeout, ""+
+		"the I/O read timeout of mysql connections, 0 means the timeout of the server is used.")
+	fs.DurationVar(&s.Mysql.WriteTimeout, "mysql-write-timeout", s.Mysql.WriteTimeout, ""+
+		"the I/O write timeout of mysql connections, 0 means the timeout of the server is used.")
+
+	fs.StringVar(&s.Mysql.TLS.CAFile, "mysql-ca-file", s.Mysql.TLS.CAFile, ""+
+		"SSL Certificate Authority file used to verify the mysql server, enables TLS.")
+	fs.StringVar(&s.Mysql.TLS.CertFile, "mysql-cert-file", s.Mysql.TLS.CertFile, ""+
+		"SSL certification file used to authenticate to mysql, enables TLS.")
+	fs.StringVar(&s.Mysql.TLS.KeyFile, "mysql-key-file", s.Mysql.TLS.KeyFile, ""+
+		"SSL key file used to authenticate to mysql.")
+	fs.StringVar(&s.Mysql.TLS.ServerName, "mysql-tls-server-name", s.Mysql.TLS.ServerName, ""+
+		"the name the mysql server certificate is verified against, enables TLS. The host of the server is used if empty.")
+}
+
+// SupportsBatch implements BatchStorageBackend, only the kv store commits a batch in
+// one transaction.
+func (s *MysqlOptions) SupportsBatch() bool {
+	return s.Store == MysqlStoreKV
+}
+
+// BackendConfig returns the storage config and default media type of mysql, the
+// servers of the legacy store carry the credentials, timeouts and TLS settings.
+func (s *MysqlOptions) BackendConfig() (*storagebackend.Config, string) {
+	if s.Store == MysqlStoreKV {
+		return &s.StorageConfig, s.DefaultStorageMediaType
+	}
+	config := s.StorageConfig
+	if servers, err := s.servers(); err == nil {
+		config.Mysql.ServerList = servers
+	}
+	return &config, s.DefaultStorageMediaType
 }
 
-//ApplyTo apply to server
+// CopyStorageBackend implements StorageBackendCopier, the copy opens its own backend.
+func (s *MysqlOptions) CopyStorageBackend() StorageBackend {
+	mysql := &MysqlOptions{
+		StorageConfig:           copyStorageConfig(s.StorageConfig),
+		DefaultStorageMediaType: s.DefaultStorageMediaType,
+		Store:                   s.Store,
+		Mysql:                   s.Mysql,
+	}
+	mysql.Mysql.ServerList = copyStrings(s.Mysql.ServerList)
+	mysql.Mysql.ReplicaList = copyStrings(s.Mysql.ReplicaList)
+	return mysql
+}
+
+// CompleteStorageConfig checks the servers can be completed with the mysql options,
+// e.g. the credentials file and the TLS files are readable.
+func (s *MysqlOptions) CompleteStorageConfig(c *server.Config) error {
+	_, err := s.servers()
+	return err
+}
+
+// SetServers overrides the mysql servers
+func (s *MysqlOptions) SetServers(servers []string) {
+	s.StorageConfig.Mysql.ServerList = servers
+}
+
+// ApplyTo apply to server
 func (s *MysqlOptions) ApplyTo(c *server.Config) error {
 	if s == nil {
 		return nil
 	}
-	c.RESTOptionsGetter = &SimpleRestOptionsFactory{Options: *s}
+	config, _ := s.BackendConfig()
+	s.applyRESTOptionsGetter(c, &StorageConfigRestOptionsFactory{StorageConfig: *config, ResourceTransformers: c.ResourceTransformers})
 	return nil
 }
 
-//ApplyWithStorageFactoryTo apply to storage factory
+// ApplyWithStorageFactoryTo apply to storage factory
 func (s *MysqlOptions) ApplyWithStorageFactoryTo(factory serverstorage.StorageFactory, c *server.Config) error {
-	c.RESTOptionsGetter = &storageFactoryRestOptionsFactory{Options: *s, StorageFactory: factory}
+	s.applyRESTOptionsGetter(c, &StorageFactoryRestOptionsFactory{StorageFactory: factory, ResourceTransformers: c.ResourceTransformers})
 	return nil
 }
 
-//SimpleRestOptionsFactory simple rest options factory
+// SimpleRestOptionsFactory simple rest options factory
 type SimpleRestOptionsFactory struct {
 	Options MysqlOptions
 }
 
-//GetRESTOptions impl generic.RESTOptions
+// GetRESTOptions impl generic.RESTOptions
 func (f *SimpleRestOptionsFactory) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
-	ret := generic.RESTOptions{
-		StorageConfig: &storagebackend.ConfigForResource{
-			Config: f.Options.StorageConfig,
+	config, _ := f.Options.BackendConfig()
+	simple := &StorageConfigRestOptionsFactory{StorageConfig: *config}
+	return simple.GetRESTOptions(resource)
+}
+
+// applyRESTOptionsGetter serves the resources from the configured store and checks its servers.
+func (s *MysqlOptions) applyRESTOptionsGetter(c *server.Config, delegate generic.RESTOptionsGetter) {
+	if s.Store != MysqlStoreKV {
+		// the pool of the legacy store is not exposed, it has no pool stats and its servers are
+		// pinged over a connection of their own
+		c.RESTOptionsGetter = &codecTransformingRestOptionsFactory{delegate: delegate}
+		servers, _ := s.servers()
+		addStorageHealthCheck(c, "mysql", sqlPingCheck("mysql", servers))
+		return
+	}
+
+	c.RESTOptionsGetter = &kvRestOptionsFactory{
+		delegate: delegate,
+		kv: func() (kvstore.KV, error) {
+			return s.getBackend()
 		},
-		Decorator:               generic.UndecoratedStorage,
-		EnableGarbageCollection: false,
-		DeleteCollectionWorkers: 0,
-		ResourcePrefix:          resource.Group + "/" + resource.Resource,
-		CountMetricPollPeriod:   0,
 	}
-	return ret, nil
+	addStorageHealthCheck(c, "mysql", func(ctx context.Context) error {
+		backend, err := s.getBackend()
+		if err != nil {
+			return err
+		}
+		return backend.DB().PingContext(ctx)
+	})
 }
 
-type storageFactoryRestOptionsFactory struct {
-	Options        MysqlOptions
-	StorageFactory serverstorage.StorageFactory
+// config returns the connection config with the servers and default limit of the storage config.
+func (s *MysqlOptions) config() mysql.Config {
+	config := s.Mysql
+	config.ServerList = s.StorageConfig.Mysql.ServerList
+	if s.StorageConfig.Mysql.ListDefaultLimit > 0 {
+		config.ListDefaultLimit = s.StorageConfig.Mysql.ListDefaultLimit
+	}
+	return config
 }
 
-func (f *storageFactoryRestOptionsFactory) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
-	storageConfig, err := f.StorageFactory.NewConfig(resource)
-	if err != nil {
-		return generic.RESTOptions{}, fmt.Errorf("unable to find storage destination for %v, due to %v", resource, err.Error())
+// servers returns the servers with the credentials, timeouts and TLS settings applied.
+func (s *MysqlOptions) servers() ([]string, error) {
+	config := s.config()
+	servers := make([]string, 0, len(config.ServerList))
+	for i, server := range config.ServerList {
+		dsn, err := config.DSN(server)
+		if err != nil {
+			return nil, fmt.Errorf("--mysql-servers server %d: %v", i, err)
+		}
+		servers = append(servers, dsn)
 	}
+	return servers, nil
+}
 
-	ret := generic.RESTOptions{
-		StorageConfig:           storageConfig,
-		Decorator:               generic.UndecoratedStorage,
-		DeleteCollectionWorkers: 0,
-		EnableGarbageCollection: false,
-		ResourcePrefix:          f.StorageFactory.ResourcePrefix(resource),
-		CountMetricPollPeriod:   0,
-	}
+// getBackend connects to mysql on first use, all resources share the connection pool.
+func (s *MysqlOptions) getBackend() (*mysql.Backend, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
 
-	return ret, nil
+	if s.backend == nil {
+		backend, err := mysql.NewBackend(s.config())
+		if err != nil {
+			return nil, err
+		}
+		storagemetrics.RegisterDBStats("mysql", backend.DB())
+		s.backend = backend
+	}
+	return s.backend, nil
 }
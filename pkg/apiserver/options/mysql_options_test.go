@@ -0,0 +1,366 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apiserver/pkg/server"
+	serverstorage "k8s.io/apiserver/pkg/server/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+
+	"github.com/seanchann/apimaster/pkg/api/legacyscheme"
+	"github.com/seanchann/apimaster/pkg/storage/mysql"
+)
+
+func TestMysqlOptionsValidate(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		modify               func(o *MysqlOptions)
+		expectErrorSubString string
+	}{
+		{
+			name: "servers only",
+			modify: func(o *MysqlOptions) {
+				o.StorageConfig.Mysql.ServerList = []string{"apimaster:secret@tcp(localhost:3306)/apimaster"}
+			},
+		},
+		{
+			name: "kv store with credentials file and tls",
+			modify: func(o *MysqlOptions) {
+				o.Store = MysqlStoreKV
+				o.StorageConfig.Mysql.ServerList = []string{"tcp(localhost:3306)/apimaster"}
+				o.Mysql.CredentialsFile = "credentials"
+				o.Mysql.TLS = mysql.TLSConfig{CAFile: "ca.pem", CertFile: "tls.crt", KeyFile: "tls.key"}
+			},
+		},
+		{
+			name: "kv store with replicas",
+			modify: func(o *MysqlOptions) {
+				o.Store = MysqlStoreKV
+				o.StorageConfig.Mysql.ServerList = []string{"apimaster:secret@tcp(primary:3306)/apimaster"}
+				o.Mysql.ReplicaList = []string{"apimaster:secret@tcp(replica-0:3306)/apimaster", "apimaster:secret@tcp(replica-1:3306)/apimaster"}
+			},
+		},
+		{
+			name:                 "missing servers",
+			modify:               func(o *MysqlOptions) {},
+			expectErrorSubString: "--mysql-servers must be specified",
+		},
+		{
+			name: "malformed server",
+			modify: func(o *MysqlOptions) {
+				o.StorageConfig.Mysql.ServerList = []string{"apimaster:secret@tcp(localhost:3306)"}
+			},
+			expectErrorSubString: "--mysql-servers invalid DSN",
+		},
+		{
+			name: "credentials file and credentials",
+			modify: func(o *MysqlOptions) {
+				o.StorageConfig.Mysql.ServerList = []string{"apimaster:secret@tcp(localhost:3306)/apimaster"}
+				o.Mysql.CredentialsFile = "credentials"
+			},
+			expectErrorSubString: "--mysql-servers must not contain credentials when --mysql-credentials-file is specified",
+		},
+		{
+			name: "replicas with legacy store",
+			modify: func(o *MysqlOptions) {
+				o.StorageConfig.Mysql.ServerList = []string{"tcp(primary:3306)/apimaster"}
+				o.Mysql.ReplicaList = []string{"tcp(replica:3306)/apimaster"}
+			},
+			expectErrorSubString: "--mysql-replicas is only supported by the kv store",
+		},
+		{
+			name: "pool with legacy store",
+			modify: func(o *MysqlOptions) {
+				o.StorageConfig.Mysql.ServerList = []string{"tcp(localhost:3306)/apimaster"}
+				o.Mysql.MaxOpenConns = 100
+			},
+			expectErrorSubString: "--mysql-max-open-conns is only supported by the kv store",
+		},
+		{
+			name: "connection lifetime with legacy store",
+			modify: func(o *MysqlOptions) {
+				o.StorageConfig.Mysql.ServerList = []string{"tcp(localhost:3306)/apimaster"}
+				o.Mysql.ConnMaxLifetime = time.Hour
+			},
+			expectErrorSubString: "--mysql-conn-max-lifetime is only supported by the kv store",
+		},
+		{
+			name: "compaction with legacy store",
+			modify: func(o *MysqlOptions) {
+				o.StorageConfig.Mysql.ServerList = []string{"tcp(localhost:3306)/apimaster"}
+				o.Mysql.CompactionRetention = 24 * time.Hour
+			},
+			expectErrorSubString: "--mysql-compaction-retention is only supported by the kv store",
+		},
+		{
+			name: "disabled compaction with legacy store",
+			modify: func(o *MysqlOptions) {
+				o.StorageConfig.Mysql.ServerList = []string{"tcp(localhost:3306)/apimaster"}
+				o.Mysql.CompactionInterval = 0
+			},
+			expectErrorSubString: "--mysql-compaction-interval is only supported by the kv store",
+		},
+		{
+			name: "pool with kv store",
+			modify: func(o *MysqlOptions) {
+				o.StorageConfig.Mysql.ServerList = []string{"tcp(localhost:3306)/apimaster"}
+				o.Store = MysqlStoreKV
+				o.Mysql.MaxOpenConns = 100
+				o.Mysql.MaxIdleConns = 20
+				o.Mysql.ConnMaxLifetime = time.Hour
+			},
+		},
+		{
+			name: "malformed replica",
+			modify: func(o *MysqlOptions) {
+				o.Store = MysqlStoreKV
+				o.StorageConfig.Mysql.ServerList = []string{"tcp(primary:3306)/apimaster"}
+				o.Mysql.ReplicaList = []string{"tcp(replica:3306)"}
+			},
+			expectErrorSubString: "--mysql-replicas invalid DSN",
+		},
+		{
+			name: "replica credentials and credentials file",
+			modify: func(o *MysqlOptions) {
+				o.Store = MysqlStoreKV
+				o.StorageConfig.Mysql.ServerList = []string{"tcp(primary:3306)/apimaster"}
+				o.Mysql.ReplicaList = []string{"apimaster:secret@tcp(replica:3306)/apimaster"}
+				o.Mysql.CredentialsFile = "credentials"
+			},
+			expectErrorSubString: "--mysql-replicas must not contain credentials when --mysql-credentials-file is specified",
+		},
+		{
+			name: "zero replica lag",
+			modify: func(o *MysqlOptions) {
+				o.Store = MysqlStoreKV
+				o.StorageConfig.Mysql.ServerList = []string{"tcp(primary:3306)/apimaster"}
+				o.Mysql.ReplicaList = []string{"tcp(replica:3306)/apimaster"}
+				o.Mysql.MaxReplicaLag = 0
+			},
+			expectErrorSubString: "--mysql-max-replica-lag must be greater than 0",
+		},
+		{
+			name: "zero watch poll interval",
+			modify: func(o *MysqlOptions) {
+				o.StorageConfig.Mysql.ServerList = []string{"tcp(localhost:3306)/apimaster"}
+				o.Mysql.WatchPollInterval = 0
+			},
+			expectErrorSubString: "--mysql-watch-poll-interval must be greater than 0",
+		},
+		{
+			name: "negative compaction interval",
+			modify: func(o *MysqlOptions) {
+				o.Store = MysqlStoreKV
+				o.StorageConfig.Mysql.ServerList = []string{"tcp(localhost:3306)/apimaster"}
+				o.Mysql.CompactionInterval = -time.Minute
+			},
+			expectErrorSubString: "--mysql-compaction-interval can not be negative",
+		},
+		{
+			name: "zero compaction retention",
+			modify: func(o *MysqlOptions) {
+				o.Store = MysqlStoreKV
+				o.StorageConfig.Mysql.ServerList = []string{"tcp(localhost:3306)/apimaster"}
+				o.Mysql.CompactionRetention = 0
+			},
+			expectErrorSubString: "--mysql-compaction-retention must be greater than 0",
+		},
+		{
+			name: "unknown store",
+			modify: func(o *MysqlOptions) {
+				o.StorageConfig.Mysql.ServerList = []string{"tcp(localhost:3306)/apimaster"}
+				o.Store = "gorm"
+			},
+			expectErrorSubString: "--mysql-store must be one of",
+		},
+		{
+			name: "negative pool size",
+			modify: func(o *MysqlOptions) {
+				o.StorageConfig.Mysql.ServerList = []string{"tcp(localhost:3306)/apimaster"}
+				o.Store = MysqlStoreKV
+				o.Mysql.MaxIdleConns = -1
+			},
+			expectErrorSubString: "--mysql-max-idle-conns can not be negative",
+		},
+		{
+			name: "negative timeout",
+			modify: func(o *MysqlOptions) {
+				o.StorageConfig.Mysql.ServerList = []string{"tcp(localhost:3306)/apimaster"}
+				o.Mysql.ReadTimeout = -time.Second
+			},
+			expectErrorSubString: "--mysql-read-timeout can not be negative",
+		},
+		{
+			name: "key without cert",
+			modify: func(o *MysqlOptions) {
+				o.StorageConfig.Mysql.ServerList = []string{"tcp(localhost:3306)/apimaster"}
+				o.Mysql.TLS.KeyFile = "tls.key"
+			},
+			expectErrorSubString: "--mysql-cert-file and --mysql-key-file must be specified together",
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			o := NewMysqlOptions(storagebackend.NewDefaultConfig("mysql", nil))
+			testcase.modify(o)
+
+			errs := o.Validate()
+			if len(testcase.expectErrorSubString) == 0 {
+				if len(errs) != 0 {
+					t.Errorf("expected no errors, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), testcase.expectErrorSubString) {
+				t.Errorf("expected error containing %q, got %v", testcase.expectErrorSubString, errs)
+			}
+		})
+	}
+}
+
+func TestMysqlOptionsAddFlags(t *testing.T) {
+	var args = []string{
+		"--mysql-servers=tcp(localhost:3306)/apimaster",
+		"--mysql-store=kv",
+		"--mysql-credentials-file=/etc/apimaster/mysql",
+		"--mysql-replicas=tcp(replica-0:3306)/apimaster,tcp(replica-1:3306)/apimaster",
+		"--mysql-max-replica-lag=10s",
+		"--mysql-watch-poll-interval=500ms",
+		"--mysql-compaction-interval=10m",
+		"--mysql-compaction-retention=24h",
+		"--mysql-max-open-conns=20",
+		"--mysql-max-idle-conns=5",
+		"--mysql-conn-max-lifetime=1h",
+		"--mysql-dial-timeout=5s",
+		"--mysql-read-timeout=30s",
+		"--mysql-write-timeout=1m",
+		"--mysql-ca-file=/etc/ssl/ca.pem",
+		"--mysql-cert-file=/etc/ssl/tls.crt",
+		"--mysql-key-file=/etc/ssl/tls.key",
+		"--mysql-tls-server-name=db",
+	}
+
+	opts := NewMysqlOptions(storagebackend.NewDefaultConfig("mysql", nil))
+	pf := pflag.NewFlagSet("test-mysql-opts", pflag.ContinueOnError)
+	opts.AddFlags(pf)
+
+	if err := pf.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := mysql.Config{
+		ReplicaList:         []string{"tcp(replica-0:3306)/apimaster", "tcp(replica-1:3306)/apimaster"},
+		MaxReplicaLag:       10 * time.Second,
+		WatchPollInterval:   500 * time.Millisecond,
+		CompactionInterval:  10 * time.Minute,
+		CompactionRetention: 24 * time.Hour,
+		CredentialsFile:     "/etc/apimaster/mysql",
+		MaxOpenConns:        20,
+		MaxIdleConns:        5,
+		ConnMaxLifetime:     time.Hour,
+		DialTimeout:         5 * time.Second,
+		ReadTimeout:         30 * time.Second,
+		WriteTimeout:        time.Minute,
+		TLS: mysql.TLSConfig{
+			CAFile:     "/etc/ssl/ca.pem",
+			CertFile:   "/etc/ssl/tls.crt",
+			KeyFile:    "/etc/ssl/tls.key",
+			ServerName: "db",
+		},
+		ListDefaultLimit: mysql.DefaultListDefaultLimit,
+	}
+	if !reflect.DeepEqual(opts.Mysql, expected) {
+		t.Errorf("expected %#v, got %#v", expected, opts.Mysql)
+	}
+	if opts.Store != MysqlStoreKV {
+		t.Errorf("expected store %q, got %q", MysqlStoreKV, opts.Store)
+	}
+	if !reflect.DeepEqual(opts.StorageConfig.Mysql.ServerList, []string{"tcp(localhost:3306)/apimaster"}) {
+		t.Errorf("unexpected servers %v", opts.StorageConfig.Mysql.ServerList)
+	}
+}
+
+func TestMysqlOptionsApplyWithStorageFactoryTo(t *testing.T) {
+	credentialsFile := filepath.Join(t.TempDir(), "credentials")
+	if err := os.WriteFile(credentialsFile, []byte("apimaster:secret"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name            string
+		store           string
+		expectedServers []string
+		expectKV        bool
+	}{
+		{
+			name:            "legacy store",
+			store:           MysqlStoreLegacy,
+			expectedServers: []string{"apimaster:secret@tcp(localhost:3306)/apimaster?readTimeout=30s&timeout=5s"},
+		},
+		{
+			name:            "kv store",
+			store:           MysqlStoreKV,
+			expectedServers: []string{"tcp(localhost:3306)/apimaster"},
+			expectKV:        true,
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			o := NewMysqlOptions(storagebackend.NewDefaultConfig("mysql", nil))
+			o.Store = testcase.store
+			o.StorageConfig.Mysql.ServerList = []string{"tcp(localhost:3306)/apimaster"}
+			o.Mysql.CredentialsFile = credentialsFile
+			o.Mysql.DialTimeout = 5 * time.Second
+			o.Mysql.ReadTimeout = 30 * time.Second
+
+			c := &server.Config{}
+			if err := o.CompleteStorageConfig(c); err != nil {
+				t.Fatal(err)
+			}
+			// completing twice keeps the configured servers
+			if err := o.CompleteStorageConfig(c); err != nil {
+				t.Fatal(err)
+			}
+			storageConfig, mediaType := o.BackendConfig()
+			if !reflect.DeepEqual(storageConfig.Mysql.ServerList, testcase.expectedServers) {
+				t.Errorf("expected servers %v, got %v", testcase.expectedServers, storageConfig.Mysql.ServerList)
+			}
+
+			storageFactory := serverstorage.NewDefaultStorageFactory(*storageConfig, mediaType, legacyscheme.Codecs,
+				serverstorage.NewDefaultResourceEncodingConfig(legacyscheme.Scheme), serverstorage.NewResourceConfig(), nil)
+			if err := o.ApplyWithStorageFactoryTo(storageFactory, c); err != nil {
+				t.Fatal(err)
+			}
+			if _, ok := c.RESTOptionsGetter.(*kvRestOptionsFactory); ok != testcase.expectKV {
+				t.Errorf("expected kv rest options getter %v, got %T", testcase.expectKV, c.RESTOptionsGetter)
+			}
+			if len(c.ReadyzChecks) != 1 || c.ReadyzChecks[0].Name() != "mysql" {
+				t.Errorf("expected mysql health check, got %v", c.ReadyzChecks)
+			}
+		})
+	}
+}
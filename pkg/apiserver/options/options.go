@@ -17,20 +17,25 @@ limitations under the License.
 package options
 
 import (
+	"fmt"
 	"net"
 
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	utilnet "k8s.io/apimachinery/pkg/util/net"
 	genericoptions "k8s.io/apiserver/pkg/server/options"
-	"k8s.io/apiserver/pkg/storage/storagebackend"
 	cliflag "k8s.io/component-base/cli/flag"
 )
 
 type StorageBackendType string
 
 const (
-	StorageBackendTypeSqlite StorageBackendType = "sqlite"
-	StorageBackendTypeEtcd   StorageBackendType = "etcd"
-	StorageBackendTypeMysql  StorageBackendType = "mysql"
+	StorageBackendTypeSqlite   StorageBackendType = "sqlite"
+	StorageBackendTypeEtcd     StorageBackendType = "etcd"
+	StorageBackendTypeMysql    StorageBackendType = "mysql"
+	StorageBackendTypeMongoDB  StorageBackendType = "mongodb"
+	StorageBackendTypeDynamoDB StorageBackendType = "dynamodb"
+	StorageBackendTypeMemory   StorageBackendType = "memory"
+	StorageBackendTypePostgres StorageBackendType = "postgres"
 )
 
 // DefaultServiceNodePortRange is the default port range for NodePort services.
@@ -44,13 +49,22 @@ const DefaultEtcdPathPrefix = "/registry"
 
 // APIMasterOptions
 type APIMasterOptions struct {
+	// Backend is the name of the storage backend set by --storage-backend, Storage is its options.
 	Backend                 StorageBackendType
 	GenericServerRunOptions *genericoptions.ServerRunOptions
-	Sqlite                  *SqliteOptions
-	Etcd                    *genericoptions.EtcdOptions
-	Mysql                   *MysqlOptions
+	Storage                 StorageBackend
+	// StorageBackends holds the options of every registered storage backend, so their flags
+	// can be set before the backend is selected.
+	StorageBackends         map[StorageBackendType]StorageBackend
+	StorageRouting          *StorageRoutingOptions
+	Encryption              *EncryptionOptions
+	WatchCache              *WatchCacheOptions
+	GarbageCollector        *GarbageCollectorOptions
+	StorageMetrics          *StorageMetricsOptions
+	StorageVersionMigration *StorageVersionMigrationOptions
 	SecureServing           *genericoptions.SecureServingOptionsWithLoopback
 	InsecureServing         *genericoptions.DeprecatedInsecureServingOptions
+	UnixSocketServing       *UnixSocketServingOptions
 	Audit                   *genericoptions.AuditOptions
 	Features                *genericoptions.FeatureOptions
 	Authentication          *BuiltInAuthenticationOptions
@@ -60,36 +74,85 @@ type APIMasterOptions struct {
 	EgressSelector          *genericoptions.EgressSelectorOptions
 	Traces                  *genericoptions.TracingOptions
 	Admission               *AdmissionOptions
+	ConfigFile              *ConfigFileOptions
+	ConfigReload            *ConfigReloadOptions
+
+	// Deprecated: use StorageBackends[StorageBackendTypeSqlite], which holds the same options.
+	Sqlite *SqliteOptions
+	// Deprecated: use StorageBackends[StorageBackendTypeEtcd], which holds the same options.
+	Etcd *genericoptions.EtcdOptions
+	// Deprecated: use StorageBackends[StorageBackendTypeMysql], which holds the same options.
+	Mysql *MysqlOptions
 }
 
-// NewAPIMasterOptions new a APIMasterOptions
+// NewAPIMasterOptions new a APIMasterOptions. An unknown backend is reported by Validate,
+// use NewAPIMasterOptionsWithBackend to get the error right away.
 func NewAPIMasterOptions(admission AdmissionProvider, backend StorageBackendType) *APIMasterOptions {
+	o, _ := newAPIMasterOptions(admission, backend)
+	return o
+}
+
+// NewAPIMasterOptionsWithBackend new a APIMasterOptions, backend must be a registered storage backend.
+func NewAPIMasterOptionsWithBackend(admission AdmissionProvider, backend StorageBackendType) (*APIMasterOptions, error) {
+	o, err := newAPIMasterOptions(admission, backend)
+	if err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// newAPIMasterOptions returns the options with backend selected, and the error of selecting
+// an unknown backend.
+func newAPIMasterOptions(admission AdmissionProvider, backend StorageBackendType) (*APIMasterOptions, error) {
 	o := &APIMasterOptions{
 		Backend:                 backend,
 		GenericServerRunOptions: genericoptions.NewServerRunOptions(),
 		SecureServing:           NewSecureServingOptions(),
 		InsecureServing:         NewInsecureServingOptions(),
+		UnixSocketServing:       NewUnixSocketServingOptions(),
 		Audit:                   genericoptions.NewAuditOptions(),
 		Features:                genericoptions.NewFeatureOptions(),
 		Authentication:          NewBuiltInAuthenticationOptions().WithWebHook(),
 		Authorization:           NewBuiltInAuthorizationOptions(),
 		StorageSerialization:    NewStorageSerializationOptions(),
+		StorageRouting:          NewStorageRoutingOptions(),
+		Encryption:              NewEncryptionOptions(),
+		WatchCache:              NewWatchCacheOptions(),
+		GarbageCollector:        NewGarbageCollectorOptions(),
+		StorageMetrics:          NewStorageMetricsOptions(),
+		StorageVersionMigration: NewStorageVersionMigrationOptions(),
 		APIEnablement:           genericoptions.NewAPIEnablementOptions(),
 		EgressSelector:          genericoptions.NewEgressSelectorOptions(),
 		Traces:                  genericoptions.NewTracingOptions(),
 		Admission:               NewAdmissionOptions(admission),
+		ConfigFile:              NewConfigFileOptions(),
+		ConfigReload:            NewConfigReloadOptions(),
 	}
 
-	switch backend {
-	case StorageBackendTypeSqlite:
-		o.Sqlite = NewSqliteOptions(storagebackend.NewDefaultConfig("sqlite", nil))
-	case StorageBackendTypeEtcd:
-		o.Etcd = genericoptions.NewEtcdOptions(storagebackend.NewDefaultConfig(DefaultEtcdPathPrefix, nil))
-	case StorageBackendTypeMysql:
-		o.Mysql = NewMysqlOptions(storagebackend.NewDefaultConfig("mysql", nil))
+	// the config files of authentication, authorization, admission and the unix socket are reloaded by ConfigReload
+	o.Authentication.Reloaders = o.ConfigReload.Reloaders
+	o.Authorization.Reloaders = o.ConfigReload.Reloaders
+	o.Admission.Reloaders = o.ConfigReload.Reloaders
+	o.UnixSocketServing.Reloaders = o.ConfigReload.Reloaders
+
+	o.StorageBackends = map[StorageBackendType]StorageBackend{}
+	for _, name := range RegisteredStorageBackends() {
+		if storage, err := NewStorageBackend(name); err == nil {
+			o.StorageBackends[name] = storage
+		}
 	}
+	err := o.SetStorageBackend(backend)
+	// the backends of the routes start from the options of the configured backends
+	o.StorageRouting.configured = o.StorageBackends
+
+	// the deprecated fields share the options of the registered backends
+	o.Sqlite, _ = o.StorageBackends[StorageBackendTypeSqlite].(*SqliteOptions)
+	if etcd, ok := o.StorageBackends[StorageBackendTypeEtcd].(*EtcdOptions); ok {
+		o.Etcd = etcd.EtcdOptions
+	}
+	o.Mysql, _ = o.StorageBackends[StorageBackendTypeMysql].(*MysqlOptions)
 
-	return o
+	return o, err
 }
 
 // AddFlags adds flags for a specific APIServer to the specified FlagSet
@@ -97,21 +160,51 @@ func (o *APIMasterOptions) AddFlags(fss *cliflag.NamedFlagSets) {
 	o.GenericServerRunOptions.AddUniversalFlags(fss.FlagSet("generic"))
 	o.SecureServing.AddFlags(fss.FlagSet("secure serving"))
 	o.InsecureServing.AddFlags(fss.FlagSet("insecure serving"))
+	o.UnixSocketServing.AddFlags(fss.FlagSet("unix socket serving"))
 	o.Audit.AddFlags(fss.FlagSet("auditing"))
 	o.Features.AddFlags(fss.FlagSet("features"))
 	o.Authentication.AddFlags(fss.FlagSet("authentication"))
 	o.Authorization.AddFlags(fss.FlagSet("authorization"))
 	o.StorageSerialization.AddFlags(fss.FlagSet("storage serialization"))
+	o.StorageRouting.AddFlags(fss.FlagSet("storage routing"))
+	o.Encryption.AddFlags(fss.FlagSet("encryption"))
+	o.WatchCache.AddFlags(fss.FlagSet("watch cache"))
+	o.GarbageCollector.AddFlags(fss.FlagSet("garbage collector"))
+	o.StorageMetrics.AddFlags(fss.FlagSet("storage metrics"))
+	o.StorageVersionMigration.AddFlags(fss.FlagSet("storage version migration"))
 	o.APIEnablement.AddFlags(fss.FlagSet("api enablement"))
 	o.Admission.AddFlags(fss.FlagSet("admission"))
+	o.ConfigFile.AddFlags(fss.FlagSet("config file"))
+	o.ConfigReload.AddFlags(fss.FlagSet("config reload"))
+	o.ConfigFile.flags = fss
+
+	fss.FlagSet("storage backend").Var(&storageBackendValue{options: o}, "storage-backend", ""+
+		"The storage backend to serve the resources from, one of "+fmt.Sprintf("%v", RegisteredStorageBackends())+
+		". Only the flags of the selected backend are validated and used.")
+	for _, name := range RegisteredStorageBackends() {
+		if storage, ok := o.StorageBackends[name]; ok {
+			storage.AddFlags(fss.FlagSet(string(name)))
+		}
+	}
+}
 
-	switch o.Backend {
-	case StorageBackendTypeSqlite:
-		o.Sqlite.AddFlags(fss.FlagSet("sqlite"))
-	case StorageBackendTypeEtcd:
-		o.Etcd.AddFlags(fss.FlagSet("etcd"))
-	case StorageBackendTypeMysql:
-		o.Mysql.AddFlags(fss.FlagSet("mysql"))
+// SupportsBatch reports whether the storage resource is served from can take part in a batch.
+func (o *APIMasterOptions) SupportsBatch(resource schema.GroupResource) bool {
+	if backend, ok := o.StorageRouting.backend(resource); ok {
+		return supportsBatch(backend)
 	}
+	return o.Storage != nil && supportsBatch(o.Storage)
+}
 
+// SetStorageBackend selects the registered storage backend name to serve the resources from.
+func (o *APIMasterOptions) SetStorageBackend(name StorageBackendType) error {
+	o.Backend = name
+	o.WatchCache.defaultForBackend(name)
+	storage, ok := o.StorageBackends[name]
+	if !ok {
+		o.Storage = nil
+		return fmt.Errorf("unknown storage backend %q, registered backends are %v", name, RegisteredStorageBackends())
+	}
+	o.Storage = storage
+	return nil
 }
@@ -0,0 +1,175 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+
+	"github.com/seanchann/apimaster/pkg/storage/postgres"
+)
+
+func TestPostgresOptionsValidate(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		modify               func(o *PostgresOptions)
+		expectErrorSubString string
+	}{
+		{
+			name: "servers only",
+			modify: func(o *PostgresOptions) {
+				o.Postgres.ServerList = []string{"postgres://apimaster@localhost:5432/apimaster"}
+			},
+		},
+		{
+			name: "tls",
+			modify: func(o *PostgresOptions) {
+				o.Postgres.ServerList = []string{"host=localhost dbname=apimaster"}
+				o.Postgres.TLS = postgres.TLSConfig{SSLMode: "verify-full", CAFile: "ca.pem", CertFile: "tls.crt", KeyFile: "tls.key"}
+			},
+		},
+		{
+			name:                 "missing servers",
+			modify:               func(o *PostgresOptions) {},
+			expectErrorSubString: "--postgres-servers must be specified",
+		},
+		{
+			name: "malformed server url",
+			modify: func(o *PostgresOptions) {
+				o.Postgres.ServerList = []string{"postgres://localhost:port/apimaster"}
+			},
+			expectErrorSubString: "--postgres-servers invalid postgres url",
+		},
+		{
+			name: "negative pool size",
+			modify: func(o *PostgresOptions) {
+				o.Postgres.ServerList = []string{"host=localhost"}
+				o.Postgres.MaxOpenConns = -1
+			},
+			expectErrorSubString: "--postgres-max-open-conns can not be negative",
+		},
+		{
+			name: "zero default limit",
+			modify: func(o *PostgresOptions) {
+				o.Postgres.ServerList = []string{"host=localhost"}
+				o.Postgres.ListDefaultLimit = 0
+			},
+			expectErrorSubString: "--postgres-default-limit must be greater than 0",
+		},
+		{
+			name: "unknown sslmode",
+			modify: func(o *PostgresOptions) {
+				o.Postgres.ServerList = []string{"host=localhost"}
+				o.Postgres.TLS.SSLMode = "prefer"
+			},
+			expectErrorSubString: "--postgres-sslmode must be one of",
+		},
+		{
+			name: "cert without key",
+			modify: func(o *PostgresOptions) {
+				o.Postgres.ServerList = []string{"host=localhost"}
+				o.Postgres.TLS.CertFile = "tls.crt"
+			},
+			expectErrorSubString: "--postgres-cert-file and --postgres-key-file must be specified together",
+		},
+		{
+			name: "compaction disabled",
+			modify: func(o *PostgresOptions) {
+				o.Postgres.ServerList = []string{"host=localhost"}
+				o.Postgres.CompactionInterval = 0
+				o.Postgres.CompactionRetention = 0
+			},
+		},
+		{
+			name: "zero compaction retention",
+			modify: func(o *PostgresOptions) {
+				o.Postgres.ServerList = []string{"host=localhost"}
+				o.Postgres.CompactionRetention = 0
+			},
+			expectErrorSubString: "--postgres-compaction-retention must be greater than 0",
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			o := NewPostgresOptions(storagebackend.NewDefaultConfig("postgres", nil))
+			testcase.modify(o)
+
+			errs := o.Validate()
+			if len(testcase.expectErrorSubString) == 0 {
+				if len(errs) != 0 {
+					t.Errorf("expected no errors, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), testcase.expectErrorSubString) {
+				t.Errorf("expected error containing %q, got %v", testcase.expectErrorSubString, errs)
+			}
+		})
+	}
+}
+
+func TestPostgresOptionsAddFlags(t *testing.T) {
+	var args = []string{
+		"--postgres-servers=postgres://localhost:5432/apimaster,host=otherhost dbname=apimaster",
+		"--postgres-max-open-conns=20",
+		"--postgres-max-idle-conns=5",
+		"--postgres-conn-max-lifetime=1h",
+		"--postgres-sslmode=verify-ca",
+		"--postgres-ca-file=/etc/ssl/ca.pem",
+		"--postgres-cert-file=/etc/ssl/tls.crt",
+		"--postgres-key-file=/etc/ssl/tls.key",
+		"--postgres-default-limit=100",
+		"--postgres-compaction-interval=10m",
+		"--postgres-compaction-retention=24h",
+	}
+
+	opts := NewPostgresOptions(storagebackend.NewDefaultConfig("postgres", nil))
+	pf := pflag.NewFlagSet("test-postgres-opts", pflag.ContinueOnError)
+	opts.AddFlags(pf)
+
+	if err := pf.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := postgres.Config{
+		ServerList:      []string{"postgres://localhost:5432/apimaster", "host=otherhost dbname=apimaster"},
+		MaxOpenConns:    20,
+		MaxIdleConns:    5,
+		ConnMaxLifetime: time.Hour,
+		TLS: postgres.TLSConfig{
+			SSLMode:  "verify-ca",
+			CAFile:   "/etc/ssl/ca.pem",
+			CertFile: "/etc/ssl/tls.crt",
+			KeyFile:  "/etc/ssl/tls.key",
+		},
+		CompactionInterval:  10 * time.Minute,
+		CompactionRetention: 24 * time.Hour,
+		ListDefaultLimit:    100,
+	}
+	if !reflect.DeepEqual(opts.Postgres, expected) {
+		t.Errorf("expected %#v, got %#v", expected, opts.Postgres)
+	}
+	if opts.StorageConfig.Type != postgres.StorageTypePostgres {
+		t.Errorf("expected storage type %q, got %q", postgres.StorageTypePostgres, opts.StorageConfig.Type)
+	}
+}
@@ -65,18 +65,44 @@ func (s *SqliteOptions) AddFlags(fs *pflag.FlagSet) {
 		"the default limit for sqlite query.")
 }
 
+// BackendConfig returns the storage config and default media type of sqlite
+func (s *SqliteOptions) BackendConfig() (*storagebackend.Config, string) {
+	return &s.StorageConfig, s.DefaultStorageMediaType
+}
+
+// CopyStorageBackend implements StorageBackendCopier
+func (s *SqliteOptions) CopyStorageBackend() StorageBackend {
+	return &SqliteOptions{
+		StorageConfig:           copyStorageConfig(s.StorageConfig),
+		DefaultStorageMediaType: s.DefaultStorageMediaType,
+	}
+}
+
+// SetServers overrides the sqlite dsn, only the first server is used
+func (s *SqliteOptions) SetServers(servers []string) {
+	if len(servers) > 0 {
+		s.StorageConfig.Sqlite.DSN = servers[0]
+	}
+}
+
 // ApplyTo apply to server
 func (s *SqliteOptions) ApplyTo(c *server.Config) error {
 	if s == nil {
 		return nil
 	}
-	c.RESTOptionsGetter = &SqliteSimpleRestOptionsFactory{Options: *s}
+	c.RESTOptionsGetter = &codecTransformingRestOptionsFactory{
+		delegate: &StorageConfigRestOptionsFactory{StorageConfig: s.StorageConfig, ResourceTransformers: c.ResourceTransformers},
+	}
+	addStorageHealthCheck(c, "sqlite", sqlPingCheck("sqlite3", []string{s.StorageConfig.Sqlite.DSN}))
 	return nil
 }
 
 // ApplyWithStorageFactoryTo apply to storage factory
 func (s *SqliteOptions) ApplyWithStorageFactoryTo(factory serverstorage.StorageFactory, c *server.Config) error {
-	c.RESTOptionsGetter = &sqliteStorageFactoryRestOptionsFactory{Options: *s, StorageFactory: factory}
+	c.RESTOptionsGetter = &codecTransformingRestOptionsFactory{
+		delegate: &StorageFactoryRestOptionsFactory{StorageFactory: factory, ResourceTransformers: c.ResourceTransformers},
+	}
+	addStorageHealthCheck(c, "sqlite", sqlPingCheck("sqlite3", []string{s.StorageConfig.Sqlite.DSN}))
 	return nil
 }
 
@@ -87,38 +113,6 @@ type SqliteSimpleRestOptionsFactory struct {
 
 // GetRESTOptions impl generic.RESTOptions
 func (f *SqliteSimpleRestOptionsFactory) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
-	ret := generic.RESTOptions{
-		StorageConfig: &storagebackend.ConfigForResource{
-			Config: f.Options.StorageConfig,
-		},
-		Decorator:               generic.UndecoratedStorage,
-		EnableGarbageCollection: false,
-		DeleteCollectionWorkers: 0,
-		ResourcePrefix:          resource.Group + "/" + resource.Resource,
-		CountMetricPollPeriod:   0,
-	}
-	return ret, nil
-}
-
-type sqliteStorageFactoryRestOptionsFactory struct {
-	Options        SqliteOptions
-	StorageFactory serverstorage.StorageFactory
-}
-
-func (f *sqliteStorageFactoryRestOptionsFactory) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
-	storageConfig, err := f.StorageFactory.NewConfig(resource)
-	if err != nil {
-		return generic.RESTOptions{}, fmt.Errorf("unable to find storage destination for %v, due to %v", resource, err.Error())
-	}
-
-	ret := generic.RESTOptions{
-		StorageConfig:           storageConfig,
-		Decorator:               generic.UndecoratedStorage,
-		DeleteCollectionWorkers: 0,
-		EnableGarbageCollection: false,
-		ResourcePrefix:          f.StorageFactory.ResourcePrefix(resource),
-		CountMetricPollPeriod:   0,
-	}
-
-	return ret, nil
+	simple := &StorageConfigRestOptionsFactory{StorageConfig: f.Options.StorageConfig}
+	return simple.GetRESTOptions(resource)
 }
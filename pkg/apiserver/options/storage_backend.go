@@ -0,0 +1,288 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/server"
+	serverstorage "k8s.io/apiserver/pkg/server/storage"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+	"k8s.io/apiserver/pkg/storage/storagebackend/factory"
+	storagevalue "k8s.io/apiserver/pkg/storage/value"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+)
+
+// StorageBackend is implemented by every storage backend that apimaster can serve resources from.
+// A backend owns its flags, validation, storage config and RESTOptionsGetter.
+type StorageBackend interface {
+	// AddFlags adds flags related to the backend to the specified FlagSet
+	AddFlags(fs *pflag.FlagSet)
+	// Validate checks the backend options and return a slice of found errors.
+	Validate() []error
+	// BackendConfig returns the storage config and the default storage media type
+	// used to build the storage factory.
+	BackendConfig() (*storagebackend.Config, string)
+	// ApplyWithStorageFactoryTo sets the RESTOptionsGetter of the backend on the server config.
+	ApplyWithStorageFactoryTo(factory serverstorage.StorageFactory, c *server.Config) error
+}
+
+// StorageConfigCompleter is an optional interface of StorageBackend. It is called with the
+// generic server config before the storage factory is built, e.g. to set up egress or tracing.
+type StorageConfigCompleter interface {
+	CompleteStorageConfig(c *server.Config) error
+}
+
+// BatchStorageBackend is an optional interface of StorageBackend. The writes of a batch are
+// only served by the backends that commit them in one transaction, the other ones answer
+// 501 Not Implemented.
+type BatchStorageBackend interface {
+	SupportsBatch() bool
+}
+
+// supportsBatch reports whether the storage of backend can take part in a batch.
+func supportsBatch(backend StorageBackend) bool {
+	b, ok := backend.(BatchStorageBackend)
+	return ok && b.SupportsBatch()
+}
+
+// StorageBackendFactory creates a StorageBackend with default values.
+type StorageBackendFactory func() StorageBackend
+
+var (
+	storageBackendsLock sync.RWMutex
+	storageBackends     = map[StorageBackendType]StorageBackendFactory{}
+)
+
+func init() {
+	RegisterStorageBackend(StorageBackendTypeSqlite, func() StorageBackend {
+		return NewSqliteOptions(storagebackend.NewDefaultConfig("sqlite", nil))
+	})
+	RegisterStorageBackend(StorageBackendTypeEtcd, func() StorageBackend {
+		return NewEtcdOptions(storagebackend.NewDefaultConfig(DefaultEtcdPathPrefix, nil))
+	})
+	RegisterStorageBackend(StorageBackendTypeMysql, func() StorageBackend {
+		return NewMysqlOptions(storagebackend.NewDefaultConfig("mysql", nil))
+	})
+	RegisterStorageBackend(StorageBackendTypeMongoDB, func() StorageBackend {
+		return NewMongoDBOptions(storagebackend.NewDefaultConfig("mongodb", nil))
+	})
+	RegisterStorageBackend(StorageBackendTypeDynamoDB, func() StorageBackend {
+		return NewDynamoDBOptions(storagebackend.NewDefaultConfig("dynamodb", nil))
+	})
+	RegisterStorageBackend(StorageBackendTypeMemory, func() StorageBackend {
+		return NewMemoryOptions(storagebackend.NewDefaultConfig("memory", nil))
+	})
+	RegisterStorageBackend(StorageBackendTypePostgres, func() StorageBackend {
+		return NewPostgresOptions(storagebackend.NewDefaultConfig("postgres", nil))
+	})
+}
+
+// RegisterStorageBackend registers a storage backend factory by name. It is expected
+// to be called from an init function, and registering the same name twice is fatal.
+func RegisterStorageBackend(name StorageBackendType, factory StorageBackendFactory) {
+	storageBackendsLock.Lock()
+	defer storageBackendsLock.Unlock()
+
+	if _, found := storageBackends[name]; found {
+		klog.Fatalf("Storage backend %q was registered twice", name)
+	}
+	klog.V(1).Infof("Registered storage backend %q", name)
+	storageBackends[name] = factory
+}
+
+// NewStorageBackend creates the storage backend registered with name.
+func NewStorageBackend(name StorageBackendType) (StorageBackend, error) {
+	storageBackendsLock.RLock()
+	factory, found := storageBackends[name]
+	storageBackendsLock.RUnlock()
+
+	if !found {
+		return nil, fmt.Errorf("unknown storage backend %q, registered backends are %v", name, RegisteredStorageBackends())
+	}
+	return factory(), nil
+}
+
+// RegisteredStorageBackends returns the sorted names of all registered storage backends.
+func RegisteredStorageBackends() []StorageBackendType {
+	storageBackendsLock.RLock()
+	defer storageBackendsLock.RUnlock()
+
+	names := make([]StorageBackendType, 0, len(storageBackends))
+	for name := range storageBackends {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool { return names[i] < names[j] })
+	return names
+}
+
+// storageBackendValue is the pflag.Value of --storage-backend.
+type storageBackendValue struct {
+	options *APIMasterOptions
+}
+
+var _ pflag.Value = &storageBackendValue{}
+
+// String implements pflag.Value.
+func (v *storageBackendValue) String() string {
+	return string(v.options.Backend)
+}
+
+// Set implements pflag.Value.
+func (v *storageBackendValue) Set(value string) error {
+	return v.options.SetStorageBackend(StorageBackendType(value))
+}
+
+// Type implements pflag.Value.
+func (v *storageBackendValue) Type() string {
+	return "string"
+}
+
+// StorageConfigRestOptionsFactory rest options factory serving every resource from a storage config
+type StorageConfigRestOptionsFactory struct {
+	StorageConfig        storagebackend.Config
+	ResourceTransformers storagevalue.ResourceTransformers
+}
+
+// GetRESTOptions impl generic.RESTOptions
+func (f *StorageConfigRestOptionsFactory) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	storageConfig := f.StorageConfig
+	if f.ResourceTransformers != nil {
+		storageConfig.Transformer = f.ResourceTransformers.TransformerForResource(resource)
+	}
+	ret := generic.RESTOptions{
+		StorageConfig:           storageConfig.ForResource(resource),
+		Decorator:               batchRejectingStorage(generic.UndecoratedStorage),
+		EnableGarbageCollection: false,
+		DeleteCollectionWorkers: 0,
+		ResourcePrefix:          resource.Group + "/" + resource.Resource,
+		CountMetricPollPeriod:   0,
+	}
+	return ret, nil
+}
+
+// StorageFactoryRestOptionsFactory rest options factory backed by a storage factory
+type StorageFactoryRestOptionsFactory struct {
+	StorageFactory       serverstorage.StorageFactory
+	ResourceTransformers storagevalue.ResourceTransformers
+}
+
+// GetRESTOptions impl generic.RESTOptions
+func (f *StorageFactoryRestOptionsFactory) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	storageConfig, err := f.StorageFactory.NewConfig(resource)
+	if err != nil {
+		return generic.RESTOptions{}, fmt.Errorf("unable to find storage destination for %v, due to %v", resource, err.Error())
+	}
+	if f.ResourceTransformers != nil {
+		configCopy := *storageConfig
+		configCopy.Config.Transformer = f.ResourceTransformers.TransformerForResource(resource)
+		storageConfig = &configCopy
+	}
+
+	ret := generic.RESTOptions{
+		StorageConfig:           storageConfig,
+		Decorator:               batchRejectingStorage(generic.UndecoratedStorage),
+		DeleteCollectionWorkers: 0,
+		EnableGarbageCollection: false,
+		ResourcePrefix:          f.StorageFactory.ResourcePrefix(resource),
+		CountMetricPollPeriod:   0,
+	}
+
+	return ret, nil
+}
+
+// batchRejectingStorage returns a decorator, whose storage fails the writes in a batch,
+// since only the kv stores commit a batch in one transaction.
+func batchRejectingStorage(decorator generic.StorageDecorator) generic.StorageDecorator {
+	return func(
+		config *storagebackend.ConfigForResource,
+		resourcePrefix string,
+		keyFunc func(obj runtime.Object) (string, error),
+		newFunc func() runtime.Object,
+		newListFunc func() runtime.Object,
+		getAttrsFunc storage.AttrFunc,
+		trigger storage.IndexerFuncs,
+		indexers *cache.Indexers) (storage.Interface, factory.DestroyFunc, error) {
+		s, d, err := decorator(config, resourcePrefix, keyFunc, newFunc, newListFunc, getAttrsFunc, trigger, indexers)
+		if err != nil {
+			return s, d, err
+		}
+		return kvstore.RejectBatches(s), d, nil
+	}
+}
+
+// batchRejectingRestOptionsFactory rest options factory whose storages fail the writes in a batch
+type batchRejectingRestOptionsFactory struct {
+	delegate generic.RESTOptionsGetter
+}
+
+// GetRESTOptions impl generic.RESTOptions
+func (f *batchRejectingRestOptionsFactory) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	ret, err := f.delegate.GetRESTOptions(resource)
+	if err != nil {
+		return generic.RESTOptions{}, err
+	}
+	ret.Decorator = batchRejectingStorage(ret.Decorator)
+	return ret, nil
+}
+
+// kvRestOptionsFactory serves every resource from a single kvstore.KV, which is
+// created by kv on first use.
+type kvRestOptionsFactory struct {
+	delegate generic.RESTOptionsGetter
+	kv       func() (kvstore.KV, error)
+}
+
+// GetRESTOptions impl generic.RESTOptions
+func (f *kvRestOptionsFactory) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	ret, err := f.delegate.GetRESTOptions(resource)
+	if err != nil {
+		return generic.RESTOptions{}, err
+	}
+	ret.Decorator = f.decorate
+	return ret, nil
+}
+
+// decorate implements generic.StorageDecorator, every resource shares the same KV.
+func (f *kvRestOptionsFactory) decorate(
+	config *storagebackend.ConfigForResource,
+	resourcePrefix string,
+	keyFunc func(obj runtime.Object) (string, error),
+	newFunc func() runtime.Object,
+	newListFunc func() runtime.Object,
+	getAttrsFunc storage.AttrFunc,
+	trigger storage.IndexerFuncs,
+	indexers *cache.Indexers) (storage.Interface, factory.DestroyFunc, error) {
+	if config.Codec == nil {
+		return nil, nil, fmt.Errorf("no codec configured for %v", config.GroupResource)
+	}
+	kv, err := f.kv()
+	if err != nil {
+		return nil, nil, err
+	}
+	return kvstore.New(kv, config.Codec, newFunc, newListFunc, config.Prefix, config.Transformer, getAttrsFunc), func() {}, nil
+}
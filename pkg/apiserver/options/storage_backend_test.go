@@ -0,0 +1,328 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/server"
+	serverstorage "k8s.io/apiserver/pkg/server/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+	cliflag "k8s.io/component-base/cli/flag"
+)
+
+type fakeAdmissionProvider struct{}
+
+func (fakeAdmissionProvider) RegisterAllAdmissionPlugins(*admission.Plugins) {}
+func (fakeAdmissionProvider) DefaultOffAdmissionPlugins() sets.String        { return sets.NewString() }
+
+// AllPluginOrder returns the plugins registered by the generic admission options.
+func (fakeAdmissionProvider) AllPluginOrder() []string {
+	return []string{"NamespaceLifecycle", "MutatingAdmissionWebhook", "ValidatingAdmissionPolicy", "ValidatingAdmissionWebhook"}
+}
+
+// newTestAPIMasterOptions returns the options serving from backend.
+func newTestAPIMasterOptions(t *testing.T, backend StorageBackendType) *APIMasterOptions {
+	t.Helper()
+	o, err := NewAPIMasterOptionsWithBackend(fakeAdmissionProvider{}, backend)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return o
+}
+
+type fakeStorageBackend struct {
+	config storagebackend.Config
+	dsn    string
+}
+
+func (f *fakeStorageBackend) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&f.dsn, "fake-dsn", f.dsn, "fake dsn.")
+}
+
+func (f *fakeStorageBackend) Validate() []error {
+	if len(f.dsn) == 0 {
+		return []error{fmt.Errorf("--fake-dsn must be specified")}
+	}
+	return nil
+}
+
+func (f *fakeStorageBackend) BackendConfig() (*storagebackend.Config, string) {
+	return &f.config, "application/json"
+}
+
+func (f *fakeStorageBackend) ApplyWithStorageFactoryTo(factory serverstorage.StorageFactory, c *server.Config) error {
+	c.RESTOptionsGetter = &StorageFactoryRestOptionsFactory{StorageFactory: factory}
+	return nil
+}
+
+func TestRegisterStorageBackend(t *testing.T) {
+	const fakeBackend StorageBackendType = "fake"
+	RegisterStorageBackend(fakeBackend, func() StorageBackend {
+		return &fakeStorageBackend{config: *storagebackend.NewDefaultConfig("fake", nil)}
+	})
+
+	o := newTestAPIMasterOptions(t, fakeBackend)
+	if _, ok := o.Storage.(*fakeStorageBackend); !ok {
+		t.Fatalf("expected fake storage backend, got %T", o.Storage)
+	}
+
+	fss := cliflag.NamedFlagSets{}
+	o.AddFlags(&fss)
+	fs, ok := fss.FlagSets[string(fakeBackend)]
+	if !ok {
+		t.Fatalf("expected flag set %q to be registered", fakeBackend)
+	}
+	if err := fs.Parse([]string{"--fake-dsn=fake://localhost"}); err != nil {
+		t.Fatal(err)
+	}
+	if errs := o.Storage.Validate(); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+}
+
+func TestNewStorageBackend(t *testing.T) {
+	testCases := []struct {
+		backend     StorageBackendType
+		expectType  string
+		expectError bool
+	}{
+		{backend: StorageBackendTypeSqlite, expectType: "*options.SqliteOptions"},
+		{backend: StorageBackendTypeEtcd, expectType: "*options.EtcdOptions"},
+		{backend: StorageBackendTypeMysql, expectType: "*options.MysqlOptions"},
+		{backend: StorageBackendTypeMongoDB, expectType: "*options.MongoDBOptions"},
+		{backend: StorageBackendTypeDynamoDB, expectType: "*options.DynamoDBOptions"},
+		{backend: StorageBackendTypeMemory, expectType: "*options.MemoryOptions"},
+		{backend: StorageBackendTypePostgres, expectType: "*options.PostgresOptions"},
+		{backend: "unknown", expectError: true},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(string(testcase.backend), func(t *testing.T) {
+			backend, err := NewStorageBackend(testcase.backend)
+			if testcase.expectError {
+				if err == nil {
+					t.Errorf("expected error for backend %q", testcase.backend)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fmt.Sprintf("%T", backend); got != testcase.expectType {
+				t.Errorf("expected %s, got %s", testcase.expectType, got)
+			}
+		})
+	}
+}
+
+func TestNewAPIMasterOptions(t *testing.T) {
+	if _, err := NewAPIMasterOptionsWithBackend(fakeAdmissionProvider{}, "unknown"); err == nil || !strings.Contains(err.Error(), `unknown storage backend "unknown"`) {
+		t.Errorf("expected an unknown storage backend error, got %v", err)
+	}
+
+	// the unknown backend of NewAPIMasterOptions is reported by Validate
+	unknown := NewAPIMasterOptions(fakeAdmissionProvider{}, "unknown")
+	if unknown == nil || unknown.Storage != nil {
+		t.Fatalf("expected options without a storage backend, got %v", unknown)
+	}
+	found := false
+	for _, err := range unknown.Validate() {
+		found = found || strings.Contains(err.Error(), `unknown storage backend "unknown"`)
+	}
+	if !found {
+		t.Errorf("expected Validate to report the unknown storage backend")
+	}
+
+	// the deprecated fields are the options of the registered backends
+	o := newTestAPIMasterOptions(t, StorageBackendTypeSqlite)
+	if o.Sqlite == nil || o.Sqlite != o.StorageBackends[StorageBackendTypeSqlite] {
+		t.Errorf("expected Sqlite to be the sqlite backend, got %v", o.Sqlite)
+	}
+	if etcd, ok := o.StorageBackends[StorageBackendTypeEtcd].(*EtcdOptions); !ok || o.Etcd != etcd.EtcdOptions {
+		t.Errorf("expected Etcd to be the etcd backend, got %v", o.Etcd)
+	}
+	if o.Mysql == nil || o.Mysql != o.StorageBackends[StorageBackendTypeMysql] {
+		t.Errorf("expected Mysql to be the mysql backend, got %v", o.Mysql)
+	}
+}
+
+func TestSupportsBatch(t *testing.T) {
+	namespaces := schema.GroupResource{Group: "coreres", Resource: "namespaces"}
+	testCases := []struct {
+		name          string
+		backend       StorageBackendType
+		modify        func(o *APIMasterOptions)
+		overrides     []string
+		expectSupport bool
+	}{
+		{name: "memory", backend: StorageBackendTypeMemory, expectSupport: true},
+		{name: "postgres", backend: StorageBackendTypePostgres, expectSupport: true},
+		{
+			name:          "mysql kv store",
+			backend:       StorageBackendTypeMysql,
+			modify:        func(o *APIMasterOptions) { o.Mysql.Store = MysqlStoreKV },
+			expectSupport: true,
+		},
+		// the stores of the apiserver write every object on its own
+		{name: "mysql legacy store", backend: StorageBackendTypeMysql},
+		{name: "sqlite", backend: StorageBackendTypeSqlite},
+		{name: "etcd", backend: StorageBackendTypeEtcd},
+		{name: "mongodb", backend: StorageBackendTypeMongoDB},
+		{name: "dynamodb", backend: StorageBackendTypeDynamoDB},
+		{
+			name:      "routed to sqlite",
+			backend:   StorageBackendTypeMemory,
+			overrides: []string{"coreres/namespaces#sqlite#/var/lib/apimaster/namespaces.db"},
+		},
+		{
+			name:          "routed to postgres",
+			backend:       StorageBackendTypeSqlite,
+			overrides:     []string{"coreres/namespaces#postgres#postgres://db/apimaster"},
+			expectSupport: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			o := newTestAPIMasterOptions(t, tc.backend)
+			if tc.modify != nil {
+				tc.modify(o)
+			}
+			o.StorageRouting.Overrides = tc.overrides
+			routes, err := o.StorageRouting.newRouteBackends()
+			if err != nil {
+				t.Fatal(err)
+			}
+			o.StorageRouting.routeBackends = routes
+			if supported := o.SupportsBatch(namespaces); supported != tc.expectSupport {
+				t.Errorf("expected batch support %v, got %v", tc.expectSupport, supported)
+			}
+		})
+	}
+}
+
+func TestStorageBackendFlag(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		args                 []string
+		expectBackend        StorageBackendType
+		expectType           string
+		expectParseError     bool
+		expectErrorSubString string
+	}{
+		{
+			name:          "default backend",
+			args:          []string{"--mysql-servers=root@tcp(localhost:3306)/apimaster"},
+			expectBackend: StorageBackendTypeMemory,
+			expectType:    "*options.MemoryOptions",
+		},
+		{
+			name:          "selected backend",
+			args:          []string{"--storage-backend=sqlite", "--sqlite-dsn=/var/lib/apimaster/apimaster.db"},
+			expectBackend: StorageBackendTypeSqlite,
+			expectType:    "*options.SqliteOptions",
+		},
+		{
+			name:                 "only the selected backend is validated",
+			args:                 []string{"--storage-backend=mysql", "--sqlite-dsn=/var/lib/apimaster/apimaster.db"},
+			expectBackend:        StorageBackendTypeMysql,
+			expectType:           "*options.MysqlOptions",
+			expectErrorSubString: "--mysql-servers",
+		},
+		{
+			name:             "unknown backend",
+			args:             []string{"--storage-backend=unknown"},
+			expectParseError: true,
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			o := newTestAPIMasterOptions(t, StorageBackendTypeMemory)
+			fss := cliflag.NamedFlagSets{}
+			o.AddFlags(&fss)
+			fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			for _, f := range fss.FlagSets {
+				fs.AddFlagSet(f)
+			}
+
+			err := fs.Parse(testcase.args)
+			if testcase.expectParseError {
+				if err == nil {
+					t.Errorf("expected a parse error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if o.Backend != testcase.expectBackend {
+				t.Errorf("expected backend %q, got %q", testcase.expectBackend, o.Backend)
+			}
+			if got := fmt.Sprintf("%T", o.Storage); got != testcase.expectType {
+				t.Errorf("expected %s, got %s", testcase.expectType, got)
+			}
+
+			errs := o.Storage.Validate()
+			if len(testcase.expectErrorSubString) == 0 {
+				if len(errs) != 0 {
+					t.Errorf("expected no errors, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), testcase.expectErrorSubString) {
+				t.Errorf("expected error containing %q, got %v", testcase.expectErrorSubString, errs)
+			}
+		})
+	}
+}
+
+func TestSimpleRestOptionsFactory(t *testing.T) {
+	mysql := NewMysqlOptions(storagebackend.NewDefaultConfig("mysql", nil))
+	mysql.StorageConfig.Mysql.ServerList = []string{"tcp(localhost:3306)/apimaster"}
+	sqlite := NewSqliteOptions(storagebackend.NewDefaultConfig("sqlite", nil))
+	sqlite.StorageConfig.Sqlite.DSN = "apimaster.db"
+
+	resource := schema.GroupResource{Group: "example.com", Resource: "widgets"}
+	mysqlOptions, err := (&SimpleRestOptionsFactory{Options: MysqlOptions{StorageConfig: mysql.StorageConfig}}).GetRESTOptions(resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if servers := mysqlOptions.StorageConfig.Config.Mysql.ServerList; !reflect.DeepEqual(servers, mysql.StorageConfig.Mysql.ServerList) {
+		t.Errorf("expected the mysql servers of the options, got %v", servers)
+	}
+	sqliteOptions, err := (&SqliteSimpleRestOptionsFactory{Options: *sqlite}).GetRESTOptions(resource)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dsn := sqliteOptions.StorageConfig.Config.Sqlite.DSN; dsn != "apimaster.db" {
+		t.Errorf("expected the sqlite dsn of the options, got %q", dsn)
+	}
+	for _, ret := range []generic.RESTOptions{mysqlOptions, sqliteOptions} {
+		if ret.ResourcePrefix != "example.com/widgets" {
+			t.Errorf("expected resource prefix example.com/widgets, got %q", ret.ResourcePrefix)
+		}
+	}
+}
@@ -0,0 +1,92 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"net/http"
+	"time"
+
+	// register the database drivers used by the health checks
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/mattn/go-sqlite3"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apiserver/pkg/server"
+	"k8s.io/apiserver/pkg/server/healthz"
+)
+
+// storageHealthCheckTimeout bounds a single health check of a storage backend.
+const storageHealthCheckTimeout = 2 * time.Second
+
+// addStorageHealthCheck adds check to healthz and readyz. It is left out of livez,
+// restarting the server does not help an unreachable database. The name gets a
+// suffix if another backend, e.g. of a routed resource, already uses it.
+func addStorageHealthCheck(c *server.Config, name string, check func(ctx context.Context) error) {
+	checkName := name
+	for i := 2; hasHealthCheck(c, checkName); i++ {
+		checkName = fmt.Sprintf("%s-%d", name, i)
+	}
+	addHealthChecksWithoutLivez(c, healthz.NamedCheck(checkName, func(r *http.Request) error {
+		ctx, cancel := context.WithTimeout(r.Context(), storageHealthCheckTimeout)
+		defer cancel()
+		return check(ctx)
+	}))
+}
+
+func hasHealthCheck(c *server.Config, name string) bool {
+	for _, check := range c.HealthzChecks {
+		if check.Name() == name {
+			return true
+		}
+	}
+	return false
+}
+
+// sqlPingCheck returns a check that succeeds if any of dsns accepts a connection. It
+// uses connections of its own, so a pool exhausted by requests does not fail it. The
+// stores of the apiserver don't expose their pools, so the checks of sqlite and the
+// legacy mysql store can't ping through them.
+func sqlPingCheck(driver string, dsns []string) func(ctx context.Context) error {
+	dbs := make([]*sql.DB, 0, len(dsns))
+	var openErrs []error
+	for _, dsn := range dsns {
+		db, err := sql.Open(driver, dsn)
+		if err != nil {
+			openErrs = append(openErrs, err)
+			continue
+		}
+		db.SetMaxOpenConns(1)
+		dbs = append(dbs, db)
+	}
+
+	return func(ctx context.Context) error {
+		errs := append([]error{}, openErrs...)
+		for _, db := range dbs {
+			err := db.PingContext(ctx)
+			if err == nil {
+				return nil
+			}
+			errs = append(errs, err)
+		}
+		if len(errs) == 0 {
+			return fmt.Errorf("no %s server configured", driver)
+		}
+		return utilerrors.NewAggregate(errs)
+	}
+}
@@ -0,0 +1,63 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"context"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"k8s.io/apiserver/pkg/server"
+)
+
+func TestAddStorageHealthCheck(t *testing.T) {
+	c := &server.Config{}
+	addStorageHealthCheck(c, "sqlite", sqlPingCheck("sqlite3", []string{filepath.Join(t.TempDir(), "test.db")}))
+	addStorageHealthCheck(c, "sqlite", sqlPingCheck("sqlite3", []string{"file::memory:"}))
+	addStorageHealthCheck(c, "mysql", sqlPingCheck("mysql", []string{"user:password@tcp(127.0.0.1:1)/apimaster"}))
+	addStorageHealthCheck(c, "mysql-empty", sqlPingCheck("mysql", nil))
+
+	testCases := []struct {
+		name        string
+		expectError bool
+	}{
+		{name: "sqlite"},
+		{name: "sqlite-2"},
+		{name: "mysql", expectError: true},
+		{name: "mysql-empty", expectError: true},
+	}
+
+	if len(c.HealthzChecks) != len(testCases) || len(c.ReadyzChecks) != len(testCases) || len(c.LivezChecks) != 0 {
+		t.Fatalf("unexpected health checks: healthz %d, readyz %d, livez %d", len(c.HealthzChecks), len(c.ReadyzChecks), len(c.LivezChecks))
+	}
+	for i, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			check := c.ReadyzChecks[i]
+			if check.Name() != testcase.name {
+				t.Fatalf("expected check %s, got %s", testcase.name, check.Name())
+			}
+			req, err := http.NewRequestWithContext(context.Background(), http.MethodGet, "/readyz", nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if err := check.Check(req); (err != nil) != testcase.expectError {
+				t.Errorf("expected error %v, got %v", testcase.expectError, err)
+			}
+		})
+	}
+}
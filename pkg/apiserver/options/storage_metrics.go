@@ -0,0 +1,127 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/server"
+	"k8s.io/apiserver/pkg/storage"
+	etcd3metrics "k8s.io/apiserver/pkg/storage/etcd3/metrics"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+	"k8s.io/apiserver/pkg/storage/storagebackend/factory"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/seanchann/apimaster/pkg/storage/storagemetrics"
+)
+
+// StorageMetricsOptions metrics of every storage backend
+type StorageMetricsOptions struct {
+	// CountMetricPollPeriod is the period the objects of every resource are counted, zero disables it.
+	CountMetricPollPeriod time.Duration
+}
+
+// NewStorageMetricsOptions create storage metrics options
+func NewStorageMetricsOptions() *StorageMetricsOptions {
+	return &StorageMetricsOptions{
+		CountMetricPollPeriod: time.Minute,
+	}
+}
+
+// Validate validate storage metrics input options
+func (s *StorageMetricsOptions) Validate() []error {
+	if s == nil {
+		return nil
+	}
+
+	allErrors := []error{}
+	if s.CountMetricPollPeriod < 0 {
+		allErrors = append(allErrors, fmt.Errorf("--storage-count-metric-poll-period must not be negative"))
+	}
+	return allErrors
+}
+
+// AddFlags adds flags related to storage metrics for a specific APIServer to the specified FlagSet
+func (s *StorageMetricsOptions) AddFlags(fs *pflag.FlagSet) {
+	if s == nil {
+		return
+	}
+
+	fs.DurationVar(&s.CountMetricPollPeriod, "storage-count-metric-poll-period", s.CountMetricPollPeriod, ""+
+		"Frequency of counting the stored objects of every resource, for backends other than etcd. 0 disables the metric collection.")
+}
+
+// ApplyTo records the latency of every storage request and counts the objects of every
+// resource, it must be called after the storage backend set the RESTOptionsGetter.
+func (s *StorageMetricsOptions) ApplyTo(c *server.Config) error {
+	if s == nil {
+		return nil
+	}
+
+	storagemetrics.Register()
+	// apiserver_storage_objects is updated by the registries
+	etcd3metrics.Register()
+	c.RESTOptionsGetter = &storageMetricsRestOptionsFactory{
+		delegate:              c.RESTOptionsGetter,
+		countMetricPollPeriod: s.CountMetricPollPeriod,
+	}
+	return nil
+}
+
+// storageMetricsRestOptionsFactory rest options factory that instruments the storage
+type storageMetricsRestOptionsFactory struct {
+	delegate              generic.RESTOptionsGetter
+	countMetricPollPeriod time.Duration
+}
+
+// GetRESTOptions impl generic.RESTOptions
+func (f *storageMetricsRestOptionsFactory) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	ret, err := f.delegate.GetRESTOptions(resource)
+	if err != nil {
+		return generic.RESTOptions{}, err
+	}
+	if ret.CountMetricPollPeriod == 0 {
+		ret.CountMetricPollPeriod = f.countMetricPollPeriod
+	}
+	ret.Decorator = instrumentedStorage(ret.Decorator, resource.String())
+	return ret, nil
+}
+
+// instrumentedStorage returns a decorator that records the requests to the storage
+// created by decorator.
+func instrumentedStorage(decorator generic.StorageDecorator, resource string) generic.StorageDecorator {
+	return func(
+		storageConfig *storagebackend.ConfigForResource,
+		resourcePrefix string,
+		keyFunc func(obj runtime.Object) (string, error),
+		newFunc func() runtime.Object,
+		newListFunc func() runtime.Object,
+		getAttrsFunc storage.AttrFunc,
+		triggerFuncs storage.IndexerFuncs,
+		indexers *cache.Indexers) (storage.Interface, factory.DestroyFunc, error) {
+		s, d, err := decorator(storageConfig, resourcePrefix, keyFunc, newFunc, newListFunc, getAttrsFunc, triggerFuncs, indexers)
+		if err != nil {
+			return s, d, err
+		}
+		return storagemetrics.Instrument(s, resource), d, nil
+	}
+}
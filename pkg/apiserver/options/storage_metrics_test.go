@@ -0,0 +1,115 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seanchann/apimaster/pkg/api/legacyscheme"
+	api "github.com/seanchann/apimaster/pkg/apis/coreres"
+	_ "github.com/seanchann/apimaster/pkg/apis/coreres/install"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/server"
+	serverstorage "k8s.io/apiserver/pkg/server/storage"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/component-base/metrics/testutil"
+)
+
+func TestStorageMetricsOptionsValidate(t *testing.T) {
+	opts := NewStorageMetricsOptions()
+	if errs := opts.Validate(); len(errs) != 0 {
+		t.Errorf("unexpected errors: %v", errs)
+	}
+	opts.CountMetricPollPeriod = -time.Second
+	if errs := opts.Validate(); len(errs) != 1 {
+		t.Errorf("expected one error, got %v", errs)
+	}
+}
+
+func TestStorageMetricsOptionsApplyTo(t *testing.T) {
+	mem := NewMemoryOptions(storagebackend.NewDefaultConfig("memory", nil))
+	storageConfig, mediaType := mem.BackendConfig()
+	storageFactory := serverstorage.NewDefaultStorageFactory(*storageConfig, mediaType, legacyscheme.Codecs,
+		serverstorage.NewDefaultResourceEncodingConfig(legacyscheme.Scheme), serverstorage.NewResourceConfig(), nil)
+
+	c := &server.Config{}
+	if err := mem.ApplyWithStorageFactoryTo(storageFactory, c); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewStorageMetricsOptions().ApplyTo(c); err != nil {
+		t.Fatal(err)
+	}
+
+	restOptions, err := c.RESTOptionsGetter.GetRESTOptions(api.Resource("namespaces"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restOptions.CountMetricPollPeriod != time.Minute {
+		t.Errorf("expected count metric poll period of a minute, got %v", restOptions.CountMetricPollPeriod)
+	}
+	s, destroy, err := restOptions.Decorator(restOptions.StorageConfig, restOptions.ResourcePrefix, nil,
+		func() runtime.Object { return &api.Namespace{} },
+		func() runtime.Object { return &api.NamespaceList{} },
+		nil, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer destroy()
+
+	ctx := context.Background()
+	key := restOptions.ResourcePrefix + "/foo"
+	if err := s.Create(ctx, key, &api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "foo"}}, nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Get(ctx, restOptions.ResourcePrefix+"/bar", storage.GetOptions{}, &api.Namespace{}); !storage.IsNotFound(err) {
+		t.Fatalf("expected not found error, got %v", err)
+	}
+
+	expected := `
+# HELP apimaster_storage_request_errors_total [ALPHA] Storage failed request counts for each operation and resource.
+# TYPE apimaster_storage_request_errors_total counter
+`
+	if err := testutil.GatherAndCompare(legacyregistry.DefaultGatherer, strings.NewReader(expected), "apimaster_storage_request_errors_total"); err != nil {
+		t.Errorf("unexpected request errors: %v", err)
+	}
+	metrics, err := legacyregistry.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range metrics {
+		if family.GetName() != "apimaster_storage_request_duration_seconds" {
+			continue
+		}
+		for _, metric := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range metric.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			if labels["operation"] == "create" && labels["resource"] == "namespaces.coreres" && metric.GetHistogram().GetSampleCount() == 1 {
+				return
+			}
+		}
+	}
+	t.Errorf("expected the latency of the create request to be recorded")
+}
@@ -0,0 +1,316 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/server"
+	serverstorage "k8s.io/apiserver/pkg/server/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+)
+
+// StorageServersSetter is an optional interface of StorageBackend. It is required to
+// route resources to servers other than the ones given by the backend flags.
+type StorageServersSetter interface {
+	SetServers(servers []string)
+}
+
+// StorageBackendCopier is an optional interface of StorageBackend. It is required to route
+// resources to a backend, the backends of the routes are copies of the ones set by the flags.
+type StorageBackendCopier interface {
+	// CopyStorageBackend returns a copy of the options of the backend, which shares
+	// no state with it.
+	CopyStorageBackend() StorageBackend
+}
+
+// StorageRoute stores one resource in a backend other than the default one.
+type StorageRoute struct {
+	Resource schema.GroupResource
+	Backend  StorageBackendType
+	// Servers overrides the servers of the backend, if set.
+	Servers []string
+}
+
+// StorageRoutingOptions routes resources to storage backends other than the default one
+type StorageRoutingOptions struct {
+	// Overrides are in the form of group/resource#backend or group/resource#backend#server1;server2.
+	// A '#', ';' or '\' in a server is escaped with a backslash.
+	Overrides []string
+
+	// configured are the options of the backends set by their flags, the backends of the
+	// routes start from them.
+	configured map[StorageBackendType]StorageBackend
+	// routeBackends are built by ApplyWithStorageFactoryTo and used by backend.
+	routeBackends map[schema.GroupResource]StorageBackend
+}
+
+// NewStorageRoutingOptions create storage routing options
+func NewStorageRoutingOptions() *StorageRoutingOptions {
+	return &StorageRoutingOptions{}
+}
+
+// AddFlags adds flags related to storage routing to the specified FlagSet
+func (s *StorageRoutingOptions) AddFlags(fs *pflag.FlagSet) {
+	fs.StringSliceVar(&s.Overrides, "storage-backend-overrides", s.Overrides, ""+
+		"Per-resource storage backend overrides, comma separated. The individual override "+
+		"format: group/resource#backend or group/resource#backend#servers, where servers are "+
+		"the servers of the backend, semicolon separated. A '#', ';' or '\\' in a server is escaped "+
+		"with a backslash. The flags of the backend apply to an override, the servers replace its servers. "+
+		"eg: rbac.authorization.k8s.io/roles#sqlite#/var/lib/apimaster/rbac.db")
+}
+
+// Routes parses the overrides.
+func (s *StorageRoutingOptions) Routes() ([]StorageRoute, error) {
+	var routes []StorageRoute
+	seen := map[schema.GroupResource]bool{}
+	for _, override := range s.Overrides {
+		tokens := splitEscaped(override, '#', 3)
+		if len(tokens) < 2 {
+			return nil, fmt.Errorf("--storage-backend-overrides invalid value %q, must be in the form of group/resource#backend[#servers]", override)
+		}
+		apiresource := strings.Split(tokens[0], "/")
+		if len(apiresource) != 2 || apiresource[1] == "" {
+			return nil, fmt.Errorf("--storage-backend-overrides invalid resource %q, must be in the form of group/resource", tokens[0])
+		}
+
+		route := StorageRoute{
+			Resource: schema.GroupResource{Group: apiresource[0], Resource: apiresource[1]},
+			Backend:  StorageBackendType(tokens[1]),
+		}
+		if seen[route.Resource] {
+			return nil, fmt.Errorf("--storage-backend-overrides resource %v is overridden more than once", route.Resource)
+		}
+		seen[route.Resource] = true
+		if len(tokens) == 3 {
+			for _, server := range splitEscaped(tokens[2], ';', -1) {
+				if server != "" {
+					route.Servers = append(route.Servers, unescapeServer(server))
+				}
+			}
+		}
+		routes = append(routes, route)
+	}
+	return routes, nil
+}
+
+// splitEscaped splits s at every sep not escaped by a backslash into at most n parts,
+// a negative n returns every part. The escapes are kept, see unescapeServer.
+func splitEscaped(s string, sep byte, n int) []string {
+	var parts []string
+	start := 0
+	for i := 0; i < len(s) && len(parts) != n-1; i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case sep:
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+	return append(parts, s[start:])
+}
+
+// unescapeServer removes the backslashes escaping a '#', ';' or '\' of server, any
+// other backslash is kept, e.g. of a windows path.
+func unescapeServer(server string) string {
+	var b strings.Builder
+	for i := 0; i < len(server); i++ {
+		if server[i] == '\\' && i+1 < len(server) && strings.IndexByte(`#;\`, server[i+1]) >= 0 {
+			i++
+		}
+		b.WriteByte(server[i])
+	}
+	return b.String()
+}
+
+// Validate validate storage routing input options, the backends of the routes are
+// validated on copies that are thrown away.
+func (s *StorageRoutingOptions) Validate() []error {
+	if _, err := s.newRouteBackends(); err != nil {
+		return []error{err}
+	}
+	return nil
+}
+
+// backend returns the backend resource is routed to, and false if it is served by the
+// default backend or the routes are not applied yet.
+func (s *StorageRoutingOptions) backend(resource schema.GroupResource) (StorageBackend, bool) {
+	backend, ok := s.routeBackends[resource]
+	return backend, ok
+}
+
+// ApplyWithStorageFactoryTo serves the overridden resources from their own backend and every
+// other resource from the RESTOptionsGetter already set on c by the default backend.
+func (s *StorageRoutingOptions) ApplyWithStorageFactoryTo(factory serverstorage.StorageFactory, c *server.Config) error {
+	routes, err := s.newRouteBackends()
+	if err != nil {
+		return err
+	}
+	s.routeBackends = routes
+	if len(routes) == 0 {
+		return nil
+	}
+
+	defaultGetter := c.RESTOptionsGetter
+	getters := map[StorageBackend]generic.RESTOptionsGetter{}
+	routedGetter := &routingRestOptionsFactory{
+		delegate: defaultGetter,
+		routes:   map[schema.GroupResource]generic.RESTOptionsGetter{},
+	}
+	for resource, backend := range routes {
+		getter, found := getters[backend]
+		if !found {
+			if completer, ok := backend.(StorageConfigCompleter); ok {
+				if err := completer.CompleteStorageConfig(c); err != nil {
+					return err
+				}
+			}
+			storageConfig, _ := backend.BackendConfig()
+			// the backend may register health checks on c, keep them and restore the getter
+			if err := backend.ApplyWithStorageFactoryTo(&routedStorageFactory{StorageFactory: factory, storageConfig: storageConfig}, c); err != nil {
+				return err
+			}
+			getter = c.RESTOptionsGetter
+			getters[backend] = getter
+		}
+		routedGetter.routes[resource] = getter
+	}
+	c.RESTOptionsGetter = routedGetter
+	return nil
+}
+
+// newRouteBackends creates the backend of every route. Routes with the same backend
+// and servers share one backend, which starts from the configured options of the backend.
+func (s *StorageRoutingOptions) newRouteBackends() (map[schema.GroupResource]StorageBackend, error) {
+	routes, err := s.Routes()
+	if err != nil {
+		return nil, err
+	}
+
+	var errs []error
+	backends := map[string]StorageBackend{}
+	ret := map[schema.GroupResource]StorageBackend{}
+	for _, route := range routes {
+		key := string(route.Backend) + "#" + strings.Join(route.Servers, ";")
+		backend, found := backends[key]
+		if !found {
+			backend, err = copyStorageBackend(route.Backend, s.configured[route.Backend])
+			if err != nil {
+				errs = append(errs, fmt.Errorf("--storage-backend-overrides %v: %v", route.Resource, err))
+				continue
+			}
+			if len(route.Servers) > 0 {
+				setter, ok := backend.(StorageServersSetter)
+				if !ok {
+					errs = append(errs, fmt.Errorf("--storage-backend-overrides %v: storage backend %q does not support servers", route.Resource, route.Backend))
+					continue
+				}
+				setter.SetServers(route.Servers)
+			}
+			for _, err := range backend.Validate() {
+				errs = append(errs, fmt.Errorf("--storage-backend-overrides %v: %v", route.Resource, err))
+			}
+			backends[key] = backend
+		}
+		ret[route.Resource] = backend
+	}
+	if len(errs) > 0 {
+		return nil, utilerrors.NewAggregate(errs)
+	}
+	return ret, nil
+}
+
+// copyStorageBackend creates the backend name with the options of configured, a nil
+// configured returns the backend with its defaults.
+func copyStorageBackend(name StorageBackendType, configured StorageBackend) (StorageBackend, error) {
+	if configured == nil {
+		return NewStorageBackend(name)
+	}
+	copier, ok := configured.(StorageBackendCopier)
+	if !ok {
+		return nil, fmt.Errorf("storage backend %q can't be copied, it does not implement StorageBackendCopier", name)
+	}
+	return copier.CopyStorageBackend(), nil
+}
+
+// copyStorageConfig returns a copy of config that shares none of its server lists.
+func copyStorageConfig(config storagebackend.Config) storagebackend.Config {
+	config.Transport.ServerList = copyStrings(config.Transport.ServerList)
+	config.Mysql.ServerList = copyStrings(config.Mysql.ServerList)
+	config.Mongodb.ServerList = copyStrings(config.Mongodb.ServerList)
+	config.Mongodb.AdminCred = copyStrings(config.Mongodb.AdminCred)
+	config.Mongodb.GeneralCred = copyStrings(config.Mongodb.GeneralCred)
+	return config
+}
+
+func copyStrings(values []string) []string {
+	if values == nil {
+		return nil
+	}
+	return append([]string{}, values...)
+}
+
+// routedStorageFactory replaces the backend of the storage configs built by the default storage
+// factory, while the encoding of every resource stays the same.
+type routedStorageFactory struct {
+	serverstorage.StorageFactory
+	storageConfig *storagebackend.Config
+}
+
+// NewConfig impl serverstorage.StorageFactory
+func (f *routedStorageFactory) NewConfig(groupResource schema.GroupResource) (*storagebackend.ConfigForResource, error) {
+	config, err := f.StorageFactory.NewConfig(groupResource)
+	if err != nil {
+		return nil, err
+	}
+	routed := *f.storageConfig
+	routed.Codec = config.Codec
+	routed.EncodeVersioner = config.EncodeVersioner
+	routed.Transformer = config.Transformer
+	return routed.ForResource(groupResource), nil
+}
+
+// Configs impl serverstorage.StorageFactory
+func (f *routedStorageFactory) Configs() []storagebackend.Config {
+	return []storagebackend.Config{*f.storageConfig}
+}
+
+// Backends impl serverstorage.StorageFactory, it returns the servers of the routed storage
+// config rather than the ones of the default storage factory.
+func (f *routedStorageFactory) Backends() []serverstorage.Backend {
+	return serverstorage.Backends(*f.storageConfig)
+}
+
+// routingRestOptionsFactory rest options factory that picks the backend by resource
+type routingRestOptionsFactory struct {
+	delegate generic.RESTOptionsGetter
+	routes   map[schema.GroupResource]generic.RESTOptionsGetter
+}
+
+// GetRESTOptions impl generic.RESTOptions
+func (f *routingRestOptionsFactory) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	if getter, found := f.routes[resource]; found {
+		return getter.GetRESTOptions(resource)
+	}
+	return f.delegate.GetRESTOptions(resource)
+}
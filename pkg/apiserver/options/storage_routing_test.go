@@ -0,0 +1,324 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/seanchann/apimaster/pkg/api/legacyscheme"
+	api "github.com/seanchann/apimaster/pkg/apis/coreres"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/server"
+	serverstorage "k8s.io/apiserver/pkg/server/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+)
+
+func TestStorageRoutingOptionsRoutes(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		overrides            []string
+		expectRoutes         []StorageRoute
+		expectErrorSubString string
+	}{
+		{
+			name:      "backend only",
+			overrides: []string{"rbac.authorization.k8s.io/roles#sqlite"},
+			expectRoutes: []StorageRoute{
+				{Resource: schema.GroupResource{Group: "rbac.authorization.k8s.io", Resource: "roles"}, Backend: "sqlite"},
+			},
+		},
+		{
+			name:      "core group with servers",
+			overrides: []string{"/namespaces#mysql#user:pass@tcp(db1:3306)/apimaster;user:pass@tcp(db2:3306)/apimaster"},
+			expectRoutes: []StorageRoute{
+				{
+					Resource: schema.GroupResource{Resource: "namespaces"},
+					Backend:  "mysql",
+					Servers:  []string{"user:pass@tcp(db1:3306)/apimaster", "user:pass@tcp(db2:3306)/apimaster"},
+				},
+			},
+		},
+		{
+			name:      "escaped servers",
+			overrides: []string{`/namespaces#mysql#user:p\#ss\;word@tcp(db1:3306)/apimaster;user:p\\ss@tcp(db2:3306)/apimaster`},
+			expectRoutes: []StorageRoute{
+				{
+					Resource: schema.GroupResource{Resource: "namespaces"},
+					Backend:  "mysql",
+					Servers:  []string{"user:p#ss;word@tcp(db1:3306)/apimaster", `user:p\ss@tcp(db2:3306)/apimaster`},
+				},
+			},
+		},
+		{
+			name:      "unescaped backslash",
+			overrides: []string{`/namespaces#sqlite#C:\apimaster\apimaster.db`},
+			expectRoutes: []StorageRoute{
+				{Resource: schema.GroupResource{Resource: "namespaces"}, Backend: "sqlite", Servers: []string{`C:\apimaster\apimaster.db`}},
+			},
+		},
+		{
+			name:                 "missing backend",
+			overrides:            []string{"rbac.authorization.k8s.io/roles"},
+			expectErrorSubString: "must be in the form of group/resource#backend[#servers]",
+		},
+		{
+			name:                 "missing resource",
+			overrides:            []string{"roles#sqlite"},
+			expectErrorSubString: "must be in the form of group/resource",
+		},
+		{
+			name:                 "duplicated resource",
+			overrides:            []string{"/namespaces#sqlite", "/namespaces#mysql"},
+			expectErrorSubString: "is overridden more than once",
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			o := &StorageRoutingOptions{Overrides: testcase.overrides}
+			routes, err := o.Routes()
+			if len(testcase.expectErrorSubString) > 0 {
+				if err == nil || !strings.Contains(err.Error(), testcase.expectErrorSubString) {
+					t.Errorf("expected error containing %q, got %v", testcase.expectErrorSubString, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(routes, testcase.expectRoutes) {
+				t.Errorf("expected %#v, got %#v", testcase.expectRoutes, routes)
+			}
+		})
+	}
+}
+
+func TestStorageRoutingOptionsValidate(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		overrides            []string
+		expectErrorSubString string
+	}{
+		{
+			name:      "valid",
+			overrides: []string{"/namespaces#mysql#user:pass@tcp(db:3306)/apimaster", "rbac.authorization.k8s.io/roles#memory"},
+		},
+		{
+			name:                 "unknown backend",
+			overrides:            []string{"/namespaces#cassandra"},
+			expectErrorSubString: `unknown storage backend "cassandra"`,
+		},
+		{
+			name:                 "servers not supported",
+			overrides:            []string{"/namespaces#memory#somewhere"},
+			expectErrorSubString: `storage backend "memory" does not support servers`,
+		},
+		{
+			name:                 "invalid backend options",
+			overrides:            []string{"/namespaces#mysql"},
+			expectErrorSubString: "--mysql-servers must be specified",
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			o := &StorageRoutingOptions{Overrides: testcase.overrides}
+			errs := o.Validate()
+			if len(testcase.expectErrorSubString) == 0 {
+				if len(errs) != 0 {
+					t.Errorf("expected no errors, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), testcase.expectErrorSubString) {
+				t.Errorf("expected error containing %q, got %v", testcase.expectErrorSubString, errs)
+			}
+		})
+	}
+}
+
+func TestStorageRoutingOptionsConfiguredBackends(t *testing.T) {
+	o := newTestAPIMasterOptions(t, StorageBackendTypeMemory)
+	mysql := o.StorageBackends[StorageBackendTypeMysql].(*MysqlOptions)
+	mysql.StorageConfig.Mysql.ServerList = []string{"tcp(db:3306)/apimaster"}
+	mysql.Store = MysqlStoreKV
+	mysql.Mysql.ReplicaList = []string{"tcp(replica:3306)/apimaster"}
+	o.StorageRouting.Overrides = []string{"/namespaces#mysql", "/users#mysql#tcp(other:3306)/apimaster"}
+
+	if errs := o.StorageRouting.Validate(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	if o.StorageRouting.routeBackends != nil {
+		t.Errorf("expected Validate to leave the routes to ApplyWithStorageFactoryTo")
+	}
+	routes, err := o.StorageRouting.newRouteBackends()
+	if err != nil {
+		t.Fatal(err)
+	}
+	namespaces := routes[schema.GroupResource{Resource: "namespaces"}].(*MysqlOptions)
+	users := routes[schema.GroupResource{Resource: "users"}].(*MysqlOptions)
+	if namespaces == mysql {
+		t.Errorf("expected a copy of the configured mysql options")
+	}
+	if !reflect.DeepEqual(namespaces.StorageConfig.Mysql.ServerList, []string{"tcp(db:3306)/apimaster"}) {
+		t.Errorf("expected the configured servers, got %v", namespaces.StorageConfig.Mysql.ServerList)
+	}
+	if !reflect.DeepEqual(users.StorageConfig.Mysql.ServerList, []string{"tcp(other:3306)/apimaster"}) {
+		t.Errorf("expected the servers of the route, got %v", users.StorageConfig.Mysql.ServerList)
+	}
+	for _, routed := range []*MysqlOptions{namespaces, users} {
+		if routed.Store != MysqlStoreKV || !reflect.DeepEqual(routed.Mysql.ReplicaList, mysql.Mysql.ReplicaList) {
+			t.Errorf("expected the configured mysql flags, got store %q and replicas %v", routed.Store, routed.Mysql.ReplicaList)
+		}
+	}
+	if !reflect.DeepEqual(mysql.StorageConfig.Mysql.ServerList, []string{"tcp(db:3306)/apimaster"}) {
+		t.Errorf("expected the servers of the configured mysql options to stay, got %v", mysql.StorageConfig.Mysql.ServerList)
+	}
+	namespaces.Mysql.ReplicaList[0] = "tcp(changed:3306)/apimaster"
+	if mysql.Mysql.ReplicaList[0] != "tcp(replica:3306)/apimaster" {
+		t.Errorf("expected the copy to share no replicas with the configured mysql options")
+	}
+}
+
+func TestCopyStorageBackend(t *testing.T) {
+	o := newTestAPIMasterOptions(t, StorageBackendTypeMemory)
+	for _, name := range []StorageBackendType{StorageBackendTypeSqlite, StorageBackendTypeEtcd, StorageBackendTypeMysql,
+		StorageBackendTypeMongoDB, StorageBackendTypeDynamoDB, StorageBackendTypeMemory, StorageBackendTypePostgres} {
+		t.Run(string(name), func(t *testing.T) {
+			configured := o.StorageBackends[name]
+			backend, err := copyStorageBackend(name, configured)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if backend == configured {
+				t.Fatalf("expected a copy of the configured options")
+			}
+			configuredFlags := pflag.NewFlagSet(string(name), pflag.ContinueOnError)
+			configured.AddFlags(configuredFlags)
+			copiedFlags := pflag.NewFlagSet(string(name), pflag.ContinueOnError)
+			backend.AddFlags(copiedFlags)
+			configuredFlags.VisitAll(func(f *pflag.Flag) {
+				if copied := copiedFlags.Lookup(f.Name); copied == nil || copied.Value.String() != f.Value.String() {
+					t.Errorf("expected --%s %q to be copied, got %v", f.Name, f.Value, copied)
+				}
+			})
+		})
+	}
+
+	uncopied := struct{ StorageBackend }{StorageBackend: NewMemoryOptions(storagebackend.NewDefaultConfig("memory", nil))}
+	if _, err := copyStorageBackend(StorageBackendTypeMemory, uncopied); err == nil {
+		t.Errorf("expected an error copying a backend that is not a StorageBackendCopier")
+	}
+}
+
+// countingStorageBackends counts the storage backends created by the factory of countingBackend.
+var countingStorageBackends int
+
+const countingBackend StorageBackendType = "counting"
+
+// countingStorageBackend is a memory backend without flags.
+type countingStorageBackend struct {
+	*MemoryOptions
+}
+
+func (countingStorageBackend) AddFlags(fs *pflag.FlagSet) {}
+
+func init() {
+	RegisterStorageBackend(countingBackend, func() StorageBackend {
+		countingStorageBackends++
+		return countingStorageBackend{MemoryOptions: NewMemoryOptions(storagebackend.NewDefaultConfig("memory", nil))}
+	})
+}
+
+func TestStorageRoutingOptionsBuildsRoutesOnce(t *testing.T) {
+	storageConfig := storagebackend.NewDefaultConfig("memory", nil)
+	storageFactory := serverstorage.NewDefaultStorageFactory(*storageConfig, "application/json", legacyscheme.Codecs,
+		serverstorage.NewDefaultResourceEncodingConfig(legacyscheme.Scheme), serverstorage.NewResourceConfig(), nil)
+
+	countingStorageBackends = 0
+	o := &StorageRoutingOptions{Overrides: []string{"coreres/namespaces#counting", "coreres/users#counting"}}
+	if errs := o.Validate(); len(errs) != 0 {
+		t.Fatalf("unexpected errors: %v", errs)
+	}
+	countingStorageBackends = 0
+	if err := o.ApplyWithStorageFactoryTo(storageFactory, &server.Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if countingStorageBackends != 1 {
+		t.Errorf("expected the routes to share one backend built once, got %d", countingStorageBackends)
+	}
+}
+
+func TestStorageRoutingOptionsApplyWithStorageFactoryTo(t *testing.T) {
+	defaultBackend := NewMemoryOptions(storagebackend.NewDefaultConfig("memory", nil))
+	storageConfig, mediaType := defaultBackend.BackendConfig()
+	storageFactory := serverstorage.NewDefaultStorageFactory(*storageConfig, mediaType, legacyscheme.Codecs,
+		serverstorage.NewDefaultResourceEncodingConfig(legacyscheme.Scheme), serverstorage.NewResourceConfig(), nil)
+
+	c := &server.Config{}
+	if err := defaultBackend.ApplyWithStorageFactoryTo(storageFactory, c); err != nil {
+		t.Fatal(err)
+	}
+	o := &StorageRoutingOptions{Overrides: []string{"coreres/namespaces#mysql#user:pass@tcp(db:3306)/apimaster"}}
+	if err := o.ApplyWithStorageFactoryTo(storageFactory, c); err != nil {
+		t.Fatal(err)
+	}
+
+	restOptions, err := c.RESTOptionsGetter.GetRESTOptions(api.Resource("namespaces"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restOptions.StorageConfig.Type != storagebackend.StorageTypeMysql {
+		t.Errorf("expected storage type %q, got %q", storagebackend.StorageTypeMysql, restOptions.StorageConfig.Type)
+	}
+	if servers := restOptions.StorageConfig.Mysql.ServerList; !reflect.DeepEqual(servers, []string{"user:pass@tcp(db:3306)/apimaster"}) {
+		t.Errorf("unexpected mysql servers %v", servers)
+	}
+	if restOptions.StorageConfig.Codec == nil {
+		t.Errorf("expected the codec of the storage factory")
+	}
+	if restOptions.ResourcePrefix != storageFactory.ResourcePrefix(api.Resource("namespaces")) {
+		t.Errorf("unexpected resource prefix %q", restOptions.ResourcePrefix)
+	}
+
+	restOptions, err = c.RESTOptionsGetter.GetRESTOptions(api.Resource("users"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if restOptions.StorageConfig.Type != "memory" {
+		t.Errorf("expected the default storage type, got %q", restOptions.StorageConfig.Type)
+	}
+}
+
+func TestRoutedStorageFactoryBackends(t *testing.T) {
+	storageConfig := storagebackend.NewDefaultConfig("memory", nil)
+	storageConfig.Transport.ServerList = []string{"http://default:2379"}
+	storageFactory := serverstorage.NewDefaultStorageFactory(*storageConfig, "application/json", legacyscheme.Codecs,
+		serverstorage.NewDefaultResourceEncodingConfig(legacyscheme.Scheme), serverstorage.NewResourceConfig(), nil)
+
+	routedConfig := storagebackend.NewDefaultConfig("etcd", nil)
+	routedConfig.Transport.ServerList = []string{"http://routed:2379"}
+	factory := &routedStorageFactory{StorageFactory: storageFactory, storageConfig: routedConfig}
+	backends := factory.Backends()
+	if len(backends) != 1 || backends[0].Server != "http://routed:2379" {
+		t.Errorf("expected the servers of the routed storage config, got %v", backends)
+	}
+}
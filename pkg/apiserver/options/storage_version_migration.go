@@ -0,0 +1,106 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/server"
+	"k8s.io/klog/v2"
+
+	"github.com/seanchann/apimaster/pkg/api/legacyscheme"
+	"github.com/seanchann/apimaster/pkg/controller/storageversionmigrator"
+	"github.com/seanchann/apimaster/pkg/storage/resourcestore"
+)
+
+// StorageVersionMigrationOptions rewrite of stored objects in their current storage version
+type StorageVersionMigrationOptions struct {
+	// EnableStorageVersionMigration rewrites every stored object after start.
+	EnableStorageVersionMigration bool
+	// StateFile records the progress, so a restarted server resumes the migration
+	// and skips the resources that are migrated to their current storage version.
+	StateFile string
+}
+
+// NewStorageVersionMigrationOptions create storage version migration options
+func NewStorageVersionMigrationOptions() *StorageVersionMigrationOptions {
+	return &StorageVersionMigrationOptions{}
+}
+
+// Validate validate storage version migration input options
+func (s *StorageVersionMigrationOptions) Validate() []error {
+	if s == nil {
+		return nil
+	}
+
+	allErrors := []error{}
+	if len(s.StateFile) > 0 && !s.EnableStorageVersionMigration {
+		allErrors = append(allErrors, fmt.Errorf("--storage-version-migration-state-file must be set with --storage-version-migration"))
+	}
+	if len(s.StateFile) > 0 {
+		if info, err := os.Stat(filepath.Dir(s.StateFile)); err != nil || !info.IsDir() {
+			allErrors = append(allErrors, fmt.Errorf("--storage-version-migration-state-file %s is not in an existing directory", s.StateFile))
+		}
+	}
+	return allErrors
+}
+
+// AddFlags adds flags related to the storage version migration for a specific APIServer to the specified FlagSet
+func (s *StorageVersionMigrationOptions) AddFlags(fs *pflag.FlagSet) {
+	if s == nil {
+		return
+	}
+
+	fs.BoolVar(&s.EnableStorageVersionMigration, "storage-version-migration", s.EnableStorageVersionMigration, ""+
+		"Rewrite every stored object after start, so objects stored in an older version than the current "+
+		"storage version (--storage-versions, --resource-storage-versions) are stored in the current one. "+
+		"Afterwards the older versions can be removed from the scheme.")
+
+	fs.StringVar(&s.StateFile, "storage-version-migration-state-file", s.StateFile, ""+
+		"The file recording the progress of the storage version migration. A restarted server resumes "+
+		"the migration from it and skips the resources migrated to their current storage version already. "+
+		"If empty, every start migrates every resource again.")
+}
+
+// ApplyTo starts the storage version migration after the server started, it must be
+// called after the storage backend set the RESTOptionsGetter.
+func (s *StorageVersionMigrationOptions) ApplyTo(c *server.Config) error {
+	if s == nil || !s.EnableStorageVersionMigration {
+		return nil
+	}
+
+	return c.AddPostStartHook("storage-version-migration", func(hookContext server.PostStartHookContext) error {
+		go s.run(wait.ContextForChannel(hookContext.StopCh), c)
+		return nil
+	})
+}
+
+// run migrates every resource stored by the server.
+func (s *StorageVersionMigrationOptions) run(ctx context.Context, c *server.Config) {
+	resources := resourcestore.Resources(legacyscheme.Scheme, c.MergedResourceConfig)
+	migrator := storageversionmigrator.NewMigrator(c.RESTOptionsGetter, legacyscheme.Scheme, resources, s.StateFile)
+	if err := migrator.Run(ctx); err != nil {
+		klog.Errorf("Storage version migration failed: %v", err)
+		return
+	}
+	klog.Infof("Storage version migration of %d resources completed", len(resources))
+}
@@ -0,0 +1,95 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/seanchann/apimaster/pkg/api/legacyscheme"
+
+	"k8s.io/apiserver/pkg/server"
+)
+
+func TestStorageVersionMigrationOptionsValidate(t *testing.T) {
+	dir := t.TempDir()
+	testCases := []struct {
+		name                 string
+		modify               func(o *StorageVersionMigrationOptions)
+		expectErrorSubString string
+	}{
+		{
+			name:   "default",
+			modify: func(o *StorageVersionMigrationOptions) {},
+		},
+		{
+			name: "enabled with state file",
+			modify: func(o *StorageVersionMigrationOptions) {
+				o.EnableStorageVersionMigration = true
+				o.StateFile = filepath.Join(dir, "state.json")
+			},
+		},
+		{
+			name: "state file without migration",
+			modify: func(o *StorageVersionMigrationOptions) {
+				o.StateFile = filepath.Join(dir, "state.json")
+			},
+			expectErrorSubString: "--storage-version-migration-state-file must be set with --storage-version-migration",
+		},
+		{
+			name: "state file in missing directory",
+			modify: func(o *StorageVersionMigrationOptions) {
+				o.EnableStorageVersionMigration = true
+				o.StateFile = filepath.Join(dir, "missing", "state.json")
+			},
+			expectErrorSubString: "is not in an existing directory",
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			o := NewStorageVersionMigrationOptions()
+			testcase.modify(o)
+
+			errs := o.Validate()
+			if len(testcase.expectErrorSubString) == 0 {
+				if len(errs) != 0 {
+					t.Errorf("expected no errors, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), testcase.expectErrorSubString) {
+				t.Errorf("expected error containing %q, got %v", testcase.expectErrorSubString, errs)
+			}
+		})
+	}
+}
+
+func TestStorageVersionMigrationOptionsApplyTo(t *testing.T) {
+	for _, enabled := range []bool{true, false} {
+		c := server.NewConfig(legacyscheme.Codecs)
+		opts := NewStorageVersionMigrationOptions()
+		opts.EnableStorageVersionMigration = enabled
+		if err := opts.ApplyTo(c); err != nil {
+			t.Fatal(err)
+		}
+		if _, found := c.PostStartHooks["storage-version-migration"]; found != enabled {
+			t.Errorf("expected storage version migration post start hook %v, got %v", enabled, found)
+		}
+	}
+}
@@ -17,6 +17,7 @@ limitations under the License.
 package options
 
 import (
+	"fmt"
 	"strings"
 
 	"k8s.io/apimachinery/pkg/runtime/schema"
@@ -35,6 +36,10 @@ type StorageSerializationOptions struct {
 	// these; you can change this if you want to change the defaults (e.g.,
 	// for testing). This is not actually exposed as a flag.
 	DefaultStorageVersions string
+	// ResourceStorageVersions lists the resources stored in a different group
+	// version than the other resources of their group, each one in the form
+	// of "group/version/resource", or "version/resource" for the core group.
+	ResourceStorageVersions []string
 }
 
 func NewStorageSerializationOptions() *StorageSerializationOptions {
@@ -61,6 +66,64 @@ func (s *StorageSerializationOptions) StorageGroupsToEncodingVersion() (map[stri
 	return storageVersionMap, nil
 }
 
+// ResourceEncodingOverrides returns the resources stored in a different group version
+// than the other resources of their group, computed from s.ResourceStorageVersions flag.
+func (s *StorageSerializationOptions) ResourceEncodingOverrides() ([]schema.GroupVersionResource, error) {
+	var overrides []schema.GroupVersionResource
+	for _, gvrString := range s.ResourceStorageVersions {
+		var gvr schema.GroupVersionResource
+		parts := strings.Split(gvrString, "/")
+		switch len(parts) {
+		case 2:
+			gvr = schema.GroupVersionResource{Version: parts[0], Resource: parts[1]}
+		case 3:
+			gvr = schema.GroupVersionResource{Group: parts[0], Version: parts[1], Resource: parts[2]}
+		default:
+			return nil, fmt.Errorf("invalid resource storage version %q, must be in the form of group/version/resource", gvrString)
+		}
+		if gvr.Version == "" || gvr.Resource == "" {
+			return nil, fmt.Errorf("invalid resource storage version %q, must be in the form of group/version/resource", gvrString)
+		}
+		overrides = append(overrides, gvr)
+	}
+	return overrides, nil
+}
+
+// Validate checks that every storage version is registered in legacyscheme.Scheme
+func (s *StorageSerializationOptions) Validate() []error {
+	allErrors := []error{}
+	storageVersions, err := s.StorageGroupsToEncodingVersion()
+	if err != nil {
+		allErrors = append(allErrors, fmt.Errorf("--storage-versions %v", err))
+	}
+	groups := make([]string, 0, len(storageVersions))
+	for group := range storageVersions {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		if gv := storageVersions[group]; !legacyscheme.Scheme.IsVersionRegistered(gv) {
+			allErrors = append(allErrors, fmt.Errorf("--storage-versions %v is not a registered version", gv))
+		}
+	}
+
+	overrides, err := s.ResourceEncodingOverrides()
+	if err != nil {
+		return append(allErrors, fmt.Errorf("--resource-storage-versions %v", err))
+	}
+	seen := map[schema.GroupResource]bool{}
+	for _, gvr := range overrides {
+		if seen[gvr.GroupResource()] {
+			allErrors = append(allErrors, fmt.Errorf("--resource-storage-versions %v is specified more than once", gvr.GroupResource()))
+		}
+		seen[gvr.GroupResource()] = true
+		if !legacyscheme.Scheme.IsVersionRegistered(gvr.GroupVersion()) {
+			allErrors = append(allErrors, fmt.Errorf("--resource-storage-versions %v is not a registered version", gvr.GroupVersion()))
+		}
+	}
+	return allErrors
+}
+
 // dest must be a map of group to groupVersion.
 func mergeGroupVersionIntoMap(gvList string, dest map[string]schema.GroupVersion) error {
 	for _, gvString := range strings.Split(gvList, ",") {
@@ -102,6 +165,10 @@ func (s *StorageSerializationOptions) AddFlags(fs *pflag.FlagSet) {
 		"You only need to pass the groups you wish to change from the defaults. "+
 		"It defaults to a list of preferred versions of all known groups.")
 
+	fs.StringSliceVar(&s.ResourceStorageVersions, "resource-storage-versions", s.ResourceStorageVersions, ""+
+		"The resources to store in a different version than the rest of their group, comma separated. "+
+		"Specified in the format \"group/version/resource\", or \"version/resource\" for the core group. "+
+		"The version must be registered in the scheme.")
 }
 
 // ToPreferredVersionString returns the preferred versions of all registered
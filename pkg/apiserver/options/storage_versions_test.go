@@ -0,0 +1,133 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	_ "github.com/seanchann/apimaster/pkg/apis/coreres/install"
+
+	"k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+func TestStorageSerializationOptionsResourceEncodingOverrides(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		resourceVersions     []string
+		expectOverrides      []schema.GroupVersionResource
+		expectErrorSubString string
+	}{
+		{
+			name:             "group and core group",
+			resourceVersions: []string{"coreres/v1/namespaces", "v1/events"},
+			expectOverrides: []schema.GroupVersionResource{
+				{Group: "coreres", Version: "v1", Resource: "namespaces"},
+				{Version: "v1", Resource: "events"},
+			},
+		},
+		{
+			name:                 "missing version",
+			resourceVersions:     []string{"namespaces"},
+			expectErrorSubString: "must be in the form of group/version/resource",
+		},
+		{
+			name:                 "empty resource",
+			resourceVersions:     []string{"coreres/v1/"},
+			expectErrorSubString: "must be in the form of group/version/resource",
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			o := NewStorageSerializationOptions()
+			o.ResourceStorageVersions = testcase.resourceVersions
+			overrides, err := o.ResourceEncodingOverrides()
+			if len(testcase.expectErrorSubString) > 0 {
+				if err == nil || !strings.Contains(err.Error(), testcase.expectErrorSubString) {
+					t.Errorf("expected error containing %q, got %v", testcase.expectErrorSubString, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(overrides, testcase.expectOverrides) {
+				t.Errorf("expected %v, got %v", testcase.expectOverrides, overrides)
+			}
+		})
+	}
+}
+
+func TestStorageSerializationOptionsValidate(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		storageVersions      string
+		resourceVersions     []string
+		expectErrorSubString string
+	}{
+		{
+			name:             "registered version",
+			resourceVersions: []string{"coreres/v1/namespaces"},
+		},
+		{
+			name:                 "unregistered version",
+			resourceVersions:     []string{"coreres/v2/namespaces"},
+			expectErrorSubString: "--resource-storage-versions coreres/v2 is not a registered version",
+		},
+		{
+			name:            "registered group version",
+			storageVersions: "coreres/v1",
+		},
+		{
+			name:                 "unregistered group version",
+			storageVersions:      "coreres/v2",
+			expectErrorSubString: "--storage-versions coreres/v2 is not a registered version",
+		},
+		{
+			name:                 "duplicated resource",
+			resourceVersions:     []string{"coreres/v1/namespaces", "coreres/v1/namespaces"},
+			expectErrorSubString: "--resource-storage-versions namespaces.coreres is specified more than once",
+		},
+		{
+			name:                 "malformed",
+			resourceVersions:     []string{"coreres"},
+			expectErrorSubString: "--resource-storage-versions invalid resource storage version",
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			o := NewStorageSerializationOptions()
+			if len(testcase.storageVersions) > 0 {
+				o.StorageVersions = testcase.storageVersions
+			}
+			o.ResourceStorageVersions = testcase.resourceVersions
+			errs := o.Validate()
+			if len(testcase.expectErrorSubString) == 0 {
+				if len(errs) != 0 {
+					t.Errorf("expected no errors, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), testcase.expectErrorSubString) {
+				t.Errorf("expected error containing %q, got %v", testcase.expectErrorSubString, errs)
+			}
+		})
+	}
+}
@@ -0,0 +1,135 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apiserver/pkg/authentication/group"
+
+	"github.com/seanchann/apimaster/pkg/apiserver/authenticator/peercred"
+	"github.com/seanchann/apimaster/pkg/apiserver/reload"
+	"github.com/seanchann/apimaster/pkg/apiserver/server"
+)
+
+// UnixSocketServingOptions serves on a unix socket, whose callers are authenticated by
+// the uid and gid of their processes and authorized like on the secure port.
+type UnixSocketServingOptions struct {
+	// Path of the unix socket, empty disables it.
+	Path string
+	// Mode is the octal file mode of the unix socket.
+	Mode string
+	// PeerConfigFile maps the uids and gids of the callers to users and groups.
+	PeerConfigFile string
+	// Reloaders reloads the peer config file when it changes, nil disables the reloads.
+	Reloaders *reload.Reloaders
+}
+
+// NewUnixSocketServingOptions create a UnixSocketServingOptions with default value
+func NewUnixSocketServingOptions() *UnixSocketServingOptions {
+	return &UnixSocketServingOptions{
+		Mode: "0660",
+	}
+}
+
+// Validate validate unix socket input options
+func (s *UnixSocketServingOptions) Validate() []error {
+	if s == nil || len(s.Path) == 0 {
+		return nil
+	}
+
+	allErrors := []error{}
+	if !peercred.Supported {
+		allErrors = append(allErrors, fmt.Errorf("--unix-socket is only supported on linux"))
+	}
+	if _, err := s.fileMode(); err != nil {
+		allErrors = append(allErrors, fmt.Errorf("--unix-socket-mode %q is not an octal file mode", s.Mode))
+	}
+	if len(s.PeerConfigFile) == 0 {
+		allErrors = append(allErrors, fmt.Errorf("--unix-socket-peer-config must be specified with --unix-socket"))
+	} else if _, err := peercred.LoadConfig(s.PeerConfigFile); err != nil {
+		allErrors = append(allErrors, fmt.Errorf("--unix-socket-peer-config %v", err))
+	}
+	return allErrors
+}
+
+// AddFlags adds flags related to the unix socket to the specified FlagSet
+func (s *UnixSocketServingOptions) AddFlags(fs *pflag.FlagSet) {
+	if s == nil {
+		return
+	}
+
+	fs.StringVar(&s.Path, "unix-socket", s.Path, ""+
+		"The path of a unix socket to serve on, in addition to the secure port. The callers are authenticated "+
+		"by the uid and gid of their processes (SO_PEERCRED), and authorized like on the secure port. Linux only.")
+
+	fs.StringVar(&s.Mode, "unix-socket-mode", s.Mode,
+		"The octal file mode of --unix-socket, it restricts the local users that can connect.")
+
+	fs.StringVar(&s.PeerConfigFile, "unix-socket-peer-config", s.PeerConfigFile, ""+
+		"A file mapping the uids and gids of the callers on --unix-socket to users and groups, "+
+		"callers whose uid and gid are not mapped are rejected.")
+}
+
+func (s *UnixSocketServingOptions) fileMode() (os.FileMode, error) {
+	mode, err := strconv.ParseUint(s.Mode, 8, 32)
+	if err != nil {
+		return 0, err
+	}
+	return os.FileMode(mode), nil
+}
+
+// ApplyTo listens on the unix socket and loads the peer config, servingInfo is left nil
+// if the unix socket is disabled.
+func (s *UnixSocketServingOptions) ApplyTo(servingInfo **server.UnixSocketServingInfo) error {
+	if s == nil || len(s.Path) == 0 {
+		return nil
+	}
+
+	mode, err := s.fileMode()
+	if err != nil {
+		return fmt.Errorf("--unix-socket-mode %q is not an octal file mode", s.Mode)
+	}
+	config, err := peercred.LoadConfig(s.PeerConfigFile)
+	if err != nil {
+		return fmt.Errorf("--unix-socket-peer-config %v", err)
+	}
+	peerAuthenticator := peercred.NewAuthenticator(config)
+	if s.Reloaders != nil {
+		s.Reloaders.Add("unix-socket-peer-config", s.PeerConfigFile, func(path string) error {
+			config, err := peercred.LoadConfig(path)
+			if err != nil {
+				return err
+			}
+			peerAuthenticator.Set(config)
+			return nil
+		})
+	}
+
+	ln, err := server.ListenUnixSocket(s.Path, mode)
+	if err != nil {
+		return fmt.Errorf("failed to listen on --unix-socket %s: %v", s.Path, err)
+	}
+	*servingInfo = &server.UnixSocketServingInfo{
+		Listener:      ln,
+		Authenticator: group.NewAuthenticatedGroupAdder(peerAuthenticator),
+	}
+	return nil
+}
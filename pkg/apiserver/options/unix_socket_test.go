@@ -0,0 +1,91 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUnixSocketServingOptionsValidate(t *testing.T) {
+	peerConfig := filepath.Join(t.TempDir(), "peers.yaml")
+	if err := os.WriteFile(peerConfig, []byte("users:\n- uid: 1000\n  name: sidecar\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name                 string
+		modify               func(o *UnixSocketServingOptions)
+		expectErrorSubString string
+	}{
+		{
+			name:   "disabled",
+			modify: func(o *UnixSocketServingOptions) {},
+		},
+		{
+			name: "socket with peer config",
+			modify: func(o *UnixSocketServingOptions) {
+				o.Path = "/run/apimaster.sock"
+				o.PeerConfigFile = peerConfig
+			},
+		},
+		{
+			name: "missing peer config",
+			modify: func(o *UnixSocketServingOptions) {
+				o.Path = "/run/apimaster.sock"
+			},
+			expectErrorSubString: "--unix-socket-peer-config must be specified",
+		},
+		{
+			name: "unreadable peer config",
+			modify: func(o *UnixSocketServingOptions) {
+				o.Path = "/run/apimaster.sock"
+				o.PeerConfigFile = filepath.Join(t.TempDir(), "missing.yaml")
+			},
+			expectErrorSubString: "--unix-socket-peer-config",
+		},
+		{
+			name: "invalid mode",
+			modify: func(o *UnixSocketServingOptions) {
+				o.Path = "/run/apimaster.sock"
+				o.PeerConfigFile = peerConfig
+				o.Mode = "rw-rw----"
+			},
+			expectErrorSubString: "--unix-socket-mode \"rw-rw----\" is not an octal file mode",
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			o := NewUnixSocketServingOptions()
+			testcase.modify(o)
+
+			errs := o.Validate()
+			if len(testcase.expectErrorSubString) == 0 {
+				if len(errs) != 0 {
+					t.Errorf("expected no errors, got %v", errs)
+				}
+				return
+			}
+			if len(errs) != 1 || !strings.Contains(errs[0].Error(), testcase.expectErrorSubString) {
+				t.Errorf("expected error containing %q, got %v", testcase.expectErrorSubString, errs)
+			}
+		})
+	}
+}
@@ -16,9 +16,93 @@
 
 package options
 
-// Validate checks ServerRunOptions and return a slice of found errors.
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/seanchann/apimaster/pkg/api/legacyscheme"
+)
+
+// Validate checks APIMasterOptions and every option it contains, and returns a slice of found errors.
 func (o *APIMasterOptions) Validate() []error {
-	var errors []error
+	var errs []error
+
+	errs = append(errs, o.GenericServerRunOptions.Validate()...)
+	errs = append(errs, o.SecureServing.Validate()...)
+	errs = append(errs, o.InsecureServing.Validate()...)
+	errs = append(errs, o.UnixSocketServing.Validate()...)
+	errs = append(errs, o.Audit.Validate()...)
+	errs = append(errs, o.Features.Validate()...)
+	errs = append(errs, o.Authentication.Validate()...)
+	errs = append(errs, o.Authorization.Validate()...)
+	errs = append(errs, o.StorageSerialization.Validate()...)
+	errs = append(errs, o.StorageRouting.Validate()...)
+	errs = append(errs, o.Encryption.Validate()...)
+	errs = append(errs, o.WatchCache.Validate()...)
+	errs = append(errs, o.GarbageCollector.Validate()...)
+	errs = append(errs, o.StorageMetrics.Validate()...)
+	errs = append(errs, o.StorageVersionMigration.Validate()...)
+	errs = append(errs, o.APIEnablement.Validate(legacyscheme.Scheme)...)
+	errs = append(errs, o.EgressSelector.Validate()...)
+	errs = append(errs, o.Traces.Validate()...)
+	errs = append(errs, o.Admission.Validate()...)
+	errs = append(errs, o.ConfigFile.Validate()...)
+	errs = append(errs, o.ConfigReload.Validate()...)
+
+	if o.Storage == nil {
+		errs = append(errs, fmt.Errorf("unknown storage backend %q, registered backends are %v", o.Backend, RegisteredStorageBackends()))
+	} else {
+		errs = append(errs, o.Storage.Validate()...)
+	}
+
+	errs = append(errs, o.validateWebhookHosts()...)
+	return errs
+}
+
+// validateWebhookHosts checks that the authentication and authorization webhooks are not
+// served by the insecure port of this server, whose requests are authenticated and
+// authorized by the webhooks again.
+func (o *APIMasterOptions) validateWebhookHosts() []error {
+	if o.InsecureServing == nil || o.InsecureServing.BindPort <= 0 {
+		return nil
+	}
+	var errs []error
+	if o.Authentication != nil && o.Authentication.WebHook != nil &&
+		o.isInsecureServingHost(o.Authentication.WebHook.Host) {
+		errs = append(errs, fmt.Errorf("--authentication-token-webhook-host %s points at the insecure port of this server",
+			o.Authentication.WebHook.Host))
+	}
+	if o.Authorization != nil && o.isInsecureServingHost(o.Authorization.WebhookHost) {
+		errs = append(errs, fmt.Errorf("--%s %s points at the insecure port of this server",
+			authorizationWebhookHost, o.Authorization.WebhookHost))
+	}
+	return errs
+}
 
-	return errors
+// isInsecureServingHost returns true if host, in the form of host:port or a url, is
+// the insecure serving address. The insecure port is only bound to loopback addresses.
+func (o *APIMasterOptions) isInsecureServingHost(host string) bool {
+	if len(host) == 0 {
+		return false
+	}
+	if !strings.Contains(host, "://") {
+		host = "//" + host
+	}
+	u, err := url.Parse(host)
+	if err != nil {
+		return false
+	}
+	port, err := strconv.Atoi(u.Port())
+	if err != nil || port != o.InsecureServing.BindPort {
+		return false
+	}
+	hostname := u.Hostname()
+	if hostname == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(hostname)
+	return ip != nil && (ip.IsLoopback() || ip.Equal(o.InsecureServing.BindAddress))
 }
@@ -0,0 +1,126 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestAPIMasterOptionsValidate(t *testing.T) {
+	testCases := []struct {
+		name                 string
+		backend              StorageBackendType
+		modify               func(o *APIMasterOptions)
+		expectErrorSubString []string
+	}{
+		{
+			name:    "defaults",
+			backend: StorageBackendTypeMemory,
+			modify:  func(o *APIMasterOptions) {},
+		},
+		{
+			name:                 "missing sqlite dsn",
+			backend:              StorageBackendTypeSqlite,
+			modify:               func(o *APIMasterOptions) {},
+			expectErrorSubString: []string{"--sqlite-dsn"},
+		},
+		{
+			name:    "unknown storage backend",
+			backend: StorageBackendTypeMemory,
+			modify: func(o *APIMasterOptions) {
+				// the error of selecting it is reported by Validate again
+				_ = o.SetStorageBackend("unknown")
+			},
+			expectErrorSubString: []string{`unknown storage backend "unknown"`},
+		},
+		{
+			name:    "unregistered resource storage version",
+			backend: StorageBackendTypeMemory,
+			modify: func(o *APIMasterOptions) {
+				o.StorageSerialization.ResourceStorageVersions = []string{"coreres/v2/namespaces"}
+			},
+			expectErrorSubString: []string{"--resource-storage-versions coreres/v2 is not a registered version"},
+		},
+		{
+			name:    "errors of every option are aggregated",
+			backend: StorageBackendTypeMemory,
+			modify: func(o *APIMasterOptions) {
+				o.SecureServing.BindPort = -1
+				o.Authorization.Modes = []string{"Unknown"}
+				o.WatchCache.DefaultWatchCacheSize = -1
+				o.StorageVersionMigration.StateFile = "migration-state.json"
+			},
+			expectErrorSubString: []string{
+				"--secure-port",
+				`authorization-mode "Unknown" is not a valid mode`,
+				"--default-watch-cache-size",
+				"--storage-version-migration-state-file must be set with --storage-version-migration",
+			},
+		},
+		{
+			name:    "authorization webhook on the insecure port",
+			backend: StorageBackendTypeMemory,
+			modify: func(o *APIMasterOptions) {
+				o.Authorization.WebhookHost = "http://localhost:8080"
+			},
+			expectErrorSubString: []string{"--authorization-webhook-host http://localhost:8080 points at the insecure port of this server"},
+		},
+		{
+			name:    "authentication webhook on the insecure port",
+			backend: StorageBackendTypeMemory,
+			modify: func(o *APIMasterOptions) {
+				o.Authentication.WebHook.Host = "127.0.0.1:8080"
+			},
+			expectErrorSubString: []string{"--authentication-token-webhook-host 127.0.0.1:8080 points at the insecure port of this server"},
+		},
+		{
+			name:    "webhooks on other servers",
+			backend: StorageBackendTypeMemory,
+			modify: func(o *APIMasterOptions) {
+				o.Authorization.WebhookHost = "https://auth.example.com:8080"
+				o.Authentication.WebHook.Host = "127.0.0.1:8443"
+			},
+		},
+		{
+			name:    "webhook with the insecure port disabled",
+			backend: StorageBackendTypeMemory,
+			modify: func(o *APIMasterOptions) {
+				o.InsecureServing.BindPort = 0
+				o.Authorization.WebhookHost = "127.0.0.1:8080"
+			},
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			o := newTestAPIMasterOptions(t, testcase.backend)
+			o.Authorization.Complete()
+			testcase.modify(o)
+
+			errs := o.Validate()
+			if len(errs) != len(testcase.expectErrorSubString) {
+				t.Fatalf("expected %d errors, got %v", len(testcase.expectErrorSubString), errs)
+			}
+			for i, substring := range testcase.expectErrorSubString {
+				if !strings.Contains(errs[i].Error(), substring) {
+					t.Errorf("expected error containing %q, got %v", substring, errs[i])
+				}
+			}
+		})
+	}
+}
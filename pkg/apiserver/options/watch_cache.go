@@ -0,0 +1,229 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/server"
+	genericoptions "k8s.io/apiserver/pkg/server/options"
+	"k8s.io/apiserver/pkg/storage"
+	cacherstorage "k8s.io/apiserver/pkg/storage/cacher"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+	"k8s.io/apiserver/pkg/storage/storagebackend/factory"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+)
+
+// WatchCacheOptions watch cache in front of every storage backend
+type WatchCacheOptions struct {
+	// EnableWatchCache serves watches, and lists with a resourceVersion, from memory. Unless it
+	// is set by --watch-cache, it is enabled for etcd and disabled for the other backends.
+	EnableWatchCache bool
+	// DefaultWatchCacheSize zero disables the cache of resources without an explicit size.
+	DefaultWatchCacheSize int
+	// WatchCacheSizes overrides the size of single resources, "resource[.group]#size".
+	WatchCacheSizes []string
+
+	// enableWatchCacheSet is true once EnableWatchCache was set by --watch-cache or the config file.
+	enableWatchCacheSet bool
+}
+
+// NewWatchCacheOptions create watch cache options
+func NewWatchCacheOptions() *WatchCacheOptions {
+	return &WatchCacheOptions{
+		EnableWatchCache:      false,
+		DefaultWatchCacheSize: 100,
+	}
+}
+
+// SetEnableWatchCache enables or disables the watch cache, regardless of the storage backend.
+func (s *WatchCacheOptions) SetEnableWatchCache(enable bool) {
+	s.EnableWatchCache = enable
+	s.enableWatchCacheSet = true
+}
+
+// defaultForBackend enables the watch cache for etcd, whose storage has no watch cache of
+// its own here, unless it was set explicitly.
+func (s *WatchCacheOptions) defaultForBackend(backend StorageBackendType) {
+	if s == nil || s.enableWatchCacheSet {
+		return
+	}
+	s.EnableWatchCache = backend == StorageBackendTypeEtcd
+}
+
+// Validate validate watch cache input options
+func (s *WatchCacheOptions) Validate() []error {
+	if s == nil {
+		return nil
+	}
+
+	allErrors := []error{}
+	if s.DefaultWatchCacheSize < 0 {
+		allErrors = append(allErrors, fmt.Errorf("--default-watch-cache-size must not be negative"))
+	}
+	if _, err := genericoptions.ParseWatchCacheSizes(s.WatchCacheSizes); err != nil {
+		allErrors = append(allErrors, fmt.Errorf("--watch-cache-sizes %v", err))
+	}
+	return allErrors
+}
+
+// AddFlags adds flags related to the watch cache for a specific APIServer to the specified FlagSet
+func (s *WatchCacheOptions) AddFlags(fs *pflag.FlagSet) {
+	if s == nil {
+		return
+	}
+
+	fs.Var(&enableWatchCacheValue{options: s}, "watch-cache", ""+
+		"Enable watch caching in the apiserver for every storage backend. Watches and lists with a "+
+		"resourceVersion are served from memory, lists without a resourceVersion still read the storage. "+
+		"Defaults to true for the etcd backend and false for the others.")
+	fs.Lookup("watch-cache").NoOptDefVal = "true"
+
+	fs.IntVar(&s.DefaultWatchCacheSize, "default-watch-cache-size", s.DefaultWatchCacheSize,
+		"Default watch cache size. If zero, watch cache will be disabled for resources that do not have a default watch size set.")
+
+	fs.StringSliceVar(&s.WatchCacheSizes, "watch-cache-sizes", s.WatchCacheSizes, ""+
+		"Watch cache size settings for some resources, comma separated. "+
+		"The individual setting format: resource[.group]#size, where resource is lowercase plural (no version) "+
+		"and group is omitted for the legacy core API. A zero size disables watch caching for the resource, "+
+		"every non-zero size enables it, the cache is sized automatically.")
+}
+
+// enableWatchCacheValue is the pflag.Value of --watch-cache.
+type enableWatchCacheValue struct {
+	options *WatchCacheOptions
+}
+
+var _ pflag.Value = &enableWatchCacheValue{}
+
+// String implements pflag.Value.
+func (v *enableWatchCacheValue) String() string {
+	return strconv.FormatBool(v.options.EnableWatchCache)
+}
+
+// Set implements pflag.Value.
+func (v *enableWatchCacheValue) Set(value string) error {
+	enable, err := strconv.ParseBool(value)
+	if err != nil {
+		return err
+	}
+	v.options.SetEnableWatchCache(enable)
+	return nil
+}
+
+// Type implements pflag.Value.
+func (v *enableWatchCacheValue) Type() string {
+	return "bool"
+}
+
+// ApplyTo puts the watch cache in front of the storage of every cached resource,
+// it must be called after the storage backend set the RESTOptionsGetter.
+func (s *WatchCacheOptions) ApplyTo(c *server.Config) error {
+	if s == nil || !s.EnableWatchCache {
+		return nil
+	}
+
+	sizes, err := genericoptions.ParseWatchCacheSizes(s.WatchCacheSizes)
+	if err != nil {
+		return err
+	}
+	c.RESTOptionsGetter = &watchCacheRestOptionsFactory{
+		delegate:    c.RESTOptionsGetter,
+		defaultSize: s.DefaultWatchCacheSize,
+		sizes:       sizes,
+	}
+	return nil
+}
+
+// watchCacheRestOptionsFactory rest options factory that wraps the storage with a cacher
+type watchCacheRestOptionsFactory struct {
+	delegate    generic.RESTOptionsGetter
+	defaultSize int
+	sizes       map[schema.GroupResource]int
+}
+
+// GetRESTOptions impl generic.RESTOptions
+func (f *watchCacheRestOptionsFactory) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	ret, err := f.delegate.GetRESTOptions(resource)
+	if err != nil {
+		return generic.RESTOptions{}, err
+	}
+
+	size, found := f.sizes[resource]
+	if !found {
+		size = f.defaultSize
+	}
+	if size == 0 {
+		klog.V(3).InfoS("Not using watch cache", "resource", resource)
+		return ret, nil
+	}
+	klog.V(3).InfoS("Using watch cache", "resource", resource)
+	ret.Decorator = storageWithCacher(ret.Decorator)
+	return ret, nil
+}
+
+// storageWithCacher returns a decorator that puts a cacher in front of the storage
+// created by decorator.
+func storageWithCacher(decorator generic.StorageDecorator) generic.StorageDecorator {
+	return func(
+		storageConfig *storagebackend.ConfigForResource,
+		resourcePrefix string,
+		keyFunc func(obj runtime.Object) (string, error),
+		newFunc func() runtime.Object,
+		newListFunc func() runtime.Object,
+		getAttrsFunc storage.AttrFunc,
+		triggerFuncs storage.IndexerFuncs,
+		indexers *cache.Indexers) (storage.Interface, factory.DestroyFunc, error) {
+		s, d, err := decorator(storageConfig, resourcePrefix, keyFunc, newFunc, newListFunc, getAttrsFunc, triggerFuncs, indexers)
+		if err != nil {
+			return s, d, err
+		}
+
+		cacher, err := cacherstorage.NewCacherFromConfig(cacherstorage.Config{
+			Storage:        s,
+			Versioner:      storage.APIObjectVersioner{},
+			GroupResource:  storageConfig.GroupResource,
+			ResourcePrefix: resourcePrefix,
+			KeyFunc:        keyFunc,
+			NewFunc:        newFunc,
+			NewListFunc:    newListFunc,
+			GetAttrsFunc:   getAttrsFunc,
+			IndexerFuncs:   triggerFuncs,
+			Indexers:       indexers,
+			Codec:          storageConfig.Codec,
+		})
+		if err != nil {
+			d()
+			return nil, func() {}, err
+		}
+		var once sync.Once
+		destroyFunc := func() {
+			once.Do(func() {
+				cacher.Stop()
+				d()
+			})
+		}
+		return cacher, destroyFunc, nil
+	}
+}
@@ -0,0 +1,208 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package options
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/seanchann/apimaster/pkg/api/legacyscheme"
+	api "github.com/seanchann/apimaster/pkg/apis/coreres"
+	_ "github.com/seanchann/apimaster/pkg/apis/coreres/install"
+
+	"github.com/spf13/pflag"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/server"
+	serverstorage "k8s.io/apiserver/pkg/server/storage"
+	"k8s.io/apiserver/pkg/storage"
+	cacherstorage "k8s.io/apiserver/pkg/storage/cacher"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+	cliflag "k8s.io/component-base/cli/flag"
+)
+
+func TestWatchCacheOptionsValidate(t *testing.T) {
+	testCases := []struct {
+		name           string
+		options        *WatchCacheOptions
+		expectErrorNum int
+	}{
+		{
+			name:    "default",
+			options: NewWatchCacheOptions(),
+		},
+		{
+			name:    "resource sizes",
+			options: &WatchCacheOptions{EnableWatchCache: true, WatchCacheSizes: []string{"namespaces.coreres#0", "roles.rbac.authorization.k8s.io#100"}},
+		},
+		{
+			name:           "negative default size",
+			options:        &WatchCacheOptions{DefaultWatchCacheSize: -1},
+			expectErrorNum: 1,
+		},
+		{
+			name:           "invalid resource size",
+			options:        &WatchCacheOptions{WatchCacheSizes: []string{"namespaces.coreres"}},
+			expectErrorNum: 1,
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			errs := testcase.options.Validate()
+			if len(errs) != testcase.expectErrorNum {
+				t.Errorf("expected %d errors, got %v", testcase.expectErrorNum, errs)
+			}
+		})
+	}
+}
+
+func TestWatchCacheOptionsBackendDefault(t *testing.T) {
+	testCases := []struct {
+		name         string
+		backend      StorageBackendType
+		args         []string
+		expectEnable bool
+	}{
+		{
+			name:         "etcd",
+			backend:      StorageBackendTypeEtcd,
+			expectEnable: true,
+		},
+		{
+			name:    "memory",
+			backend: StorageBackendTypeMemory,
+		},
+		{
+			name:         "etcd selected by flag",
+			backend:      StorageBackendTypeMemory,
+			args:         []string{"--storage-backend=etcd"},
+			expectEnable: true,
+		},
+		{
+			name:    "disabled for etcd",
+			backend: StorageBackendTypeMemory,
+			args:    []string{"--watch-cache=false", "--storage-backend=etcd"},
+		},
+		{
+			name:         "enabled for memory",
+			backend:      StorageBackendTypeEtcd,
+			args:         []string{"--watch-cache", "--storage-backend=memory"},
+			expectEnable: true,
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			o := newTestAPIMasterOptions(t, testcase.backend)
+			fss := cliflag.NamedFlagSets{}
+			o.AddFlags(&fss)
+			fs := pflag.NewFlagSet("test", pflag.ContinueOnError)
+			for _, f := range fss.FlagSets {
+				fs.AddFlagSet(f)
+			}
+			if err := fs.Parse(testcase.args); err != nil {
+				t.Fatal(err)
+			}
+			if o.WatchCache.EnableWatchCache != testcase.expectEnable {
+				t.Errorf("expected watch cache %v, got %v", testcase.expectEnable, o.WatchCache.EnableWatchCache)
+			}
+		})
+	}
+}
+
+func TestWatchCacheOptionsApplyTo(t *testing.T) {
+	testCases := []struct {
+		name         string
+		options      *WatchCacheOptions
+		expectCacher bool
+	}{
+		{
+			name:    "disabled",
+			options: NewWatchCacheOptions(),
+		},
+		{
+			name:         "enabled",
+			options:      &WatchCacheOptions{EnableWatchCache: true, DefaultWatchCacheSize: 100},
+			expectCacher: true,
+		},
+		{
+			name:    "disabled for resource",
+			options: &WatchCacheOptions{EnableWatchCache: true, DefaultWatchCacheSize: 100, WatchCacheSizes: []string{"namespaces.coreres#0"}},
+		},
+		{
+			name:         "enabled for resource",
+			options:      &WatchCacheOptions{EnableWatchCache: true, WatchCacheSizes: []string{"namespaces.coreres#10"}},
+			expectCacher: true,
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			mem := NewMemoryOptions(storagebackend.NewDefaultConfig("memory", nil))
+			storageConfig, mediaType := mem.BackendConfig()
+			storageFactory := serverstorage.NewDefaultStorageFactory(*storageConfig, mediaType, legacyscheme.Codecs,
+				serverstorage.NewDefaultResourceEncodingConfig(legacyscheme.Scheme), serverstorage.NewResourceConfig(), nil)
+
+			c := &server.Config{}
+			if err := mem.ApplyWithStorageFactoryTo(storageFactory, c); err != nil {
+				t.Fatal(err)
+			}
+			if err := testcase.options.ApplyTo(c); err != nil {
+				t.Fatal(err)
+			}
+
+			restOptions, err := c.RESTOptionsGetter.GetRESTOptions(api.Resource("namespaces"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			prefix := "/" + restOptions.ResourcePrefix
+			s, destroy, err := restOptions.Decorator(restOptions.StorageConfig, prefix,
+				func(obj runtime.Object) (string, error) { return storage.NoNamespaceKeyFunc(prefix, obj) },
+				func() runtime.Object { return &api.Namespace{} },
+				func() runtime.Object { return &api.NamespaceList{} },
+				storage.DefaultClusterScopedAttr, nil, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer destroy()
+
+			if _, isCacher := s.(*cacherstorage.Cacher); isCacher != testcase.expectCacher {
+				t.Fatalf("expected cacher %v, got %T", testcase.expectCacher, s)
+			}
+
+			ctx := context.Background()
+			if err := s.Create(ctx, prefix+"/foo", &api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "foo"}}, nil, 0); err != nil {
+				t.Fatal(err)
+			}
+			// a list at resourceVersion 0 is served by the cache once it observed the object
+			err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, wait.ForeverTestTimeout, true, func(ctx context.Context) (bool, error) {
+				list := &api.NamespaceList{}
+				opts := storage.ListOptions{ResourceVersion: "0", Recursive: true, Predicate: storage.Everything}
+				if err := s.GetList(ctx, prefix, opts, list); err != nil {
+					return false, err
+				}
+				return len(list.Items) == 1, nil
+			})
+			if err != nil {
+				t.Errorf("expected the namespace to be listed: %v", err)
+			}
+		})
+	}
+}
@@ -22,6 +22,7 @@ import (
 	"time"
 
 	"github.com/emicklei/go-restful/v3"
+	"k8s.io/apimachinery/pkg/runtime/schema"
 	"k8s.io/apimachinery/pkg/version"
 	apimachineryversion "k8s.io/apimachinery/pkg/version"
 	"k8s.io/apiserver/pkg/registry/generic"
@@ -31,6 +32,8 @@ import (
 	"k8s.io/client-go/rest"
 	"k8s.io/klog"
 	"k8s.io/kube-openapi/pkg/common"
+
+	"github.com/seanchann/apimaster/pkg/apiserver/batch"
 )
 
 // ControllerProvider is a new custom controller that will be registered with the k8s/apiserver core.
@@ -67,6 +70,10 @@ type ExtraConfig struct {
 
 	//ControllerConfig config a controller
 	ControllerConfig ControllerProviderConfig
+
+	//SupportsBatch reports whether the storage of a resource can take part in a batch,
+	//nil supports every resource
+	SupportsBatch func(resource schema.GroupResource) bool
 }
 
 // Config master config
@@ -117,6 +124,13 @@ func (c completedConfig) New(delegateAPIServer genericapiserver.DelegationTarget
 		c.ExtraConfig.ExtendRoutesFunc(genericServer.Handler.GoRestfulContainer)
 	}
 
+	genericServer.Handler.NonGoRestfulMux.Handle(batch.Path, &batch.Handler{
+		Director:            genericServer.Handler.Director,
+		RequestInfoResolver: c.GenericConfig.RequestInfoResolver,
+		Authorizer:          c.GenericConfig.Authorization.Authorizer,
+		SupportsBatch:       c.ExtraConfig.SupportsBatch,
+	})
+
 	gm := &APIServer{
 		GenericAPIServer: genericServer,
 	}
@@ -0,0 +1,217 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package reload
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/user"
+	"k8s.io/apiserver/pkg/authorization/authorizer"
+	"k8s.io/apiserver/pkg/endpoints/request"
+)
+
+// Authorizer is an authorizer.Authorizer and authorizer.RuleResolver, whose
+// delegate is swapped atomically on reload.
+type Authorizer struct {
+	delegate atomic.Pointer[authorizerDelegate]
+}
+
+type authorizerDelegate struct {
+	authorizer.Authorizer
+	authorizer.RuleResolver
+}
+
+var _ authorizer.Authorizer = &Authorizer{}
+var _ authorizer.RuleResolver = &Authorizer{}
+
+// NewAuthorizer creates an Authorizer delegating to a and r.
+func NewAuthorizer(a authorizer.Authorizer, r authorizer.RuleResolver) *Authorizer {
+	ret := &Authorizer{}
+	ret.Set(a, r)
+	return ret
+}
+
+// Set swaps the delegate.
+func (a *Authorizer) Set(delegate authorizer.Authorizer, resolver authorizer.RuleResolver) {
+	a.delegate.Store(&authorizerDelegate{Authorizer: delegate, RuleResolver: resolver})
+}
+
+// Authorize implements authorizer.Authorizer.
+func (a *Authorizer) Authorize(ctx context.Context, attrs authorizer.Attributes) (authorizer.Decision, string, error) {
+	return a.delegate.Load().Authorize(ctx, attrs)
+}
+
+// RulesFor implements authorizer.RuleResolver.
+func (a *Authorizer) RulesFor(user user.Info, namespace string) ([]authorizer.ResourceRuleInfo, []authorizer.NonResourceRuleInfo, bool, error) {
+	return a.delegate.Load().RulesFor(user, namespace)
+}
+
+// Token is an authenticator.Token, whose delegate is swapped atomically on reload.
+type Token struct {
+	delegate atomic.Pointer[tokenDelegate]
+}
+
+type tokenDelegate struct {
+	authenticator.Token
+}
+
+var _ authenticator.Token = &Token{}
+
+// NewToken creates a Token delegating to t.
+func NewToken(t authenticator.Token) *Token {
+	ret := &Token{}
+	ret.Set(t)
+	return ret
+}
+
+// Set swaps the delegate.
+func (t *Token) Set(delegate authenticator.Token) {
+	t.delegate.Store(&tokenDelegate{Token: delegate})
+}
+
+// AuthenticateToken implements authenticator.Token.
+func (t *Token) AuthenticateToken(ctx context.Context, token string) (*authenticator.Response, bool, error) {
+	return t.delegate.Load().AuthenticateToken(ctx, token)
+}
+
+// Admission is an admission chain, whose delegate is swapped atomically on reload. A request
+// is admitted by the chain of its first admission call, which is kept until the request is done.
+type Admission struct {
+	delegate atomic.Pointer[admissionDelegate]
+
+	lock sync.Mutex
+	// pins are the chains of the requests being served, by their RequestInfo.
+	pins map[*request.RequestInfo]*admissionDelegate
+}
+
+type admissionDelegate struct {
+	admission.Interface
+
+	lock sync.Mutex
+	// refs counts the requests pinned to the chain and the calls without a request running on it.
+	refs     int
+	replaced bool
+	// drained is closed when the chain is replaced and refs is zero.
+	drained chan struct{}
+}
+
+var _ admission.MutationInterface = &Admission{}
+var _ admission.ValidationInterface = &Admission{}
+
+// NewAdmission creates an Admission delegating to chain.
+func NewAdmission(chain admission.Interface) *Admission {
+	ret := &Admission{pins: map[*request.RequestInfo]*admissionDelegate{}}
+	ret.Set(chain)
+	return ret
+}
+
+// Set swaps the delegate. The returned channel is closed when no request uses the replaced
+// chain anymore, the replaced chain can be stopped then.
+func (a *Admission) Set(chain admission.Interface) <-chan struct{} {
+	old := a.delegate.Swap(&admissionDelegate{Interface: chain, drained: make(chan struct{})})
+	if old == nil {
+		drained := make(chan struct{})
+		close(drained)
+		return drained
+	}
+
+	old.lock.Lock()
+	defer old.lock.Unlock()
+	old.replaced = true
+	if old.refs == 0 {
+		close(old.drained)
+	}
+	return old.drained
+}
+
+// acquire returns the current chain with a reference the caller releases.
+func (a *Admission) acquire() *admissionDelegate {
+	for {
+		d := a.delegate.Load()
+		d.lock.Lock()
+		if !d.replaced {
+			d.refs++
+			d.lock.Unlock()
+			return d
+		}
+		// swapped after the load, the next load returns the new chain
+		d.lock.Unlock()
+	}
+}
+
+func (d *admissionDelegate) release() {
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	d.refs--
+	if d.refs == 0 && d.replaced {
+		close(d.drained)
+	}
+}
+
+// chain returns the chain of the request of ctx and the function to call once the admission
+// call is finished. The calls without a request hold the chain for their own duration.
+func (a *Admission) chain(ctx context.Context) (*admissionDelegate, func()) {
+	info, ok := request.RequestInfoFrom(ctx)
+	if !ok || ctx.Err() != nil {
+		d := a.acquire()
+		return d, d.release
+	}
+
+	a.lock.Lock()
+	defer a.lock.Unlock()
+	d, ok := a.pins[info]
+	if !ok {
+		d = a.acquire()
+		a.pins[info] = d
+		context.AfterFunc(ctx, func() {
+			a.lock.Lock()
+			delete(a.pins, info)
+			a.lock.Unlock()
+			d.release()
+		})
+	}
+	return d, func() {}
+}
+
+// Handles implements admission.Interface.
+func (a *Admission) Handles(operation admission.Operation) bool {
+	return a.delegate.Load().Handles(operation)
+}
+
+// Admit implements admission.MutationInterface.
+func (a *Admission) Admit(ctx context.Context, attrs admission.Attributes, o admission.ObjectInterfaces) error {
+	d, done := a.chain(ctx)
+	defer done()
+	if mutator, ok := d.Interface.(admission.MutationInterface); ok {
+		return mutator.Admit(ctx, attrs, o)
+	}
+	return nil
+}
+
+// Validate implements admission.ValidationInterface.
+func (a *Admission) Validate(ctx context.Context, attrs admission.Attributes, o admission.ObjectInterfaces) error {
+	d, done := a.chain(ctx)
+	defer done()
+	if validator, ok := d.Interface.(admission.ValidationInterface); ok {
+		return validator.Validate(ctx, attrs, o)
+	}
+	return nil
+}
@@ -0,0 +1,166 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package reload
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/endpoints/request"
+)
+
+const admissionTimeout = 5 * time.Second
+
+// fakeChain records the admission calls it serves, a call blocks while block is open.
+type fakeChain struct {
+	name  string
+	calls chan string
+	block chan struct{}
+}
+
+func newFakeChain(name string) *fakeChain {
+	return &fakeChain{name: name, calls: make(chan string, 10)}
+}
+
+func (c *fakeChain) Handles(operation admission.Operation) bool { return true }
+
+func (c *fakeChain) Admit(ctx context.Context, attrs admission.Attributes, o admission.ObjectInterfaces) error {
+	c.calls <- c.name + " admit"
+	if c.block != nil {
+		<-c.block
+	}
+	return nil
+}
+
+func (c *fakeChain) Validate(ctx context.Context, attrs admission.Attributes, o admission.ObjectInterfaces) error {
+	c.calls <- c.name + " validate"
+	return nil
+}
+
+func expectCall(t *testing.T, chain *fakeChain, call string) {
+	t.Helper()
+	select {
+	case got := <-chain.calls:
+		if got != call {
+			t.Errorf("expected %q, got %q", call, got)
+		}
+	case <-time.After(admissionTimeout):
+		t.Errorf("expected %q", call)
+	}
+}
+
+func expectDrained(t *testing.T, drained <-chan struct{}, expected bool) {
+	t.Helper()
+	timeout := admissionTimeout
+	if !expected {
+		timeout = 50 * time.Millisecond
+	}
+	select {
+	case <-drained:
+		if !expected {
+			t.Errorf("expected the replaced chain to be in use")
+		}
+	case <-time.After(timeout):
+		if expected {
+			t.Errorf("expected the replaced chain to be drained")
+		}
+	}
+}
+
+func TestAdmission(t *testing.T) {
+	newRequest := func() (context.Context, context.CancelFunc) {
+		ctx := request.WithRequestInfo(context.Background(), &request.RequestInfo{Verb: "create"})
+		return context.WithCancel(ctx)
+	}
+
+	testCases := []struct {
+		name string
+		run  func(t *testing.T, a *Admission, old, replacement *fakeChain)
+	}{
+		{
+			name: "request keeps its chain",
+			run: func(t *testing.T, a *Admission, old, replacement *fakeChain) {
+				ctx, cancel := newRequest()
+				if err := a.Admit(ctx, nil, nil); err != nil {
+					t.Fatal(err)
+				}
+				expectCall(t, old, "old admit")
+
+				drained := a.Set(replacement)
+				if err := a.Validate(ctx, nil, nil); err != nil {
+					t.Fatal(err)
+				}
+				expectCall(t, old, "old validate")
+				expectDrained(t, drained, false)
+
+				next, cancelNext := newRequest()
+				defer cancelNext()
+				if err := a.Validate(next, nil, nil); err != nil {
+					t.Fatal(err)
+				}
+				expectCall(t, replacement, "new validate")
+
+				cancel()
+				expectDrained(t, drained, true)
+			},
+		},
+		{
+			name: "running call without a request",
+			run: func(t *testing.T, a *Admission, old, replacement *fakeChain) {
+				old.block = make(chan struct{})
+				errs := make(chan error)
+				go func() { errs <- a.Admit(context.Background(), nil, nil) }()
+				expectCall(t, old, "old admit")
+
+				drained := a.Set(replacement)
+				expectDrained(t, drained, false)
+				close(old.block)
+				if err := <-errs; err != nil {
+					t.Fatal(err)
+				}
+				expectDrained(t, drained, true)
+
+				if err := a.Admit(context.Background(), nil, nil); err != nil {
+					t.Fatal(err)
+				}
+				expectCall(t, replacement, "new admit")
+			},
+		},
+		{
+			name: "unused chain",
+			run: func(t *testing.T, a *Admission, old, replacement *fakeChain) {
+				ctx, cancel := newRequest()
+				cancel()
+				if err := a.Admit(ctx, nil, nil); err != nil {
+					t.Fatal(err)
+				}
+				expectCall(t, old, "old admit")
+				expectDrained(t, a.Set(replacement), true)
+			},
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			old, replacement := newFakeChain("old"), newFakeChain("new")
+			a := NewAdmission(old)
+			testcase.run(t, a, old, replacement)
+		})
+	}
+}
@@ -0,0 +1,66 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package reload
+
+import (
+	"sync"
+	"time"
+
+	compbasemetrics "k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+)
+
+var (
+	reloadsTotal = compbasemetrics.NewCounterVec(
+		&compbasemetrics.CounterOpts{
+			Subsystem:      "apimaster",
+			Name:           "config_file_reloads_total",
+			Help:           "Reloads of each config file, by status success or failure.",
+			StabilityLevel: compbasemetrics.ALPHA,
+		},
+		[]string{"name", "status"},
+	)
+	lastReloadSuccess = compbasemetrics.NewGaugeVec(
+		&compbasemetrics.GaugeOpts{
+			Subsystem:      "apimaster",
+			Name:           "config_file_last_reload_success_timestamp_seconds",
+			Help:           "Timestamp of the last successful reload of each config file.",
+			StabilityLevel: compbasemetrics.ALPHA,
+		},
+		[]string{"name"},
+	)
+)
+
+var registerMetrics sync.Once
+
+// RegisterMetrics registers the reload metrics.
+func RegisterMetrics() {
+	registerMetrics.Do(func() {
+		legacyregistry.MustRegister(reloadsTotal)
+		legacyregistry.MustRegister(lastReloadSuccess)
+	})
+}
+
+// recordReload records a reload of the config file name and whether it failed.
+func recordReload(name string, err error) {
+	if err != nil {
+		reloadsTotal.WithLabelValues(name, "failure").Inc()
+		return
+	}
+	reloadsTotal.WithLabelValues(name, "success").Inc()
+	lastReloadSuccess.WithLabelValues(name).Set(float64(time.Now().Unix()))
+}
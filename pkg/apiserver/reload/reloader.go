@@ -0,0 +1,227 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package reload
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+)
+
+const (
+	// ReasonReloaded is the reason of the event of a successful reload.
+	ReasonReloaded = "ConfigFileReloaded"
+	// ReasonReloadFailed is the reason of the event of a failed reload.
+	ReasonReloadFailed = "ConfigFileReloadFailed"
+)
+
+// reloadDelay coalesces the events of editors and tools writing a file in several steps.
+var reloadDelay = 500 * time.Millisecond
+
+// LoadFunc loads and validates a config file, and swaps it in only if it is valid.
+type LoadFunc func(path string) error
+
+// Reloaders reloads the registered config files.
+type Reloaders struct {
+	// Watch reloads the files when they change, they are always reloaded on SIGHUP.
+	Watch bool
+	// Recorder emits an event for each reload, nil disables the events.
+	Recorder record.EventRecorder
+
+	lock      sync.Mutex
+	reloaders []*Reloader
+}
+
+// NewReloaders creates an empty set of reloaders.
+func NewReloaders() *Reloaders {
+	return &Reloaders{}
+}
+
+// Add registers the config file path, which was loaded already, load is called on each reload.
+func (rs *Reloaders) Add(name, path string, load LoadFunc) *Reloader {
+	r := &Reloader{
+		name:      name,
+		path:      path,
+		load:      load,
+		reloaders: rs,
+	}
+	r.checksum, _ = fileChecksum(path)
+
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	rs.reloaders = append(rs.reloaders, r)
+	return r
+}
+
+// Reload reloads every config file, whether it changed or not.
+func (rs *Reloaders) Reload() {
+	for _, r := range rs.list() {
+		r.Reload()
+	}
+}
+
+func (rs *Reloaders) list() []*Reloader {
+	rs.lock.Lock()
+	defer rs.lock.Unlock()
+	return append([]*Reloader(nil), rs.reloaders...)
+}
+
+// Run reloads the config files on SIGHUP, and when they change if Watch is set, until ctx is done.
+func (rs *Reloaders) Run(ctx context.Context) error {
+	if rs == nil || len(rs.list()) == 0 {
+		return nil
+	}
+
+	var events <-chan fsnotify.Event
+	var errors <-chan error
+	if rs.Watch {
+		watcher, err := fsnotify.NewWatcher()
+		if err != nil {
+			return fmt.Errorf("failed to watch the config files: %v", err)
+		}
+		for _, r := range rs.list() {
+			// watch the directory, files are often replaced by a rename or a symlink swap
+			if err := watcher.Add(filepath.Dir(r.path)); err != nil {
+				watcher.Close()
+				return fmt.Errorf("failed to watch %s: %v", r.path, err)
+			}
+		}
+		events, errors = watcher.Events, watcher.Errors
+		go func() {
+			<-ctx.Done()
+			watcher.Close()
+		}()
+	}
+
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+
+	go func() {
+		defer signal.Stop(hangup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-hangup:
+				klog.Infof("Reloading the config files on SIGHUP")
+				rs.Reload()
+			case event, ok := <-events:
+				if !ok {
+					events = nil
+					continue
+				}
+				for _, r := range rs.list() {
+					if filepath.Dir(r.path) == filepath.Dir(event.Name) {
+						r.scheduleReload()
+					}
+				}
+			case err, ok := <-errors:
+				if !ok {
+					errors = nil
+					continue
+				}
+				klog.Warningf("Failed to watch the config files: %v", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// Reloader reloads a single config file.
+type Reloader struct {
+	name      string
+	path      string
+	load      LoadFunc
+	reloaders *Reloaders
+
+	lock     sync.Mutex
+	checksum []byte
+	timer    *time.Timer
+}
+
+// Reload loads the config file, whether it changed or not.
+func (r *Reloader) Reload() error {
+	return r.reload(true)
+}
+
+// scheduleReload reloads the config file after reloadDelay, if it changed.
+func (r *Reloader) scheduleReload() {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if r.timer != nil {
+		r.timer.Stop()
+	}
+	r.timer = time.AfterFunc(reloadDelay, func() { r.reload(false) })
+}
+
+func (r *Reloader) reload(force bool) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	checksum, err := fileChecksum(r.path)
+	if err == nil && !force && bytes.Equal(checksum, r.checksum) {
+		return nil
+	}
+	if err == nil {
+		err = r.load(r.path)
+	}
+	recordReload(r.name, err)
+	if err != nil {
+		klog.Errorf("Failed to reload %s %s, keeping the previous config: %v", r.name, r.path, err)
+		r.event(corev1.EventTypeWarning, ReasonReloadFailed, "Failed to reload %s, keeping the previous config: %v", r.path, err)
+		return err
+	}
+
+	r.checksum = checksum
+	klog.Infof("Reloaded %s %s", r.name, r.path)
+	r.event(corev1.EventTypeNormal, ReasonReloaded, "Reloaded %s", r.path)
+	return nil
+}
+
+func (r *Reloader) event(eventType, reason, messageFmt string, args ...interface{}) {
+	if r.reloaders.Recorder == nil {
+		return
+	}
+	ref := &corev1.ObjectReference{
+		Kind:      "ConfigFile",
+		Namespace: metav1.NamespaceSystem,
+		Name:      r.name,
+	}
+	r.reloaders.Recorder.Eventf(ref, eventType, reason, messageFmt, args...)
+}
+
+func fileChecksum(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256(data)
+	return sum[:], nil
+}
@@ -0,0 +1,147 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package reload
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/authentication/token/tokenfile"
+	"k8s.io/client-go/tools/record"
+)
+
+func writeFile(t *testing.T, path, data string) {
+	t.Helper()
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// newTokenReloader returns a Token loaded from path, which is reloaded by the returned Reloaders.
+func newTokenReloader(t *testing.T, path string) (*Reloaders, *Reloader, *Token, *record.FakeRecorder) {
+	t.Helper()
+	tokens, err := tokenfile.NewCSV(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token := NewToken(tokens)
+
+	recorder := record.NewFakeRecorder(10)
+	rs := NewReloaders()
+	rs.Recorder = recorder
+	r := rs.Add("token-auth-file", path, func(path string) error {
+		tokens, err := tokenfile.NewCSV(path)
+		if err != nil {
+			return err
+		}
+		token.Set(tokens)
+		return nil
+	})
+	return rs, r, token, recorder
+}
+
+func authenticates(token authenticator.Token, value, expectUser string) bool {
+	resp, ok, err := token.AuthenticateToken(context.Background(), value)
+	return err == nil && ok && resp.User.GetName() == expectUser
+}
+
+func expectEvent(t *testing.T, recorder *record.FakeRecorder, reason string) {
+	t.Helper()
+	select {
+	case event := <-recorder.Events:
+		if !strings.Contains(event, reason) {
+			t.Errorf("expected event %s, got %q", reason, event)
+		}
+	default:
+		t.Errorf("expected event %s", reason)
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "tokens.csv")
+	writeFile(t, path, "token1,alice,1\n")
+	_, r, token, recorder := newTokenReloader(t, path)
+
+	writeFile(t, path, "token2,bob,2\n")
+	if err := r.Reload(); err != nil {
+		t.Fatal(err)
+	}
+	if authenticates(token, "token1", "alice") || !authenticates(token, "token2", "bob") {
+		t.Errorf("expected only token2 to authenticate after the reload")
+	}
+	expectEvent(t, recorder, ReasonReloaded)
+
+	// an invalid file keeps the previous tokens
+	writeFile(t, path, "token3\n")
+	if err := r.Reload(); err == nil {
+		t.Errorf("expected the reload of an invalid file to fail")
+	}
+	if !authenticates(token, "token2", "bob") {
+		t.Errorf("expected token2 to authenticate after the failed reload")
+	}
+	expectEvent(t, recorder, ReasonReloadFailed)
+
+	// unchanged files are reloaded on SIGHUP only
+	writeFile(t, path, "token4,carol,4\n")
+	if err := r.reload(false); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, recorder, ReasonReloaded)
+	if err := r.reload(false); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case event := <-recorder.Events:
+		t.Errorf("expected no event for an unchanged file, got %q", event)
+	default:
+	}
+}
+
+func TestWatch(t *testing.T) {
+	reloadDelay = 10 * time.Millisecond
+	defer func() { reloadDelay = 500 * time.Millisecond }()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "tokens.csv")
+	writeFile(t, path, "token1,alice,1\n")
+	rs, _, token, _ := newTokenReloader(t, path)
+	rs.Watch = true
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := rs.Run(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	// replace the file by a rename, like most tools do
+	writeFile(t, filepath.Join(dir, "tokens.csv.tmp"), "token2,bob,2\n")
+	if err := os.Rename(filepath.Join(dir, "tokens.csv.tmp"), path); err != nil {
+		t.Fatal(err)
+	}
+	err := wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, 5*time.Second, true, func(context.Context) (bool, error) {
+		return authenticates(token, "token2", "bob"), nil
+	})
+	if err != nil {
+		t.Errorf("expected the changed file to be reloaded: %v", err)
+	}
+}
@@ -19,6 +19,7 @@ package server
 import (
 	"net/http"
 
+	"k8s.io/apiserver/pkg/authentication/authenticator"
 	genericapifilters "k8s.io/apiserver/pkg/endpoints/filters"
 	"k8s.io/apiserver/pkg/server"
 	genericfilters "k8s.io/apiserver/pkg/server/filters"
@@ -46,6 +47,24 @@ func BuildInsecureHandlerChain(apiHandler http.Handler, c *server.Config, enable
 		handler = genericapifilters.WithAuthentication(handler, server.InsecureSuperuser{}, nil, nil, nil)
 	}
 
+	return withServingFilters(handler, c)
+}
+
+// BuildUnixSocketHandlerChain sets up the server to listen on a unix socket, the callers are
+// authenticated by peerAuthenticator and authorized like on the secure port.
+func BuildUnixSocketHandlerChain(apiHandler http.Handler, c *server.Config, peerAuthenticator authenticator.Request) http.Handler {
+	handler := genericapifilters.WithAuthorization(apiHandler, c.Authorization.Authorizer, c.Serializer)
+	handler = genericapifilters.WithAudit(handler, c.AuditBackend, c.AuditPolicyRuleEvaluator, c.LongRunningFunc)
+
+	failedHandler := genericapifilters.Unauthorized(c.Serializer)
+	failedHandler = genericapifilters.WithFailedAuthenticationAudit(failedHandler, c.AuditBackend, c.AuditPolicyRuleEvaluator)
+	handler = genericapifilters.WithAuthentication(handler, peerAuthenticator, failedHandler, nil, nil)
+
+	return withServingFilters(handler, c)
+}
+
+// withServingFilters adds the filters shared by the insecure and the unix socket handler chains.
+func withServingFilters(handler http.Handler, c *server.Config) http.Handler {
 	handler = genericfilters.WithCORS(handler, c.CorsAllowedOriginList, nil, nil, nil, "true")
 	handler = genericfilters.WithTimeoutForNonLongRunningRequests(handler, c.LongRunningFunc)
 	handler = genericfilters.WithMaxInFlightLimit(handler, c.MaxRequestsInFlight, c.MaxMutatingRequestsInFlight, c.LongRunningFunc)
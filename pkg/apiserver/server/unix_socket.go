@@ -0,0 +1,79 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package server
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"k8s.io/apiserver/pkg/authentication/authenticator"
+	"k8s.io/apiserver/pkg/server"
+	"k8s.io/klog/v2"
+
+	"github.com/seanchann/apimaster/pkg/apiserver/authenticator/peercred"
+)
+
+// UnixSocketServingInfo serves on a unix socket, the callers are authenticated by the
+// credentials of their processes.
+type UnixSocketServingInfo struct {
+	// Listener is the unix socket listener.
+	Listener net.Listener
+	// Authenticator authenticates the callers by their peer credentials.
+	Authenticator authenticator.Request
+}
+
+// ListenUnixSocket listens on the unix socket path with the file mode, a stale socket
+// left by a previous run is removed.
+func ListenUnixSocket(path string, mode os.FileMode) (net.Listener, error) {
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode()&os.ModeSocket == 0 {
+			return nil, fmt.Errorf("%s exists and is not a unix socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, err
+		}
+	}
+
+	ln, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, mode); err != nil {
+		ln.Close()
+		return nil, err
+	}
+	return ln, nil
+}
+
+// Serve serves handler on the unix socket until stopCh is closed.
+func (s *UnixSocketServingInfo) Serve(handler http.Handler, shutdownTimeout time.Duration, stopCh <-chan struct{}) error {
+	unixSocketServer := &http.Server{
+		Handler:        handler,
+		MaxHeaderBytes: 1 << 20,
+		ConnContext:    peercred.ConnContext,
+
+		IdleTimeout:       90 * time.Second, // matches http.DefaultTransport keep-alive timeout
+		ReadHeaderTimeout: 32 * time.Second, // just shy of requestTimeoutUpperBound
+	}
+
+	klog.Infof("Serving on unix socket %s", s.Listener.Addr())
+	_, _, err := server.RunServer(unixSocketServer, s.Listener, shutdownTimeout, stopCh)
+	return err
+}
@@ -0,0 +1,102 @@
+//go:build linux
+
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package server
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/seanchann/apimaster/pkg/apiserver/authenticator/peercred"
+)
+
+func TestUnixSocketServingInfo(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apimaster.sock")
+	uid := uint32(os.Getuid())
+
+	// a stale socket of a previous run is replaced
+	stale, err := ListenUnixSocket(path, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	ln, err := ListenUnixSocket(path, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected mode 0600, got %v", info.Mode().Perm())
+	}
+
+	a := peercred.NewAuthenticator(&peercred.Config{
+		Users: []peercred.UserMapping{{UID: uid, Name: "sidecar"}},
+	})
+	servingInfo := &UnixSocketServingInfo{Listener: ln, Authenticator: a}
+	handler := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		resp, ok, err := servingInfo.Authenticator.AuthenticateRequest(req)
+		if err != nil || !ok {
+			http.Error(w, fmt.Sprintf("unauthenticated: %v", err), http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprint(w, resp.User.GetName())
+	})
+
+	stopCh := make(chan struct{})
+	defer close(stopCh)
+	if err := servingInfo.Serve(handler, time.Second, stopCh); err != nil {
+		t.Fatal(err)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://unix/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "sidecar" {
+		t.Errorf("expected sidecar, got %d %q", resp.StatusCode, body)
+	}
+}
+
+func TestListenUnixSocketRefusesFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "apimaster.sock")
+	if err := os.WriteFile(path, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ListenUnixSocket(path, 0600); err == nil {
+		t.Errorf("expected an error for a regular file")
+	}
+}
@@ -0,0 +1,322 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package apiserver
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/seanchann/apimaster/pkg/api/legacyscheme"
+	"github.com/seanchann/apimaster/pkg/apiserver/options"
+	"github.com/seanchann/apimaster/pkg/storage/resourcestore"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/klog/v2"
+)
+
+// SnapshotVersion is the version of the snapshot archive format.
+const SnapshotVersion = 1
+
+const (
+	snapshotManifestName = "snapshot.json"
+	snapshotResourcesDir = "resources"
+)
+
+// SnapshotManifest describes the content of a snapshot archive, it is the first entry of the archive.
+type SnapshotManifest struct {
+	// Version is the version of the archive format.
+	Version int `json:"version"`
+	// Backend is the storage backend the snapshot was taken from.
+	Backend options.StorageBackendType `json:"backend"`
+	// CreationTimestamp is the time the snapshot was started.
+	CreationTimestamp metav1.Time `json:"creationTimestamp"`
+	// ResourceVersion is the resourceVersion every resource was read at.
+	ResourceVersion string `json:"resourceVersion,omitempty"`
+	// Resources are the stored resources, each object is archived in the storage version of its resource.
+	Resources []SnapshotResource `json:"resources"`
+}
+
+// SnapshotResource is a resource of a snapshot archive.
+type SnapshotResource struct {
+	// Resource is the group resource, eg: namespaces.coreres
+	Resource string `json:"resource"`
+	// StorageVersion is the group version the objects are encoded in.
+	StorageVersion string `json:"storageVersion"`
+}
+
+// snapshotStore is the storage of one resource and the version its objects are encoded in.
+type snapshotStore struct {
+	*resourcestore.Store
+	version schema.GroupVersion
+	codec   runtime.Codec
+}
+
+// openSnapshotStores opens the storage of every enabled resource of s, keyed by the resource.
+func openSnapshotStores(s *options.APIMasterOptions, apiProvider APIServerProvider) ([]SnapshotResource, map[string]*snapshotStore, error) {
+	c, err := buildStorageConfig(s, apiProvider)
+	if err != nil {
+		return nil, nil, err
+	}
+	storageFactory, err := BuildStorageFactory(s, c.MergedResourceConfig)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resources := []SnapshotResource{}
+	stores := map[string]*snapshotStore{}
+	for _, gr := range resourcestore.Resources(legacyscheme.Scheme, c.MergedResourceConfig) {
+		gv, err := storageFactory.ResourceEncodingConfig.StorageEncodingFor(gr)
+		if err != nil {
+			closeSnapshotStores(stores)
+			return nil, nil, err
+		}
+		store, err := resourcestore.Open(c.RESTOptionsGetter, legacyscheme.Scheme, gr)
+		if err != nil {
+			closeSnapshotStores(stores)
+			return nil, nil, err
+		}
+		resources = append(resources, SnapshotResource{Resource: gr.String(), StorageVersion: gv.String()})
+		stores[gr.String()] = &snapshotStore{Store: store, version: gv, codec: legacyscheme.Codecs.LegacyCodec(gv)}
+	}
+	return resources, stores, nil
+}
+
+func closeSnapshotStores(stores map[string]*snapshotStore) {
+	for _, store := range stores {
+		store.Destroy()
+	}
+}
+
+// SaveSnapshot writes every object of the enabled resources of the storage backend of s
+// to w, as a gzipped tar archive. Every resource is read at the same resourceVersion, so the
+// archive is consistent. Backends that keep no history, e.g. the kv stores, can only read
+// the latest revision, the snapshot fails if the backend is written while it is saved.
+func SaveSnapshot(ctx context.Context, s *options.APIMasterOptions, apiProvider APIServerProvider, w io.Writer) (*SnapshotManifest, error) {
+	resources, stores, err := openSnapshotStores(s, apiProvider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up storage: %v", err)
+	}
+	defer closeSnapshotStores(stores)
+
+	manifest := &SnapshotManifest{
+		Version:           SnapshotVersion,
+		Backend:           s.Backend,
+		CreationTimestamp: metav1.NewTime(time.Now().UTC()),
+		Resources:         resources,
+	}
+	if len(resources) > 0 {
+		// every resource shares the revision counter of the backend
+		manifest.ResourceVersion, err = stores[resources[0].Resource].ResourceVersion(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read the resourceVersion: %v", err)
+		}
+	}
+	gzipWriter := gzip.NewWriter(w)
+	tarWriter := tar.NewWriter(gzipWriter)
+
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := writeSnapshotEntry(tarWriter, snapshotManifestName, data, manifest.CreationTimestamp.Time); err != nil {
+		return nil, err
+	}
+	for _, resource := range manifest.Resources {
+		store := stores[resource.Resource]
+		count := 0
+		readAt, err := store.EachAt(ctx, manifest.ResourceVersion, func(obj runtime.Object) error {
+			accessor, err := meta.Accessor(obj)
+			if err != nil {
+				return err
+			}
+			data, err := runtime.Encode(store.codec, obj)
+			if err != nil {
+				return err
+			}
+			name := path.Join(snapshotResourcesDir, resource.Resource, accessor.GetNamespace(), accessor.GetName()+".json")
+			count++
+			return writeSnapshotEntry(tarWriter, name, data, manifest.CreationTimestamp.Time)
+		})
+		if apierrors.IsResourceExpired(err) || apierrors.IsGone(err) || storage.IsTooLargeResourceVersion(err) {
+			return nil, fmt.Errorf("failed to save %s at resourceVersion %s, the storage has been written meanwhile: %v",
+				resource.Resource, manifest.ResourceVersion, err)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to save %s: %v", resource.Resource, err)
+		}
+		if readAt != manifest.ResourceVersion {
+			return nil, fmt.Errorf("failed to save %s at resourceVersion %s, the storage read it at %s",
+				resource.Resource, manifest.ResourceVersion, readAt)
+		}
+		klog.Infof("Saved %d %s", count, resource.Resource)
+	}
+
+	if err := tarWriter.Close(); err != nil {
+		return nil, err
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, err
+	}
+	return manifest, nil
+}
+
+func writeSnapshotEntry(w *tar.Writer, name string, data []byte, modTime time.Time) error {
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0600,
+		Size:     int64(len(data)),
+		ModTime:  modTime,
+	}
+	if err := w.WriteHeader(header); err != nil {
+		return err
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// RestoreSnapshot writes the objects of the snapshot archive read from r to the storage
+// backend of s, which must not store any object of the archived resources yet. UIDs,
+// creationTimestamps and finalizers are kept, the resourceVersions are assigned by the
+// backend. Archives whose resources are stored in another version than the one s is
+// configured with, by --storage-versions and --resource-storage-versions, are refused
+// before anything is written.
+func RestoreSnapshot(ctx context.Context, s *options.APIMasterOptions, apiProvider APIServerProvider, r io.Reader) (*SnapshotManifest, error) {
+	gzipReader, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %v", err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+
+	header, err := tarReader.Next()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %v", err)
+	}
+	if header.Name != snapshotManifestName {
+		return nil, fmt.Errorf("invalid snapshot: expected %s as first entry, got %s", snapshotManifestName, header.Name)
+	}
+	manifest := &SnapshotManifest{}
+	if err := json.NewDecoder(tarReader).Decode(manifest); err != nil {
+		return nil, fmt.Errorf("invalid snapshot manifest: %v", err)
+	}
+	if manifest.Version != SnapshotVersion {
+		return nil, fmt.Errorf("unsupported snapshot version %d, expected %d", manifest.Version, SnapshotVersion)
+	}
+
+	_, stores, err := openSnapshotStores(s, apiProvider)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up storage: %v", err)
+	}
+	defer closeSnapshotStores(stores)
+	if err := checkSnapshotRestorable(ctx, manifest, stores); err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int{}
+	for {
+		header, err := tarReader.Next()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read snapshot: %v", err)
+		}
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+		parts := strings.SplitN(header.Name, "/", 3)
+		if len(parts) != 3 || parts[0] != snapshotResourcesDir || stores[parts[1]] == nil {
+			return nil, fmt.Errorf("invalid snapshot entry %s", header.Name)
+		}
+		if err := restoreSnapshotEntry(ctx, stores[parts[1]], tarReader); err != nil {
+			return nil, fmt.Errorf("failed to restore %s: %v", header.Name, err)
+		}
+		counts[parts[1]]++
+	}
+	for _, resource := range manifest.Resources {
+		klog.Infof("Restored %d %s", counts[resource.Resource], resource.Resource)
+	}
+	return manifest, nil
+}
+
+// checkSnapshotRestorable makes sure every archived resource is stored in the same version
+// by stores and none of them stores an object yet.
+func checkSnapshotRestorable(ctx context.Context, manifest *SnapshotManifest, stores map[string]*snapshotStore) error {
+	for _, resource := range manifest.Resources {
+		store := stores[resource.Resource]
+		if store == nil {
+			return fmt.Errorf("can not restore %s, the resource is not enabled", resource.Resource)
+		}
+		gv, err := schema.ParseGroupVersion(resource.StorageVersion)
+		if err != nil {
+			return fmt.Errorf("invalid storage version of %s: %v", resource.Resource, err)
+		}
+		if gv != store.version {
+			return fmt.Errorf("can not restore %s stored as %s, the storage version is %s", resource.Resource, gv, store.version)
+		}
+		empty := true
+		err = store.Each(ctx, func(obj runtime.Object) error {
+			empty = false
+			return errStopEach
+		})
+		if err != nil && !errors.Is(err, errStopEach) {
+			return err
+		}
+		if !empty {
+			return fmt.Errorf("can not restore %s, the storage is not empty", resource.Resource)
+		}
+	}
+	return nil
+}
+
+// errStopEach stops walking the objects of a store.
+var errStopEach = errors.New("stop")
+
+// restoreSnapshotEntry creates the object read from r in store.
+func restoreSnapshotEntry(ctx context.Context, store *snapshotStore, r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	obj, _, err := store.codec.Decode(data, nil, store.NewFunc())
+	if err != nil {
+		return err
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	accessor.SetResourceVersion("")
+	key, err := resourcestore.Key(store.Prefix, obj)
+	if err != nil {
+		return err
+	}
+	return store.Create(ctx, key, obj, nil, 0)
+}
@@ -0,0 +1,116 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package apiserver
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	cliflag "k8s.io/component-base/cli/flag"
+
+	"github.com/seanchann/apimaster/pkg/apiserver/options"
+)
+
+// NewSnapshotCommand returns the snapshot command of the apiserver, like etcdctl snapshot
+// does for etcd: "snapshot save <file>" writes a consistent archive of every stored object
+// of the configured storage backend, "snapshot restore <file>" writes an archive to a fresh
+// database. The command takes the flags and the --config of the apiserver.
+func NewSnapshotCommand(o *options.APIMasterOptions, apiProvider APIServerProvider) *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "snapshot",
+		Short: "Save or restore a snapshot of the stored objects",
+	}
+	fss := cliflag.NamedFlagSets{}
+	o.AddFlags(&fss)
+	for _, fs := range fss.FlagSets {
+		cmd.PersistentFlags().AddFlagSet(fs)
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "save <file>",
+		Short: "Save every stored object to a snapshot archive",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := completeSnapshotOptions(o); err != nil {
+				return err
+			}
+			// the archive is written next to the file, an incomplete snapshot never replaces it
+			partPath := args[0] + ".part"
+			f, err := os.OpenFile(partPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+			if err != nil {
+				return err
+			}
+			manifest, err := SaveSnapshot(cmd.Context(), o, apiProvider, f)
+			if err == nil {
+				err = f.Sync()
+			}
+			if closeErr := f.Close(); err == nil {
+				err = closeErr
+			}
+			if err == nil {
+				err = os.Rename(partPath, args[0])
+			}
+			if err != nil {
+				os.Remove(partPath)
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Snapshot of %d resources saved at resourceVersion %s to %s\n",
+				len(manifest.Resources), manifest.ResourceVersion, args[0])
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "restore <file>",
+		Short: "Restore a snapshot archive to a fresh database",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := completeSnapshotOptions(o); err != nil {
+				return err
+			}
+			f, err := os.Open(args[0])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			manifest, err := RestoreSnapshot(cmd.Context(), o, apiProvider, f)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Snapshot of %d resources taken at %s restored from %s\n",
+				len(manifest.Resources), manifest.CreationTimestamp.Format("2006-01-02T15:04:05Z"), args[0])
+			return nil
+		},
+	})
+	return cmd
+}
+
+// completeSnapshotOptions applies --config and validates the storage options of o.
+func completeSnapshotOptions(o *options.APIMasterOptions) error {
+	if err := o.ApplyConfigFile(); err != nil {
+		return err
+	}
+	if o.Storage == nil {
+		return fmt.Errorf("not configure any storage backend")
+	}
+	errs := o.Storage.Validate()
+	errs = append(errs, o.StorageRouting.Validate()...)
+	errs = append(errs, o.StorageSerialization.Validate()...)
+	return utilerrors.NewAggregate(errs)
+}
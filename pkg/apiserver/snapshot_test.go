@@ -0,0 +1,348 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package apiserver
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/seanchann/apimaster/pkg/api/legacyscheme"
+	api "github.com/seanchann/apimaster/pkg/apis/coreres"
+	"github.com/seanchann/apimaster/pkg/apiserver/options"
+	"github.com/seanchann/apimaster/pkg/storage/mysql/mysqltest"
+	"github.com/seanchann/apimaster/pkg/storage/postgres/postgrestest"
+	"github.com/seanchann/apimaster/pkg/storage/resourcestore"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/admission"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+)
+
+func listNamespaces(t *testing.T, store *resourcestore.Store) map[string]*api.Namespace {
+	namespaces := map[string]*api.Namespace{}
+	err := store.Each(context.Background(), func(obj runtime.Object) error {
+		ns := obj.(*api.Namespace)
+		namespaces[ns.Name] = ns
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return namespaces
+}
+
+// newStorageAPIMasterOptions returns options with the memory options replaced by storage.
+func newStorageAPIMasterOptions(backend options.StorageBackendType, storage options.StorageBackend) *options.APIMasterOptions {
+	s := newMemoryAPIMasterOptions()
+	s.Backend = backend
+	s.Storage = storage
+	return s
+}
+
+func newMysqlAPIMasterOptions(t *testing.T) *options.APIMasterOptions {
+	mysql := options.NewMysqlOptions(storagebackend.NewDefaultConfig(options.DefaultEtcdPathPrefix, nil))
+	mysql.Store = options.MysqlStoreKV
+	mysql.StorageConfig.Mysql.ServerList = []string{mysqltest.NewTestServer(t)}
+	mysql.Mysql.CompactionInterval = 0
+	return newStorageAPIMasterOptions(options.StorageBackendTypeMysql, mysql)
+}
+
+func newPostgresAPIMasterOptions(t *testing.T) *options.APIMasterOptions {
+	pg := options.NewPostgresOptions(storagebackend.NewDefaultConfig(options.DefaultEtcdPathPrefix, nil))
+	pg.Postgres.ServerList = []string{postgrestest.NewTestServer(t)}
+	pg.Postgres.CompactionInterval = 0
+	return newStorageAPIMasterOptions(options.StorageBackendTypePostgres, pg)
+}
+
+func newSqliteAPIMasterOptions(t *testing.T) *options.APIMasterOptions {
+	sqlite := options.NewSqliteOptions(storagebackend.NewDefaultConfig(options.DefaultEtcdPathPrefix, nil))
+	sqlite.StorageConfig.Sqlite.DSN = filepath.Join(t.TempDir(), "apimaster.db")
+	s := newStorageAPIMasterOptions(options.StorageBackendTypeSqlite, sqlite)
+	// the sqlite store is part of the apiserver, a build without it can't run the test
+	c, err := buildStorageConfig(s, fakeAPIServerProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	store, err := resourcestore.Open(c.RESTOptionsGetter, legacyscheme.Scheme, api.Resource("namespaces"))
+	if err != nil && strings.Contains(err.Error(), "unknown storage type") {
+		t.Skipf("the apiserver has no sqlite store: %v", err)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	store.Destroy()
+	return s
+}
+
+func TestSaveAndRestoreSnapshot(t *testing.T) {
+	testCases := []struct {
+		name       string
+		newOptions func(t *testing.T) *options.APIMasterOptions
+	}{
+		{
+			name:       "memory",
+			newOptions: func(*testing.T) *options.APIMasterOptions { return newMemoryAPIMasterOptions() },
+		},
+		{
+			name:       "mysql",
+			newOptions: newMysqlAPIMasterOptions,
+		},
+		{
+			name:       "postgres",
+			newOptions: newPostgresAPIMasterOptions,
+		},
+		{
+			name:       "sqlite",
+			newOptions: newSqliteAPIMasterOptions,
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			ctx := context.Background()
+			source := testcase.newOptions(t)
+			from := openNamespaces(t, source)
+			createNamespace(t, from, "foo", "uid-foo")
+			createNamespace(t, from, "bar", "uid-bar")
+
+			archive := &bytes.Buffer{}
+			manifest, err := SaveSnapshot(ctx, source, fakeAPIServerProvider{}, archive)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := []SnapshotResource{{Resource: "namespaces.coreres", StorageVersion: "coreres/v1"}}
+			if manifest.Version != SnapshotVersion || !reflect.DeepEqual(manifest.Resources, expected) || manifest.ResourceVersion == "" {
+				t.Fatalf("unexpected manifest %#v", manifest)
+			}
+
+			target := testcase.newOptions(t)
+			to := openNamespaces(t, target)
+			if _, err := RestoreSnapshot(ctx, target, fakeAPIServerProvider{}, bytes.NewReader(archive.Bytes())); err != nil {
+				t.Fatal(err)
+			}
+			original, restored := listNamespaces(t, from), listNamespaces(t, to)
+			if len(restored) != len(original) {
+				t.Fatalf("expected %d namespaces to be restored, got %d", len(original), len(restored))
+			}
+			for name, ns := range original {
+				if !equalIgnoringResourceVersion(ns, restored[name]) {
+					t.Errorf("expected %#v to be restored, got %#v", ns, restored[name])
+				}
+			}
+
+			// a database that is not fresh is refused
+			if _, err := RestoreSnapshot(ctx, target, fakeAPIServerProvider{}, bytes.NewReader(archive.Bytes())); err == nil ||
+				!strings.Contains(err.Error(), "not empty") {
+				t.Errorf("expected not empty error, got %v", err)
+			}
+		})
+	}
+}
+
+// writingWriter calls write before the first write to the archive.
+type writingWriter struct {
+	io.Writer
+	write func()
+}
+
+func (w *writingWriter) Write(p []byte) (int, error) {
+	if w.write != nil {
+		w.write()
+		w.write = nil
+	}
+	return w.Writer.Write(p)
+}
+
+func TestSaveSnapshotWrittenMeanwhile(t *testing.T) {
+	source := newMemoryAPIMasterOptions()
+	from := openNamespaces(t, source)
+	createNamespace(t, from, "foo", "uid-foo")
+
+	// the namespace is written after the resourceVersion of the snapshot was read
+	w := &writingWriter{Writer: io.Discard, write: func() { createNamespace(t, from, "bar", "uid-bar") }}
+	_, err := SaveSnapshot(context.Background(), source, fakeAPIServerProvider{}, w)
+	if err == nil || !strings.Contains(err.Error(), "the storage has been written meanwhile") {
+		t.Errorf("expected the snapshot to be refused, got %v", err)
+	}
+}
+
+func TestRestoreSnapshotRefused(t *testing.T) {
+	ctx := context.Background()
+	source := newMemoryAPIMasterOptions()
+	createNamespace(t, openNamespaces(t, source), "foo", "uid-foo")
+	archive := &bytes.Buffer{}
+	if _, err := SaveSnapshot(ctx, source, fakeAPIServerProvider{}, archive); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name            string
+		archive         func() []byte
+		setup           func(*testing.T, *resourcestore.Store)
+		storageVersions string
+		storeVersions   []string
+		expectError     string
+	}{
+		{
+			name:          "incompatible storage version",
+			archive:       archive.Bytes,
+			storeVersions: []string{"coreres/v2/namespaces"},
+			expectError:   "the storage version is coreres/v2",
+		},
+		{
+			name:            "incompatible group storage version",
+			archive:         archive.Bytes,
+			storageVersions: v1beta1.String(),
+			expectError:     "the storage version is coreres/v1beta1",
+		},
+		{
+			name:        "unsupported archive version",
+			archive:     func() []byte { return rewriteManifest(t, archive.Bytes(), `"version":1`, `"version":2`) },
+			expectError: "unsupported snapshot version 2",
+		},
+		{
+			name:        "not an archive",
+			archive:     func() []byte { return []byte("snapshot") },
+			expectError: "failed to read snapshot",
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			target := newMemoryAPIMasterOptions()
+			if len(testcase.storageVersions) > 0 {
+				target.StorageSerialization.StorageVersions = testcase.storageVersions
+			}
+			target.StorageSerialization.ResourceStorageVersions = testcase.storeVersions
+			to := openNamespaces(t, target)
+			_, err := RestoreSnapshot(ctx, target, fakeAPIServerProvider{}, bytes.NewReader(testcase.archive()))
+			if err == nil || !strings.Contains(err.Error(), testcase.expectError) {
+				t.Fatalf("expected error %q, got %v", testcase.expectError, err)
+			}
+			if err := to.Get(ctx, to.Prefix+"/foo", storage.GetOptions{}, &api.Namespace{}); !storage.IsNotFound(err) {
+				t.Errorf("expected nothing to be restored, got %v", err)
+			}
+		})
+	}
+}
+
+// rewriteManifest replaces old with new in the manifest of archive.
+func rewriteManifest(t *testing.T, archive []byte, old, new string) []byte {
+	gzipReader, err := gzip.NewReader(bytes.NewReader(archive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tarReader := tar.NewReader(gzipReader)
+	out := &bytes.Buffer{}
+	gzipWriter := gzip.NewWriter(out)
+	tarWriter := tar.NewWriter(gzipWriter)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		data, err := io.ReadAll(tarReader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if header.Name == snapshotManifestName {
+			data = []byte(strings.Replace(string(data), old, new, 1))
+			header.Size = int64(len(data))
+		}
+		if err := tarWriter.WriteHeader(header); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tarWriter.Write(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tarWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return out.Bytes()
+}
+
+type fakeAdmissionProvider struct{}
+
+func (fakeAdmissionProvider) RegisterAllAdmissionPlugins(*admission.Plugins) {}
+func (fakeAdmissionProvider) AllPluginOrder() []string                       { return nil }
+func (fakeAdmissionProvider) DefaultOffAdmissionPlugins() sets.String        { return sets.NewString() }
+
+// runSnapshotCommand runs the snapshot command with args on the memory backend of o.
+func runSnapshotCommand(o *options.APIMasterOptions, args ...string) (string, error) {
+	cmd := NewSnapshotCommand(o, fakeAPIServerProvider{})
+	out := &bytes.Buffer{}
+	cmd.SetOut(out)
+	cmd.SetErr(out)
+	cmd.SetArgs(append(args, "--storage-backend=memory"))
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestSnapshotCommand(t *testing.T) {
+	source, err := options.NewAPIMasterOptionsWithBackend(fakeAdmissionProvider{}, options.StorageBackendTypeMemory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := openNamespaces(t, source)
+	createNamespace(t, from, "foo", "uid-foo")
+
+	file := filepath.Join(t.TempDir(), "snapshot.tar.gz")
+	out, err := runSnapshotCommand(source, "save", file)
+	if err != nil {
+		t.Fatalf("save failed: %v: %s", err, out)
+	}
+	if !strings.Contains(out, "saved at resourceVersion") {
+		t.Errorf("unexpected output %q", out)
+	}
+	if _, err := os.Stat(file + ".part"); !os.IsNotExist(err) {
+		t.Errorf("expected the partial snapshot to be renamed, got %v", err)
+	}
+
+	target, err := options.NewAPIMasterOptionsWithBackend(fakeAdmissionProvider{}, options.StorageBackendTypeMemory)
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := openNamespaces(t, target)
+	if out, err := runSnapshotCommand(target, "restore", file); err != nil {
+		t.Fatalf("restore failed: %v: %s", err, out)
+	}
+	if restored := listNamespaces(t, to); restored["foo"] == nil || restored["foo"].UID != "uid-foo" {
+		t.Errorf("expected foo to be restored, got %v", restored)
+	}
+
+	// restoring twice is refused, the database is not fresh anymore
+	if _, err := runSnapshotCommand(target, "restore", file); err == nil || !strings.Contains(err.Error(), "not empty") {
+		t.Errorf("expected not empty error, got %v", err)
+	}
+}
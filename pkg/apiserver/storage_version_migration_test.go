@@ -0,0 +1,134 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package apiserver
+
+import (
+	"context"
+	"encoding/json"
+	"reflect"
+	"testing"
+
+	"github.com/seanchann/apimaster/pkg/api/legacyscheme"
+	apiv1 "github.com/seanchann/apimaster/pkg/apis/coreres/v1"
+	"github.com/seanchann/apimaster/pkg/apiserver/options"
+	"github.com/seanchann/apimaster/pkg/controller/storageversionmigrator"
+	"github.com/seanchann/apimaster/pkg/storage/resourcestore"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/generic"
+)
+
+// v1beta1 is an older version of coreres, the namespaces are stored in it until they are migrated.
+var v1beta1 = schema.GroupVersion{Group: apiv1.GroupName, Version: "v1beta1"}
+
+func init() {
+	legacyscheme.Scheme.AddKnownTypes(v1beta1, &apiv1.Namespace{}, &apiv1.NamespaceList{})
+}
+
+// rawRestOptionsGetter records the apiVersion of every object decoded from the storage.
+type rawRestOptionsGetter struct {
+	delegate    generic.RESTOptionsGetter
+	apiVersions *[]string
+}
+
+func (g *rawRestOptionsGetter) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	ret, err := g.delegate.GetRESTOptions(resource)
+	if err != nil {
+		return ret, err
+	}
+	storageConfig := *ret.StorageConfig
+	storageConfig.Codec = &rawRecordingCodec{Codec: storageConfig.Codec, apiVersions: g.apiVersions}
+	ret.StorageConfig = &storageConfig
+	return ret, nil
+}
+
+type rawRecordingCodec struct {
+	runtime.Codec
+	apiVersions *[]string
+}
+
+func (c *rawRecordingCodec) Decode(data []byte, defaults *schema.GroupVersionKind, into runtime.Object) (runtime.Object, *schema.GroupVersionKind, error) {
+	var meta struct {
+		APIVersion string `json:"apiVersion"`
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return nil, nil, err
+	}
+	*c.apiVersions = append(*c.apiVersions, meta.APIVersion)
+	return c.Codec.Decode(data, defaults, into)
+}
+
+// storedAPIVersions returns the apiVersion every namespace is stored in.
+func storedAPIVersions(t *testing.T, s *options.APIMasterOptions) []string {
+	c, err := buildStorageConfig(s, fakeAPIServerProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var apiVersions []string
+	store, err := resourcestore.Open(&rawRestOptionsGetter{delegate: c.RESTOptionsGetter, apiVersions: &apiVersions},
+		legacyscheme.Scheme, apiv1.Resource("namespaces"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Destroy()
+	if err := store.Each(context.Background(), func(runtime.Object) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	return apiVersions
+}
+
+// migrate runs the storage version migration of s and returns the progress of the namespaces.
+func migrate(t *testing.T, s *options.APIMasterOptions) storageversionmigrator.ResourceState {
+	c, err := buildStorageConfig(s, fakeAPIServerProvider{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	resources := resourcestore.Resources(legacyscheme.Scheme, fakeAPIServerProvider{}.DefaultAPIResourceConfigSource())
+	migrator := storageversionmigrator.NewMigrator(c.RESTOptionsGetter, legacyscheme.Scheme, resources, "")
+	if err := migrator.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return migrator.State().Resources[apiv1.Resource("namespaces").String()]
+}
+
+func TestStorageVersionMigrationWithStorageVersions(t *testing.T) {
+	s := newMemoryAPIMasterOptions()
+	s.StorageSerialization.StorageVersions = v1beta1.String()
+	store := openNamespaces(t, s)
+	createNamespace(t, store, "foo", "uid-foo")
+	createNamespace(t, store, "bar", "uid-bar")
+
+	expected := []string{v1beta1.String(), v1beta1.String()}
+	if apiVersions := storedAPIVersions(t, s); !reflect.DeepEqual(apiVersions, expected) {
+		t.Fatalf("expected the namespaces to be stored in %v, got %v", expected, apiVersions)
+	}
+	if state := migrate(t, s); state.StorageVersion != v1beta1.String() || !state.Completed {
+		t.Errorf("expected the namespaces to be migrated to %v, got %+v", v1beta1, state)
+	}
+
+	// the group is stored in v1 from now on, the migration rewrites the stored namespaces
+	s.StorageSerialization.StorageVersions = apiv1.SchemeGroupVersion.String()
+	expectedState := storageversionmigrator.ResourceState{StorageVersion: apiv1.SchemeGroupVersion.String(), Count: 2, Completed: true}
+	if state := migrate(t, s); !reflect.DeepEqual(state, expectedState) {
+		t.Errorf("expected state %+v, got %+v", expectedState, state)
+	}
+	expected = []string{apiv1.SchemeGroupVersion.String(), apiv1.SchemeGroupVersion.String()}
+	if apiVersions := storedAPIVersions(t, s); !reflect.DeepEqual(apiVersions, expected) {
+		t.Errorf("expected the namespaces to be stored in %v, got %v", expected, apiVersions)
+	}
+}
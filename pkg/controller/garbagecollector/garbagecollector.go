@@ -0,0 +1,466 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package garbagecollector
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/metadatainformer"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/client-go/util/workqueue"
+	"k8s.io/klog/v2"
+)
+
+const (
+	uidIndex      = "uid"
+	ownerUIDIndex = "ownerUID"
+)
+
+// objectReference identifies a single object of a resource.
+type objectReference struct {
+	resource  schema.GroupVersionResource
+	namespace string
+	name      string
+	uid       types.UID
+}
+
+func (r objectReference) String() string {
+	return fmt.Sprintf("%s %s/%s (%s)", r.resource, r.namespace, r.name, r.uid)
+}
+
+// GarbageCollector watches the metadata of every resource and
+//   - deletes objects whose owners no longer exist,
+//   - removes the owner references to an owner deleted with the orphan finalizer,
+//   - deletes the dependents of an owner deleted with the foregroundDeletion finalizer.
+type GarbageCollector struct {
+	client    metadata.Interface
+	resources map[schema.GroupKind]Resource
+	factory   metadatainformer.SharedInformerFactory
+	informers map[schema.GroupVersionResource]cache.SharedIndexInformer
+	queue     workqueue.RateLimitingInterface
+}
+
+// NewGarbageCollector creates a garbage collector for the objects of resources.
+func NewGarbageCollector(client metadata.Interface, resources []Resource, resyncPeriod time.Duration) (*GarbageCollector, error) {
+	gc := &GarbageCollector{
+		client:    client,
+		resources: map[schema.GroupKind]Resource{},
+		factory:   metadatainformer.NewSharedInformerFactory(client, resyncPeriod),
+		informers: map[schema.GroupVersionResource]cache.SharedIndexInformer{},
+		queue:     workqueue.NewNamedRateLimitingQueue(workqueue.DefaultControllerRateLimiter(), "garbage_collector"),
+	}
+	for _, r := range resources {
+		gc.resources[schema.GroupKind{Group: r.Group, Kind: r.Kind}] = r
+
+		informer := gc.factory.ForResource(r.GroupVersionResource).Informer()
+		if err := informer.AddIndexers(cache.Indexers{uidIndex: indexByUID, ownerUIDIndex: indexByOwnerUID}); err != nil {
+			return nil, err
+		}
+		resource := r.GroupVersionResource
+		_, err := informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+			AddFunc: func(obj interface{}) {
+				gc.enqueue(resource, obj)
+			},
+			UpdateFunc: func(_, obj interface{}) {
+				gc.enqueue(resource, obj)
+				if accessor, err := meta.Accessor(obj); err == nil && accessor.GetDeletionTimestamp() != nil {
+					gc.enqueueDependents(accessor.GetUID())
+				}
+			},
+			DeleteFunc: func(obj interface{}) {
+				if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+					obj = tombstone.Obj
+				}
+				accessor, err := meta.Accessor(obj)
+				if err != nil {
+					return
+				}
+				gc.enqueueDependents(accessor.GetUID())
+				// an owner deleted in the foreground waits for its blocking dependents
+				gc.enqueueOwners(accessor)
+			},
+		})
+		if err != nil {
+			return nil, err
+		}
+		gc.informers[resource] = informer
+	}
+	return gc, nil
+}
+
+func indexByUID(obj interface{}) ([]string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	return []string{string(accessor.GetUID())}, nil
+}
+
+func indexByOwnerUID(obj interface{}) ([]string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return nil, err
+	}
+	uids := []string{}
+	for _, ref := range accessor.GetOwnerReferences() {
+		uids = append(uids, string(ref.UID))
+	}
+	return uids, nil
+}
+
+// Run starts workers and blocks until ctx is done.
+func (gc *GarbageCollector) Run(ctx context.Context, workers int) {
+	defer utilruntime.HandleCrash()
+	defer gc.queue.ShutDown()
+	defer gc.factory.Shutdown()
+
+	klog.Infof("Starting garbage collector for %d resources", len(gc.informers))
+	defer klog.Infof("Shutting down garbage collector")
+
+	gc.factory.Start(ctx.Done())
+	for resource, synced := range gc.factory.WaitForCacheSync(ctx.Done()) {
+		if !synced {
+			klog.Errorf("Garbage collector failed to sync the cache of %v", resource)
+			return
+		}
+	}
+
+	for i := 0; i < workers; i++ {
+		go wait.UntilWithContext(ctx, gc.runWorker, time.Second)
+	}
+	<-ctx.Done()
+}
+
+func (gc *GarbageCollector) runWorker(ctx context.Context) {
+	for gc.processNextItem(ctx) {
+	}
+}
+
+func (gc *GarbageCollector) processNextItem(ctx context.Context) bool {
+	item, quit := gc.queue.Get()
+	if quit {
+		return false
+	}
+	defer gc.queue.Done(item)
+
+	ref := item.(objectReference)
+	if err := gc.process(ctx, ref); err != nil {
+		klog.V(2).Infof("Garbage collector failed to process %v, retrying: %v", ref, err)
+		gc.queue.AddRateLimited(item)
+		return true
+	}
+	gc.queue.Forget(item)
+	return true
+}
+
+func (gc *GarbageCollector) enqueue(resource schema.GroupVersionResource, obj interface{}) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		utilruntime.HandleError(err)
+		return
+	}
+	gc.queue.Add(objectReference{
+		resource:  resource,
+		namespace: accessor.GetNamespace(),
+		name:      accessor.GetName(),
+		uid:       accessor.GetUID(),
+	})
+}
+
+func (gc *GarbageCollector) enqueueDependents(uid types.UID) {
+	for resource, informer := range gc.informers {
+		dependents, err := informer.GetIndexer().ByIndex(ownerUIDIndex, string(uid))
+		if err != nil {
+			utilruntime.HandleError(err)
+			continue
+		}
+		for _, dependent := range dependents {
+			gc.enqueue(resource, dependent)
+		}
+	}
+}
+
+func (gc *GarbageCollector) enqueueOwners(dependent metav1.Object) {
+	for _, ref := range dependent.GetOwnerReferences() {
+		r, found := gc.resourceFor(ref)
+		if !found {
+			continue
+		}
+		owner := objectReference{resource: r.GroupVersionResource, name: ref.Name, uid: ref.UID}
+		if r.Namespaced {
+			owner.namespace = dependent.GetNamespace()
+		}
+		gc.queue.Add(owner)
+	}
+}
+
+// process handles the current state of ref.
+func (gc *GarbageCollector) process(ctx context.Context, ref objectReference) error {
+	obj, found := gc.cached(ref.resource, ref.namespace, ref.name)
+	if !found || obj.GetUID() != ref.uid {
+		return nil
+	}
+
+	if obj.GetDeletionTimestamp() != nil {
+		switch {
+		case hasFinalizer(obj, metav1.FinalizerOrphanDependents):
+			return gc.orphanDependents(ctx, ref, obj)
+		case hasFinalizer(obj, metav1.FinalizerDeleteDependents):
+			return gc.deleteDependents(ctx, ref, obj)
+		}
+		return nil
+	}
+	if len(obj.GetOwnerReferences()) == 0 {
+		return nil
+	}
+	return gc.checkOwners(ctx, ref, obj)
+}
+
+// cached returns the object name of resource from the informer cache.
+func (gc *GarbageCollector) cached(resource schema.GroupVersionResource, namespace, name string) (metav1.Object, bool) {
+	informer, found := gc.informers[resource]
+	if !found {
+		return nil, false
+	}
+	key := name
+	if len(namespace) > 0 {
+		key = namespace + "/" + name
+	}
+	item, exists, err := informer.GetIndexer().GetByKey(key)
+	if err != nil || !exists {
+		return nil, false
+	}
+	obj, err := meta.Accessor(item)
+	if err != nil {
+		return nil, false
+	}
+	return obj, true
+}
+
+// dependent is an object that references an owner.
+type dependent struct {
+	objectReference
+	obj metav1.Object
+	ref metav1.OwnerReference
+}
+
+// dependents returns the cached objects that reference uid.
+func (gc *GarbageCollector) dependents(uid types.UID) ([]dependent, error) {
+	var dependents []dependent
+	for resource, informer := range gc.informers {
+		items, err := informer.GetIndexer().ByIndex(ownerUIDIndex, string(uid))
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range items {
+			obj, err := meta.Accessor(item)
+			if err != nil {
+				return nil, err
+			}
+			for _, ref := range obj.GetOwnerReferences() {
+				if ref.UID == uid {
+					dependents = append(dependents, dependent{
+						objectReference: objectReference{resource: resource, namespace: obj.GetNamespace(), name: obj.GetName(), uid: obj.GetUID()},
+						obj:             obj,
+						ref:             ref,
+					})
+					break
+				}
+			}
+		}
+	}
+	return dependents, nil
+}
+
+// orphanDependents removes the owner references to owner from its dependents
+// and then the orphan finalizer of owner.
+func (gc *GarbageCollector) orphanDependents(ctx context.Context, ref objectReference, owner metav1.Object) error {
+	dependents, err := gc.dependents(ref.uid)
+	if err != nil {
+		return err
+	}
+	for _, d := range dependents {
+		if err := gc.removeOwnerReferences(ctx, d.objectReference, d.obj, sets.New(ref.uid)); err != nil {
+			return err
+		}
+	}
+	klog.V(2).Infof("Orphaned %d dependents of %v", len(dependents), ref)
+	return gc.removeFinalizer(ctx, ref, owner, metav1.FinalizerOrphanDependents)
+}
+
+// deleteDependents deletes the dependents of owner, and removes the foregroundDeletion
+// finalizer of owner once no dependent that blocks the deletion of owner is left.
+func (gc *GarbageCollector) deleteDependents(ctx context.Context, ref objectReference, owner metav1.Object) error {
+	dependents, err := gc.dependents(ref.uid)
+	if err != nil {
+		return err
+	}
+	blocking := 0
+	for _, d := range dependents {
+		blocks := d.ref.BlockOwnerDeletion != nil && *d.ref.BlockOwnerDeletion
+		if blocks {
+			blocking++
+		}
+		if d.obj.GetDeletionTimestamp() != nil {
+			continue
+		}
+		// a blocking dependent goes away only after its own dependents
+		policy := metav1.DeletePropagationBackground
+		if blocks {
+			policy = metav1.DeletePropagationForeground
+		}
+		if err := gc.delete(ctx, d.objectReference, &policy); err != nil {
+			return err
+		}
+	}
+	if blocking > 0 {
+		klog.V(4).Infof("Waiting for %d dependents of %v to be deleted", blocking, ref)
+		return nil
+	}
+	return gc.removeFinalizer(ctx, ref, owner, metav1.FinalizerDeleteDependents)
+}
+
+// checkOwners deletes obj if none of its owners exists anymore, and otherwise
+// removes the references to the owners that no longer exist.
+func (gc *GarbageCollector) checkOwners(ctx context.Context, ref objectReference, obj metav1.Object) error {
+	dangling := sets.New[types.UID]()
+	existing := 0
+	for _, ownerRef := range obj.GetOwnerReferences() {
+		exists, err := gc.ownerExists(ctx, obj, ownerRef)
+		if err != nil {
+			return err
+		}
+		if exists {
+			existing++
+		} else {
+			dangling.Insert(ownerRef.UID)
+		}
+	}
+
+	switch {
+	case dangling.Len() == 0:
+		return nil
+	case existing > 0:
+		return gc.removeOwnerReferences(ctx, ref, obj, dangling)
+	}
+	klog.V(2).Infof("Deleting %v, all of its owners are gone", ref)
+	return gc.delete(ctx, ref, nil)
+}
+
+// ownerExists checks the cache first and confirms a missing owner with the server,
+// an owner of a resource that is not collected is assumed to exist.
+func (gc *GarbageCollector) ownerExists(ctx context.Context, obj metav1.Object, ownerRef metav1.OwnerReference) (bool, error) {
+	r, found := gc.resourceFor(ownerRef)
+	if !found {
+		klog.V(4).Infof("Unable to resolve owner %s %s of %s/%s", ownerRef.APIVersion, ownerRef.Kind, obj.GetNamespace(), obj.GetName())
+		return true, nil
+	}
+	namespace := ""
+	if r.Namespaced {
+		// a cluster scoped object can not be owned by a namespaced one
+		if len(obj.GetNamespace()) == 0 {
+			return true, nil
+		}
+		namespace = obj.GetNamespace()
+	}
+	if owner, found := gc.cached(r.GroupVersionResource, namespace, ownerRef.Name); found && owner.GetUID() == ownerRef.UID {
+		return true, nil
+	}
+
+	owner, err := gc.client.Resource(r.GroupVersionResource).Namespace(namespace).Get(ctx, ownerRef.Name, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		return false, nil
+	case err != nil:
+		return false, err
+	}
+	return owner.GetUID() == ownerRef.UID, nil
+}
+
+func (gc *GarbageCollector) delete(ctx context.Context, ref objectReference, policy *metav1.DeletionPropagation) error {
+	uid := ref.uid
+	err := gc.client.Resource(ref.resource).Namespace(ref.namespace).Delete(ctx, ref.name, metav1.DeleteOptions{
+		Preconditions:     &metav1.Preconditions{UID: &uid},
+		PropagationPolicy: policy,
+	})
+	if apierrors.IsNotFound(err) || apierrors.IsConflict(err) {
+		// deleted or recreated in the meantime
+		return nil
+	}
+	return err
+}
+
+// patch sets field of the metadata of obj with a merge patch, a nil value removes the
+// field. The uid and resourceVersion make the patch fail if obj changed in the meantime.
+func (gc *GarbageCollector) patch(ctx context.Context, ref objectReference, obj metav1.Object, field string, value interface{}) error {
+	data, err := json.Marshal(map[string]interface{}{
+		"metadata": map[string]interface{}{
+			"resourceVersion": obj.GetResourceVersion(),
+			"uid":             obj.GetUID(),
+			field:             value,
+		},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = gc.client.Resource(ref.resource).Namespace(ref.namespace).Patch(ctx, ref.name, types.MergePatchType, data, metav1.PatchOptions{})
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
+}
+
+func (gc *GarbageCollector) removeOwnerReferences(ctx context.Context, ref objectReference, obj metav1.Object, uids sets.Set[types.UID]) error {
+	var refs []metav1.OwnerReference
+	for _, ownerRef := range obj.GetOwnerReferences() {
+		if !uids.Has(ownerRef.UID) {
+			refs = append(refs, ownerRef)
+		}
+	}
+	return gc.patch(ctx, ref, obj, "ownerReferences", refs)
+}
+
+func (gc *GarbageCollector) removeFinalizer(ctx context.Context, ref objectReference, obj metav1.Object, finalizer string) error {
+	var finalizers []string
+	for _, f := range obj.GetFinalizers() {
+		if f != finalizer {
+			finalizers = append(finalizers, f)
+		}
+	}
+	return gc.patch(ctx, ref, obj, "finalizers", finalizers)
+}
+
+func hasFinalizer(obj metav1.Object, finalizer string) bool {
+	for _, f := range obj.GetFinalizers() {
+		if f == finalizer {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package garbagecollector
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/metadata"
+	"k8s.io/client-go/metadata/fake"
+)
+
+var (
+	widgets = Resource{GroupVersionResource: schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "widgets"}, Kind: "Widget", Namespaced: true}
+	gadgets = Resource{GroupVersionResource: schema.GroupVersionResource{Group: "example.com", Version: "v1", Resource: "gadgets"}, Kind: "Gadget", Namespaced: true}
+)
+
+func newObject(r Resource, name string, uid types.UID, finalizers []string, owners ...metav1.OwnerReference) *metav1.PartialObjectMetadata {
+	obj := &metav1.PartialObjectMetadata{
+		TypeMeta: metav1.TypeMeta{APIVersion: r.GroupVersion().String(), Kind: r.Kind},
+		ObjectMeta: metav1.ObjectMeta{
+			Namespace:       "default",
+			Name:            name,
+			UID:             uid,
+			Finalizers:      finalizers,
+			OwnerReferences: owners,
+		},
+	}
+	if len(finalizers) > 0 {
+		now := metav1.Now()
+		obj.DeletionTimestamp = &now
+	}
+	return obj
+}
+
+func ownerReference(name string, uid types.UID, blockOwnerDeletion bool) metav1.OwnerReference {
+	return metav1.OwnerReference{APIVersion: "example.com/v1", Kind: "Widget", Name: name, UID: uid, BlockOwnerDeletion: &blockOwnerDeletion}
+}
+
+func TestGarbageCollector(t *testing.T) {
+	testCases := []struct {
+		name    string
+		objects []runtime.Object
+		// action runs once the garbage collector started
+		action func(ctx context.Context, client metadata.Interface) error
+		// done reports whether the garbage collector finished its work
+		done func(ctx context.Context, client metadata.Interface) (bool, error)
+	}{
+		{
+			name: "background deletion of the owner",
+			objects: []runtime.Object{
+				newObject(widgets, "parent", "p1", nil),
+				newObject(gadgets, "child", "c1", nil, ownerReference("parent", "p1", false)),
+			},
+			action: func(ctx context.Context, client metadata.Interface) error {
+				return client.Resource(widgets.GroupVersionResource).Namespace("default").Delete(ctx, "parent", metav1.DeleteOptions{})
+			},
+			done: func(ctx context.Context, client metadata.Interface) (bool, error) {
+				return isDeleted(ctx, client, gadgets, "child")
+			},
+		},
+		{
+			name: "dangling owner reference",
+			objects: []runtime.Object{
+				newObject(widgets, "parent", "p1", nil),
+				newObject(gadgets, "child", "c1", nil, ownerReference("parent", "p1", false), ownerReference("gone", "p2", false)),
+			},
+			done: func(ctx context.Context, client metadata.Interface) (bool, error) {
+				child, err := client.Resource(gadgets.GroupVersionResource).Namespace("default").Get(ctx, "child", metav1.GetOptions{})
+				if err != nil {
+					return false, err
+				}
+				return len(child.OwnerReferences) == 1 && child.OwnerReferences[0].UID == "p1", nil
+			},
+		},
+		{
+			name: "orphan deletion of the owner",
+			objects: []runtime.Object{
+				newObject(widgets, "parent", "p1", []string{metav1.FinalizerOrphanDependents}),
+				newObject(gadgets, "child", "c1", nil, ownerReference("parent", "p1", false)),
+			},
+			done: func(ctx context.Context, client metadata.Interface) (bool, error) {
+				child, err := client.Resource(gadgets.GroupVersionResource).Namespace("default").Get(ctx, "child", metav1.GetOptions{})
+				if err != nil {
+					return false, err
+				}
+				parent, err := client.Resource(widgets.GroupVersionResource).Namespace("default").Get(ctx, "parent", metav1.GetOptions{})
+				if err != nil {
+					return false, err
+				}
+				return len(child.OwnerReferences) == 0 && len(parent.Finalizers) == 0, nil
+			},
+		},
+		{
+			name: "foreground deletion of the owner",
+			objects: []runtime.Object{
+				newObject(widgets, "parent", "p1", []string{metav1.FinalizerDeleteDependents}),
+				newObject(gadgets, "child", "c1", nil, ownerReference("parent", "p1", true)),
+			},
+			done: func(ctx context.Context, client metadata.Interface) (bool, error) {
+				deleted, err := isDeleted(ctx, client, gadgets, "child")
+				if err != nil || !deleted {
+					return false, err
+				}
+				parent, err := client.Resource(widgets.GroupVersionResource).Namespace("default").Get(ctx, "parent", metav1.GetOptions{})
+				if err != nil {
+					return false, err
+				}
+				return len(parent.Finalizers) == 0, nil
+			},
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			scheme := fake.NewTestScheme()
+			metav1.AddMetaToScheme(scheme)
+			client := fake.NewSimpleMetadataClient(scheme, testcase.objects...)
+
+			gc, err := NewGarbageCollector(client, []Resource{widgets, gadgets}, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			go gc.Run(ctx, 1)
+
+			if testcase.action != nil {
+				if err := testcase.action(ctx, client); err != nil {
+					t.Fatal(err)
+				}
+			}
+			err = wait.PollUntilContextTimeout(ctx, 10*time.Millisecond, wait.ForeverTestTimeout, true, func(ctx context.Context) (bool, error) {
+				return testcase.done(ctx, client)
+			})
+			if err != nil {
+				t.Errorf("garbage collector did not finish: %v", err)
+			}
+		})
+	}
+}
+
+func isDeleted(ctx context.Context, client metadata.Interface, r Resource, name string) (bool, error) {
+	_, err := client.Resource(r.GroupVersionResource).Namespace("default").Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		return true, nil
+	}
+	return false, err
+}
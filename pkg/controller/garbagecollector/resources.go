@@ -0,0 +1,76 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package garbagecollector
+
+import (
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/klog/v2"
+)
+
+// Resource is a resource whose objects can be owners or dependents.
+type Resource struct {
+	schema.GroupVersionResource
+	Kind       string
+	Namespaced bool
+}
+
+// DeletableResources returns the preferred version of every resource of the server
+// that supports list, watch and delete.
+func DeletableResources(client discovery.DiscoveryInterface) ([]Resource, error) {
+	lists, err := client.ServerPreferredResources()
+	if err != nil {
+		if len(lists) == 0 {
+			return nil, err
+		}
+		klog.Warningf("Failed to discover some resources for garbage collection: %v", err)
+	}
+
+	resources := []Resource{}
+	lists = discovery.FilteredBy(discovery.SupportsAllVerbs{Verbs: []string{"delete", "list", "watch"}}, lists)
+	for _, list := range lists {
+		gv, err := schema.ParseGroupVersion(list.GroupVersion)
+		if err != nil {
+			return nil, err
+		}
+		for _, r := range list.APIResources {
+			// subresources are not objects of their own
+			if strings.Contains(r.Name, "/") {
+				continue
+			}
+			resources = append(resources, Resource{
+				GroupVersionResource: gv.WithResource(r.Name),
+				Kind:                 r.Kind,
+				Namespaced:           r.Namespaced,
+			})
+		}
+	}
+	return resources, nil
+}
+
+// resourceFor returns the resource of the owner reference ref.
+func (gc *GarbageCollector) resourceFor(ref metav1.OwnerReference) (Resource, bool) {
+	gv, err := schema.ParseGroupVersion(ref.APIVersion)
+	if err != nil {
+		return Resource{}, false
+	}
+	r, found := gc.resources[gv.WithKind(ref.Kind).GroupKind()]
+	return r, found
+}
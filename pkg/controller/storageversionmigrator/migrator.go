@@ -0,0 +1,212 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package storageversionmigrator
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/klog/v2"
+
+	"github.com/seanchann/apimaster/pkg/storage/resourcestore"
+)
+
+// State is the progress of the migration, it is kept in the state file to resume
+// the migration after a restart.
+type State struct {
+	Resources map[string]ResourceState `json:"resources"`
+}
+
+// ResourceState is the progress of the migration of one resource.
+type ResourceState struct {
+	// StorageVersion is the version the objects are rewritten in.
+	StorageVersion string `json:"storageVersion"`
+	// Continue is the list continue token of the next page to rewrite.
+	Continue string `json:"continue,omitempty"`
+	// Completed is set once every object has been rewritten in StorageVersion.
+	Completed bool `json:"completed,omitempty"`
+	// Count is the number of objects visited so far.
+	Count int `json:"count"`
+}
+
+// Migrator rewrites the stored objects of resources in their current storage version.
+// The storage skips objects that are already stored as it would write them, so only
+// objects stored in another version or encoding are replaced.
+type Migrator struct {
+	getter    generic.RESTOptionsGetter
+	scheme    *runtime.Scheme
+	resources []schema.GroupResource
+	// stateFile keeps the progress, the progress is lost on restart if it is empty.
+	stateFile string
+	state     State
+}
+
+// NewMigrator creates a migrator of resources, whose types are registered in scheme.
+func NewMigrator(getter generic.RESTOptionsGetter, scheme *runtime.Scheme, resources []schema.GroupResource, stateFile string) *Migrator {
+	return &Migrator{
+		getter:    getter,
+		scheme:    scheme,
+		resources: resources,
+		stateFile: stateFile,
+		state:     State{Resources: map[string]ResourceState{}},
+	}
+}
+
+// Run migrates every resource that has not been migrated to its current storage
+// version yet. A resource that fails does not stop the migration of the others.
+func (m *Migrator) Run(ctx context.Context) error {
+	if err := m.loadState(); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, gr := range m.resources {
+		if err := m.migrate(ctx, gr); err != nil {
+			errs = append(errs, fmt.Errorf("failed to migrate %v: %v", gr, err))
+		}
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// State returns the progress of the migration.
+func (m *Migrator) State() State {
+	state := State{Resources: make(map[string]ResourceState, len(m.state.Resources))}
+	for resource, rs := range m.state.Resources {
+		state.Resources[resource] = rs
+	}
+	return state
+}
+
+// migrate rewrites the objects of gr, resuming from the recorded progress if the
+// storage version did not change in the meantime.
+func (m *Migrator) migrate(ctx context.Context, gr schema.GroupResource) error {
+	store, err := resourcestore.Open(m.getter, m.scheme, gr)
+	if err != nil {
+		return err
+	}
+	defer store.Destroy()
+
+	version, err := m.storageVersion(gr, store.Kind)
+	if err != nil {
+		return err
+	}
+	rs := m.state.Resources[gr.String()]
+	if rs.StorageVersion != version {
+		rs = ResourceState{StorageVersion: version}
+	}
+	if rs.Completed {
+		klog.V(2).Infof("Skipping %v, it has been migrated to %s already", gr, version)
+		return nil
+	}
+
+	for {
+		start := rs.Count
+		_, err = store.RewriteFrom(ctx, rs.Continue, func(continueKey string, count int) error {
+			rs.Continue = continueKey
+			rs.Count = start + count
+			rs.Completed = continueKey == ""
+			m.state.Resources[gr.String()] = rs
+			return m.saveState()
+		})
+		// the recorded page is gone, e.g. compacted, start over
+		if rs.Continue != "" && (apierrors.IsResourceExpired(err) || apierrors.IsGone(err)) {
+			klog.Infof("Restarting the migration of %v: %v", gr, err)
+			rs = ResourceState{StorageVersion: version}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		klog.Infof("Migrated %d %v to %s", rs.Count, gr, version)
+		return nil
+	}
+}
+
+// storageVersion returns the group version that objects of kind are stored in.
+func (m *Migrator) storageVersion(gr schema.GroupResource, kind schema.GroupVersionKind) (string, error) {
+	opts, err := m.getter.GetRESTOptions(gr)
+	if err != nil {
+		return "", err
+	}
+	if opts.StorageConfig == nil || opts.StorageConfig.EncodeVersioner == nil {
+		return "", fmt.Errorf("no storage version configured for %v", gr)
+	}
+	target, ok := opts.StorageConfig.EncodeVersioner.KindForGroupVersionKinds([]schema.GroupVersionKind{kind})
+	if !ok {
+		return "", fmt.Errorf("no storage version of %v found", kind)
+	}
+	return target.GroupVersion().String(), nil
+}
+
+// loadState reads the state file, a missing file means nothing has been migrated yet.
+func (m *Migrator) loadState() error {
+	if len(m.stateFile) == 0 {
+		return nil
+	}
+	data, err := os.ReadFile(m.stateFile)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	state := State{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("invalid state file %s: %v", m.stateFile, err)
+	}
+	if state.Resources == nil {
+		state.Resources = map[string]ResourceState{}
+	}
+	m.state = state
+	return nil
+}
+
+// saveState replaces the state file, so a crash never leaves a partial file behind.
+func (m *Migrator) saveState() error {
+	if len(m.stateFile) == 0 {
+		return nil
+	}
+	data, err := json.MarshalIndent(m.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(m.stateFile), filepath.Base(m.stateFile)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), m.stateFile)
+}
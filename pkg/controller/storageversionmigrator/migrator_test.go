@@ -0,0 +1,214 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package storageversionmigrator
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/registry/generic"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend"
+	"k8s.io/apiserver/pkg/storage/storagebackend/factory"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/seanchann/apimaster/pkg/api/legacyscheme"
+	api "github.com/seanchann/apimaster/pkg/apis/coreres"
+	_ "github.com/seanchann/apimaster/pkg/apis/coreres/install"
+	apiv1 "github.com/seanchann/apimaster/pkg/apis/coreres/v1"
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+	"github.com/seanchann/apimaster/pkg/storage/memory"
+)
+
+var namespaces = api.Resource("namespaces")
+
+// memoryRestOptionsGetter serves every resource from one memory backend, stored in v1.
+type memoryRestOptionsGetter struct {
+	backend *memory.Backend
+}
+
+func (g *memoryRestOptionsGetter) GetRESTOptions(resource schema.GroupResource) (generic.RESTOptions, error) {
+	config := storagebackend.NewDefaultConfig("", legacyscheme.Codecs.LegacyCodec(apiv1.SchemeGroupVersion))
+	config.EncodeVersioner = apiv1.SchemeGroupVersion
+	return generic.RESTOptions{
+		StorageConfig:  config.ForResource(resource),
+		ResourcePrefix: resource.Resource,
+		Decorator: func(config *storagebackend.ConfigForResource, resourcePrefix string,
+			keyFunc func(obj runtime.Object) (string, error),
+			newFunc func() runtime.Object,
+			newListFunc func() runtime.Object,
+			getAttrsFunc storage.AttrFunc,
+			trigger storage.IndexerFuncs,
+			indexers *cache.Indexers) (storage.Interface, factory.DestroyFunc, error) {
+			return kvstore.New(g.backend, config.Codec, newFunc, newListFunc, config.Prefix, nil, getAttrsFunc), func() {}, nil
+		},
+	}, nil
+}
+
+// newBackend returns a backend with the namespaces a and c stored in the current
+// encoding and b stored in an outdated one.
+func newBackend(t *testing.T) *memory.Backend {
+	backend := memory.NewBackend(memory.DefaultHistorySize)
+	store := kvstore.New(backend, legacyscheme.Codecs.LegacyCodec(apiv1.SchemeGroupVersion),
+		func() runtime.Object { return &api.Namespace{} }, func() runtime.Object { return &api.NamespaceList{} }, "", nil, nil)
+	for _, name := range []string{"a", "c"} {
+		ns := &api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		if err := store.Create(context.Background(), "/namespaces/"+name, ns, nil, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	stale := []byte(`{"apiVersion":"coreres/v1","kind":"Namespace","metadata":{"name":"b"}}`)
+	if _, ok, err := backend.Commit(context.Background(), "/namespaces/b", 0, stale); err != nil || !ok {
+		t.Fatalf("failed to store b: %v", err)
+	}
+	return backend
+}
+
+// revisions returns the revision of every stored namespace.
+func revisions(t *testing.T, backend *memory.Backend) map[string]uint64 {
+	kvs, _, _, err := backend.List(context.Background(), "/namespaces/", "", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	revs := map[string]uint64{}
+	for _, kv := range kvs {
+		revs[kv.Key] = kv.Revision
+	}
+	return revs
+}
+
+func writeState(t *testing.T, file string, state State) {
+	data, err := json.Marshal(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readState(t *testing.T, file string) State {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	state := State{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		t.Fatal(err)
+	}
+	return state
+}
+
+func TestMigratorRun(t *testing.T) {
+	completed := ResourceState{StorageVersion: "coreres/v1", Completed: true, Count: 3}
+	continueAfterA, err := storage.EncodeContinue("/namespaces/a\x00", "/namespaces/", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name            string
+		state           *State
+		expectRewritten []string
+		expectCount     int
+	}{
+		{
+			name:            "first run",
+			expectRewritten: []string{"/namespaces/b"},
+			expectCount:     3,
+		},
+		{
+			name:  "completed",
+			state: &State{Resources: map[string]ResourceState{namespaces.String(): completed}},
+		},
+		{
+			name: "storage version changed",
+			state: &State{Resources: map[string]ResourceState{
+				namespaces.String(): {StorageVersion: "coreres/v1beta1", Completed: true, Count: 3},
+			}},
+			expectRewritten: []string{"/namespaces/b"},
+			expectCount:     3,
+		},
+		{
+			name: "resume",
+			state: &State{Resources: map[string]ResourceState{
+				namespaces.String(): {StorageVersion: "coreres/v1", Continue: continueAfterA, Count: 1},
+			}},
+			expectRewritten: []string{"/namespaces/b"},
+			expectCount:     3,
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			backend := newBackend(t)
+			before := revisions(t, backend)
+
+			stateFile := filepath.Join(t.TempDir(), "state.json")
+			if testcase.state != nil {
+				writeState(t, stateFile, *testcase.state)
+			}
+			m := NewMigrator(&memoryRestOptionsGetter{backend: backend}, legacyscheme.Scheme,
+				[]schema.GroupResource{namespaces}, stateFile)
+			if err := m.Run(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+
+			var rewritten []string
+			for key, rev := range revisions(t, backend) {
+				if rev != before[key] {
+					rewritten = append(rewritten, key)
+				}
+			}
+			if !reflect.DeepEqual(rewritten, testcase.expectRewritten) {
+				t.Errorf("expected rewritten %v, got %v", testcase.expectRewritten, rewritten)
+			}
+
+			expected := completed
+			if testcase.expectCount != 0 {
+				expected.Count = testcase.expectCount
+			}
+			if rs := readState(t, stateFile).Resources[namespaces.String()]; !reflect.DeepEqual(rs, expected) {
+				t.Errorf("expected state %#v, got %#v", expected, rs)
+			}
+			if !reflect.DeepEqual(m.State().Resources[namespaces.String()], expected) {
+				t.Errorf("expected state %#v, got %#v", expected, m.State().Resources[namespaces.String()])
+			}
+		})
+	}
+}
+
+func TestMigratorRunWithoutStateFile(t *testing.T) {
+	backend := newBackend(t)
+	m := NewMigrator(&memoryRestOptionsGetter{backend: backend}, legacyscheme.Scheme,
+		[]schema.GroupResource{namespaces, api.Resource("unknowns")}, "")
+	err := m.Run(context.Background())
+	if err == nil {
+		t.Fatal("expected an error for the unknown resource")
+	}
+	expected := ResourceState{StorageVersion: "coreres/v1", Completed: true, Count: 3}
+	if rs := m.State().Resources[namespaces.String()]; !reflect.DeepEqual(rs, expected) {
+		t.Errorf("expected state %#v, got %#v", expected, rs)
+	}
+}
@@ -0,0 +1,247 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apiserver/pkg/storage"
+)
+
+// provisionalRevisionBase is added to the revisions of the staged writes, which are
+// replaced once the batch is committed. It keeps them apart from the real revisions.
+const provisionalRevisionBase = 1 << 62
+
+// ErrBatchUnsupported is returned by the writes in a batch to a storage that can not
+// take part in one.
+var ErrBatchUnsupported = errors.New("the storage does not support batches")
+
+// NewBatchUnsupportedError returns a 501 Not Implemented error, the batch is refused
+// for a reason of the storages, not of its operations.
+func NewBatchUnsupportedError(message string) error {
+	return &apierrors.StatusError{ErrStatus: metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusNotImplemented,
+		Message: message,
+	}}
+}
+
+type batchKey struct{}
+
+// WithBatch returns a copy of ctx, in which the writes of the stores are staged in b.
+func WithBatch(ctx context.Context, b *Batch) context.Context {
+	return context.WithValue(ctx, batchKey{}, b)
+}
+
+// BatchFrom returns the batch of ctx, or nil.
+func BatchFrom(ctx context.Context) *Batch {
+	b, _ := ctx.Value(batchKey{}).(*Batch)
+	return b
+}
+
+// Batch collects the writes of several requests, which are committed together in one
+// transaction of a TxnKV. The writes made with a context holding a Batch are staged
+// and seen by the reads of single keys in that context, lists and watches don't see
+// them until the batch is committed.
+type Batch struct {
+	lock sync.Mutex
+	// kv is the KV of the first staged write, every write of the batch must go to it.
+	kv        TxnKV
+	ops       []*stagedOp
+	keys      map[string]*stagedOp
+	revisions map[uint64]*stagedOp
+	nextRev   uint64
+	committed bool
+}
+
+// stagedOp is the latest write of a key in a batch.
+type stagedOp struct {
+	Op
+	// rev is the provisional revision of the write.
+	rev uint64
+	// committedRev is the revision the write was committed at.
+	committedRev uint64
+}
+
+// NewBatch returns an empty batch.
+func NewBatch() *Batch {
+	return &Batch{
+		keys:      map[string]*stagedOp{},
+		revisions: map[uint64]*stagedOp{},
+		nextRev:   provisionalRevisionBase,
+	}
+}
+
+// get returns the staged value of key in kv, and false if the key has not been written
+// in the batch. A nil KeyValue means the key has been deleted.
+func (b *Batch) get(kv KV, key string) (*KeyValue, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	op, staged := b.keys[key]
+	if !staged || KV(b.kv) != kv {
+		return nil, false
+	}
+	if op.Value == nil {
+		return nil, true
+	}
+	return &KeyValue{Key: key, Value: op.Value, Revision: op.rev}, true
+}
+
+// stage records a write of key to kv, which is at expectedRev, and returns its provisional
+// revision. It returns false if key is no longer at expectedRev in the batch.
+func (b *Batch) stage(kv KV, key string, expectedRev uint64, value []byte, attrs *Attributes) (uint64, bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.committed {
+		return 0, false, errors.New("the batch has already been committed")
+	}
+	txn, ok := kv.(TxnKV)
+	switch {
+	case !ok:
+		return 0, false, NewBatchUnsupportedError(ErrBatchUnsupported.Error())
+	case b.kv == nil:
+		b.kv = txn
+	case b.kv != txn:
+		return 0, false, NewBatchUnsupportedError("the batch spans more than one storage")
+	}
+
+	op, staged := b.keys[key]
+	if staged {
+		current := op.rev
+		if op.Value == nil {
+			current = 0
+		}
+		if current != expectedRev {
+			return 0, false, nil
+		}
+	} else {
+		op = &stagedOp{Op: Op{Key: key, ExpectedRev: expectedRev}}
+		b.keys[key] = op
+		b.ops = append(b.ops, op)
+	}
+	b.nextRev++
+	op.Value, op.Attrs, op.rev = value, attrs, b.nextRev
+	b.revisions[op.rev] = op
+	return op.rev, true, nil
+}
+
+// Len returns the number of keys written in the batch.
+func (b *Batch) Len() int {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return len(b.ops)
+}
+
+// Commit writes the staged writes in one transaction. It returns a conflict error and
+// writes nothing if a key has been modified since the batch read it.
+func (b *Batch) Commit(ctx context.Context) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	if b.committed {
+		return errors.New("the batch has already been committed")
+	}
+	b.committed = true
+
+	ops := make([]Op, 0, len(b.ops))
+	committed := make([]*stagedOp, 0, len(b.ops))
+	for _, op := range b.ops {
+		// a key created and deleted again in the batch is not written at all
+		if op.ExpectedRev == 0 && op.Value == nil {
+			continue
+		}
+		ops = append(ops, op.Op)
+		committed = append(committed, op)
+	}
+	if len(ops) == 0 {
+		return nil
+	}
+
+	rev, ok, err := b.kv.CommitTxn(ctx, ops)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return apierrors.NewConflict(schema.GroupResource{}, "",
+			fmt.Errorf("the objects of the batch have been modified concurrently, read them and try again"))
+	}
+	for i, op := range committed {
+		op.committedRev = rev - uint64(len(committed)-1-i)
+	}
+	return nil
+}
+
+// Revision returns the committed revision of the write with the provisional revision rev,
+// and false if rev is not the revision of a committed write of the batch.
+func (b *Batch) Revision(rev uint64) (uint64, bool) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	op, found := b.revisions[rev]
+	if !found || op.committedRev == 0 {
+		return 0, false
+	}
+	return op.committedRev, true
+}
+
+// RejectBatches wraps s, which can not take part in a batch, so that its writes in a
+// batch fail with a 501 Not Implemented error instead of being committed on their own.
+func RejectBatches(s storage.Interface) storage.Interface {
+	return &batchRejectingStore{Interface: s}
+}
+
+type batchRejectingStore struct {
+	storage.Interface
+}
+
+// Create implements storage.Interface.Create.
+func (s *batchRejectingStore) Create(ctx context.Context, key string, obj, out runtime.Object, ttl uint64) error {
+	if BatchFrom(ctx) != nil {
+		return NewBatchUnsupportedError(ErrBatchUnsupported.Error())
+	}
+	return s.Interface.Create(ctx, key, obj, out, ttl)
+}
+
+// Delete implements storage.Interface.Delete.
+func (s *batchRejectingStore) Delete(
+	ctx context.Context, key string, out runtime.Object, preconditions *storage.Preconditions,
+	validateDeletion storage.ValidateObjectFunc, cachedExistingObject runtime.Object) error {
+	if BatchFrom(ctx) != nil {
+		return NewBatchUnsupportedError(ErrBatchUnsupported.Error())
+	}
+	return s.Interface.Delete(ctx, key, out, preconditions, validateDeletion, cachedExistingObject)
+}
+
+// GuaranteedUpdate implements storage.Interface.GuaranteedUpdate.
+func (s *batchRejectingStore) GuaranteedUpdate(
+	ctx context.Context, key string, destination runtime.Object, ignoreNotFound bool,
+	preconditions *storage.Preconditions, tryUpdate storage.UpdateFunc, cachedExistingObject runtime.Object) error {
+	if BatchFrom(ctx) != nil {
+		return NewBatchUnsupportedError(ErrBatchUnsupported.Error())
+	}
+	return s.Interface.GuaranteedUpdate(ctx, key, destination, ignoreNotFound, preconditions, tryUpdate, cachedExistingObject)
+}
@@ -0,0 +1,106 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/klog/v2"
+
+	"github.com/seanchann/apimaster/pkg/storage/storagemetrics"
+)
+
+// RevisionHistory keeps the revisions a KV had during the last Window.
+type RevisionHistory struct {
+	Window  time.Duration
+	samples []revisionSample
+}
+
+// revisionSample is the revision of a KV at a point in time.
+type revisionSample struct {
+	time     time.Time
+	revision uint64
+}
+
+// Add records revision at now and returns the revision the KV had Window ago, and whether
+// the history covers Window. Until it does, the oldest recorded revision is returned.
+func (h *RevisionHistory) Add(now time.Time, revision uint64) (uint64, bool) {
+	h.samples = append(h.samples, revisionSample{time: now, revision: revision})
+	// keep the latest sample that is at least Window old
+	cutoff := now.Add(-h.Window)
+	for len(h.samples) > 1 && !h.samples[1].time.After(cutoff) {
+		h.samples = h.samples[1:]
+	}
+	return h.samples[0].revision, !h.samples[0].time.After(cutoff)
+}
+
+// Compactor is implemented by a KV that keeps a history of events to resume watches from.
+type Compactor interface {
+	// CurrentRevision returns the revision of the last write.
+	CurrentRevision(ctx context.Context) (uint64, error)
+	// Compact removes the events up to rev and returns how many were removed. Watches
+	// from a revision older than rev fail with 410 Gone afterwards.
+	Compact(ctx context.Context, rev uint64) (int64, error)
+}
+
+// RunCompactor compacts c every interval to the revision it had retention ago, until ctx
+// is done. The compactions are reported in the storage metrics labeled with name.
+func RunCompactor(ctx context.Context, name string, c Compactor, interval, retention time.Duration) {
+	history := &RevisionHistory{Window: retention}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		rev, err := c.CurrentRevision(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				klog.Warningf("Failed to read the revision of %s to compact: %v", name, err)
+			}
+			continue
+		}
+		// nothing is compacted until the history covers the retention, e.g. after a restart
+		compactRev, covered := history.Add(time.Now(), rev)
+		if !covered {
+			continue
+		}
+
+		startTime := time.Now()
+		deleted, err := c.Compact(ctx, compactRev)
+		storagemetrics.RecordCompaction(name, startTime, compactRev, deleted, err)
+		if err != nil {
+			if ctx.Err() == nil {
+				klog.Warningf("Failed to compact %s to revision %d: %v", name, compactRev, err)
+			}
+			continue
+		}
+		klog.V(2).Infof("Compacted %s to revision %d, removed %d events", name, compactRev, deleted)
+	}
+}
+
+// NewCompactedError returns the error of a watch from rev, whose events up to
+// compactedRev have been removed.
+func NewCompactedError(rev, compactedRev uint64) error {
+	return apierrors.NewResourceExpired(fmt.Sprintf("too old resource version: %d (%d)", rev, compactedRev+1))
+}
@@ -0,0 +1,54 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package kvstore
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRevisionHistory(t *testing.T) {
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	h := &RevisionHistory{Window: 5 * time.Second}
+
+	testCases := []struct {
+		offset        time.Duration
+		revision      uint64
+		expected      uint64
+		expectCovered bool
+	}{
+		// the history does not cover the window yet, the oldest revision is returned
+		{offset: 0, revision: 10, expected: 10},
+		{offset: 2 * time.Second, revision: 20, expected: 10},
+		{offset: 5 * time.Second, revision: 30, expected: 10, expectCovered: true},
+		{offset: 6 * time.Second, revision: 40, expected: 10, expectCovered: true},
+		{offset: 7 * time.Second, revision: 50, expected: 20, expectCovered: true},
+		// no writes for a while, the latest revision is more than the window old
+		{offset: 20 * time.Second, revision: 50, expected: 50, expectCovered: true},
+		{offset: 21 * time.Second, revision: 60, expected: 50, expectCovered: true},
+	}
+	for _, testcase := range testCases {
+		rev, covered := h.Add(start.Add(testcase.offset), testcase.revision)
+		if rev != testcase.expected || covered != testcase.expectCovered {
+			t.Errorf("at %v expected revision %d covered %v, got %d %v",
+				testcase.offset, testcase.expected, testcase.expectCovered, rev, covered)
+		}
+	}
+	if len(h.samples) != 3 {
+		t.Errorf("expected 3 samples kept, got %v", h.samples)
+	}
+}
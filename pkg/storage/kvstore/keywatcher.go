@@ -0,0 +1,168 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package kvstore
+
+import (
+	"context"
+	"strings"
+
+	"k8s.io/klog/v2"
+)
+
+// incomingBufSize is the buffer of events committed while a KeyWatcher is busy,
+// it reduces the context switches of the backends.
+const incomingBufSize = 100
+
+// KeyWatcher delivers the events of one key, or of every key below a prefix when
+// recursive, to a single client. It is shared by the KV implementations, which
+// queue every committed event with Add.
+type KeyWatcher struct {
+	key       string
+	recursive bool
+
+	ctx      context.Context
+	cancel   context.CancelFunc
+	incoming chan *Event
+	result   chan *Event
+}
+
+// NewKeyWatcher creates a watcher of key, it is stopped once ctx is done.
+func NewKeyWatcher(ctx context.Context, key string, recursive bool) *KeyWatcher {
+	ctx, cancel := context.WithCancel(ctx)
+	return &KeyWatcher{
+		key:       key,
+		recursive: recursive,
+		ctx:       ctx,
+		cancel:    cancel,
+		incoming:  make(chan *Event, incomingBufSize),
+		result:    make(chan *Event, outgoingBufSize),
+	}
+}
+
+// Context returns the context of the watch, it is done once the watcher stopped.
+func (w *KeyWatcher) Context() context.Context {
+	return w.ctx
+}
+
+// Stop ends the watch.
+func (w *KeyWatcher) Stop() {
+	w.cancel()
+}
+
+// ResultChan returns the events of the watch, it is closed once the watch ended.
+func (w *KeyWatcher) ResultChan() <-chan *Event {
+	return w.result
+}
+
+// Add queues e without blocking, the backend must call it in revision order. Events
+// of other keys are queued as progress events. A watcher that can not keep up is
+// stopped and Add returns false.
+func (w *KeyWatcher) Add(e *Event) bool {
+	if w.ctx.Err() != nil {
+		return false
+	}
+	if !w.MatchesKey(e.Key) {
+		w.addProgress(e.Revision)
+		return true
+	}
+	select {
+	case w.incoming <- e:
+		return true
+	default:
+		klog.V(2).Infof("Fast watcher, slow processing. Stopping watcher on %q", w.key)
+		w.cancel()
+		return false
+	}
+}
+
+// addProgress queues a progress event for a write to another key. Progress events
+// are skipped while the watcher is busy, they must not fill its buffer.
+func (w *KeyWatcher) addProgress(rev uint64) {
+	if len(w.incoming) >= cap(w.incoming)/2 {
+		return
+	}
+	select {
+	case w.incoming <- &Event{Revision: rev, Progress: true}:
+	default:
+	}
+}
+
+// RequestProgress queues a progress event at rev, which must not be less than the revision
+// of the events queued by Add. Like the progress of other keys, it is skipped while the
+// watcher is busy, the client asks again.
+func (w *KeyWatcher) RequestProgress(rev uint64) {
+	if w.ctx.Err() == nil {
+		w.addProgress(rev)
+	}
+}
+
+// Expire ends the watch with err, e.g. once the events it waits for are compacted.
+func (w *KeyWatcher) Expire(err error) {
+	select {
+	case w.incoming <- &Event{Err: err}:
+	default:
+		w.cancel()
+	}
+}
+
+// MatchesKey returns true if the events of key are sent to the client.
+func (w *KeyWatcher) MatchesKey(key string) bool {
+	if w.recursive {
+		return strings.HasPrefix(key, w.key)
+	}
+	return key == w.key
+}
+
+// Run sends the initial events by sendInitial, then the queued events after the revision
+// it returns, until the watch ends. An error of sendInitial is sent to the client.
+func (w *KeyWatcher) Run(sendInitial func(w *KeyWatcher) (uint64, error)) {
+	defer close(w.result)
+	defer w.cancel()
+
+	lastRev, err := sendInitial(w)
+	if err != nil {
+		if w.ctx.Err() == nil {
+			w.Send(&Event{Err: err})
+		}
+		return
+	}
+	for {
+		select {
+		case e := <-w.incoming:
+			// skip the events sent by sendInitial already, the client is at lastRev,
+			// which is still worth a progress event
+			if e.Err == nil && (e.Revision < lastRev || e.Revision == lastRev && !e.Progress) {
+				continue
+			}
+			if !w.Send(e) || e.Err != nil {
+				return
+			}
+		case <-w.ctx.Done():
+			return
+		}
+	}
+}
+
+// Send delivers e to the client, it returns false once the watch ended.
+func (w *KeyWatcher) Send(e *Event) bool {
+	select {
+	case w.result <- e:
+		return true
+	case <-w.ctx.Done():
+		return false
+	}
+}
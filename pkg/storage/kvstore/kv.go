@@ -0,0 +1,144 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package kvstore
+
+import (
+	"context"
+
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// KeyValue is a stored value together with the revision it was last modified at.
+type KeyValue struct {
+	Key      string
+	Value    []byte
+	Revision uint64
+}
+
+// Event is a change of a single key. Value is nil for a deleted key and
+// PrevValue is nil for a created key. A non-nil Err ends the event stream.
+type Event struct {
+	Key       string
+	Revision  uint64
+	Value     []byte
+	PrevValue []byte
+	Deleted   bool
+	// Progress marks an event without a change, which reports that every event of
+	// the watch up to Revision has been sent, e.g. after writes to other keys.
+	Progress bool
+	Err      error
+}
+
+// Attributes are the labels and the selectable fields of a stored object.
+type Attributes struct {
+	Labels labels.Set
+	Fields fields.Set
+}
+
+// Reader reads the stored keys, it is the read part of KV.
+type Reader interface {
+	// Get returns the value stored at key, or nil if the key does not exist.
+	Get(ctx context.Context, key string) (*KeyValue, error)
+	// List returns at most limit keys with prefix that are not less than fromKey,
+	// sorted by key. A non-positive limit returns every key. It also returns the
+	// number of keys left after the returned ones and the revision the keys were read at.
+	List(ctx context.Context, prefix, fromKey string, limit int64) ([]*KeyValue, int64, uint64, error)
+	// Count returns the number of keys with prefix.
+	Count(ctx context.Context, prefix string) (int64, error)
+	// CurrentRevision returns the revision of the last write.
+	CurrentRevision(ctx context.Context) (uint64, error)
+}
+
+// KV is a key value store with a single revision counter that is increased by
+// every write, like etcd does. Keys are compared byte wise.
+type KV interface {
+	Reader
+	// Commit writes value to key if the key is still at expectedRev, a zero expectedRev
+	// means the key must not exist. A nil value deletes the key. It returns the new
+	// revision and false if the key was modified concurrently.
+	Commit(ctx context.Context, key string, expectedRev uint64, value []byte) (uint64, bool, error)
+	// Watch streams the events of key, or of every key with the prefix key when recursive,
+	// in revision order. A zero rev starts with the current state as created events,
+	// otherwise every event after rev is sent. Writes to other keys may be reported by
+	// progress events. The channel is closed once ctx is done.
+	Watch(ctx context.Context, key string, recursive bool, rev uint64) (<-chan *Event, error)
+}
+
+// ProgressKV is an optional interface of KV, whose watchers report their progress on request.
+type ProgressKV interface {
+	KV
+	// RequestProgress queues a progress event to every watcher at the revision of the last
+	// event queued to the watchers, so every event up to it is sent before the progress.
+	RequestProgress(ctx context.Context) error
+}
+
+// ReplicaKV is an optional interface of KV, whose reads that don't have to observe the
+// latest write, i.e. those with a resourceVersion, can be served by read replicas.
+type ReplicaKV interface {
+	KV
+	// Replica returns a healthy replica that has caught up with minRev, or nil if
+	// there is none and the KV itself has to be read.
+	Replica(minRev uint64) Reader
+}
+
+// IndexedKV is an optional interface of KV, which keeps the attributes of the stored
+// objects to select keys by them, see SelectReader.
+type IndexedKV interface {
+	KV
+	// CommitWithAttributes is Commit, which also stores the attributes of value.
+	// Keys written by Commit have no attributes.
+	CommitWithAttributes(ctx context.Context, key string, expectedRev uint64, value []byte, attrs *Attributes) (uint64, bool, error)
+}
+
+// SelectReader is an optional interface of Reader, which lists only the keys whose
+// attributes match a selector.
+type SelectReader interface {
+	Reader
+	// ListSelected is List, which skips the keys whose attributes don't match selector.
+	// Keys without attributes are always returned, so the values still have to be filtered.
+	ListSelected(ctx context.Context, prefix, fromKey string, limit int64, selector Selector) ([]*KeyValue, int64, uint64, error)
+}
+
+// ChangesReader is an optional interface of Reader, which tells whether keys were written
+// after a revision. The pages of a list are only served while their keys are unchanged.
+type ChangesReader interface {
+	Reader
+	// ChangedSince returns whether a key with prefix that is not less than fromKey was written
+	// after rev. It fails with a compacted error if the events after rev were compacted.
+	ChangedSince(ctx context.Context, prefix, fromKey string, rev uint64) (bool, error)
+}
+
+// Op is one write of a transaction, see TxnKV.
+type Op struct {
+	Key string
+	// ExpectedRev is the revision the key must still be at, zero means it must not exist.
+	ExpectedRev uint64
+	// Value is nil to delete the key.
+	Value []byte
+	Attrs *Attributes
+}
+
+// TxnKV is an optional interface of KV, which commits the writes of several keys atomically.
+type TxnKV interface {
+	KV
+	// CommitTxn writes every op in one transaction if all keys are still at their expected
+	// revision, the keys must be distinct. The ops get consecutive revisions in order and
+	// the revision of the last one is returned. It returns false and writes nothing if a
+	// key was modified concurrently.
+	CommitTxn(ctx context.Context, ops []Op) (uint64, bool, error)
+}
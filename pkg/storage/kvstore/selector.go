@@ -0,0 +1,120 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package kvstore
+
+import (
+	"k8s.io/apimachinery/pkg/selection"
+	"k8s.io/apiserver/pkg/storage"
+)
+
+// Requirement selects keys by one label or field of their attributes.
+type Requirement struct {
+	// Field selects by a field instead of a label.
+	Field bool
+	Key   string
+	// Values are the accepted values, any value is accepted if empty.
+	Values []string
+	// Negate selects the keys without a matching label or field instead.
+	Negate bool
+}
+
+// Selector selects the keys whose attributes match all of its requirements.
+type Selector []Requirement
+
+// NewSelector returns the requirements of the label and field selector of p that can be
+// evaluated on the attributes, the others are left to the predicate.
+func NewSelector(p storage.SelectionPredicate) Selector {
+	var s Selector
+	if p.Label != nil {
+		if reqs, selectable := p.Label.Requirements(); selectable {
+			for _, req := range reqs {
+				r := Requirement{Key: req.Key()}
+				switch req.Operator() {
+				case selection.Equals, selection.DoubleEquals, selection.In:
+					r.Values = req.Values().List()
+				case selection.NotEquals, selection.NotIn:
+					r.Values, r.Negate = req.Values().List(), true
+				case selection.Exists:
+				case selection.DoesNotExist:
+					r.Negate = true
+				default:
+					// e.g. Gt and Lt
+					continue
+				}
+				s = append(s, r)
+			}
+		}
+	}
+	if p.Field != nil {
+		for _, req := range p.Field.Requirements() {
+			// an empty value also matches a missing field, which is left to the predicate
+			if req.Value == "" {
+				continue
+			}
+			r := Requirement{Field: true, Key: req.Field, Values: []string{req.Value}}
+			switch req.Operator {
+			case selection.Equals, selection.DoubleEquals:
+			case selection.NotEquals:
+				r.Negate = true
+			default:
+				continue
+			}
+			s = append(s, r)
+		}
+	}
+	return s
+}
+
+// Matches returns whether attrs match every requirement of s. Nil attributes, and
+// fields missing from them, are unknown and never excluded.
+func (s Selector) Matches(attrs *Attributes) bool {
+	if attrs == nil {
+		return true
+	}
+	for _, r := range s {
+		var value string
+		var found bool
+		if r.Field {
+			value, found = attrs.Fields[r.Key]
+			if !found {
+				continue
+			}
+		} else {
+			value, found = attrs.Labels[r.Key]
+		}
+		if r.matches(value, found) == r.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+// matches returns whether value is accepted by r, ignoring Negate.
+func (r Requirement) matches(value string, found bool) bool {
+	if !found {
+		return false
+	}
+	if len(r.Values) == 0 {
+		return true
+	}
+	for _, v := range r.Values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package kvstore
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apiserver/pkg/storage"
+)
+
+func TestNewSelector(t *testing.T) {
+	testCases := []struct {
+		name     string
+		label    string
+		field    string
+		expected Selector
+	}{
+		{
+			name: "everything",
+		},
+		{
+			name:  "label operators",
+			label: "tier=web,env in (prod,dev),team!=a,app notin (x),canary,!legacy",
+			expected: Selector{
+				{Key: "app", Values: []string{"x"}, Negate: true},
+				{Key: "canary"},
+				{Key: "env", Values: []string{"dev", "prod"}},
+				{Key: "legacy", Negate: true},
+				{Key: "team", Values: []string{"a"}, Negate: true},
+				{Key: "tier", Values: []string{"web"}},
+			},
+		},
+		{
+			name:  "greater than is left to the predicate",
+			label: "replicas>1",
+		},
+		{
+			name:  "field operators",
+			field: "metadata.name=foo,status.phase!=Terminating",
+			expected: Selector{
+				{Field: true, Key: "metadata.name", Values: []string{"foo"}},
+				{Field: true, Key: "status.phase", Values: []string{"Terminating"}, Negate: true},
+			},
+		},
+		{
+			name:  "empty field values are left to the predicate",
+			field: "spec.nodeName=,metadata.name!=",
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			p := storage.SelectionPredicate{Label: labels.Everything(), Field: fields.Everything()}
+			if testcase.label != "" {
+				selector, err := labels.Parse(testcase.label)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p.Label = selector
+			}
+			if testcase.field != "" {
+				selector, err := fields.ParseSelector(testcase.field)
+				if err != nil {
+					t.Fatal(err)
+				}
+				p.Field = selector
+			}
+
+			if selector := NewSelector(p); !reflect.DeepEqual(selector, testcase.expected) {
+				t.Errorf("expected %#v, got %#v", testcase.expected, selector)
+			}
+		})
+	}
+}
+
+func TestSelectorMatches(t *testing.T) {
+	attrs := &Attributes{
+		Labels: labels.Set{"tier": "web", "env": "prod"},
+		Fields: fields.Set{"metadata.name": "foo", "spec.nodeName": ""},
+	}
+
+	testCases := []struct {
+		name     string
+		selector Selector
+		attrs    *Attributes
+		expected bool
+	}{
+		{
+			name:     "label value",
+			selector: Selector{{Key: "tier", Values: []string{"web", "db"}}},
+			expected: true,
+		},
+		{
+			name:     "other label value",
+			selector: Selector{{Key: "tier", Values: []string{"db"}}},
+		},
+		{
+			name:     "missing label",
+			selector: Selector{{Key: "team"}},
+		},
+		{
+			name:     "negated missing label",
+			selector: Selector{{Key: "team", Values: []string{"a"}, Negate: true}},
+			expected: true,
+		},
+		{
+			name:     "negated label",
+			selector: Selector{{Key: "env", Negate: true}},
+		},
+		{
+			name:     "all requirements",
+			selector: Selector{{Key: "tier"}, {Field: true, Key: "metadata.name", Values: []string{"bar"}}},
+		},
+		{
+			name:     "field value",
+			selector: Selector{{Field: true, Key: "metadata.name", Values: []string{"foo"}}},
+			expected: true,
+		},
+		{
+			name:     "missing field is unknown",
+			selector: Selector{{Field: true, Key: "spec.foo", Values: []string{"bar"}}},
+			expected: true,
+		},
+		{
+			name:     "no attributes",
+			selector: Selector{{Key: "team"}},
+			attrs:    &Attributes{},
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			a := attrs
+			if testcase.attrs != nil {
+				a = testcase.attrs
+			}
+			if matched := testcase.selector.Matches(a); matched != testcase.expected {
+				t.Errorf("expected %v, got %v", testcase.expected, matched)
+			}
+		})
+	}
+	if !(Selector{{Key: "team"}}).Matches(nil) {
+		t.Errorf("expected keys without attributes to match")
+	}
+}
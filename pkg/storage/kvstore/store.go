@@ -0,0 +1,687 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package kvstore
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"reflect"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/conversion"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/storage/value"
+	"k8s.io/apiserver/pkg/storage/value/encrypt/identity"
+)
+
+// store implements storage.Interface on top of a KV.
+type store struct {
+	kv          KV
+	codec       runtime.Codec
+	transformer value.Transformer
+	versioner   storage.Versioner
+	pathPrefix  string
+	newFunc     func() runtime.Object
+	newListFunc func() runtime.Object
+	getAttrs    storage.AttrFunc
+}
+
+var _ storage.Interface = &store{}
+
+// New returns a storage.Interface for one resource on kv. The prefix is
+// prepended to every key, as storagebackend.Config.Prefix does for etcd.
+// Values are passed through transformer, a nil transformer stores them as is. If kv is an
+// IndexedKV, the attributes returned by getAttrs are stored with the objects, so lists
+// with a label or field selector only read the matching ones. Writes with a context
+// holding a Batch are staged in it, see WithBatch.
+func New(kv KV, codec runtime.Codec, newFunc, newListFunc func() runtime.Object, prefix string,
+	transformer value.Transformer, getAttrs storage.AttrFunc) storage.Interface {
+	pathPrefix := path.Join("/", prefix)
+	if !strings.HasSuffix(pathPrefix, "/") {
+		// Ensure the pathPrefix ends in "/" here to simplify key concatenation later.
+		pathPrefix += "/"
+	}
+	if transformer == nil {
+		transformer = identity.NewEncryptCheckTransformer()
+	}
+	return &store{
+		kv:          kv,
+		codec:       codec,
+		transformer: transformer,
+		versioner:   storage.APIObjectVersioner{},
+		pathPrefix:  pathPrefix,
+		newFunc:     newFunc,
+		newListFunc: newListFunc,
+		getAttrs:    getAttrs,
+	}
+}
+
+// Versioner implements storage.Interface.Versioner.
+func (s *store) Versioner() storage.Versioner {
+	return s.versioner
+}
+
+// Get implements storage.Interface.Get.
+func (s *store) Get(ctx context.Context, key string, opts storage.GetOptions, out runtime.Object) error {
+	preparedKey, err := s.prepareKey(key)
+	if err != nil {
+		return err
+	}
+	r := s.reader(opts.ResourceVersion)
+	if err := s.validateMinimumResourceVersion(ctx, r, opts.ResourceVersion); err != nil {
+		return err
+	}
+
+	kv, err := s.get(ctx, r, preparedKey)
+	if err != nil {
+		return err
+	}
+	if kv == nil {
+		if opts.IgnoreNotFound {
+			return runtime.SetZeroValue(out)
+		}
+		return storage.NewKeyNotFoundError(preparedKey, 0)
+	}
+	_, err = s.decode(ctx, kv, out)
+	return err
+}
+
+// Create implements storage.Interface.Create. TTLs are not supported and ttl is ignored.
+func (s *store) Create(ctx context.Context, key string, obj, out runtime.Object, ttl uint64) error {
+	preparedKey, err := s.prepareKey(key)
+	if err != nil {
+		return err
+	}
+	if version, err := s.versioner.ObjectResourceVersion(obj); err == nil && version != 0 {
+		return errors.New("resourceVersion should not be set on objects to be created")
+	}
+	if err := s.versioner.PrepareObjectForStorage(obj); err != nil {
+		return fmt.Errorf("PrepareObjectForStorage failed: %v", err)
+	}
+
+	data, err := runtime.Encode(s.codec, obj)
+	if err != nil {
+		return err
+	}
+	newData, err := s.transformer.TransformToStorage(ctx, data, authenticatedDataString(preparedKey))
+	if err != nil {
+		return storage.NewInternalError(err.Error())
+	}
+	if BatchFrom(ctx) != nil {
+		// a staged create of an existing key would only fail once the batch is committed
+		if kv, err := s.get(ctx, s.kv, preparedKey); err != nil {
+			return err
+		} else if kv != nil {
+			return storage.NewKeyExistsError(preparedKey, 0)
+		}
+	}
+
+	rev, ok, err := s.commit(ctx, preparedKey, 0, newData, obj)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return storage.NewKeyExistsError(preparedKey, 0)
+	}
+	if out != nil {
+		return decode(s.codec, s.versioner, data, out, rev)
+	}
+	return nil
+}
+
+// Delete implements storage.Interface.Delete.
+func (s *store) Delete(
+	ctx context.Context, key string, out runtime.Object, preconditions *storage.Preconditions,
+	validateDeletion storage.ValidateObjectFunc, _ runtime.Object) error {
+	preparedKey, err := s.prepareKey(key)
+	if err != nil {
+		return err
+	}
+	if _, err := conversion.EnforcePtr(out); err != nil {
+		return fmt.Errorf("unable to convert output object to pointer: %v", err)
+	}
+
+	for {
+		kv, err := s.get(ctx, s.kv, preparedKey)
+		if err != nil {
+			return err
+		}
+		if kv == nil {
+			return storage.NewKeyNotFoundError(preparedKey, 0)
+		}
+		origObj := s.newFunc()
+		if _, err := s.decode(ctx, kv, origObj); err != nil {
+			return err
+		}
+		if err := preconditions.Check(preparedKey, origObj); err != nil {
+			return err
+		}
+		if err := validateDeletion(ctx, origObj); err != nil {
+			return err
+		}
+
+		rev, ok, err := s.commit(ctx, preparedKey, kv.Revision, nil, nil)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// the object was modified concurrently, check the preconditions again
+			continue
+		}
+		kv.Revision = rev
+		_, err = s.decode(ctx, kv, out)
+		return err
+	}
+}
+
+// GuaranteedUpdate implements storage.Interface.GuaranteedUpdate. The cached existing
+// object is never trusted, the current state is always read from the KV.
+func (s *store) GuaranteedUpdate(
+	ctx context.Context, key string, destination runtime.Object, ignoreNotFound bool,
+	preconditions *storage.Preconditions, tryUpdate storage.UpdateFunc, _ runtime.Object) error {
+	preparedKey, err := s.prepareKey(key)
+	if err != nil {
+		return err
+	}
+	v, err := conversion.EnforcePtr(destination)
+	if err != nil {
+		return fmt.Errorf("unable to convert output object to pointer: %v", err)
+	}
+
+	for {
+		var origData []byte
+		var origRev uint64
+		var stale bool
+		origObj := reflect.New(v.Type()).Interface().(runtime.Object)
+		kv, err := s.get(ctx, s.kv, preparedKey)
+		if err != nil {
+			return err
+		}
+		if kv != nil {
+			origRev = kv.Revision
+			if origData, stale, err = s.transformFromStorage(ctx, kv); err != nil {
+				return err
+			}
+			if err := decode(s.codec, s.versioner, origData, origObj, origRev); err != nil {
+				return err
+			}
+		} else if !ignoreNotFound {
+			return storage.NewKeyNotFoundError(preparedKey, 0)
+		}
+
+		if err := preconditions.Check(preparedKey, origObj); err != nil {
+			return err
+		}
+
+		ret, _, err := tryUpdate(origObj, storage.ResponseMeta{ResourceVersion: origRev})
+		if err != nil {
+			return err
+		}
+		if err := s.versioner.PrepareObjectForStorage(ret); err != nil {
+			return fmt.Errorf("PrepareObjectForStorage failed: %v", err)
+		}
+		data, err := runtime.Encode(s.codec, ret)
+		if err != nil {
+			return err
+		}
+		if origData != nil && !stale && bytes.Equal(data, origData) {
+			// nothing changed, skip the write
+			return decode(s.codec, s.versioner, origData, destination, origRev)
+		}
+		newData, err := s.transformer.TransformToStorage(ctx, data, authenticatedDataString(preparedKey))
+		if err != nil {
+			return storage.NewInternalError(err.Error())
+		}
+
+		rev, ok, err := s.commit(ctx, preparedKey, origRev, newData, ret)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			// the object was modified concurrently, retry with the current state
+			continue
+		}
+		return decode(s.codec, s.versioner, data, destination, rev)
+	}
+}
+
+// get reads key from r, or the value staged for it by the batch of ctx.
+func (s *store) get(ctx context.Context, r Reader, key string) (*KeyValue, error) {
+	if b := BatchFrom(ctx); b != nil {
+		if kv, staged := b.get(s.kv, key); staged {
+			return kv, nil
+		}
+	}
+	return r.Get(ctx, key)
+}
+
+// commit writes value to key, together with the attributes of obj if the KV keeps them.
+// A nil obj deletes the key. The write is staged if ctx holds a batch.
+func (s *store) commit(ctx context.Context, key string, expectedRev uint64, value []byte, obj runtime.Object) (uint64, bool, error) {
+	var attrs *Attributes
+	indexed, ok := s.kv.(IndexedKV)
+	if ok && s.getAttrs != nil && obj != nil {
+		labels, fields, err := s.getAttrs(obj)
+		if err != nil {
+			return 0, false, err
+		}
+		attrs = &Attributes{Labels: labels, Fields: fields}
+	}
+	if b := BatchFrom(ctx); b != nil {
+		return b.stage(s.kv, key, expectedRev, value, attrs)
+	}
+	if attrs == nil {
+		return s.kv.Commit(ctx, key, expectedRev, value)
+	}
+	return indexed.CommitWithAttributes(ctx, key, expectedRev, value, attrs)
+}
+
+// Count implements storage.Interface.Count.
+func (s *store) Count(key string) (int64, error) {
+	preparedKey, err := s.prepareKey(key)
+	if err != nil {
+		return 0, err
+	}
+	// We need to make sure the key ended with "/" so that we only get children "directories".
+	if !strings.HasSuffix(preparedKey, "/") {
+		preparedKey += "/"
+	}
+	// counts are only used for metrics, a replica is good enough
+	return s.replicaOrKV(0).Count(context.TODO(), preparedKey)
+}
+
+// GetList implements storage.Interface.GetList. The KV only keeps the latest state,
+// so lists are always served at the current revision of the KV or the replica read.
+// The pages after the first one report the revision of the first page, but may hold
+// writes made after it. An exact resourceVersion can only be served while it is current.
+func (s *store) GetList(ctx context.Context, key string, opts storage.ListOptions, listObj runtime.Object) error {
+	preparedKey, err := s.prepareKey(key)
+	if err != nil {
+		return err
+	}
+	listPtr, err := meta.GetItemsPtr(listObj)
+	if err != nil {
+		return err
+	}
+	v, err := conversion.EnforcePtr(listPtr)
+	if err != nil || v.Kind() != reflect.Slice {
+		return fmt.Errorf("need ptr to slice: %v", err)
+	}
+
+	if !opts.Recursive {
+		return s.getSingle(ctx, preparedKey, opts, listObj, v)
+	}
+
+	keyPrefix := preparedKey
+	if !strings.HasSuffix(keyPrefix, "/") {
+		keyPrefix += "/"
+	}
+	fromKey := keyPrefix
+	var r Reader
+	var rev, exactRev, continueRev uint64
+	if len(opts.Predicate.Continue) > 0 {
+		continueKey, continueRV, err := storage.DecodeContinue(opts.Predicate.Continue, keyPrefix)
+		if err != nil {
+			return err
+		}
+		fromKey = continueKey
+		// the next pages must not be older than the first one, whose revision is kept
+		r = s.replicaOrKV(uint64(continueRV))
+		rev = uint64(continueRV)
+		continueRev = rev
+	} else {
+		if exactRev, err = s.exactRevision(opts); err != nil {
+			return err
+		}
+		r = s.reader(opts.ResourceVersion)
+		if exactRev != 0 {
+			r = s.kv
+		}
+		if err := s.validateMinimumResourceVersion(ctx, r, opts.ResourceVersion); err != nil {
+			return err
+		}
+	}
+
+	list := r.List
+	// the selector only skips keys, the predicate is still applied to every listed object
+	if selectReader, ok := r.(SelectReader); ok {
+		if selector := NewSelector(opts.Predicate); len(selector) > 0 {
+			list = func(ctx context.Context, prefix, fromKey string, limit int64) ([]*KeyValue, int64, uint64, error) {
+				return selectReader.ListSelected(ctx, prefix, fromKey, limit, selector)
+			}
+		}
+	}
+
+	limit := opts.Predicate.Limit
+	pageKey := fromKey
+	var count int64
+	var latestRev uint64
+	var lastKey string
+	var remainingItemCount *int64
+	hasMore := false
+	for {
+		kvs, remaining, listRev, err := list(ctx, keyPrefix, fromKey, limit)
+		if err != nil {
+			return err
+		}
+		if exactRev != 0 && listRev != exactRev {
+			return NewCompactedError(exactRev, listRev-1)
+		}
+		if rev == 0 {
+			rev = listRev
+		}
+		if listRev > latestRev {
+			latestRev = listRev
+		}
+		for i, kv := range kvs {
+			obj := s.newFunc()
+			if _, err := s.decode(ctx, kv, obj); err != nil {
+				return err
+			}
+			lastKey = kv.Key
+			if matched, err := opts.Predicate.Matches(obj); err != nil {
+				return err
+			} else if !matched {
+				continue
+			}
+			appendListItem(v, obj)
+			count++
+			if limit > 0 && count >= limit {
+				left := int64(len(kvs)-i-1) + remaining
+				hasMore = left > 0
+				if opts.Predicate.Empty() {
+					remainingItemCount = &left
+				}
+				break
+			}
+		}
+		// stop once the page is full or there are no more keys to filter
+		if hasMore || (limit > 0 && count >= limit) || remaining == 0 || len(kvs) == 0 {
+			break
+		}
+		fromKey = lastKey + "\x00"
+	}
+	// the keys are read at a later revision than the first page, which is only consistent
+	// with it if none of the keys of this and the next pages were written since
+	if continueRev != 0 && latestRev > continueRev {
+		if err := s.checkUnchanged(ctx, keyPrefix, pageKey, continueRev); err != nil {
+			return err
+		}
+	}
+
+	if v.IsNil() {
+		// Ensure that we never return a nil Items pointer in the result for consistency.
+		v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+	}
+
+	if !hasMore {
+		return s.versioner.UpdateList(listObj, rev, "", nil)
+	}
+	next, err := storage.EncodeContinue(lastKey+"\x00", keyPrefix, int64(rev))
+	if err != nil {
+		return err
+	}
+	return s.versioner.UpdateList(listObj, rev, next, remainingItemCount)
+}
+
+// getSingle serves a non-recursive list, which holds at most the object stored at key.
+func (s *store) getSingle(ctx context.Context, key string, opts storage.ListOptions, listObj runtime.Object, v reflect.Value) error {
+	if len(opts.Predicate.Continue) > 0 {
+		return fmt.Errorf("continue key is not supported for non-recursive list")
+	}
+	exactRev, err := s.exactRevision(opts)
+	if err != nil {
+		return err
+	}
+	r := s.reader(opts.ResourceVersion)
+	if exactRev != 0 {
+		r = s.kv
+	}
+	if err := s.validateMinimumResourceVersion(ctx, r, opts.ResourceVersion); err != nil {
+		return err
+	}
+	rev, err := r.CurrentRevision(ctx)
+	if err != nil {
+		return err
+	}
+	kv, err := r.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	if exactRev != 0 {
+		// the key may have been written after the revision was read
+		if current, err := r.CurrentRevision(ctx); err != nil {
+			return err
+		} else if current != exactRev || rev != exactRev {
+			return NewCompactedError(exactRev, current-1)
+		}
+	}
+	if kv != nil {
+		obj := s.newFunc()
+		if _, err := s.decode(ctx, kv, obj); err != nil {
+			return err
+		}
+		if matched, err := opts.Predicate.Matches(obj); err != nil {
+			return err
+		} else if matched {
+			appendListItem(v, obj)
+		}
+	}
+	if v.IsNil() {
+		v.Set(reflect.MakeSlice(v.Type(), 0, 0))
+	}
+	return s.versioner.UpdateList(listObj, rev, "", nil)
+}
+
+// Watch implements storage.Interface.Watch.
+func (s *store) Watch(ctx context.Context, key string, opts storage.ListOptions) (watch.Interface, error) {
+	preparedKey, err := s.prepareKey(key)
+	if err != nil {
+		return nil, err
+	}
+	rev, err := s.versioner.ParseResourceVersion(opts.ResourceVersion)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Recursive && !strings.HasSuffix(preparedKey, "/") {
+		preparedKey += "/"
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	events, err := s.kv.Watch(ctx, preparedKey, opts.Recursive, rev)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	w := newWatcher(s, opts.Predicate, opts.ProgressNotify, cancel)
+	go w.run(ctx, events)
+	return w, nil
+}
+
+// RequestWatchProgress implements storage.Interface.RequestWatchProgress. The watches
+// with progress notify get a bookmark once they are at the revision of the last event,
+// e.g. a watch cache serving a consistent read waits for it.
+func (s *store) RequestWatchProgress(ctx context.Context) error {
+	kv, ok := s.kv.(ProgressKV)
+	if !ok {
+		return fmt.Errorf("watch progress is not supported by the storage of %s", s.pathPrefix)
+	}
+	return kv.RequestProgress(ctx)
+}
+
+// checkUnchanged fails with 410 Gone if a key with prefix that is not less than fromKey was
+// written after rev, or if that can't be told since the KV is no ChangesReader.
+func (s *store) checkUnchanged(ctx context.Context, prefix, fromKey string, rev uint64) error {
+	if changes, ok := s.kv.(ChangesReader); ok {
+		changed, err := changes.ChangedSince(ctx, prefix, fromKey, rev)
+		if err != nil || !changed {
+			return err
+		}
+	}
+	return apierrors.NewResourceExpired(fmt.Sprintf("the objects listed at resource version %d have changed since, "+
+		"the continue token is too old to list them consistently, start a new list without it", rev))
+}
+
+// exactRevision returns the revision a list with ResourceVersionMatch=Exact asks for, or
+// zero for any other list. The older states are not kept, so only the current revision
+// can be served, older ones are reported as compacted.
+func (s *store) exactRevision(opts storage.ListOptions) (uint64, error) {
+	if opts.ResourceVersionMatch != metav1.ResourceVersionMatchExact {
+		return 0, nil
+	}
+	rev, err := s.versioner.ParseResourceVersion(opts.ResourceVersion)
+	if err != nil {
+		return 0, err
+	}
+	if rev == 0 {
+		return 0, fmt.Errorf("resourceVersionMatch %q requires a non-zero resourceVersion", metav1.ResourceVersionMatchExact)
+	}
+	return rev, nil
+}
+
+// reader returns the Reader to serve a read at resourceVersion from. An empty resourceVersion
+// asks for the latest state, which is only read from the KV itself, any other one may be
+// served by a replica that has caught up with it.
+func (s *store) reader(resourceVersion string) Reader {
+	if resourceVersion == "" {
+		return s.kv
+	}
+	minRev, err := s.versioner.ParseResourceVersion(resourceVersion)
+	if err != nil {
+		// the error is returned by validateMinimumResourceVersion
+		return s.kv
+	}
+	return s.replicaOrKV(minRev)
+}
+
+// replicaOrKV returns a replica that has caught up with minRev if the KV has one, the KV otherwise.
+func (s *store) replicaOrKV(minRev uint64) Reader {
+	if replicas, ok := s.kv.(ReplicaKV); ok {
+		if r := replicas.Replica(minRev); r != nil {
+			return r
+		}
+	}
+	return s.kv
+}
+
+// validateMinimumResourceVersion returns a 'too large resource' version error when the provided
+// minimumResourceVersion is greater than the most recent revision of r.
+func (s *store) validateMinimumResourceVersion(ctx context.Context, r Reader, minimumResourceVersion string) error {
+	if minimumResourceVersion == "" {
+		return nil
+	}
+	minimumRV, err := s.versioner.ParseResourceVersion(minimumResourceVersion)
+	if err != nil {
+		return err
+	}
+	currentRev, err := r.CurrentRevision(ctx)
+	if err != nil {
+		return err
+	}
+	if minimumRV > currentRev {
+		return storage.NewTooLargeResourceVersionError(minimumRV, currentRev, 0)
+	}
+	return nil
+}
+
+func (s *store) prepareKey(key string) (string, error) {
+	if key == ".." ||
+		strings.HasPrefix(key, "../") ||
+		strings.HasSuffix(key, "/..") ||
+		strings.Contains(key, "/../") {
+		return "", fmt.Errorf("invalid key: %q", key)
+	}
+	if key == "." ||
+		strings.HasPrefix(key, "./") ||
+		strings.HasSuffix(key, "/.") ||
+		strings.Contains(key, "/./") {
+		return "", fmt.Errorf("invalid key: %q", key)
+	}
+	if key == "" || key == "/" {
+		return "", fmt.Errorf("empty key: %q", key)
+	}
+	// We ensured that pathPrefix ends in '/' in construction, so skip any leading '/' in the key now.
+	startIndex := 0
+	if key[0] == '/' {
+		startIndex = 1
+	}
+	return s.pathPrefix + key[startIndex:], nil
+}
+
+// transformFromStorage returns the plain value of kv and whether it should be rewritten,
+// e.g. because it was encrypted with a key that is no longer the primary one.
+func (s *store) transformFromStorage(ctx context.Context, kv *KeyValue) ([]byte, bool, error) {
+	data, stale, err := s.transformer.TransformFromStorage(ctx, kv.Value, authenticatedDataString(kv.Key))
+	if err != nil {
+		return nil, false, storage.NewInternalError(err.Error())
+	}
+	return data, stale, nil
+}
+
+// decode transforms and decodes kv into objPtr, it returns whether the value is stale.
+func (s *store) decode(ctx context.Context, kv *KeyValue, objPtr runtime.Object) (bool, error) {
+	data, stale, err := s.transformFromStorage(ctx, kv)
+	if err != nil {
+		return false, err
+	}
+	return stale, decode(s.codec, s.versioner, data, objPtr, kv.Revision)
+}
+
+// authenticatedDataString satisfies the value.Context interface. It uses the key to
+// authenticate the stored data, so an encrypted value can not be moved to another key.
+type authenticatedDataString string
+
+// AuthenticatedData implements the value.Context interface.
+func (d authenticatedDataString) AuthenticatedData() []byte {
+	return []byte(string(d))
+}
+
+var _ value.Context = authenticatedDataString("")
+
+// decode decodes value of bytes into object. It will also set the object resource version to rev.
+// On success, objPtr would be set to the object.
+func decode(codec runtime.Codec, versioner storage.Versioner, value []byte, objPtr runtime.Object, rev uint64) error {
+	if _, err := conversion.EnforcePtr(objPtr); err != nil {
+		return fmt.Errorf("unable to convert output object to pointer: %v", err)
+	}
+	_, _, err := codec.Decode(value, nil, objPtr)
+	if err != nil {
+		return err
+	}
+	// being unable to set the version does not prevent the object from being extracted
+	if err := versioner.UpdateObject(objPtr, rev); err != nil {
+		return fmt.Errorf("failed to update object version: %v", err)
+	}
+	return nil
+}
+
+// appendListItem appends obj to the items slice v.
+func appendListItem(v reflect.Value, obj runtime.Object) {
+	if v.Type().Elem().Kind() == reflect.Struct {
+		v.Set(reflect.Append(v, reflect.ValueOf(obj).Elem()))
+		return
+	}
+	v.Set(reflect.Append(v, reflect.ValueOf(obj)))
+}
@@ -0,0 +1,169 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package kvstore
+
+import (
+	"context"
+	"errors"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/storage"
+)
+
+// outgoingBufSize is the buffer of decoded events waiting for the client.
+const outgoingBufSize = 100
+
+// watcher decodes the KV events of one watch and filters them with its predicate.
+type watcher struct {
+	store *store
+	pred  storage.SelectionPredicate
+	// progressNotify sends the progress events of the KV as bookmarks, like etcd
+	// progress notifications, so a watch cache learns the revisions of other resources.
+	progressNotify bool
+	cancel         context.CancelFunc
+	result         chan watch.Event
+}
+
+func newWatcher(s *store, pred storage.SelectionPredicate, progressNotify bool, cancel context.CancelFunc) *watcher {
+	return &watcher{
+		store:          s,
+		pred:           pred,
+		progressNotify: progressNotify,
+		cancel:         cancel,
+		result:         make(chan watch.Event, outgoingBufSize),
+	}
+}
+
+func (w *watcher) run(ctx context.Context, events <-chan *Event) {
+	defer close(w.result)
+	defer w.cancel()
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok || !w.send(ctx, e) {
+				return
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// send transforms e into a watch event and delivers it, it returns false once the watcher is done.
+func (w *watcher) send(ctx context.Context, e *Event) bool {
+	err := e.Err
+	var res *watch.Event
+	if err == nil {
+		res, err = w.transform(ctx, e)
+	}
+	if err != nil {
+		status := apierrors.NewInternalError(err).ErrStatus
+		// e.g. 410 Gone, which makes the client list again
+		var apiStatus apierrors.APIStatus
+		if errors.As(err, &apiStatus) {
+			status = apiStatus.Status()
+		}
+		res = &watch.Event{Type: watch.Error, Object: &status}
+	}
+	if res == nil {
+		return true
+	}
+	select {
+	case w.result <- *res:
+	case <-ctx.Done():
+		return false
+	}
+	return err == nil
+}
+
+// transform converts e into a watch event that honors the predicate of the watcher,
+// it returns nil if the event is filtered out.
+func (w *watcher) transform(ctx context.Context, e *Event) (*watch.Event, error) {
+	if e.Progress {
+		if !w.progressNotify {
+			return nil, nil
+		}
+		obj := w.store.newFunc()
+		if err := w.store.versioner.UpdateObject(obj, e.Revision); err != nil {
+			return nil, err
+		}
+		return &watch.Event{Type: watch.Bookmark, Object: obj}, nil
+	}
+
+	var curObj, oldObj runtime.Object
+	if !e.Deleted {
+		curObj = w.store.newFunc()
+		if _, err := w.store.decode(ctx, &KeyValue{Key: e.Key, Value: e.Value, Revision: e.Revision}, curObj); err != nil {
+			return nil, err
+		}
+	}
+	if e.PrevValue != nil && (e.Deleted || !w.pred.Empty()) {
+		oldObj = w.store.newFunc()
+		// the previous object is reported at the revision that removed it
+		if _, err := w.store.decode(ctx, &KeyValue{Key: e.Key, Value: e.PrevValue, Revision: e.Revision}, oldObj); err != nil {
+			return nil, err
+		}
+	}
+
+	switch {
+	case e.Deleted:
+		if !w.filter(oldObj) {
+			return nil, nil
+		}
+		return &watch.Event{Type: watch.Deleted, Object: oldObj}, nil
+	case e.PrevValue == nil:
+		if !w.filter(curObj) {
+			return nil, nil
+		}
+		return &watch.Event{Type: watch.Added, Object: curObj}, nil
+	case w.pred.Empty():
+		return &watch.Event{Type: watch.Modified, Object: curObj}, nil
+	}
+
+	curObjPasses := w.filter(curObj)
+	oldObjPasses := w.filter(oldObj)
+	switch {
+	case curObjPasses && oldObjPasses:
+		return &watch.Event{Type: watch.Modified, Object: curObj}, nil
+	case curObjPasses && !oldObjPasses:
+		return &watch.Event{Type: watch.Added, Object: curObj}, nil
+	case !curObjPasses && oldObjPasses:
+		return &watch.Event{Type: watch.Deleted, Object: oldObj}, nil
+	}
+	return nil, nil
+}
+
+func (w *watcher) filter(obj runtime.Object) bool {
+	if w.pred.Empty() {
+		return true
+	}
+	matched, err := w.pred.Matches(obj)
+	return err == nil && matched
+}
+
+// Stop implements watch.Interface.
+func (w *watcher) Stop() {
+	w.cancel()
+}
+
+// ResultChan implements watch.Interface.
+func (w *watcher) ResultChan() <-chan watch.Event {
+	return w.result
+}
@@ -0,0 +1,218 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package memory
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apiserver/pkg/storage"
+
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+)
+
+const (
+	// StorageTypeMemory is the storagebackend.Config type of the in-memory storage.
+	StorageTypeMemory = "memory"
+
+	// DefaultHistorySize is the number of events kept to resume watches from an older resourceVersion.
+	DefaultHistorySize = 1000
+)
+
+// item is a stored object together with the revision it was last modified at.
+type item struct {
+	data  []byte
+	rev   uint64
+	attrs *kvstore.Attributes
+}
+
+// Backend holds the objects of every resource served from memory. All stores
+// created from one Backend share a single revision counter, like etcd does.
+type Backend struct {
+	lock sync.RWMutex
+	// rev is the revision of the last write.
+	rev   uint64
+	items map[string]*item
+
+	// history is a ring of the most recent events, used to resume watches.
+	history     []*kvstore.Event
+	historySize int
+	// compactedRev is the newest revision no longer available in history.
+	compactedRev uint64
+
+	watchers      map[int]*kvstore.KeyWatcher
+	nextWatcherID int
+}
+
+var _ kvstore.IndexedKV = &Backend{}
+var _ kvstore.TxnKV = &Backend{}
+var _ kvstore.ProgressKV = &Backend{}
+var _ kvstore.SelectReader = &Backend{}
+var _ kvstore.ChangesReader = &Backend{}
+
+// NewBackend creates an empty in-memory backend that keeps historySize events
+// for watches. A non-positive historySize means DefaultHistorySize.
+func NewBackend(historySize int) *Backend {
+	if historySize <= 0 {
+		historySize = DefaultHistorySize
+	}
+	return &Backend{
+		// like etcd, the revision of an empty backend is 1, which is a valid resourceVersion
+		rev:         1,
+		items:       map[string]*item{},
+		historySize: historySize,
+		watchers:    map[int]*kvstore.KeyWatcher{},
+	}
+}
+
+// New returns a storage.Interface for one resource on backend. The prefix is
+// prepended to every key, as storagebackend.Config.Prefix does for etcd.
+func New(backend *Backend, codec runtime.Codec, newFunc, newListFunc func() runtime.Object, prefix string) storage.Interface {
+	return kvstore.New(backend, codec, newFunc, newListFunc, prefix, nil, nil)
+}
+
+// CurrentRevision implements kvstore.KV.
+func (b *Backend) CurrentRevision(ctx context.Context) (uint64, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	return b.rev, nil
+}
+
+// Get implements kvstore.KV.
+func (b *Backend) Get(ctx context.Context, key string) (*kvstore.KeyValue, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+	it, ok := b.items[key]
+	if !ok {
+		return nil, nil
+	}
+	return &kvstore.KeyValue{Key: key, Value: it.data, Revision: it.rev}, nil
+}
+
+// List implements kvstore.KV.
+func (b *Backend) List(ctx context.Context, prefix, fromKey string, limit int64) ([]*kvstore.KeyValue, int64, uint64, error) {
+	return b.ListSelected(ctx, prefix, fromKey, limit, nil)
+}
+
+// ListSelected implements kvstore.SelectReader.
+func (b *Backend) ListSelected(ctx context.Context, prefix, fromKey string, limit int64, selector kvstore.Selector) ([]*kvstore.KeyValue, int64, uint64, error) {
+	b.lock.RLock()
+	var kvs []*kvstore.KeyValue
+	for k, it := range b.items {
+		if strings.HasPrefix(k, prefix) && k >= fromKey && selector.Matches(it.attrs) {
+			kvs = append(kvs, &kvstore.KeyValue{Key: k, Value: it.data, Revision: it.rev})
+		}
+	}
+	rev := b.rev
+	b.lock.RUnlock()
+
+	sort.Slice(kvs, func(i, j int) bool { return kvs[i].Key < kvs[j].Key })
+	var remaining int64
+	if limit > 0 && int64(len(kvs)) > limit {
+		remaining = int64(len(kvs)) - limit
+		kvs = kvs[:limit]
+	}
+	return kvs, remaining, rev, nil
+}
+
+// ChangedSince implements kvstore.ChangesReader.
+func (b *Backend) ChangedSince(ctx context.Context, prefix, fromKey string, rev uint64) (bool, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	if rev < b.compactedRev {
+		return false, kvstore.NewCompactedError(rev, b.compactedRev)
+	}
+	for _, e := range b.history {
+		if e.Revision > rev && strings.HasPrefix(e.Key, prefix) && e.Key >= fromKey {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Count implements kvstore.KV.
+func (b *Backend) Count(ctx context.Context, prefix string) (int64, error) {
+	b.lock.RLock()
+	defer b.lock.RUnlock()
+
+	var count int64
+	for k := range b.items {
+		if strings.HasPrefix(k, prefix) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Commit implements kvstore.KV.
+func (b *Backend) Commit(ctx context.Context, key string, expectedRev uint64, data []byte) (uint64, bool, error) {
+	return b.CommitWithAttributes(ctx, key, expectedRev, data, nil)
+}
+
+// CommitWithAttributes implements kvstore.IndexedKV.
+func (b *Backend) CommitWithAttributes(ctx context.Context, key string, expectedRev uint64, data []byte, attrs *kvstore.Attributes) (uint64, bool, error) {
+	return b.CommitTxn(ctx, []kvstore.Op{{Key: key, ExpectedRev: expectedRev, Value: data, Attrs: attrs}})
+}
+
+// CommitTxn implements kvstore.TxnKV.
+func (b *Backend) CommitTxn(ctx context.Context, ops []kvstore.Op) (uint64, bool, error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+
+	for _, op := range ops {
+		existing, ok := b.items[op.Key]
+		if (ok && existing.rev != op.ExpectedRev) || (!ok && op.ExpectedRev != 0) {
+			return 0, false, nil
+		}
+	}
+	for _, op := range ops {
+		b.commit(op)
+	}
+	return b.rev, true, nil
+}
+
+// commit applies op at the next revision, it is called with the lock held.
+func (b *Backend) commit(op kvstore.Op) {
+	var prevData []byte
+	if existing, ok := b.items[op.Key]; ok {
+		prevData = existing.data
+	}
+
+	b.rev++
+	e := &kvstore.Event{Key: op.Key, Revision: b.rev, Value: op.Value, PrevValue: prevData}
+	if op.Value == nil {
+		delete(b.items, op.Key)
+		e.Deleted = true
+	} else {
+		b.items[op.Key] = &item{data: op.Value, rev: b.rev, attrs: op.Attrs}
+	}
+
+	b.history = append(b.history, e)
+	if len(b.history) > b.historySize {
+		b.compactedRev = b.history[0].Revision
+		b.history = b.history[1:]
+	}
+	for id, w := range b.watchers {
+		if !w.Add(e) {
+			delete(b.watchers, id)
+		}
+	}
+}
@@ -0,0 +1,826 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package memory
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"testing"
+	"time"
+
+	api "github.com/seanchann/apimaster/pkg/apis/coreres"
+	"github.com/seanchann/apimaster/pkg/apis/coreres/install"
+	apiv1 "github.com/seanchann/apimaster/pkg/apis/coreres/v1"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/storage"
+
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+)
+
+func newTestStore(historySize int) storage.Interface {
+	scheme := runtime.NewScheme()
+	install.Install(scheme)
+	codec := serializer.NewCodecFactory(scheme).LegacyCodec(apiv1.SchemeGroupVersion)
+	return New(NewBackend(historySize), codec,
+		func() runtime.Object { return &api.Namespace{} },
+		func() runtime.Object { return &api.NamespaceList{} },
+		"/registry")
+}
+
+func newNamespace(name string, labels map[string]string) *api.Namespace {
+	return &api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func labelPredicate(selector string) storage.SelectionPredicate {
+	return storage.SelectionPredicate{
+		Label: labels.SelectorFromSet(labels.Set{"tier": selector}),
+		GetAttrs: func(obj runtime.Object) (labels.Set, fields.Set, error) {
+			return labels.Set(obj.(*api.Namespace).Labels), nil, nil
+		},
+	}
+}
+
+func TestCreateGetDelete(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(0)
+
+	out := &api.Namespace{}
+	if err := s.Create(ctx, "/namespaces/foo", newNamespace("foo", nil), out, 0); err != nil {
+		t.Fatal(err)
+	}
+	if out.ResourceVersion == "" {
+		t.Errorf("expected resourceVersion to be set")
+	}
+	if err := s.Create(ctx, "/namespaces/foo", newNamespace("foo", nil), nil, 0); !storage.IsExist(err) {
+		t.Errorf("expected key exists error, got %v", err)
+	}
+
+	got := &api.Namespace{}
+	if err := s.Get(ctx, "/namespaces/foo", storage.GetOptions{}, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.Name != "foo" || got.ResourceVersion != out.ResourceVersion {
+		t.Errorf("unexpected object %#v", got)
+	}
+	if err := s.Get(ctx, "/namespaces/foo", storage.GetOptions{ResourceVersion: "100"}, got); !storage.IsTooLargeResourceVersion(err) {
+		t.Errorf("expected too large resource version error, got %v", err)
+	}
+
+	uid := types.UID("other")
+	deleted := &api.Namespace{}
+	err := s.Delete(ctx, "/namespaces/foo", deleted, &storage.Preconditions{UID: &uid}, storage.ValidateAllObjectFunc, nil)
+	if !storage.IsInvalidObj(err) {
+		t.Errorf("expected precondition failure, got %v", err)
+	}
+	if err := s.Delete(ctx, "/namespaces/foo", deleted, nil, storage.ValidateAllObjectFunc, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Get(ctx, "/namespaces/foo", storage.GetOptions{}, got); !storage.IsNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+	if err := s.Get(ctx, "/namespaces/foo", storage.GetOptions{IgnoreNotFound: true}, got); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestEmptyRevision(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(0)
+
+	// like etcd, an empty backend is at revision 1, which is a valid resourceVersion
+	list := &api.NamespaceList{}
+	if err := s.GetList(ctx, "/namespaces", storage.ListOptions{Predicate: storage.Everything, Recursive: true}, list); err != nil {
+		t.Fatal(err)
+	}
+	if list.ResourceVersion != "1" {
+		t.Errorf("expected resourceVersion 1, got %q", list.ResourceVersion)
+	}
+
+	w, err := s.Watch(ctx, "/namespaces", storage.ListOptions{ResourceVersion: list.ResourceVersion, Predicate: storage.Everything, Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	foo := &api.Namespace{}
+	if err := s.Create(ctx, "/namespaces/foo", newNamespace("foo", nil), foo, 0); err != nil {
+		t.Fatal(err)
+	}
+	if foo.ResourceVersion != "2" {
+		t.Errorf("expected resourceVersion 2, got %q", foo.ResourceVersion)
+	}
+	expectEvent(t, w, watch.Added, "foo")
+}
+
+func TestGuaranteedUpdate(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(0)
+
+	created := &api.Namespace{}
+	if err := s.Create(ctx, "/namespaces/foo", newNamespace("foo", nil), created, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	updated := &api.Namespace{}
+	err := s.GuaranteedUpdate(ctx, "/namespaces/foo", updated, false, nil,
+		storage.SimpleUpdate(func(obj runtime.Object) (runtime.Object, error) {
+			ns := obj.(*api.Namespace)
+			ns.Labels = map[string]string{"tier": "backend"}
+			return ns, nil
+		}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if updated.ResourceVersion == created.ResourceVersion || updated.Labels["tier"] != "backend" {
+		t.Errorf("unexpected object %#v", updated)
+	}
+
+	// an update without changes keeps the resourceVersion
+	unchanged := &api.Namespace{}
+	err = s.GuaranteedUpdate(ctx, "/namespaces/foo", unchanged, false, nil,
+		storage.SimpleUpdate(func(obj runtime.Object) (runtime.Object, error) { return obj, nil }), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if unchanged.ResourceVersion != updated.ResourceVersion {
+		t.Errorf("expected resourceVersion %s, got %s", updated.ResourceVersion, unchanged.ResourceVersion)
+	}
+
+	err = s.GuaranteedUpdate(ctx, "/namespaces/bar", &api.Namespace{}, false, nil,
+		storage.SimpleUpdate(func(obj runtime.Object) (runtime.Object, error) { return obj, nil }), nil)
+	if !storage.IsNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+	err = s.GuaranteedUpdate(ctx, "/namespaces/bar", &api.Namespace{}, true, nil,
+		storage.SimpleUpdate(func(obj runtime.Object) (runtime.Object, error) {
+			return newNamespace("bar", nil), nil
+		}), nil)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if count, _ := s.Count("/namespaces"); count != 2 {
+		t.Errorf("expected 2 objects, got %d", count)
+	}
+}
+
+func TestBatch(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(0)
+	if err := s.Create(ctx, "/namespaces/foo", newNamespace("foo", nil), &api.Namespace{}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(ctx, "/namespaces/bar", newNamespace("bar", nil), &api.Namespace{}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := kvstore.NewBatch()
+	batchCtx := kvstore.WithBatch(ctx, batch)
+	created := &api.Namespace{}
+	if err := s.Create(batchCtx, "/namespaces/baz", newNamespace("baz", nil), created, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(batchCtx, "/namespaces/foo", newNamespace("foo", nil), nil, 0); !storage.IsExist(err) {
+		t.Errorf("expected key exists error, got %v", err)
+	}
+	updated := &api.Namespace{}
+	err := s.GuaranteedUpdate(batchCtx, "/namespaces/baz", updated, false, nil,
+		storage.SimpleUpdate(func(obj runtime.Object) (runtime.Object, error) {
+			ns := obj.(*api.Namespace)
+			ns.Labels = map[string]string{"tier": "backend"}
+			return ns, nil
+		}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(batchCtx, "/namespaces/bar", &api.Namespace{}, nil, storage.ValidateAllObjectFunc, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// the staged writes are only seen in the batch
+	got := &api.Namespace{}
+	if err := s.Get(batchCtx, "/namespaces/baz", storage.GetOptions{}, got); err != nil || got.Labels["tier"] != "backend" {
+		t.Errorf("expected the staged update, got %#v, %v", got, err)
+	}
+	if err := s.Get(batchCtx, "/namespaces/bar", storage.GetOptions{}, got); !storage.IsNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+	if err := s.Get(ctx, "/namespaces/baz", storage.GetOptions{}, got); !storage.IsNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+	if err := s.Get(ctx, "/namespaces/bar", storage.GetOptions{}, got); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+
+	if err := batch.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Get(ctx, "/namespaces/baz", storage.GetOptions{}, got); err != nil || got.Labels["tier"] != "backend" {
+		t.Errorf("expected the committed update, got %#v, %v", got, err)
+	}
+	staged, _ := strconv.ParseUint(updated.ResourceVersion, 10, 64)
+	if rev, ok := batch.Revision(staged); !ok || strconv.FormatUint(rev, 10) != got.ResourceVersion {
+		t.Errorf("expected resourceVersion %s, got %d", got.ResourceVersion, rev)
+	}
+	if err := s.Get(ctx, "/namespaces/bar", storage.GetOptions{}, got); !storage.IsNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestBatchConflict(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(0)
+	if err := s.Create(ctx, "/namespaces/foo", newNamespace("foo", nil), &api.Namespace{}, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	batch := kvstore.NewBatch()
+	batchCtx := kvstore.WithBatch(ctx, batch)
+	if err := s.Create(batchCtx, "/namespaces/bar", newNamespace("bar", nil), &api.Namespace{}, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(batchCtx, "/namespaces/foo", &api.Namespace{}, nil, storage.ValidateAllObjectFunc, nil); err != nil {
+		t.Fatal(err)
+	}
+	// foo is modified after the batch read it
+	err := s.GuaranteedUpdate(ctx, "/namespaces/foo", &api.Namespace{}, false, nil,
+		storage.SimpleUpdate(func(obj runtime.Object) (runtime.Object, error) {
+			ns := obj.(*api.Namespace)
+			ns.Labels = map[string]string{"tier": "backend"}
+			return ns, nil
+		}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := batch.Commit(ctx); !apierrors.IsConflict(err) {
+		t.Fatalf("expected conflict error, got %v", err)
+	}
+	if err := s.Get(ctx, "/namespaces/bar", storage.GetOptions{}, &api.Namespace{}); !storage.IsNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+	if err := s.Get(ctx, "/namespaces/foo", storage.GetOptions{}, &api.Namespace{}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestGetListPagination(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(0)
+
+	for i := 0; i < 5; i++ {
+		tier := "frontend"
+		if i%2 == 0 {
+			tier = "backend"
+		}
+		name := fmt.Sprintf("ns-%d", i)
+		if err := s.Create(ctx, "/namespaces/"+name, newNamespace(name, map[string]string{"tier": tier}), nil, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var names []string
+	continueToken := ""
+	for pages := 0; ; pages++ {
+		pred := storage.Everything
+		pred.Limit = 2
+		pred.Continue = continueToken
+		list := &api.NamespaceList{}
+		if err := s.GetList(ctx, "/namespaces", storage.ListOptions{Predicate: pred, Recursive: true}, list); err != nil {
+			t.Fatal(err)
+		}
+		for _, ns := range list.Items {
+			names = append(names, ns.Name)
+		}
+		continueToken = list.Continue
+		if continueToken == "" {
+			break
+		}
+		if pages > 5 {
+			t.Fatalf("too many pages")
+		}
+	}
+	if fmt.Sprint(names) != "[ns-0 ns-1 ns-2 ns-3 ns-4]" {
+		t.Errorf("unexpected items %v", names)
+	}
+
+	list := &api.NamespaceList{}
+	opts := storage.ListOptions{Predicate: labelPredicate("backend"), Recursive: true}
+	if err := s.GetList(ctx, "/namespaces", opts, list); err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Items) != 3 {
+		t.Errorf("expected 3 backend namespaces, got %d", len(list.Items))
+	}
+}
+
+func TestGetListPaginationWithWrites(t *testing.T) {
+	ctx := context.Background()
+
+	testCases := []struct {
+		name        string
+		historySize int
+		write       func(s storage.Interface) error
+		expectNames string
+		expectGone  bool
+	}{
+		{
+			name:        "no write",
+			write:       func(s storage.Interface) error { return nil },
+			expectNames: "[ns-2 ns-3]",
+		},
+		{
+			name: "write to a key of a former page",
+			write: func(s storage.Interface) error {
+				return s.Delete(ctx, "/namespaces/ns-0", &api.Namespace{}, nil, storage.ValidateAllObjectFunc, nil)
+			},
+			expectNames: "[ns-2 ns-3]",
+		},
+		{
+			name: "write to another resource",
+			write: func(s storage.Interface) error {
+				return s.Create(ctx, "/other/ns-5", newNamespace("ns-5", nil), nil, 0)
+			},
+			expectNames: "[ns-2 ns-3]",
+		},
+		{
+			name: "create a key of the next page",
+			write: func(s storage.Interface) error {
+				return s.Create(ctx, "/namespaces/ns-5", newNamespace("ns-5", nil), nil, 0)
+			},
+			expectGone: true,
+		},
+		{
+			name: "delete a key of the next page",
+			write: func(s storage.Interface) error {
+				return s.Delete(ctx, "/namespaces/ns-3", &api.Namespace{}, nil, storage.ValidateAllObjectFunc, nil)
+			},
+			expectGone: true,
+		},
+		{
+			name:        "compacted writes to another resource",
+			historySize: 1,
+			write: func(s storage.Interface) error {
+				if err := s.Create(ctx, "/other/ns-5", newNamespace("ns-5", nil), nil, 0); err != nil {
+					return err
+				}
+				return s.Create(ctx, "/other/ns-6", newNamespace("ns-6", nil), nil, 0)
+			},
+			expectGone: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			s := newTestStore(tc.historySize)
+			for i := 0; i < 4; i++ {
+				name := fmt.Sprintf("ns-%d", i)
+				if err := s.Create(ctx, "/namespaces/"+name, newNamespace(name, nil), nil, 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			pred := storage.Everything
+			pred.Limit = 2
+			first := &api.NamespaceList{}
+			if err := s.GetList(ctx, "/namespaces", storage.ListOptions{Predicate: pred, Recursive: true}, first); err != nil {
+				t.Fatal(err)
+			}
+			if err := tc.write(s); err != nil {
+				t.Fatal(err)
+			}
+
+			// the next page is served as of the first one, or not at all
+			pred.Continue = first.Continue
+			list := &api.NamespaceList{}
+			err := s.GetList(ctx, "/namespaces", storage.ListOptions{Predicate: pred, Recursive: true}, list)
+			if tc.expectGone {
+				if !apierrors.IsResourceExpired(err) {
+					t.Errorf("expected resource expired error, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if list.ResourceVersion != first.ResourceVersion {
+				t.Errorf("expected resourceVersion %s, got %s", first.ResourceVersion, list.ResourceVersion)
+			}
+			var names []string
+			for _, ns := range list.Items {
+				names = append(names, ns.Name)
+			}
+			if fmt.Sprint(names) != tc.expectNames || list.Continue != "" {
+				t.Errorf("unexpected last page %v, continue %q", names, list.Continue)
+			}
+		})
+	}
+}
+
+func TestGetListExact(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(0)
+
+	foo := &api.Namespace{}
+	if err := s.Create(ctx, "/namespaces/foo", newNamespace("foo", nil), foo, 0); err != nil {
+		t.Fatal(err)
+	}
+	exact := func(key string, recursive bool) error {
+		opts := storage.ListOptions{
+			ResourceVersion:      foo.ResourceVersion,
+			ResourceVersionMatch: metav1.ResourceVersionMatchExact,
+			Predicate:            storage.Everything,
+			Recursive:            recursive,
+		}
+		list := &api.NamespaceList{}
+		if err := s.GetList(ctx, key, opts, list); err != nil {
+			return err
+		}
+		if list.ResourceVersion != foo.ResourceVersion {
+			t.Errorf("expected resourceVersion %s, got %s", foo.ResourceVersion, list.ResourceVersion)
+		}
+		return nil
+	}
+
+	if err := exact("/namespaces", true); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := exact("/namespaces/foo", false); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := s.Create(ctx, "/namespaces/bar", newNamespace("bar", nil), nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	// the state at the revision of foo is gone once anything is written
+	if err := exact("/namespaces", true); !apierrors.IsResourceExpired(err) {
+		t.Errorf("expected resource expired error, got %v", err)
+	}
+	if err := exact("/namespaces/foo", false); !apierrors.IsResourceExpired(err) {
+		t.Errorf("expected resource expired error, got %v", err)
+	}
+}
+
+func TestGetListSelected(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	install.Install(scheme)
+	codec := serializer.NewCodecFactory(scheme).LegacyCodec(apiv1.SchemeGroupVersion)
+	backend := NewBackend(0)
+	newStore := func(getAttrs storage.AttrFunc) storage.Interface {
+		return kvstore.New(backend, codec,
+			func() runtime.Object { return &api.Namespace{} },
+			func() runtime.Object { return &api.NamespaceList{} },
+			"/registry", nil, getAttrs)
+	}
+	s := newStore(labelPredicate("").GetAttrs)
+
+	for name, tier := range map[string]string{"foo": "web", "bar": "db", "baz": "web", "qux": "web"} {
+		if err := s.Create(ctx, "/namespaces/"+name, newNamespace(name, map[string]string{"tier": tier}), nil, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// old is stored without attributes, e.g. before they were kept
+	if err := newStore(nil).Create(ctx, "/namespaces/old", newNamespace("old", map[string]string{"tier": "db"}), nil, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the keys without attributes are always listed
+	selector := kvstore.NewSelector(labelPredicate("web"))
+	kvs, _, _, err := backend.ListSelected(ctx, "/registry/namespaces/", "", 0, selector)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, kv := range kvs {
+		keys = append(keys, kv.Key)
+	}
+	if fmt.Sprint(keys) != "[/registry/namespaces/baz /registry/namespaces/foo /registry/namespaces/old /registry/namespaces/qux]" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	// and filtered by the predicate
+	var names []string
+	continueToken := ""
+	for pages := 0; ; pages++ {
+		pred := labelPredicate("web")
+		pred.Limit = 1
+		pred.Continue = continueToken
+		list := &api.NamespaceList{}
+		if err := s.GetList(ctx, "/namespaces", storage.ListOptions{Predicate: pred, Recursive: true}, list); err != nil {
+			t.Fatal(err)
+		}
+		for _, ns := range list.Items {
+			names = append(names, ns.Name)
+		}
+		continueToken = list.Continue
+		if continueToken == "" {
+			break
+		}
+		if pages > 5 {
+			t.Fatalf("too many pages")
+		}
+	}
+	if fmt.Sprint(names) != "[baz foo qux]" {
+		t.Errorf("unexpected items %v", names)
+	}
+}
+
+// replicatedBackend serves the reads that don't need the latest write from replica,
+// once replica has caught up.
+type replicatedBackend struct {
+	*Backend
+	replica *Backend
+}
+
+func (b *replicatedBackend) Replica(minRev uint64) kvstore.Reader {
+	if rev, _ := b.replica.CurrentRevision(context.Background()); rev < minRev {
+		return nil
+	}
+	return b.replica
+}
+
+func TestReadFromReplica(t *testing.T) {
+	ctx := context.Background()
+	scheme := runtime.NewScheme()
+	install.Install(scheme)
+	codec := serializer.NewCodecFactory(scheme).LegacyCodec(apiv1.SchemeGroupVersion)
+	newStore := func(kv kvstore.KV) storage.Interface {
+		return kvstore.New(kv, codec,
+			func() runtime.Object { return &api.Namespace{} },
+			func() runtime.Object { return &api.NamespaceList{} },
+			"/registry", nil, nil)
+	}
+
+	// the primary is at revision 4, the replica holds another state at revision 3
+	primary, replica := NewBackend(0), NewBackend(0)
+	for _, name := range []string{"foo", "bar", "baz"} {
+		if err := newStore(primary).Create(ctx, "/namespaces/"+name, newNamespace(name, map[string]string{"tier": "primary"}), nil, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, name := range []string{"foo", "bar"} {
+		if err := newStore(replica).Create(ctx, "/namespaces/"+name, newNamespace(name, map[string]string{"tier": "replica"}), nil, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newStore(&replicatedBackend{Backend: primary, replica: replica})
+
+	testCases := []struct {
+		resourceVersion string
+		expectTier      string
+	}{
+		{resourceVersion: "", expectTier: "primary"},
+		{resourceVersion: "0", expectTier: "replica"},
+		{resourceVersion: "3", expectTier: "replica"},
+		{resourceVersion: "4", expectTier: "primary"},
+	}
+	for _, testcase := range testCases {
+		got := &api.Namespace{}
+		if err := s.Get(ctx, "/namespaces/foo", storage.GetOptions{ResourceVersion: testcase.resourceVersion}, got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Labels["tier"] != testcase.expectTier {
+			t.Errorf("get at %q: expected the %s to be read, got %s", testcase.resourceVersion, testcase.expectTier, got.Labels["tier"])
+		}
+
+		list := &api.NamespaceList{}
+		opts := storage.ListOptions{ResourceVersion: testcase.resourceVersion, Recursive: true, Predicate: storage.Everything}
+		if err := s.GetList(ctx, "/namespaces", opts, list); err != nil {
+			t.Fatal(err)
+		}
+		if len(list.Items) == 0 || list.Items[0].Labels["tier"] != testcase.expectTier {
+			t.Errorf("list at %q: expected the %s to be read, got %v", testcase.resourceVersion, testcase.expectTier, list.Items)
+		}
+	}
+
+	if err := s.Get(ctx, "/namespaces/foo", storage.GetOptions{ResourceVersion: "5"}, &api.Namespace{}); !storage.IsTooLargeResourceVersion(err) {
+		t.Errorf("expected too large resource version error, got %v", err)
+	}
+	if count, err := s.Count("/namespaces"); err != nil || count != 2 {
+		t.Errorf("expected the replica to count 2 namespaces, got %d: %v", count, err)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(0)
+
+	created := &api.Namespace{}
+	if err := s.Create(ctx, "/namespaces/foo", newNamespace("foo", map[string]string{"tier": "backend"}), created, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// a zero resourceVersion starts with the current state
+	w, err := s.Watch(ctx, "/namespaces", storage.ListOptions{ResourceVersion: "0", Predicate: labelPredicate("backend"), Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	expectEvent(t, w, watch.Added, "foo")
+
+	// resuming from the create revision replays everything after it
+	resumed, err := s.Watch(ctx, "/namespaces", storage.ListOptions{ResourceVersion: created.ResourceVersion, Predicate: storage.Everything, Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Stop()
+
+	if err := s.Create(ctx, "/namespaces/bar", newNamespace("bar", map[string]string{"tier": "frontend"}), nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	err = s.GuaranteedUpdate(ctx, "/namespaces/foo", &api.Namespace{}, false, nil,
+		storage.SimpleUpdate(func(obj runtime.Object) (runtime.Object, error) {
+			ns := obj.(*api.Namespace)
+			ns.Labels = map[string]string{"tier": "frontend"}
+			return ns, nil
+		}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// foo leaves the selector, so the filtered watcher sees it deleted
+	expectEvent(t, w, watch.Deleted, "foo")
+	expectEvent(t, resumed, watch.Added, "bar")
+	expectEvent(t, resumed, watch.Modified, "foo")
+}
+
+func TestWatchTooOldResourceVersion(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(2)
+
+	for i := 0; i < 5; i++ {
+		name := fmt.Sprintf("ns-%d", i)
+		if err := s.Create(ctx, "/namespaces/"+name, newNamespace(name, nil), nil, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	_, err := s.Watch(ctx, "/namespaces", storage.ListOptions{ResourceVersion: "1", Predicate: storage.Everything, Recursive: true})
+	if !apierrors.IsResourceExpired(err) {
+		t.Errorf("expected resource expired error, got %v", err)
+	}
+
+	// the revisions start at 1, ns-3 and ns-4 are created at 5 and 6
+	w, err := s.Watch(ctx, "/namespaces", storage.ListOptions{ResourceVersion: "4", Predicate: storage.Everything, Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	expectEvent(t, w, watch.Added, "ns-3")
+	expectEvent(t, w, watch.Added, "ns-4")
+}
+
+func TestWatchProgressNotify(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(0)
+
+	progress, err := s.Watch(ctx, "/namespaces/foo", storage.ListOptions{ResourceVersion: "0", Predicate: storage.Everything, ProgressNotify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer progress.Stop()
+	plain, err := s.Watch(ctx, "/namespaces/foo", storage.ListOptions{ResourceVersion: "0", Predicate: storage.Everything})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer plain.Stop()
+
+	bar := &api.Namespace{}
+	if err := s.Create(ctx, "/namespaces/bar", newNamespace("bar", nil), bar, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(ctx, "/namespaces/foo", newNamespace("foo", nil), nil, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the write to bar is reported as a bookmark at its revision, before the events after it
+	select {
+	case e := <-progress.ResultChan():
+		ns, isNamespace := e.Object.(*api.Namespace)
+		if e.Type != watch.Bookmark || !isNamespace || ns.ResourceVersion != bar.ResourceVersion {
+			t.Errorf("expected a bookmark at %s, got %s %#v", bar.ResourceVersion, e.Type, e.Object)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatal("timed out waiting for a bookmark")
+	}
+	expectEvent(t, progress, watch.Added, "foo")
+	// without progress notifications only the changes of foo are sent
+	expectEvent(t, plain, watch.Added, "foo")
+}
+
+func TestRequestWatchProgress(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(0)
+
+	foo := &api.Namespace{}
+	if err := s.Create(ctx, "/namespaces/foo", newNamespace("foo", nil), foo, 0); err != nil {
+		t.Fatal(err)
+	}
+	w, err := s.Watch(ctx, "/namespaces", storage.ListOptions{ResourceVersion: foo.ResourceVersion, Predicate: storage.Everything, Recursive: true, ProgressNotify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	// without writes the watch only learns its revision from a progress request
+	if err := s.RequestWatchProgress(ctx); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case e := <-w.ResultChan():
+		ns, isNamespace := e.Object.(*api.Namespace)
+		if e.Type != watch.Bookmark || !isNamespace || ns.ResourceVersion != foo.ResourceVersion {
+			t.Errorf("expected a bookmark at %s, got %s %#v", foo.ResourceVersion, e.Type, e.Object)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatal("timed out waiting for a bookmark")
+	}
+
+	// a KV without progress requests fails them, rather than leaving the client waiting
+	scheme := runtime.NewScheme()
+	install.Install(scheme)
+	codec := serializer.NewCodecFactory(scheme).LegacyCodec(apiv1.SchemeGroupVersion)
+	plain := kvstore.New(struct{ kvstore.KV }{NewBackend(0)}, codec,
+		func() runtime.Object { return &api.Namespace{} },
+		func() runtime.Object { return &api.NamespaceList{} },
+		"/registry", nil, nil)
+	if err := plain.RequestWatchProgress(ctx); err == nil {
+		t.Errorf("expected an error requesting the progress of a KV without progress requests")
+	}
+}
+
+// compactedBackend fails every watch as if its events had been compacted.
+type compactedBackend struct {
+	*Backend
+}
+
+func (b *compactedBackend) Watch(ctx context.Context, key string, recursive bool, rev uint64) (<-chan *kvstore.Event, error) {
+	events := make(chan *kvstore.Event, 1)
+	events <- &kvstore.Event{Err: kvstore.NewCompactedError(rev, rev+10)}
+	close(events)
+	return events, nil
+}
+
+func TestWatchCompacted(t *testing.T) {
+	scheme := runtime.NewScheme()
+	install.Install(scheme)
+	codec := serializer.NewCodecFactory(scheme).LegacyCodec(apiv1.SchemeGroupVersion)
+	s := kvstore.New(&compactedBackend{Backend: NewBackend(0)}, codec,
+		func() runtime.Object { return &api.Namespace{} },
+		func() runtime.Object { return &api.NamespaceList{} },
+		"/registry", nil, nil)
+
+	w, err := s.Watch(context.Background(), "/namespaces", storage.ListOptions{ResourceVersion: "1", Predicate: storage.Everything, Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	// the client must see 410 Gone to list again, not an internal error
+	select {
+	case e := <-w.ResultChan():
+		status, isStatus := e.Object.(*metav1.Status)
+		if e.Type != watch.Error || !isStatus || status.Reason != metav1.StatusReasonExpired {
+			t.Errorf("expected an expired error, got %s %#v", e.Type, e.Object)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatal("timed out waiting for the watch error")
+	}
+}
+
+func expectEvent(t *testing.T, w watch.Interface, eventType watch.EventType, name string) {
+	t.Helper()
+	select {
+	case e, ok := <-w.ResultChan():
+		if !ok {
+			t.Fatalf("watch closed, expected %s %s", eventType, name)
+		}
+		ns, isNamespace := e.Object.(*api.Namespace)
+		if e.Type != eventType || !isNamespace || ns.Name != name {
+			t.Errorf("expected %s %s, got %s %#v", eventType, name, e.Type, e.Object)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("timed out waiting for %s %s", eventType, name)
+	}
+}
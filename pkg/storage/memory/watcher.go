@@ -0,0 +1,89 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package memory
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+)
+
+// Watch implements kvstore.KV.
+func (b *Backend) Watch(ctx context.Context, key string, recursive bool, rev uint64) (<-chan *kvstore.Event, error) {
+	w := kvstore.NewKeyWatcher(ctx, key, recursive)
+
+	b.lock.Lock()
+	var initial []*kvstore.Event
+	if rev == 0 {
+		for k, it := range b.items {
+			if w.MatchesKey(k) {
+				initial = append(initial, &kvstore.Event{Key: k, Revision: it.rev, Value: it.data})
+			}
+		}
+		sort.Slice(initial, func(i, j int) bool { return initial[i].Revision < initial[j].Revision })
+	} else {
+		if rev < b.compactedRev {
+			b.lock.Unlock()
+			w.Stop()
+			return nil, apierrors.NewResourceExpired(fmt.Sprintf("too old resource version: %d (%d)", rev, b.compactedRev+1))
+		}
+		for _, e := range b.history {
+			if e.Revision > rev && w.MatchesKey(e.Key) {
+				initial = append(initial, e)
+			}
+		}
+	}
+	id := b.nextWatcherID
+	b.nextWatcherID++
+	b.watchers[id] = w
+	b.lock.Unlock()
+
+	go func() {
+		defer b.removeWatcher(id)
+		// the initial events are read with the lock held, every queued event is newer
+		w.Run(func(w *kvstore.KeyWatcher) (uint64, error) {
+			for _, e := range initial {
+				if !w.Send(e) {
+					return 0, w.Context().Err()
+				}
+			}
+			return 0, nil
+		})
+	}()
+	return w.ResultChan(), nil
+}
+
+// RequestProgress implements kvstore.ProgressKV, the events are queued with the lock held
+// as they are committed, so every watcher is at the current revision.
+func (b *Backend) RequestProgress(ctx context.Context) error {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, w := range b.watchers {
+		w.RequestProgress(b.rev)
+	}
+	return nil
+}
+
+func (b *Backend) removeWatcher(id int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.watchers, id)
+}
@@ -0,0 +1,493 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+)
+
+const (
+	pingTimeout = 10 * time.Second
+
+	// compactBatchSize is the number of events removed per statement, to keep the transactions small.
+	compactBatchSize = 1000
+)
+
+// schema creates the tables. Keys are binary, so they are compared byte wise. The row 1
+// of apimaster_revision holds the revision of the last write, every write locks it, so
+// revisions always become visible in order and pollers never skip one. The row 2 holds
+// the revision the events have been compacted to. apimaster_attributes holds the labels
+// and fields of the objects to select them by.
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS apimaster_kv (
+		name VARBINARY(767) NOT NULL PRIMARY KEY,
+		value LONGBLOB NOT NULL,
+		revision BIGINT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS apimaster_events (
+		revision BIGINT NOT NULL PRIMARY KEY,
+		name VARBINARY(767) NOT NULL,
+		value LONGBLOB NULL,
+		prev_value LONGBLOB NULL,
+		deleted BOOLEAN NOT NULL DEFAULT FALSE,
+		INDEX apimaster_events_name (name)
+	)`,
+	`CREATE TABLE IF NOT EXISTS apimaster_attributes (
+		name VARBINARY(767) NOT NULL,
+		kind TINYINT NOT NULL,
+		attr VARBINARY(317) NOT NULL,
+		value VARBINARY(1024) NOT NULL,
+		PRIMARY KEY (name, kind, attr),
+		INDEX apimaster_attributes_value (kind, attr, value)
+	)`,
+	`CREATE TABLE IF NOT EXISTS apimaster_revision (
+		id TINYINT NOT NULL PRIMARY KEY,
+		revision BIGINT NOT NULL
+	)`,
+	// like etcd, the revision of an empty database is 1, which is a valid resourceVersion
+	`INSERT IGNORE INTO apimaster_revision (id, revision) VALUES (1, 1)`,
+	`INSERT IGNORE INTO apimaster_revision (id, revision) VALUES (2, 0)`,
+}
+
+// Backend stores the objects of every resource in one mysql database. Every
+// write is recorded in an event table, which is polled to drive the watches of
+// all apimaster instances sharing the database.
+type Backend struct {
+	reader
+	config Config
+	// replicas serve the reads that don't have to observe the latest write.
+	replicas    []*replica
+	nextReplica uint32
+
+	lock          sync.Mutex
+	watchers      map[int]*kvstore.KeyWatcher
+	nextWatcherID int
+	// dispatchedRev is the revision of the last event sent to the watchers.
+	dispatchedRev uint64
+	// written is signaled after a local write and a progress request, to poll without
+	// waiting for the next tick.
+	written chan struct{}
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	// background tracks the replica checks and the compactor.
+	background sync.WaitGroup
+}
+
+var _ kvstore.ReplicaKV = &Backend{}
+var _ kvstore.IndexedKV = &Backend{}
+var _ kvstore.TxnKV = &Backend{}
+var _ kvstore.ProgressKV = &Backend{}
+var _ kvstore.SelectReader = &Backend{}
+var _ kvstore.ChangesReader = &Backend{}
+
+// reader reads the keys of one database, the primary or a replica.
+type reader struct {
+	db               *sql.DB
+	listDefaultLimit int
+}
+
+// NewBackend connects to the first reachable server of config, creates the
+// tables if needed and starts polling for new revisions.
+func NewBackend(config Config) (*Backend, error) {
+	db, err := open(config)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create mysql schema: %v", err)
+		}
+	}
+
+	replicas, err := openReplicas(config)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Backend{
+		reader:   reader{db: db, listDefaultLimit: config.ListDefaultLimit},
+		config:   config,
+		replicas: replicas,
+		watchers: map[int]*kvstore.KeyWatcher{},
+		written:  make(chan struct{}, 1),
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	rev, err := b.CurrentRevision(ctx)
+	if err != nil {
+		cancel()
+		b.closeDBs()
+		return nil, err
+	}
+	b.dispatchedRev = rev
+
+	go b.run(ctx)
+	b.background.Add(1)
+	go func() {
+		defer b.background.Done()
+		b.checkReplicas(ctx)
+	}()
+	if config.CompactionInterval > 0 {
+		b.background.Add(1)
+		go func() {
+			defer b.background.Done()
+			kvstore.RunCompactor(ctx, StorageTypeMysql, b, config.CompactionInterval, config.CompactionRetention)
+		}()
+	}
+	return b, nil
+}
+
+// open returns a connection pool to the first reachable server.
+func open(config Config) (*sql.DB, error) {
+	var errs []error
+	for i, server := range config.ServerList {
+		db, err := newDB(config, server)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("server %d: %v", i, err))
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		err = db.PingContext(ctx)
+		cancel()
+		if err != nil {
+			db.Close()
+			errs = append(errs, fmt.Errorf("server %d: %v", i, err))
+			continue
+		}
+		return db, nil
+	}
+	if len(errs) == 0 {
+		return nil, errors.New("no mysql server configured")
+	}
+	return nil, fmt.Errorf("unable to connect to mysql: %v", utilerrors.NewAggregate(errs))
+}
+
+// newDB returns a connection pool to server, which connects on first use.
+func newDB(config Config, server string) (*sql.DB, error) {
+	dsn, err := config.DSN(server)
+	if err != nil {
+		return nil, err
+	}
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(config.MaxOpenConns)
+	db.SetMaxIdleConns(config.MaxIdleConns)
+	db.SetConnMaxLifetime(config.ConnMaxLifetime)
+	return db, nil
+}
+
+// Close stops every watch and closes the database connections.
+func (b *Backend) Close() error {
+	b.cancel()
+	<-b.done
+	b.background.Wait()
+	return b.closeDBs()
+}
+
+// closeDBs closes the connections to the primary and the replicas.
+func (b *Backend) closeDBs() error {
+	errs := []error{b.db.Close()}
+	for _, r := range b.replicas {
+		errs = append(errs, r.db.Close())
+	}
+	return utilerrors.NewAggregate(errs)
+}
+
+// DB returns the connection pool, e.g. to check the database or to expose its statistics.
+func (b *Backend) DB() *sql.DB {
+	return b.db
+}
+
+// CurrentRevision implements kvstore.Reader.
+func (r *reader) CurrentRevision(ctx context.Context) (uint64, error) {
+	return currentRevision(ctx, r.db, "")
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+// currentRevision reads the revision of the last write, lock is appended to the query.
+func currentRevision(ctx context.Context, q queryer, lock string) (uint64, error) {
+	var rev int64
+	if err := q.QueryRowContext(ctx, `SELECT revision FROM apimaster_revision WHERE id = 1`+lock).Scan(&rev); err != nil {
+		return 0, err
+	}
+	return uint64(rev), nil
+}
+
+// compactedRevision reads the revision the events have been compacted to.
+func compactedRevision(ctx context.Context, q queryer) (uint64, error) {
+	var rev int64
+	if err := q.QueryRowContext(ctx, `SELECT revision FROM apimaster_revision WHERE id = 2`).Scan(&rev); err != nil {
+		return 0, err
+	}
+	return uint64(rev), nil
+}
+
+// Compact implements kvstore.Compactor. The compacted revision is recorded before the
+// events are removed, so watches never miss a removed event unnoticed.
+func (b *Backend) Compact(ctx context.Context, rev uint64) (int64, error) {
+	_, err := b.db.ExecContext(ctx, `UPDATE apimaster_revision SET revision = GREATEST(revision, ?) WHERE id = 2`, int64(rev))
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for {
+		result, err := b.db.ExecContext(ctx, `DELETE FROM apimaster_events WHERE revision <= ? ORDER BY revision LIMIT ?`,
+			int64(rev), compactBatchSize)
+		if err != nil {
+			return deleted, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+		if n < compactBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// Get implements kvstore.Reader.
+func (r *reader) Get(ctx context.Context, key string) (*kvstore.KeyValue, error) {
+	kv := &kvstore.KeyValue{Key: key}
+	var rev int64
+	err := r.db.QueryRowContext(ctx, `SELECT value, revision FROM apimaster_kv WHERE name = ?`, key).Scan(&kv.Value, &rev)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	kv.Revision = uint64(rev)
+	return kv, nil
+}
+
+// List implements kvstore.Reader.
+func (r *reader) List(ctx context.Context, prefix, fromKey string, limit int64) ([]*kvstore.KeyValue, int64, uint64, error) {
+	return r.ListSelected(ctx, prefix, fromKey, limit, nil)
+}
+
+// ListSelected implements kvstore.SelectReader. All pages are read in one repeatable
+// read transaction, so the result is consistent with the returned revision.
+func (r *reader) ListSelected(ctx context.Context, prefix, fromKey string, limit int64, selector kvstore.Selector) ([]*kvstore.KeyValue, int64, uint64, error) {
+	tx, err := r.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer tx.Rollback()
+
+	// the first read of the transaction takes the snapshot every later read sees
+	rev, err := currentRevision(ctx, tx, "")
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	pageSize := limit
+	if pageSize <= 0 {
+		pageSize = int64(r.listDefaultLimit)
+	}
+	end := prefixEnd(prefix)
+	selection, selectionArgs := selectionClause(selector)
+	args := func(fromKey string, more ...interface{}) []interface{} {
+		return append(append([]interface{}{fromKey, end}, selectionArgs...), more...)
+	}
+
+	var kvs []*kvstore.KeyValue
+	query := `SELECT name, value, revision FROM apimaster_kv WHERE name >= ? AND name < ?` + selection + ` ORDER BY name LIMIT ?`
+	for {
+		page, err := queryKeyValues(ctx, tx, query, args(fromKey, pageSize)...)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		kvs = append(kvs, page...)
+		if int64(len(page)) < pageSize {
+			return kvs, 0, rev, nil
+		}
+		fromKey = page[len(page)-1].Key
+		if limit > 0 {
+			break
+		}
+		query = `SELECT name, value, revision FROM apimaster_kv WHERE name > ? AND name < ?` + selection + ` ORDER BY name LIMIT ?`
+	}
+
+	var remaining int64
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM apimaster_kv WHERE name > ? AND name < ?`+selection, args(fromKey)...).Scan(&remaining)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return kvs, remaining, rev, nil
+}
+
+type rowsQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func queryKeyValues(ctx context.Context, q rowsQueryer, query string, args ...interface{}) ([]*kvstore.KeyValue, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var kvs []*kvstore.KeyValue
+	for rows.Next() {
+		kv := &kvstore.KeyValue{}
+		var rev int64
+		if err := rows.Scan(&kv.Key, &kv.Value, &rev); err != nil {
+			return nil, err
+		}
+		kv.Revision = uint64(rev)
+		kvs = append(kvs, kv)
+	}
+	return kvs, rows.Err()
+}
+
+// Count implements kvstore.Reader.
+func (r *reader) Count(ctx context.Context, prefix string) (int64, error) {
+	var count int64
+	err := r.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM apimaster_kv WHERE name >= ? AND name < ?`,
+		prefix, prefixEnd(prefix)).Scan(&count)
+	return count, err
+}
+
+// ChangedSince implements kvstore.ChangesReader. The compacted revision is read after the
+// events, as it is raised before the events are removed.
+func (r *reader) ChangedSince(ctx context.Context, prefix, fromKey string, rev uint64) (bool, error) {
+	var found int
+	err := r.db.QueryRowContext(ctx, `SELECT 1 FROM apimaster_events WHERE revision > ? AND name >= ? AND name < ? LIMIT 1`,
+		int64(rev), fromKey, prefixEnd(prefix)).Scan(&found)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+	compactedRev, err := compactedRevision(ctx, r.db)
+	if err != nil {
+		return false, err
+	}
+	if rev < compactedRev {
+		return false, kvstore.NewCompactedError(rev, compactedRev)
+	}
+	return found == 1, nil
+}
+
+// Commit implements kvstore.KV.
+func (b *Backend) Commit(ctx context.Context, key string, expectedRev uint64, value []byte) (uint64, bool, error) {
+	return b.CommitWithAttributes(ctx, key, expectedRev, value, nil)
+}
+
+// CommitWithAttributes implements kvstore.IndexedKV.
+func (b *Backend) CommitWithAttributes(ctx context.Context, key string, expectedRev uint64, value []byte, attrs *kvstore.Attributes) (uint64, bool, error) {
+	return b.CommitTxn(ctx, []kvstore.Op{{Key: key, ExpectedRev: expectedRev, Value: value, Attrs: attrs}})
+}
+
+// CommitTxn implements kvstore.TxnKV.
+func (b *Backend) CommitTxn(ctx context.Context, ops []kvstore.Op) (uint64, bool, error) {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	// locking the revision row serializes the writes
+	rev, err := currentRevision(ctx, tx, " FOR UPDATE")
+	if err != nil {
+		return 0, false, err
+	}
+	for _, op := range ops {
+		rev++
+		if ok, err := commitOp(ctx, tx, rev, op); err != nil || !ok {
+			return 0, false, err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, `UPDATE apimaster_revision SET revision = ? WHERE id = 1`, int64(rev)); err != nil {
+		return 0, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, false, err
+	}
+
+	select {
+	case b.written <- struct{}{}:
+	default:
+	}
+	return rev, true, nil
+}
+
+// commitOp writes op at rev in tx, it returns false if the key is not at the expected revision.
+func commitOp(ctx context.Context, tx *sql.Tx, rev uint64, op kvstore.Op) (bool, error) {
+	var prevValue []byte
+	var prevRev int64
+	err := tx.QueryRowContext(ctx, `SELECT value, revision FROM apimaster_kv WHERE name = ? FOR UPDATE`, op.Key).Scan(&prevValue, &prevRev)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if op.ExpectedRev != 0 {
+			return false, nil
+		}
+	case err != nil:
+		return false, err
+	case uint64(prevRev) != op.ExpectedRev:
+		return false, nil
+	}
+
+	if op.Value == nil {
+		_, err = tx.ExecContext(ctx, `DELETE FROM apimaster_kv WHERE name = ?`, op.Key)
+	} else {
+		_, err = tx.ExecContext(ctx, `INSERT INTO apimaster_kv (name, value, revision) VALUES (?, ?, ?)
+			ON DUPLICATE KEY UPDATE value = VALUES(value), revision = VALUES(revision)`, op.Key, op.Value, int64(rev))
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := writeAttributes(ctx, tx, op.Key, op.Attrs); err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO apimaster_events (revision, name, value, prev_value, deleted) VALUES (?, ?, ?, ?, ?)`,
+		int64(rev), op.Key, op.Value, prevValue, op.Value == nil)
+	return err == nil, err
+}
+
+// prefixEnd returns the smallest key greater than every key with prefix.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	// no limit, every byte of the prefix is 0xff
+	return "\xff"
+}
@@ -0,0 +1,419 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	api "github.com/seanchann/apimaster/pkg/apis/coreres"
+	"github.com/seanchann/apimaster/pkg/apis/coreres/install"
+	apiv1 "github.com/seanchann/apimaster/pkg/apis/coreres/v1"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/storage"
+
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+	"github.com/seanchann/apimaster/pkg/storage/mysql/mysqltest"
+)
+
+// newTestBackend connects a backend to the database of dsn, which polls it every 10ms.
+func newTestBackend(t *testing.T, dsn string) *Backend {
+	t.Helper()
+	config := NewDefaultConfig()
+	config.ServerList = []string{dsn}
+	config.WatchPollInterval = 10 * time.Millisecond
+	config.CompactionInterval = 0
+	b, err := NewBackend(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { b.Close() })
+	return b
+}
+
+func newTestStore(b *Backend) storage.Interface {
+	scheme := runtime.NewScheme()
+	install.Install(scheme)
+	codec := serializer.NewCodecFactory(scheme).LegacyCodec(apiv1.SchemeGroupVersion)
+	return kvstore.New(b, codec,
+		func() runtime.Object { return &api.Namespace{} },
+		func() runtime.Object { return &api.NamespaceList{} },
+		"/registry", nil, labelPredicate("").GetAttrs)
+}
+
+func newNamespace(name string, labels map[string]string) *api.Namespace {
+	return &api.Namespace{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+}
+
+func labelPredicate(selector string) storage.SelectionPredicate {
+	return storage.SelectionPredicate{
+		Label: labels.SelectorFromSet(labels.Set{"tier": selector}),
+		GetAttrs: func(obj runtime.Object) (labels.Set, fields.Set, error) {
+			return labels.Set(obj.(*api.Namespace).Labels), nil, nil
+		},
+	}
+}
+
+func setTier(tier string) storage.UpdateFunc {
+	return storage.SimpleUpdate(func(obj runtime.Object) (runtime.Object, error) {
+		ns := obj.(*api.Namespace)
+		ns.Labels = map[string]string{"tier": tier}
+		return ns, nil
+	})
+}
+
+func TestBackendCreateUpdateDelete(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(newTestBackend(t, mysqltest.NewTestServer(t)))
+
+	created := &api.Namespace{}
+	if err := s.Create(ctx, "/namespaces/foo", newNamespace("foo", nil), created, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Create(ctx, "/namespaces/foo", newNamespace("foo", nil), nil, 0); !storage.IsExist(err) {
+		t.Errorf("expected key exists error, got %v", err)
+	}
+
+	updated := &api.Namespace{}
+	if err := s.GuaranteedUpdate(ctx, "/namespaces/foo", updated, false, nil, setTier("backend"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if updated.ResourceVersion == created.ResourceVersion || updated.Labels["tier"] != "backend" {
+		t.Errorf("unexpected object %#v", updated)
+	}
+
+	got := &api.Namespace{}
+	if err := s.Get(ctx, "/namespaces/foo", storage.GetOptions{}, got); err != nil {
+		t.Fatal(err)
+	}
+	if got.ResourceVersion != updated.ResourceVersion {
+		t.Errorf("expected resourceVersion %s, got %s", updated.ResourceVersion, got.ResourceVersion)
+	}
+
+	// a precondition on the old resourceVersion fails
+	stale := &storage.Preconditions{ResourceVersion: &created.ResourceVersion}
+	if err := s.Delete(ctx, "/namespaces/foo", &api.Namespace{}, stale, storage.ValidateAllObjectFunc, nil); !storage.IsInvalidObj(err) {
+		t.Errorf("expected invalid object error, got %v", err)
+	}
+	if err := s.Delete(ctx, "/namespaces/foo", &api.Namespace{}, nil, storage.ValidateAllObjectFunc, nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Get(ctx, "/namespaces/foo", storage.GetOptions{}, got); !storage.IsNotFound(err) {
+		t.Errorf("expected not found error, got %v", err)
+	}
+}
+
+func TestBackendCommitConflict(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackend(t, mysqltest.NewTestServer(t))
+
+	rev, ok, err := b.Commit(ctx, "/registry/foo", 0, []byte("1"))
+	if err != nil || !ok {
+		t.Fatalf("expected the create to succeed, got %v, %v", ok, err)
+	}
+	if _, ok, err := b.Commit(ctx, "/registry/foo", 0, []byte("2")); err != nil || ok {
+		t.Errorf("expected the second create to conflict, got %v, %v", ok, err)
+	}
+	if _, ok, err := b.Commit(ctx, "/registry/foo", rev-1, []byte("2")); err != nil || ok {
+		t.Errorf("expected the stale update to conflict, got %v, %v", ok, err)
+	}
+
+	// a conflicting op rolls the whole transaction back
+	_, ok, err = b.CommitTxn(ctx, []kvstore.Op{
+		{Key: "/registry/bar", Value: []byte("1")},
+		{Key: "/registry/foo", Value: []byte("2")},
+	})
+	if err != nil || ok {
+		t.Errorf("expected the transaction to conflict, got %v, %v", ok, err)
+	}
+	if kv, err := b.Get(ctx, "/registry/bar"); err != nil || kv != nil {
+		t.Errorf("expected bar to be absent, got %v, %v", kv, err)
+	}
+	if current, err := b.CurrentRevision(ctx); err != nil || current != rev {
+		t.Errorf("expected revision %d, got %d, %v", rev, current, err)
+	}
+
+	next, ok, err := b.CommitTxn(ctx, []kvstore.Op{
+		{Key: "/registry/bar", Value: []byte("1")},
+		{Key: "/registry/foo", ExpectedRev: rev, Value: []byte("2")},
+	})
+	if err != nil || !ok {
+		t.Fatalf("expected the transaction to succeed, got %v, %v", ok, err)
+	}
+	// every op gets its own revision
+	if next != rev+2 {
+		t.Errorf("expected revision %d, got %d", rev+2, next)
+	}
+	if kv, err := b.Get(ctx, "/registry/foo"); err != nil || kv == nil || kv.Revision != next || string(kv.Value) != "2" {
+		t.Errorf("unexpected foo %v, %v", kv, err)
+	}
+}
+
+func TestBackendGetList(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackend(t, mysqltest.NewTestServer(t))
+	b.listDefaultLimit = 2
+	s := newTestStore(b)
+
+	for name, tier := range map[string]string{"foo": "web", "bar": "db", "baz": "web", "qux": "web"} {
+		if err := s.Create(ctx, "/namespaces/"+name, newNamespace(name, map[string]string{"tier": tier}), nil, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// the rows are read in pages of listDefaultLimit
+	list := &api.NamespaceList{}
+	if err := s.GetList(ctx, "/namespaces", storage.ListOptions{Predicate: storage.Everything, Recursive: true}, list); err != nil {
+		t.Fatal(err)
+	}
+	if names := namespaceNames(list); names != "[bar baz foo qux]" {
+		t.Errorf("unexpected items %s", names)
+	}
+	if count, err := s.Count("/namespaces"); err != nil || count != 4 {
+		t.Errorf("expected 4 objects, got %d, %v", count, err)
+	}
+
+	// the selector is applied to the stored attributes
+	kvs, _, _, err := b.ListSelected(ctx, "/registry/namespaces/", "", 0, kvstore.NewSelector(labelPredicate("web")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, kv := range kvs {
+		keys = append(keys, kv.Key)
+	}
+	if fmt.Sprint(keys) != "[/registry/namespaces/baz /registry/namespaces/foo /registry/namespaces/qux]" {
+		t.Errorf("unexpected keys %v", keys)
+	}
+
+	pred := labelPredicate("web")
+	pred.Limit = 2
+	list = &api.NamespaceList{}
+	if err := s.GetList(ctx, "/namespaces", storage.ListOptions{Predicate: pred, Recursive: true}, list); err != nil {
+		t.Fatal(err)
+	}
+	if names := namespaceNames(list); names != "[baz foo]" || list.Continue == "" {
+		t.Errorf("unexpected first page %s, continue %q", names, list.Continue)
+	}
+	pred.Continue = list.Continue
+	list = &api.NamespaceList{}
+	if err := s.GetList(ctx, "/namespaces", storage.ListOptions{Predicate: pred, Recursive: true}, list); err != nil {
+		t.Fatal(err)
+	}
+	if names := namespaceNames(list); names != "[qux]" || list.Continue != "" {
+		t.Errorf("unexpected last page %s, continue %q", names, list.Continue)
+	}
+}
+
+func TestBackendChangedSince(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackend(t, mysqltest.NewTestServer(t))
+	s := newTestStore(b)
+
+	// the revisions start at 1, bar and foo are created at 2 and 3, foo is updated at 4
+	for _, name := range []string{"bar", "foo"} {
+		if err := s.Create(ctx, "/namespaces/"+name, newNamespace(name, nil), nil, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.GuaranteedUpdate(ctx, "/namespaces/foo", &api.Namespace{}, false, nil, setTier("web"), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	testCases := []struct {
+		name          string
+		prefix        string
+		fromKey       string
+		rev           uint64
+		expectChanged bool
+	}{
+		{name: "written key", prefix: "/registry/namespaces/", fromKey: "/registry/namespaces/", rev: 3, expectChanged: true},
+		{name: "written key before fromKey", prefix: "/registry/namespaces/", fromKey: "/registry/namespaces/g", rev: 3},
+		{name: "other prefix", prefix: "/registry/other/", fromKey: "/registry/other/", rev: 3},
+		{name: "current revision", prefix: "/registry/namespaces/", fromKey: "/registry/namespaces/", rev: 4},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			changed, err := b.ChangedSince(ctx, tc.prefix, tc.fromKey, tc.rev)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if changed != tc.expectChanged {
+				t.Errorf("expected changed %v, got %v", tc.expectChanged, changed)
+			}
+		})
+	}
+
+	// the writes after a compacted revision can't be told
+	if _, err := b.Compact(ctx, 3); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := b.ChangedSince(ctx, "/registry/namespaces/", "/registry/namespaces/", 2); !apierrors.IsResourceExpired(err) {
+		t.Errorf("expected resource expired error, got %v", err)
+	}
+	if changed, err := b.ChangedSince(ctx, "/registry/namespaces/", "/registry/namespaces/", 3); err != nil || !changed {
+		t.Errorf("expected a change, got %v, %v", changed, err)
+	}
+}
+
+func TestBackendWatch(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(newTestBackend(t, mysqltest.NewTestServer(t)))
+
+	created := &api.Namespace{}
+	if err := s.Create(ctx, "/namespaces/foo", newNamespace("foo", nil), created, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// a zero resourceVersion starts with the current state
+	w, err := s.Watch(ctx, "/namespaces", storage.ListOptions{ResourceVersion: "0", Predicate: storage.Everything, Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	expectEvent(t, w, watch.Added, "foo")
+
+	if err := s.Create(ctx, "/namespaces/bar", newNamespace("bar", nil), nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.GuaranteedUpdate(ctx, "/namespaces/foo", &api.Namespace{}, false, nil, setTier("web"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Delete(ctx, "/namespaces/bar", &api.Namespace{}, nil, storage.ValidateAllObjectFunc, nil); err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, w, watch.Added, "bar")
+	expectEvent(t, w, watch.Modified, "foo")
+	expectEvent(t, w, watch.Deleted, "bar")
+
+	// resuming from the create revision replays the events table
+	resumed, err := s.Watch(ctx, "/namespaces/foo", storage.ListOptions{ResourceVersion: created.ResourceVersion, Predicate: storage.Everything})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Stop()
+	expectEvent(t, resumed, watch.Modified, "foo")
+}
+
+func TestBackendWatchCompacted(t *testing.T) {
+	ctx := context.Background()
+	b := newTestBackend(t, mysqltest.NewTestServer(t))
+	s := newTestStore(b)
+
+	for i := 0; i < 3; i++ {
+		name := fmt.Sprintf("ns-%d", i)
+		if err := s.Create(ctx, "/namespaces/"+name, newNamespace(name, nil), nil, 0); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the revisions start at 1, ns-0 to ns-2 are created at 2 to 4
+	if deleted, err := b.Compact(ctx, 3); err != nil || deleted != 2 {
+		t.Fatalf("expected 2 events compacted, got %d, %v", deleted, err)
+	}
+
+	// the client must see 410 Gone to list again
+	expired, err := s.Watch(ctx, "/namespaces", storage.ListOptions{ResourceVersion: "2", Predicate: storage.Everything, Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer expired.Stop()
+	select {
+	case e := <-expired.ResultChan():
+		status, isStatus := e.Object.(*metav1.Status)
+		if e.Type != watch.Error || !isStatus || status.Reason != metav1.StatusReasonExpired {
+			t.Errorf("expected an expired error, got %s %#v", e.Type, e.Object)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatal("timed out waiting for the watch error")
+	}
+
+	w, err := s.Watch(ctx, "/namespaces", storage.ListOptions{ResourceVersion: "3", Predicate: storage.Everything, Recursive: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	expectEvent(t, w, watch.Added, "ns-2")
+}
+
+func TestBackendRequestWatchProgress(t *testing.T) {
+	ctx := context.Background()
+	s := newTestStore(newTestBackend(t, mysqltest.NewTestServer(t)))
+
+	foo := &api.Namespace{}
+	if err := s.Create(ctx, "/namespaces/foo", newNamespace("foo", nil), foo, 0); err != nil {
+		t.Fatal(err)
+	}
+	w, err := s.Watch(ctx, "/namespaces", storage.ListOptions{ResourceVersion: foo.ResourceVersion, Predicate: storage.Everything, Recursive: true, ProgressNotify: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+
+	// without writes the watch only learns its revision from a progress request
+	err = wait.PollUntilContextTimeout(ctx, 100*time.Millisecond, wait.ForeverTestTimeout, true, func(ctx context.Context) (bool, error) {
+		if err := s.RequestWatchProgress(ctx); err != nil {
+			return false, err
+		}
+		select {
+		case e := <-w.ResultChan():
+			ns, isNamespace := e.Object.(*api.Namespace)
+			if e.Type != watch.Bookmark || !isNamespace {
+				return false, fmt.Errorf("expected a bookmark, got %s %#v", e.Type, e.Object)
+			}
+			return ns.ResourceVersion == foo.ResourceVersion, nil
+		case <-time.After(100 * time.Millisecond):
+			return false, nil
+		}
+	})
+	if err != nil {
+		t.Fatalf("expected a bookmark at %s: %v", foo.ResourceVersion, err)
+	}
+}
+
+func namespaceNames(list *api.NamespaceList) string {
+	var names []string
+	for _, ns := range list.Items {
+		names = append(names, ns.Name)
+	}
+	return fmt.Sprint(names)
+}
+
+func expectEvent(t *testing.T, w watch.Interface, eventType watch.EventType, name string) {
+	t.Helper()
+	select {
+	case e, ok := <-w.ResultChan():
+		if !ok {
+			t.Fatalf("watch closed, expected %s %s", eventType, name)
+		}
+		ns, isNamespace := e.Object.(*api.Namespace)
+		if e.Type != eventType || !isNamespace || ns.Name != name {
+			t.Errorf("expected %s %s, got %s %#v", eventType, name, e.Type, e.Object)
+		}
+	case <-time.After(wait.ForeverTestTimeout):
+		t.Fatalf("timed out waiting for %s %s", eventType, name)
+	}
+}
@@ -0,0 +1,143 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package mysql
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+)
+
+// run reads new events after every local write and every poll interval, and hands them to the watchers.
+// All instances sharing the database read the same event table, so they send the same events in
+// revision order.
+func (b *Backend) run(ctx context.Context) {
+	defer close(b.done)
+
+	interval := b.config.WatchPollInterval
+	if interval <= 0 {
+		interval = DefaultWatchPollInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.written:
+		case <-ticker.C:
+		}
+		if err := b.poll(ctx); err != nil && ctx.Err() == nil {
+			klog.Warningf("Failed to read mysql events: %v", err)
+		}
+	}
+}
+
+// poll dispatches every event after dispatchedRev.
+func (b *Backend) poll(ctx context.Context) error {
+	for {
+		events, err := b.events(ctx, b.db, b.dispatchedRev, "", false, int64(b.config.ListDefaultLimit))
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		b.lock.Lock()
+		// revisions have no gaps, unless the events were compacted before they were read
+		if events[0].Revision != b.dispatchedRev+1 {
+			b.expireWatchers(kvstore.NewCompactedError(b.dispatchedRev, events[0].Revision-1))
+		}
+		for _, e := range events {
+			for id, w := range b.watchers {
+				if !w.Add(e) {
+					delete(b.watchers, id)
+				}
+			}
+		}
+		b.dispatchedRev = events[len(events)-1].Revision
+		b.lock.Unlock()
+	}
+}
+
+// RequestProgress implements kvstore.ProgressKV. The watchers are at the revision of the last
+// dispatched event, the events committed since are read by a poll right away, so a client
+// asking again soon sees the latest revision.
+func (b *Backend) RequestProgress(ctx context.Context) error {
+	select {
+	case b.written <- struct{}{}:
+	default:
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, w := range b.watchers {
+		w.RequestProgress(b.dispatchedRev)
+	}
+	return nil
+}
+
+// expireWatchers stops every watcher with err, it is called with the backend lock held.
+func (b *Backend) expireWatchers(err error) {
+	klog.Warningf("Stopping %d mysql watchers: %v", len(b.watchers), err)
+	for id, w := range b.watchers {
+		w.Expire(err)
+		delete(b.watchers, id)
+	}
+}
+
+// events returns at most limit events after rev, only those of key, or of
+// every key with the prefix key when recursive, unless key is empty.
+func (b *Backend) events(ctx context.Context, q rowsQueryer, rev uint64, key string, recursive bool, limit int64) ([]*kvstore.Event, error) {
+	query := `SELECT revision, name, value, prev_value, deleted FROM apimaster_events WHERE revision > ?`
+	args := []interface{}{int64(rev)}
+	switch {
+	case key == "":
+	case recursive:
+		query += ` AND name >= ? AND name < ?`
+		args = append(args, key, prefixEnd(key))
+	default:
+		query += ` AND name = ?`
+		args = append(args, key)
+	}
+	query += ` ORDER BY revision`
+	if limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, limit)
+	}
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*kvstore.Event
+	for rows.Next() {
+		e := &kvstore.Event{}
+		var eventRev int64
+		if err := rows.Scan(&eventRev, &e.Key, &e.Value, &e.PrevValue, &e.Deleted); err != nil {
+			return nil, err
+		}
+		e.Revision = uint64(eventRev)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
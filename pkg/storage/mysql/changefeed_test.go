@@ -0,0 +1,111 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	api "github.com/seanchann/apimaster/pkg/apis/coreres"
+	"github.com/seanchann/apimaster/pkg/storage/mysql/mysqltest"
+
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/storage"
+)
+
+func TestChangeFeedSharedDatabase(t *testing.T) {
+	ctx := context.Background()
+	dsn := mysqltest.NewTestServer(t)
+	first := newTestStore(newTestBackend(t, dsn))
+	// go-mysql-server fails CREATE TABLE IF NOT EXISTS on a table with indexes once it exists,
+	// the second instance uses the tables of the first
+	tables := schema
+	schema = nil
+	second := newTestStore(newTestBackend(t, dsn))
+	schema = tables
+
+	start := &api.Namespace{}
+	if err := first.Create(ctx, "/namespaces/start", newNamespace("start", nil), start, 0); err != nil {
+		t.Fatal(err)
+	}
+	var watchers []watch.Interface
+	for _, s := range []storage.Interface{first, second} {
+		w, err := s.Watch(ctx, "/namespaces", storage.ListOptions{ResourceVersion: start.ResourceVersion, Predicate: storage.Everything, Recursive: true})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer w.Stop()
+		watchers = append(watchers, w)
+	}
+
+	// the writes alternate between the instances, every one is seen by both
+	if err := first.Create(ctx, "/namespaces/foo", newNamespace("foo", nil), nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.Create(ctx, "/namespaces/bar", newNamespace("bar", nil), nil, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := second.GuaranteedUpdate(ctx, "/namespaces/foo", &api.Namespace{}, false, nil, setTier("web"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := first.Delete(ctx, "/namespaces/bar", &api.Namespace{}, nil, storage.ValidateAllObjectFunc, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	var streams [][]string
+	for _, w := range watchers {
+		var stream []string
+		lastRev := uint64(0)
+		for i := 0; i < 4; i++ {
+			select {
+			case e, ok := <-w.ResultChan():
+				if !ok {
+					t.Fatal("watch closed")
+				}
+				ns, isNamespace := e.Object.(*api.Namespace)
+				if !isNamespace {
+					t.Fatalf("unexpected event %s %#v", e.Type, e.Object)
+				}
+				rev, err := strconv.ParseUint(ns.ResourceVersion, 10, 64)
+				if err != nil || rev <= lastRev {
+					t.Errorf("expected a resourceVersion after %d, got %q", lastRev, ns.ResourceVersion)
+				}
+				lastRev = rev
+				stream = append(stream, fmt.Sprintf("%s %s %s", e.Type, ns.Name, ns.ResourceVersion))
+			case <-time.After(wait.ForeverTestTimeout):
+				t.Fatalf("timed out waiting for event %d, got %v", i, stream)
+			}
+		}
+		streams = append(streams, stream)
+	}
+
+	if fmt.Sprint(streams[0]) != fmt.Sprint(streams[1]) {
+		t.Errorf("expected the same events on both instances, got %v and %v", streams[0], streams[1])
+	}
+	var types []string
+	for _, e := range streams[0] {
+		types = append(types, strings.Fields(e)[0])
+	}
+	if fmt.Sprint(types) != "[ADDED ADDED MODIFIED DELETED]" {
+		t.Errorf("unexpected events %v", streams[0])
+	}
+}
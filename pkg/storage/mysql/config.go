@@ -0,0 +1,199 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package mysql
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+const (
+	// StorageTypeMysql is the storagebackend.Config type of the mysql storage.
+	StorageTypeMysql = "mysql"
+
+	// DefaultListDefaultLimit is the number of rows read per query when a list has no limit.
+	DefaultListDefaultLimit = 500
+
+	// DefaultMaxReplicaLag is how far a replica may fall behind the primary by default.
+	DefaultMaxReplicaLag = 5 * time.Second
+
+	// DefaultWatchPollInterval is the default period the event table is polled for the
+	// writes of other apimaster instances.
+	DefaultWatchPollInterval = time.Second
+
+	// DefaultCompactionInterval is the default period the event table is compacted, like
+	// the default of --etcd-compaction-interval.
+	DefaultCompactionInterval = 5 * time.Minute
+	// DefaultCompactionRetention is how long events are kept by default to resume watches from.
+	DefaultCompactionRetention = time.Hour
+)
+
+// TLSConfig is the client TLS configuration of the connections.
+type TLSConfig struct {
+	CAFile   string
+	CertFile string
+	KeyFile  string
+	// ServerName overrides the host name the server certificate is verified against.
+	ServerName string
+}
+
+// Config is the configuration of the mysql storage.
+type Config struct {
+	// ServerList is the DSNs tried in order, eg: user:password@tcp(host:port)/dbname.
+	// The first reachable server is used.
+	ServerList []string
+	// ReplicaList is the DSNs of the read replicas of the servers, which serve the
+	// reads that don't have to observe the latest write.
+	ReplicaList []string
+	// MaxReplicaLag is how far a replica may fall behind the primary before its reads
+	// go to the primary again.
+	MaxReplicaLag time.Duration
+	// CredentialsFile holds the user and password of every server as "user:password",
+	// the servers must not contain credentials then.
+	CredentialsFile string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+
+	DialTimeout  time.Duration
+	ReadTimeout  time.Duration
+	WriteTimeout time.Duration
+
+	TLS TLSConfig
+
+	// WatchPollInterval bounds the delay of the watch events of writes by other
+	// apimaster instances sharing the database.
+	WatchPollInterval time.Duration
+
+	// CompactionInterval is the period the events older than CompactionRetention are
+	// removed, 0 disables the compaction and keeps every event.
+	CompactionInterval  time.Duration
+	CompactionRetention time.Duration
+
+	// ListDefaultLimit is the number of rows read per query when a list has no limit.
+	ListDefaultLimit int
+}
+
+// NewDefaultConfig creates a mysql config with default values.
+func NewDefaultConfig() Config {
+	return Config{
+		MaxOpenConns:        50,
+		MaxIdleConns:        10,
+		ConnMaxLifetime:     30 * time.Minute,
+		MaxReplicaLag:       DefaultMaxReplicaLag,
+		WatchPollInterval:   DefaultWatchPollInterval,
+		CompactionInterval:  DefaultCompactionInterval,
+		CompactionRetention: DefaultCompactionRetention,
+		ListDefaultLimit:    DefaultListDefaultLimit,
+	}
+}
+
+// DSN returns server with the credentials, timeouts and TLS settings applied.
+// Errors never contain the server, which may hold a password.
+func (c *Config) DSN(server string) (string, error) {
+	cfg, err := mysqldriver.ParseDSN(server)
+	if err != nil {
+		return "", err
+	}
+
+	if c.CredentialsFile != "" {
+		if cfg.User != "" || cfg.Passwd != "" {
+			return "", errors.New("must not contain credentials when a credentials file is specified")
+		}
+		if cfg.User, cfg.Passwd, err = readCredentials(c.CredentialsFile); err != nil {
+			return "", err
+		}
+	}
+	if c.DialTimeout > 0 {
+		cfg.Timeout = c.DialTimeout
+	}
+	if c.ReadTimeout > 0 {
+		cfg.ReadTimeout = c.ReadTimeout
+	}
+	if c.WriteTimeout > 0 {
+		cfg.WriteTimeout = c.WriteTimeout
+	}
+	if c.TLS != (TLSConfig{}) {
+		if cfg.TLSConfig, err = registerTLSConfig(c.TLS); err != nil {
+			return "", err
+		}
+	}
+	return cfg.FormatDSN(), nil
+}
+
+// HasCredentials returns whether server contains a user or a password.
+func HasCredentials(server string) (bool, error) {
+	cfg, err := mysqldriver.ParseDSN(server)
+	if err != nil {
+		return false, err
+	}
+	return cfg.User != "" || cfg.Passwd != "", nil
+}
+
+// readCredentials reads "user:password" from file.
+func readCredentials(file string) (string, string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read credentials: %v", err)
+	}
+	user, password, found := strings.Cut(strings.TrimRight(string(data), "\r\n"), ":")
+	if !found || user == "" {
+		return "", "", fmt.Errorf("invalid credentials file %s, expected user:password", file)
+	}
+	return user, password, nil
+}
+
+// registerTLSConfig registers the client TLS configuration with the driver and returns the
+// name to refer to it in a DSN. The name is derived from the configuration, so equal
+// configurations share a registration.
+func registerTLSConfig(config TLSConfig) (string, error) {
+	tlsConfig := &tls.Config{ServerName: config.ServerName}
+	if config.CAFile != "" {
+		ca, err := os.ReadFile(config.CAFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read CA file: %v", err)
+		}
+		tlsConfig.RootCAs = x509.NewCertPool()
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(ca) {
+			return "", fmt.Errorf("no certificate found in CA file %s", config.CAFile)
+		}
+	}
+	if config.CertFile != "" || config.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(config.CertFile, config.KeyFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	hash := sha256.Sum256([]byte(strings.Join([]string{config.CAFile, config.CertFile, config.KeyFile, config.ServerName}, "\x00")))
+	name := "apimaster-" + hex.EncodeToString(hash[:8])
+	if err := mysqldriver.RegisterTLSConfig(name, tlsConfig); err != nil {
+		return "", err
+	}
+	return name, nil
+}
@@ -0,0 +1,158 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package mysql
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	certutil "k8s.io/client-go/util/cert"
+)
+
+func writeFile(t *testing.T, name string, data []byte) string {
+	file := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(file, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestConfigDSN(t *testing.T) {
+	cert, key, err := certutil.GenerateSelfSignedCertKey("db", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	certFile, keyFile := writeFile(t, "tls.crt", cert), writeFile(t, "tls.key", key)
+	credentialsFile := writeFile(t, "credentials", []byte("apimaster:s3cr:et\n"))
+
+	tests := []struct {
+		name    string
+		server  string
+		config  func(c *Config)
+		want    string
+		wantErr string
+	}{
+		{
+			name:   "defaults",
+			server: "apimaster:secret@tcp(db:3306)/apimaster",
+			want:   "apimaster:secret@tcp(db:3306)/apimaster",
+		},
+		{
+			name:   "timeouts",
+			server: "apimaster:secret@tcp(db:3306)/apimaster?timeout=1s",
+			config: func(c *Config) {
+				c.ReadTimeout, c.WriteTimeout = 30*time.Second, time.Minute
+			},
+			want: "apimaster:secret@tcp(db:3306)/apimaster?readTimeout=30s&timeout=1s&writeTimeout=1m0s",
+		},
+		{
+			name:   "credentials file",
+			server: "tcp(db:3306)/apimaster",
+			config: func(c *Config) { c.CredentialsFile = credentialsFile },
+			want:   "apimaster:s3cr:et@tcp(db:3306)/apimaster",
+		},
+		{
+			name:    "credentials file and credentials",
+			server:  "apimaster:secret@tcp(db:3306)/apimaster",
+			config:  func(c *Config) { c.CredentialsFile = credentialsFile },
+			wantErr: "must not contain credentials",
+		},
+		{
+			name:    "missing credentials file",
+			server:  "tcp(db:3306)/apimaster",
+			config:  func(c *Config) { c.CredentialsFile = filepath.Join(t.TempDir(), "missing") },
+			wantErr: "failed to read credentials",
+		},
+		{
+			name:   "tls",
+			server: "apimaster:secret@tcp(db:3306)/apimaster",
+			config: func(c *Config) {
+				c.TLS = TLSConfig{CAFile: certFile, CertFile: certFile, KeyFile: keyFile, ServerName: "db"}
+			},
+			want: "apimaster:secret@tcp(db:3306)/apimaster?tls=apimaster-",
+		},
+		{
+			name:    "invalid ca file",
+			server:  "apimaster:secret@tcp(db:3306)/apimaster",
+			config:  func(c *Config) { c.TLS = TLSConfig{CAFile: keyFile} },
+			wantErr: "no certificate found",
+		},
+		{
+			name:    "invalid dsn",
+			server:  "apimaster:secret@tcp(db:3306)",
+			wantErr: "invalid DSN",
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewDefaultConfig()
+			if tc.config != nil {
+				tc.config(&c)
+			}
+			got, err := c.DSN(tc.server)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("expected error %q, got %v", tc.wantErr, err)
+				}
+				if strings.Contains(err.Error(), "secret") {
+					t.Errorf("expected error without credentials, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.HasPrefix(got, tc.want) {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestHasCredentials(t *testing.T) {
+	tests := map[string]bool{
+		"tcp(db:3306)/apimaster":                  false,
+		"apimaster@tcp(db:3306)/apimaster":        true,
+		"apimaster:secret@tcp(db:3306)/apimaster": true,
+	}
+	for server, want := range tests {
+		got, err := HasCredentials(server)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("HasCredentials(%q): expected %v, got %v", server, want, got)
+		}
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := map[string]string{
+		"/registry/namespaces/": "/registry/namespaces0",
+		"/":                     "0",
+		"a\xff":                 "b",
+	}
+	for prefix, want := range tests {
+		if got := prefixEnd(prefix); got != want {
+			t.Errorf("prefixEnd(%q): expected %q, got %q", prefix, want, got)
+		}
+	}
+}
@@ -0,0 +1,107 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+// Package mysqltest serves an in-memory mysql database for the tests of the packages using
+// the mysql backend. It is meant for tests only.
+package mysqltest
+
+import (
+	"context"
+	"sync"
+	"testing"
+
+	sqle "github.com/dolthub/go-mysql-server"
+	"github.com/dolthub/go-mysql-server/memory"
+	"github.com/dolthub/go-mysql-server/server"
+	gmssql "github.com/dolthub/go-mysql-server/sql"
+	vitessmysql "github.com/dolthub/vitess/go/mysql"
+	"github.com/dolthub/vitess/go/sqltypes"
+	querypb "github.com/dolthub/vitess/go/vt/proto/query"
+)
+
+func init() {
+	listen := server.DefaultProtocolListenerFunc
+	server.DefaultProtocolListenerFunc = func(cfg vitessmysql.ListenerConfig) (server.ProtocolListener, error) {
+		cfg.Handler = &serialHandler{Handler: cfg.Handler}
+		return listen(cfg)
+	}
+}
+
+// serialHandler runs one command at a time. The memory databases of go-mysql-server don't
+// isolate the sessions, a statement writes back the tables it has read when it commits, so
+// a concurrent read could undo a write.
+type serialHandler struct {
+	vitessmysql.Handler
+	lock sync.Mutex
+}
+
+func (h *serialHandler) ComInitDB(c *vitessmysql.Conn, schemaName string) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.Handler.ComInitDB(c, schemaName)
+}
+
+func (h *serialHandler) ComQuery(c *vitessmysql.Conn, query string, callback vitessmysql.ResultSpoolFn) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.Handler.ComQuery(c, query, callback)
+}
+
+func (h *serialHandler) ComMultiQuery(c *vitessmysql.Conn, query string, callback vitessmysql.ResultSpoolFn) (string, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.Handler.ComMultiQuery(c, query, callback)
+}
+
+func (h *serialHandler) ComPrepare(c *vitessmysql.Conn, query string, prepare *vitessmysql.PrepareData) ([]*querypb.Field, error) {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.Handler.ComPrepare(c, query, prepare)
+}
+
+func (h *serialHandler) ComStmtExecute(c *vitessmysql.Conn, prepare *vitessmysql.PrepareData, callback func(*sqltypes.Result) error) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.Handler.ComStmtExecute(c, prepare, callback)
+}
+
+func (h *serialHandler) ComResetConnection(c *vitessmysql.Conn) error {
+	h.lock.Lock()
+	defer h.lock.Unlock()
+	return h.Handler.ComResetConnection(c)
+}
+
+// NewTestServer starts an in-memory mysql server and returns the DSN of its database, the
+// server is stopped by the cleanup of t.
+func NewTestServer(t testing.TB) string {
+	t.Helper()
+	pro := memory.NewDBProvider(memory.NewDatabase("apimaster"))
+	// the tables of the memory databases are kept in the memory sessions
+	sessionBuilder := func(ctx context.Context, conn *vitessmysql.Conn, addr string) (gmssql.Session, error) {
+		session, err := server.DefaultSessionBuilder(ctx, conn, addr)
+		if err != nil {
+			return nil, err
+		}
+		return memory.NewSession(session.(*gmssql.BaseSession), pro), nil
+	}
+	s, err := server.NewServer(server.Config{Protocol: "tcp", Address: "127.0.0.1:0"}, sqle.NewDefault(pro), sessionBuilder, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go s.Start()
+	t.Cleanup(func() { s.Close() })
+	return "root@tcp(" + s.Listener.Addr().String() + ")/apimaster"
+}
@@ -0,0 +1,151 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package mysql
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+)
+
+// replicaCheckInterval is the period the revisions of the replicas are compared with the primary.
+const replicaCheckInterval = time.Second
+
+// replica is a read replica of the primary database.
+type replica struct {
+	reader
+	// index is the position in the replica list, servers are not logged as they may hold a password.
+	index int
+
+	lock    sync.RWMutex
+	checked bool
+	healthy bool
+	// revision is the revision of the replica at the last check, it only increases.
+	revision uint64
+}
+
+// openReplicas returns the connection pools to the replicas of config, which connect on first use.
+func openReplicas(config Config) ([]*replica, error) {
+	replicas := make([]*replica, 0, len(config.ReplicaList))
+	for i, server := range config.ReplicaList {
+		db, err := newDB(config, server)
+		if err != nil {
+			for _, r := range replicas {
+				r.db.Close()
+			}
+			return nil, fmt.Errorf("replica %d: %v", i, err)
+		}
+		replicas = append(replicas, &replica{
+			reader: reader{db: db, listDefaultLimit: config.ListDefaultLimit},
+			index:  i,
+		})
+	}
+	return replicas, nil
+}
+
+// Replica implements kvstore.ReplicaKV, the reads are spread over the healthy replicas.
+func (b *Backend) Replica(minRev uint64) kvstore.Reader {
+	n := len(b.replicas)
+	if n == 0 {
+		return nil
+	}
+	start := int(atomic.AddUint32(&b.nextReplica, 1))
+	for i := 0; i < n; i++ {
+		if r := b.replicas[(start+i)%n]; r.caughtUp(minRev) {
+			return r
+		}
+	}
+	return nil
+}
+
+// caughtUp returns whether the replica is healthy and has applied minRev.
+func (r *replica) caughtUp(minRev uint64) bool {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.healthy && r.revision >= minRev
+}
+
+// checkReplicas compares the revisions of the replicas with the revision the primary had
+// MaxReplicaLag ago, a replica that is unreachable or lags further behind is not read.
+func (b *Backend) checkReplicas(ctx context.Context) {
+	if len(b.replicas) == 0 {
+		return
+	}
+
+	history := &kvstore.RevisionHistory{Window: b.config.MaxReplicaLag}
+	ticker := time.NewTicker(replicaCheckInterval)
+	defer ticker.Stop()
+	for {
+		checkCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+		rev, err := b.CurrentRevision(checkCtx)
+		cancel()
+		switch {
+		case err == nil:
+			// until the history covers the maximum lag, replicas must reach the oldest revision
+			minRev, _ := history.Add(time.Now(), rev)
+			for _, r := range b.replicas {
+				r.check(ctx, minRev, b.config.MaxReplicaLag)
+			}
+		case ctx.Err() == nil:
+			// the replicas keep their state, their reads don't depend on the primary
+			klog.Warningf("Failed to read the revision of the mysql primary: %v", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// check reads the revision of the replica, it is healthy if it has caught up with minRev,
+// the revision of the primary maxLag ago.
+func (r *replica) check(ctx context.Context, minRev uint64, maxLag time.Duration) {
+	checkCtx, cancel := context.WithTimeout(ctx, pingTimeout)
+	rev, err := r.CurrentRevision(checkCtx)
+	cancel()
+	if ctx.Err() != nil {
+		return
+	}
+	if err == nil && rev < minRev {
+		err = fmt.Errorf("revision %d lags more than %v behind the primary at revision %d", rev, maxLag, minRev)
+	}
+
+	r.lock.Lock()
+	changed := !r.checked || r.healthy != (err == nil)
+	r.checked = true
+	r.healthy = err == nil
+	if err == nil {
+		r.revision = rev
+	}
+	r.lock.Unlock()
+
+	switch {
+	case !changed:
+	case err != nil:
+		klog.Warningf("Reading from mysql replica %d stopped: %v", r.index, err)
+	default:
+		klog.Infof("Reading from mysql replica %d at revision %d", r.index, rev)
+	}
+}
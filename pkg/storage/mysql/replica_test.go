@@ -0,0 +1,88 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package mysql
+
+import (
+	"testing"
+)
+
+func TestBackendReplica(t *testing.T) {
+	unchecked := &replica{index: 0}
+	lagging := &replica{index: 1, checked: true, healthy: true, revision: 5}
+	healthy := &replica{index: 2, checked: true, healthy: true, revision: 10}
+	unhealthy := &replica{index: 3, checked: true, revision: 20}
+
+	testCases := []struct {
+		name     string
+		replicas []*replica
+		minRev   uint64
+		expected *replica
+	}{
+		{
+			name: "no replicas",
+		},
+		{
+			name:     "any revision",
+			replicas: []*replica{unchecked, healthy, unhealthy},
+			expected: healthy,
+		},
+		{
+			name:     "caught up",
+			replicas: []*replica{lagging, healthy},
+			minRev:   8,
+			expected: healthy,
+		},
+		{
+			name:     "none caught up",
+			replicas: []*replica{lagging, healthy, unhealthy},
+			minRev:   15,
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			b := &Backend{replicas: testcase.replicas}
+			for i := 0; i < 2*len(testcase.replicas)+1; i++ {
+				r := b.Replica(testcase.minRev)
+				if testcase.expected == nil {
+					if r != nil {
+						t.Fatalf("expected no replica, got %v", r)
+					}
+					continue
+				}
+				if r != testcase.expected {
+					t.Fatalf("expected replica %d, got %v", testcase.expected.index, r)
+				}
+			}
+		})
+	}
+}
+
+func TestBackendReplicaSpreadsReads(t *testing.T) {
+	replicas := []*replica{
+		{index: 0, checked: true, healthy: true, revision: 10},
+		{index: 1, checked: true, healthy: true, revision: 10},
+	}
+	b := &Backend{replicas: replicas}
+	reads := map[int]int{}
+	for i := 0; i < 10; i++ {
+		reads[b.Replica(10).(*replica).index]++
+	}
+	if reads[0] != 5 || reads[1] != 5 {
+		t.Errorf("expected the reads to be spread evenly, got %v", reads)
+	}
+}
@@ -0,0 +1,140 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"sort"
+	"strings"
+
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+)
+
+// The kinds of the rows of apimaster_attributes.
+const (
+	// attributeIndexed marks a key whose attributes are stored, the other keys are
+	// selected by every selector.
+	attributeIndexed = iota
+	attributeLabel
+	attributeField
+)
+
+const (
+	// maxAttributeNameLength is the length of a qualified label name.
+	maxAttributeNameLength  = 317
+	maxAttributeValueLength = 1024
+)
+
+// attribute is a row of apimaster_attributes.
+type attribute struct {
+	kind  int
+	name  string
+	value string
+}
+
+// attributeRows returns the rows storing attrs, led by the attributeIndexed row. It
+// returns nil if attrs is nil or does not fit the table.
+func attributeRows(attrs *kvstore.Attributes) []attribute {
+	if attrs == nil {
+		return nil
+	}
+	rows := []attribute{{kind: attributeIndexed}}
+	for name, value := range attrs.Labels {
+		rows = append(rows, attribute{kind: attributeLabel, name: name, value: value})
+	}
+	for name, value := range attrs.Fields {
+		rows = append(rows, attribute{kind: attributeField, name: name, value: value})
+	}
+	for _, row := range rows {
+		if len(row.name) > maxAttributeNameLength || len(row.value) > maxAttributeValueLength {
+			return nil
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].kind != rows[j].kind {
+			return rows[i].kind < rows[j].kind
+		}
+		return rows[i].name < rows[j].name
+	})
+	return rows
+}
+
+// writeAttributes replaces the attributes of key with attrs.
+func writeAttributes(ctx context.Context, tx *sql.Tx, key string, attrs *kvstore.Attributes) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM apimaster_attributes WHERE name = ?`, key); err != nil {
+		return err
+	}
+	rows := attributeRows(attrs)
+	if len(rows) == 0 {
+		return nil
+	}
+	placeholders := make([]string, 0, len(rows))
+	args := make([]interface{}, 0, 4*len(rows))
+	for _, row := range rows {
+		placeholders = append(placeholders, "(?, ?, ?, ?)")
+		args = append(args, key, row.kind, row.name, row.value)
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO apimaster_attributes (name, kind, attr, value) VALUES `+
+		strings.Join(placeholders, ", "), args...)
+	return err
+}
+
+// selectionClause returns the condition selecting the rows of apimaster_kv by selector,
+// to be appended to a WHERE clause, and its arguments. Keys without attributes and
+// fields that were not stored are never excluded.
+func selectionClause(selector kvstore.Selector) (string, []interface{}) {
+	if len(selector) == 0 {
+		return "", nil
+	}
+	var args []interface{}
+	exists := func(kind int, attr string) string {
+		condition := `EXISTS (SELECT 1 FROM apimaster_attributes a WHERE a.name = apimaster_kv.name AND a.kind = ?`
+		args = append(args, kind)
+		if attr != "" {
+			condition += ` AND a.attr = ?`
+			args = append(args, attr)
+		}
+		return condition
+	}
+
+	clause := ` AND (NOT ` + exists(attributeIndexed, "") + `)`
+	conditions := make([]string, 0, len(selector))
+	for _, r := range selector {
+		kind := attributeLabel
+		if r.Field {
+			kind = attributeField
+		}
+		condition := exists(kind, r.Key)
+		if len(r.Values) > 0 {
+			condition += ` AND a.value IN (?` + strings.Repeat(", ?", len(r.Values)-1) + `)`
+			for _, value := range r.Values {
+				args = append(args, value)
+			}
+		}
+		condition += `)`
+
+		switch {
+		case r.Negate:
+			condition = `NOT ` + condition
+		case r.Field:
+			condition = `(` + condition + ` OR NOT ` + exists(kind, r.Key) + `))`
+		}
+		conditions = append(conditions, condition)
+	}
+	return clause + ` OR (` + strings.Join(conditions, " AND ") + `))`, args
+}
@@ -0,0 +1,111 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package mysql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+)
+
+func TestAttributeRows(t *testing.T) {
+	testCases := []struct {
+		name     string
+		attrs    *kvstore.Attributes
+		expected []attribute
+	}{
+		{
+			name: "no attributes",
+		},
+		{
+			name: "labels and fields",
+			attrs: &kvstore.Attributes{
+				Labels: labels.Set{"tier": "web", "app": "foo"},
+				Fields: fields.Set{"metadata.name": "foo"},
+			},
+			expected: []attribute{
+				{kind: attributeIndexed},
+				{kind: attributeLabel, name: "app", value: "foo"},
+				{kind: attributeLabel, name: "tier", value: "web"},
+				{kind: attributeField, name: "metadata.name", value: "foo"},
+			},
+		},
+		{
+			name: "value too long",
+			attrs: &kvstore.Attributes{
+				Fields: fields.Set{"spec.description": strings.Repeat("x", maxAttributeValueLength+1)},
+			},
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			if rows := attributeRows(testcase.attrs); !reflect.DeepEqual(rows, testcase.expected) {
+				t.Errorf("expected %v, got %v", testcase.expected, rows)
+			}
+		})
+	}
+}
+
+func TestSelectionClause(t *testing.T) {
+	const exists = `EXISTS (SELECT 1 FROM apimaster_attributes a WHERE a.name = apimaster_kv.name AND a.kind = ?`
+
+	testCases := []struct {
+		name         string
+		selector     kvstore.Selector
+		expected     string
+		expectedArgs []interface{}
+	}{
+		{
+			name: "no selector",
+		},
+		{
+			name:     "labels",
+			selector: kvstore.Selector{{Key: "env", Values: []string{"dev", "prod"}}, {Key: "legacy", Negate: true}},
+			expected: ` AND (NOT ` + exists + `) OR (` + exists + ` AND a.attr = ? AND a.value IN (?, ?)) AND NOT ` +
+				exists + ` AND a.attr = ?)))`,
+			expectedArgs: []interface{}{attributeIndexed, attributeLabel, "env", "dev", "prod", attributeLabel, "legacy"},
+		},
+		{
+			name:     "field",
+			selector: kvstore.Selector{{Field: true, Key: "metadata.name", Values: []string{"foo"}}},
+			expected: ` AND (NOT ` + exists + `) OR ((` + exists + ` AND a.attr = ? AND a.value IN (?)) OR NOT ` +
+				exists + ` AND a.attr = ?))))`,
+			expectedArgs: []interface{}{attributeIndexed, attributeField, "metadata.name", "foo", attributeField, "metadata.name"},
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			clause, args := selectionClause(testcase.selector)
+			if clause != testcase.expected {
+				t.Errorf("expected clause\n%s\ngot\n%s", testcase.expected, clause)
+			}
+			if !reflect.DeepEqual(args, testcase.expectedArgs) {
+				t.Errorf("expected args %v, got %v", testcase.expectedArgs, args)
+			}
+			if strings.Count(clause, "?") != len(args) {
+				t.Errorf("expected %d placeholders, got %d", len(args), strings.Count(clause, "?"))
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package mysql
+
+import (
+	"context"
+	"database/sql"
+
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+)
+
+// Watch implements kvstore.KV. The watcher is registered before its initial
+// events are read, so no event committed in between is lost.
+func (b *Backend) Watch(ctx context.Context, key string, recursive bool, rev uint64) (<-chan *kvstore.Event, error) {
+	w := kvstore.NewKeyWatcher(ctx, key, recursive)
+
+	b.lock.Lock()
+	id := b.nextWatcherID
+	b.nextWatcherID++
+	b.watchers[id] = w
+	b.lock.Unlock()
+
+	go func() {
+		defer b.removeWatcher(id)
+		w.Run(func(w *kvstore.KeyWatcher) (uint64, error) { return b.sendInitial(w, key, recursive, rev) })
+	}()
+	return w.ResultChan(), nil
+}
+
+func (b *Backend) removeWatcher(id int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.watchers, id)
+}
+
+// sendInitial sends the current state as created events for a zero rev, and every
+// stored event after rev otherwise. It returns the revision of the last sent event.
+func (b *Backend) sendInitial(w *kvstore.KeyWatcher, key string, recursive bool, rev uint64) (uint64, error) {
+	ctx := w.Context()
+	// events removed by a compaction after the snapshot was taken are still read
+	tx, err := b.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if rev != 0 {
+		compactedRev, err := compactedRevision(ctx, tx)
+		if err != nil {
+			return 0, err
+		}
+		if rev < compactedRev {
+			return 0, kvstore.NewCompactedError(rev, compactedRev)
+		}
+		for {
+			events, err := b.events(ctx, tx, rev, key, recursive, int64(b.config.ListDefaultLimit))
+			if err != nil || len(events) == 0 {
+				return rev, err
+			}
+			for _, e := range events {
+				if !w.Send(e) {
+					return rev, ctx.Err()
+				}
+				rev = e.Revision
+			}
+		}
+	}
+
+	rev, err = currentRevision(ctx, tx, "")
+	if err != nil {
+		return 0, err
+	}
+	query := `SELECT name, value, revision FROM apimaster_kv WHERE name = ? ORDER BY revision`
+	args := []interface{}{key}
+	if recursive {
+		query = `SELECT name, value, revision FROM apimaster_kv WHERE name >= ? AND name < ? ORDER BY revision`
+		args = append(args, prefixEnd(key))
+	}
+	kvs, err := queryKeyValues(ctx, tx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	for _, kv := range kvs {
+		if !w.Send(&kvstore.Event{Key: kv.Key, Revision: kv.Revision, Value: kv.Value}) {
+			return rev, ctx.Err()
+		}
+	}
+	return rev, nil
+}
@@ -0,0 +1,492 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/lib/pq"
+	utilerrors "k8s.io/apimachinery/pkg/util/errors"
+	"k8s.io/klog/v2"
+
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+)
+
+const (
+	// notifyChannel is the LISTEN/NOTIFY channel announcing new revisions.
+	notifyChannel = "apimaster_events"
+	// writeLockID is the transaction level advisory lock that serializes writes, so
+	// revisions always become visible in order and watchers never skip one.
+	writeLockID = 0x61706d73
+
+	pingTimeout = 10 * time.Second
+
+	// compactBatchSize is the number of events removed per statement, to keep the transactions small.
+	compactBatchSize = 1000
+)
+
+var schema = []string{
+	`CREATE TABLE IF NOT EXISTS apimaster_kv (
+		key TEXT COLLATE "C" PRIMARY KEY,
+		value BYTEA NOT NULL,
+		revision BIGINT NOT NULL
+	)`,
+	`CREATE TABLE IF NOT EXISTS apimaster_events (
+		revision BIGINT PRIMARY KEY,
+		key TEXT COLLATE "C" NOT NULL,
+		value BYTEA,
+		prev_value BYTEA,
+		deleted BOOLEAN NOT NULL DEFAULT FALSE
+	)`,
+	// the single row holds the revision the events have been compacted to
+	`CREATE TABLE IF NOT EXISTS apimaster_compaction (
+		id SMALLINT PRIMARY KEY,
+		revision BIGINT NOT NULL
+	)`,
+	`INSERT INTO apimaster_compaction (id, revision) VALUES (1, 0) ON CONFLICT (id) DO NOTHING`,
+	// the labels and fields of the objects to select them by
+	`CREATE TABLE IF NOT EXISTS apimaster_attributes (
+		key TEXT COLLATE "C" NOT NULL,
+		kind SMALLINT NOT NULL,
+		attr TEXT COLLATE "C" NOT NULL,
+		value TEXT COLLATE "C" NOT NULL,
+		PRIMARY KEY (key, kind, attr)
+	)`,
+	`CREATE INDEX IF NOT EXISTS apimaster_attributes_value ON apimaster_attributes (kind, attr, value)`,
+}
+
+// Backend stores the objects of every resource in one postgres database. Every
+// write is recorded in an event table and announced with NOTIFY, which drives
+// the watches of all apimaster instances sharing the database.
+type Backend struct {
+	config   Config
+	db       *sql.DB
+	listener *pq.Listener
+
+	lock          sync.Mutex
+	watchers      map[int]*kvstore.KeyWatcher
+	nextWatcherID int
+	// dispatchedRev is the revision of the last event sent to the watchers.
+	dispatchedRev uint64
+	// progressRequested is signaled by a progress request, to poll without waiting for
+	// a notification.
+	progressRequested chan struct{}
+
+	cancel context.CancelFunc
+	done   chan struct{}
+	// background tracks the compactor.
+	background sync.WaitGroup
+}
+
+var _ kvstore.IndexedKV = &Backend{}
+var _ kvstore.TxnKV = &Backend{}
+var _ kvstore.ProgressKV = &Backend{}
+var _ kvstore.SelectReader = &Backend{}
+var _ kvstore.Compactor = &Backend{}
+var _ kvstore.ChangesReader = &Backend{}
+
+// NewBackend connects to the first reachable server of config, creates the
+// tables if needed and starts listening for new revisions.
+func NewBackend(config Config) (*Backend, error) {
+	db, dsn, err := open(config)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range schema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to create postgres schema: %v", err)
+		}
+	}
+
+	listener := pq.NewListener(dsn, 10*time.Second, time.Minute, func(event pq.ListenerEventType, err error) {
+		if err != nil {
+			klog.Warningf("postgres listener: %v", err)
+		}
+	})
+	if err := listener.Listen(notifyChannel); err != nil {
+		listener.Close()
+		db.Close()
+		return nil, fmt.Errorf("failed to listen on %s: %v", notifyChannel, err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	b := &Backend{
+		config:            config,
+		db:                db,
+		listener:          listener,
+		watchers:          map[int]*kvstore.KeyWatcher{},
+		progressRequested: make(chan struct{}, 1),
+		cancel:            cancel,
+		done:              make(chan struct{}),
+	}
+	rev, err := b.CurrentRevision(ctx)
+	if err != nil {
+		b.Close()
+		return nil, err
+	}
+	b.dispatchedRev = rev
+
+	go b.run(ctx)
+	if config.CompactionInterval > 0 {
+		b.background.Add(1)
+		go func() {
+			defer b.background.Done()
+			kvstore.RunCompactor(ctx, StorageTypePostgres, b, config.CompactionInterval, config.CompactionRetention)
+		}()
+	}
+	return b, nil
+}
+
+// open returns a connection pool to the first reachable server and its DSN.
+func open(config Config) (*sql.DB, string, error) {
+	var errs []error
+	for _, server := range config.ServerList {
+		dsn, err := config.DSN(server)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		db, err := sql.Open("postgres", dsn)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		db.SetMaxOpenConns(config.MaxOpenConns)
+		db.SetMaxIdleConns(config.MaxIdleConns)
+		db.SetConnMaxLifetime(config.ConnMaxLifetime)
+
+		ctx, cancel := context.WithTimeout(context.Background(), pingTimeout)
+		err = db.PingContext(ctx)
+		cancel()
+		if err != nil {
+			db.Close()
+			errs = append(errs, err)
+			continue
+		}
+		return db, dsn, nil
+	}
+	if len(errs) == 0 {
+		return nil, "", errors.New("no postgres server configured")
+	}
+	return nil, "", fmt.Errorf("unable to connect to postgres: %v", utilerrors.NewAggregate(errs))
+}
+
+// Close stops every watch and closes the database connections.
+func (b *Backend) Close() error {
+	b.cancel()
+	<-b.done
+	b.background.Wait()
+	return utilerrors.NewAggregate([]error{b.listener.Close(), b.db.Close()})
+}
+
+// DB returns the connection pool, e.g. to check the database or to expose its statistics.
+func (b *Backend) DB() *sql.DB {
+	return b.db
+}
+
+// CurrentRevision implements kvstore.KV.
+func (b *Backend) CurrentRevision(ctx context.Context) (uint64, error) {
+	return currentRevision(ctx, b.db)
+}
+
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}
+
+func currentRevision(ctx context.Context, q queryer) (uint64, error) {
+	var rev int64
+	// like etcd, the revision of an empty database is 1, which is a valid resourceVersion
+	if err := q.QueryRowContext(ctx, `SELECT COALESCE(MAX(revision), 1) FROM apimaster_events`).Scan(&rev); err != nil {
+		return 0, err
+	}
+	return uint64(rev), nil
+}
+
+// compactedRevision reads the revision the events have been compacted to.
+func compactedRevision(ctx context.Context, q queryer) (uint64, error) {
+	var rev int64
+	if err := q.QueryRowContext(ctx, `SELECT revision FROM apimaster_compaction WHERE id = 1`).Scan(&rev); err != nil {
+		return 0, err
+	}
+	return uint64(rev), nil
+}
+
+// Compact implements kvstore.Compactor. The compacted revision is recorded before the
+// events are removed, so watches never miss a removed event unnoticed. The event of the
+// current revision is always kept, the current revision is read from the event table.
+func (b *Backend) Compact(ctx context.Context, rev uint64) (int64, error) {
+	current, err := b.CurrentRevision(ctx)
+	if err != nil {
+		return 0, err
+	}
+	if rev >= current {
+		rev = current - 1
+	}
+	_, err = b.db.ExecContext(ctx, `UPDATE apimaster_compaction SET revision = GREATEST(revision, $1) WHERE id = 1`, int64(rev))
+	if err != nil {
+		return 0, err
+	}
+
+	var deleted int64
+	for {
+		result, err := b.db.ExecContext(ctx, `DELETE FROM apimaster_events WHERE revision IN
+			(SELECT revision FROM apimaster_events WHERE revision <= $1 ORDER BY revision LIMIT $2)`,
+			int64(rev), compactBatchSize)
+		if err != nil {
+			return deleted, err
+		}
+		n, err := result.RowsAffected()
+		if err != nil {
+			return deleted, err
+		}
+		deleted += n
+		if n < compactBatchSize {
+			return deleted, nil
+		}
+	}
+}
+
+// Get implements kvstore.KV.
+func (b *Backend) Get(ctx context.Context, key string) (*kvstore.KeyValue, error) {
+	kv := &kvstore.KeyValue{Key: key}
+	var rev int64
+	err := b.db.QueryRowContext(ctx, `SELECT value, revision FROM apimaster_kv WHERE key = $1`, key).Scan(&kv.Value, &rev)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	kv.Revision = uint64(rev)
+	return kv, nil
+}
+
+// List implements kvstore.KV.
+func (b *Backend) List(ctx context.Context, prefix, fromKey string, limit int64) ([]*kvstore.KeyValue, int64, uint64, error) {
+	return b.ListSelected(ctx, prefix, fromKey, limit, nil)
+}
+
+// ListSelected implements kvstore.SelectReader. All pages are read in one repeatable
+// read transaction, so the result is consistent with the returned revision.
+func (b *Backend) ListSelected(ctx context.Context, prefix, fromKey string, limit int64, selector kvstore.Selector) ([]*kvstore.KeyValue, int64, uint64, error) {
+	tx, err := b.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	defer tx.Rollback()
+
+	rev, err := currentRevision(ctx, tx)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+
+	pageSize := limit
+	if pageSize <= 0 {
+		pageSize = int64(b.config.ListDefaultLimit)
+	}
+	end := prefixEnd(prefix)
+	// text can not hold NUL, so a key followed by NUL means the first key after it
+	after := strings.HasSuffix(fromKey, "\x00")
+	fromKey = strings.TrimSuffix(fromKey, "\x00")
+	selection, selectionArgs := selectionClause(selector, 3)
+	args := func(fromKey string, more ...interface{}) []interface{} {
+		return append(append([]interface{}{fromKey, end}, selectionArgs...), more...)
+	}
+	limitArg := fmt.Sprintf("$%d", 3+len(selectionArgs))
+
+	var kvs []*kvstore.KeyValue
+	for {
+		query := `SELECT key, value, revision FROM apimaster_kv WHERE key >= $1 AND key < $2` + selection + ` ORDER BY key LIMIT ` + limitArg
+		if after {
+			query = `SELECT key, value, revision FROM apimaster_kv WHERE key > $1 AND key < $2` + selection + ` ORDER BY key LIMIT ` + limitArg
+		}
+		page, err := queryKeyValues(ctx, tx, query, args(fromKey, pageSize)...)
+		if err != nil {
+			return nil, 0, 0, err
+		}
+		kvs = append(kvs, page...)
+		if int64(len(page)) < pageSize {
+			return kvs, 0, rev, nil
+		}
+		fromKey, after = page[len(page)-1].Key, true
+		if limit > 0 {
+			break
+		}
+	}
+
+	var remaining int64
+	err = tx.QueryRowContext(ctx, `SELECT COUNT(*) FROM apimaster_kv WHERE key > $1 AND key < $2`+selection, args(fromKey)...).Scan(&remaining)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	return kvs, remaining, rev, nil
+}
+
+type rowsQueryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+func queryKeyValues(ctx context.Context, q rowsQueryer, query string, args ...interface{}) ([]*kvstore.KeyValue, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var kvs []*kvstore.KeyValue
+	for rows.Next() {
+		kv := &kvstore.KeyValue{}
+		var rev int64
+		if err := rows.Scan(&kv.Key, &kv.Value, &rev); err != nil {
+			return nil, err
+		}
+		kv.Revision = uint64(rev)
+		kvs = append(kvs, kv)
+	}
+	return kvs, rows.Err()
+}
+
+// Count implements kvstore.KV.
+func (b *Backend) Count(ctx context.Context, prefix string) (int64, error) {
+	var count int64
+	err := b.db.QueryRowContext(ctx, `SELECT COUNT(*) FROM apimaster_kv WHERE key >= $1 AND key < $2`,
+		prefix, prefixEnd(prefix)).Scan(&count)
+	return count, err
+}
+
+// ChangedSince implements kvstore.ChangesReader. The compacted revision is read after the
+// events, as it is raised before the events are removed.
+func (b *Backend) ChangedSince(ctx context.Context, prefix, fromKey string, rev uint64) (bool, error) {
+	var found int
+	err := b.db.QueryRowContext(ctx, `SELECT 1 FROM apimaster_events WHERE revision > $1 AND key >= $2 AND key < $3 LIMIT 1`,
+		int64(rev), fromKey, prefixEnd(prefix)).Scan(&found)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return false, err
+	}
+	compactedRev, err := compactedRevision(ctx, b.db)
+	if err != nil {
+		return false, err
+	}
+	if rev < compactedRev {
+		return false, kvstore.NewCompactedError(rev, compactedRev)
+	}
+	return found == 1, nil
+}
+
+// Commit implements kvstore.KV.
+func (b *Backend) Commit(ctx context.Context, key string, expectedRev uint64, value []byte) (uint64, bool, error) {
+	return b.CommitWithAttributes(ctx, key, expectedRev, value, nil)
+}
+
+// CommitWithAttributes implements kvstore.IndexedKV.
+func (b *Backend) CommitWithAttributes(ctx context.Context, key string, expectedRev uint64, value []byte, attrs *kvstore.Attributes) (uint64, bool, error) {
+	return b.CommitTxn(ctx, []kvstore.Op{{Key: key, ExpectedRev: expectedRev, Value: value, Attrs: attrs}})
+}
+
+// CommitTxn implements kvstore.TxnKV.
+func (b *Backend) CommitTxn(ctx context.Context, ops []kvstore.Op) (uint64, bool, error) {
+	tx, err := b.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, false, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `SELECT pg_advisory_xact_lock($1)`, writeLockID); err != nil {
+		return 0, false, err
+	}
+	rev, err := currentRevision(ctx, tx)
+	if err != nil {
+		return 0, false, err
+	}
+	for _, op := range ops {
+		rev++
+		if ok, err := commitOp(ctx, tx, rev, op); err != nil || !ok {
+			return 0, false, err
+		}
+	}
+	// notifications are delivered on commit
+	if _, err := tx.ExecContext(ctx, `SELECT pg_notify($1, $2)`, notifyChannel, strconv.FormatUint(rev, 10)); err != nil {
+		return 0, false, err
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, false, err
+	}
+	return rev, true, nil
+}
+
+// commitOp writes op at rev in tx, it returns false if the key is not at the expected revision.
+func commitOp(ctx context.Context, tx *sql.Tx, rev uint64, op kvstore.Op) (bool, error) {
+	var prevValue []byte
+	var prevRev int64
+	err := tx.QueryRowContext(ctx, `SELECT value, revision FROM apimaster_kv WHERE key = $1`, op.Key).Scan(&prevValue, &prevRev)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		if op.ExpectedRev != 0 {
+			return false, nil
+		}
+	case err != nil:
+		return false, err
+	case uint64(prevRev) != op.ExpectedRev:
+		return false, nil
+	}
+
+	if op.Value == nil {
+		_, err = tx.ExecContext(ctx, `DELETE FROM apimaster_kv WHERE key = $1`, op.Key)
+	} else {
+		_, err = tx.ExecContext(ctx, `INSERT INTO apimaster_kv (key, value, revision) VALUES ($1, $2, $3)
+			ON CONFLICT (key) DO UPDATE SET value = EXCLUDED.value, revision = EXCLUDED.revision`, op.Key, op.Value, int64(rev))
+	}
+	if err != nil {
+		return false, err
+	}
+	if err := writeAttributes(ctx, tx, op.Key, op.Attrs); err != nil {
+		return false, err
+	}
+	_, err = tx.ExecContext(ctx, `INSERT INTO apimaster_events (revision, key, value, prev_value, deleted) VALUES ($1, $2, $3, $4, $5)`,
+		int64(rev), op.Key, nullBytes(op.Value), nullBytes(prevValue), op.Value == nil)
+	return err == nil, err
+}
+
+// nullBytes returns NULL for a nil value, the driver sends a nil slice as an empty bytea,
+// which would turn the event of a created key into a modification.
+func nullBytes(value []byte) interface{} {
+	if value == nil {
+		return nil
+	}
+	return value
+}
+
+// prefixEnd returns the smallest key greater than every key with prefix.
+func prefixEnd(prefix string) string {
+	end := []byte(prefix)
+	for i := len(end) - 1; i >= 0; i-- {
+		if end[i] < 0xff {
+			end[i]++
+			return string(end[:i+1])
+		}
+	}
+	// no limit, every byte of the prefix is 0xff
+	return "\xff"
+}
@@ -0,0 +1,116 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package postgres
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+const (
+	// StorageTypePostgres is the storagebackend.Config type of the postgres storage.
+	StorageTypePostgres = "postgres"
+
+	// DefaultListDefaultLimit is the number of rows read per query when a list has no limit.
+	DefaultListDefaultLimit = 500
+
+	// DefaultCompactionInterval is the default period the event table is compacted, like
+	// the default of --etcd-compaction-interval.
+	DefaultCompactionInterval = 5 * time.Minute
+	// DefaultCompactionRetention is how long events are kept by default to resume watches from.
+	DefaultCompactionRetention = time.Hour
+)
+
+// SSLModes are the sslmode values supported by the postgres driver.
+var SSLModes = []string{"disable", "require", "verify-ca", "verify-full"}
+
+// TLSConfig is the client TLS configuration of the connections.
+type TLSConfig struct {
+	// SSLMode overrides the sslmode of every server when set.
+	SSLMode  string
+	CAFile   string
+	CertFile string
+	KeyFile  string
+}
+
+// Config is the configuration of the postgres storage.
+type Config struct {
+	// ServerList is the connection strings tried in order, either postgres:// URLs
+	// or key=value DSNs. The first reachable server is used.
+	ServerList []string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+
+	TLS TLSConfig
+
+	// CompactionInterval is the period the events older than CompactionRetention are
+	// removed, 0 disables the compaction and keeps every event.
+	CompactionInterval  time.Duration
+	CompactionRetention time.Duration
+
+	// ListDefaultLimit is the number of rows read per query when a list has no limit.
+	ListDefaultLimit int
+}
+
+// NewDefaultConfig creates a postgres config with default values.
+func NewDefaultConfig() Config {
+	return Config{
+		MaxOpenConns:        50,
+		MaxIdleConns:        10,
+		ConnMaxLifetime:     30 * time.Minute,
+		CompactionInterval:  DefaultCompactionInterval,
+		CompactionRetention: DefaultCompactionRetention,
+		ListDefaultLimit:    DefaultListDefaultLimit,
+	}
+}
+
+// DSN returns the key=value connection string of server with the TLS settings applied.
+func (c *Config) DSN(server string) (string, error) {
+	dsn := server
+	if strings.HasPrefix(server, "postgres://") || strings.HasPrefix(server, "postgresql://") {
+		var err error
+		if dsn, err = pq.ParseURL(server); err != nil {
+			return "", fmt.Errorf("invalid postgres url: %v", err)
+		}
+	}
+
+	params := []struct{ name, value string }{
+		{"sslmode", c.TLS.SSLMode},
+		{"sslrootcert", c.TLS.CAFile},
+		{"sslcert", c.TLS.CertFile},
+		{"sslkey", c.TLS.KeyFile},
+	}
+	for _, p := range params {
+		if p.value != "" {
+			// later values override earlier ones in a postgres connection string
+			dsn = strings.TrimSpace(dsn + " " + p.name + "=" + quote(p.value))
+		}
+	}
+	return dsn, nil
+}
+
+// quote quotes a connection string value, see https://www.postgresql.org/docs/current/libpq-connect.html.
+func quote(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	value = strings.ReplaceAll(value, `'`, `\'`)
+	return "'" + value + "'"
+}
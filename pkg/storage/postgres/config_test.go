@@ -0,0 +1,80 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package postgres
+
+import (
+	"testing"
+)
+
+func TestConfigDSN(t *testing.T) {
+	tests := []struct {
+		name    string
+		server  string
+		tls     TLSConfig
+		want    string
+		wantErr bool
+	}{
+		{
+			name:   "key value dsn",
+			server: "host=db user=apimaster dbname=apimaster",
+			want:   "host=db user=apimaster dbname=apimaster",
+		},
+		{
+			name:   "url",
+			server: "postgres://apimaster:secret@db:5432/apimaster",
+			want:   "dbname='apimaster' host='db' password='secret' port='5432' user='apimaster'",
+		},
+		{
+			name:   "tls overrides",
+			server: "host=db sslmode=disable",
+			tls:    TLSConfig{SSLMode: "verify-full", CAFile: "/etc/ssl/ca's.pem", CertFile: "/etc/ssl/tls.crt", KeyFile: "/etc/ssl/tls.key"},
+			want:   `host=db sslmode=disable sslmode='verify-full' sslrootcert='/etc/ssl/ca\'s.pem' sslcert='/etc/ssl/tls.crt' sslkey='/etc/ssl/tls.key'`,
+		},
+		{
+			name:    "invalid url",
+			server:  "postgres://db:port/apimaster",
+			wantErr: true,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			c := NewDefaultConfig()
+			c.TLS = tc.tls
+			got, err := c.DSN(tc.server)
+			if (err != nil) != tc.wantErr {
+				t.Fatalf("expected error %v, got %v", tc.wantErr, err)
+			}
+			if got != tc.want {
+				t.Errorf("expected %q, got %q", tc.want, got)
+			}
+		})
+	}
+}
+
+func TestPrefixEnd(t *testing.T) {
+	tests := map[string]string{
+		"/registry/namespaces/": "/registry/namespaces0",
+		"/":                     "0",
+		"a\xff":                 "b",
+	}
+	for prefix, want := range tests {
+		if got := prefixEnd(prefix); got != want {
+			t.Errorf("prefixEnd(%q): expected %q, got %q", prefix, want, got)
+		}
+	}
+}
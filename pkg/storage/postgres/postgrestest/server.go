@@ -0,0 +1,568 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package postgrestest
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgproto3/v2"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+var (
+	collateC       = regexp.MustCompile(`\s+COLLATE "C"`)
+	placeholder    = regexp.MustCompile(`\$(\d+)`)
+	greatest       = regexp.MustCompile(`\bGREATEST\(`)
+	advisoryLock   = regexp.MustCompile(`^SELECT pg_advisory_xact_lock\(\$1\)$`)
+	notifyFunction = regexp.MustCompile(`^SELECT pg_notify\(\$1, \$2\)$`)
+	listenCommand  = regexp.MustCompile(`^LISTEN "?([^"]+)"?$`)
+)
+
+// testServer serves the statements of the backend over the postgres protocol from a sqlite
+// database, there is no postgres server to test against. Besides the tables, upserts and
+// transactions it implements pg_advisory_xact_lock, pg_notify and LISTEN. The clients connect
+// with binary_parameters=yes, so every statement is parsed, bound and executed at once and
+// the byte parameters keep their type.
+type testServer struct {
+	db       *sql.DB
+	listener net.Listener
+	done     sync.WaitGroup
+
+	// writeLock is the advisory lock, it also serializes the writes outside of transactions:
+	// sqlite fails a transaction that writes after another one committed since its first read.
+	writeLock sync.Mutex
+
+	lock     sync.Mutex
+	sessions map[*testSession]bool
+	nextPID  uint32
+}
+
+// testSession is a client connection of the testServer.
+type testSession struct {
+	server  *testServer
+	conn    net.Conn
+	backend *pgproto3.Backend
+	db      *sql.Conn
+	pid     uint32
+
+	sendLock sync.Mutex
+	// listening is guarded by the server lock.
+	listening map[string]bool
+
+	inTxn          bool
+	failed         bool
+	holdsWriteLock bool
+	// pending are the notifications delivered on commit.
+	pending []*pgproto3.NotificationResponse
+
+	// the unnamed statement and portal of the extended protocol, the messages after an
+	// error are skipped up to the next Sync.
+	statement string
+	portal    *testPortal
+	skip      bool
+}
+
+type testPortal struct {
+	query  string
+	params []interface{}
+	result *testResult
+}
+
+// testResult holds the rows of a statement, columns is nil for a statement without rows.
+type testResult struct {
+	columns []string
+	rows    [][][]byte
+	tag     string
+}
+
+// NewTestServer starts a server on a new database and returns its DSN, the server
+// is stopped by the cleanup of t.
+func NewTestServer(t testing.TB) string {
+	t.Helper()
+	db, err := sql.Open("sqlite3", "file:"+filepath.Join(t.TempDir(), "apimaster.db")+"?_journal_mode=WAL&_busy_timeout=10000")
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		db.Close()
+		t.Fatal(err)
+	}
+	s := &testServer{db: db, listener: listener, sessions: map[*testSession]bool{}}
+	s.done.Add(1)
+	go s.serve()
+	t.Cleanup(func() {
+		listener.Close()
+		s.lock.Lock()
+		for session := range s.sessions {
+			session.conn.Close()
+		}
+		s.lock.Unlock()
+		s.done.Wait()
+		db.Close()
+	})
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	return fmt.Sprintf("host=%s port=%s user=apimaster dbname=apimaster sslmode=disable binary_parameters=yes", host, port)
+}
+
+func (s *testServer) serve() {
+	defer s.done.Done()
+	for {
+		conn, err := s.listener.Accept()
+		if err != nil {
+			return
+		}
+		s.done.Add(1)
+		go func() {
+			defer s.done.Done()
+			defer conn.Close()
+			s.serveConn(conn)
+		}()
+	}
+}
+
+func (s *testServer) serveConn(conn net.Conn) {
+	backend := pgproto3.NewBackend(pgproto3.NewChunkReader(conn), conn)
+	for {
+		msg, err := backend.ReceiveStartupMessage()
+		if err != nil {
+			return
+		}
+		switch msg.(type) {
+		case *pgproto3.SSLRequest:
+			if _, err := conn.Write([]byte("N")); err != nil {
+				return
+			}
+			continue
+		case *pgproto3.StartupMessage:
+		default:
+			// the queries are not canceled
+			return
+		}
+		break
+	}
+
+	db, err := s.db.Conn(context.Background())
+	if err != nil {
+		backend.Send(&pgproto3.ErrorResponse{Severity: "FATAL", Code: "08006", Message: err.Error()})
+		return
+	}
+	s.lock.Lock()
+	s.nextPID++
+	c := &testSession{server: s, conn: conn, backend: backend, db: db, pid: s.nextPID, listening: map[string]bool{}}
+	s.sessions[c] = true
+	s.lock.Unlock()
+	defer c.close()
+
+	c.send(&pgproto3.AuthenticationOk{})
+	c.send(&pgproto3.ParameterStatus{Name: "server_version", Value: "14.0"})
+	c.send(&pgproto3.ParameterStatus{Name: "client_encoding", Value: "UTF8"})
+	c.send(&pgproto3.BackendKeyData{ProcessID: c.pid})
+	c.send(&pgproto3.ReadyForQuery{TxStatus: c.txStatus()})
+	for {
+		msg, err := backend.Receive()
+		if err != nil {
+			return
+		}
+		if !c.handle(msg) {
+			return
+		}
+	}
+}
+
+// handle serves msg, it returns false when the session ends.
+func (c *testSession) handle(msg pgproto3.FrontendMessage) bool {
+	switch msg := msg.(type) {
+	case *pgproto3.Query:
+		result, err := c.exec(msg.String, nil)
+		if err != nil {
+			c.sendError(err)
+		} else {
+			c.sendResult(result, true)
+		}
+		c.send(&pgproto3.ReadyForQuery{TxStatus: c.txStatus()})
+	case *pgproto3.Parse:
+		if !c.skip {
+			c.statement = msg.Query
+			c.send(&pgproto3.ParseComplete{})
+		}
+	case *pgproto3.Bind:
+		if !c.skip {
+			c.portal = &testPortal{query: c.statement, params: bindParameters(msg)}
+			c.send(&pgproto3.BindComplete{})
+		}
+	case *pgproto3.Describe:
+		if c.skip {
+			break
+		}
+		if msg.ObjectType != 'P' || c.portal == nil {
+			c.failExtended(errors.New("only the unnamed portal can be described, connect with binary_parameters=yes"))
+			break
+		}
+		if result, ok := c.executePortal(); ok {
+			if result.columns == nil {
+				c.send(&pgproto3.NoData{})
+			} else {
+				c.send(rowDescription(result.columns))
+			}
+		}
+	case *pgproto3.Execute:
+		if c.skip {
+			break
+		}
+		if c.portal == nil {
+			c.failExtended(errors.New("no portal to execute"))
+			break
+		}
+		if result, ok := c.executePortal(); ok {
+			c.sendResult(result, false)
+			c.portal = nil
+		}
+	case *pgproto3.Sync:
+		c.skip = false
+		c.send(&pgproto3.ReadyForQuery{TxStatus: c.txStatus()})
+	case *pgproto3.Close:
+		c.send(&pgproto3.CloseComplete{})
+	case *pgproto3.Flush:
+	case *pgproto3.Terminate:
+		return false
+	default:
+		c.failExtended(fmt.Errorf("unsupported message %T", msg))
+	}
+	return true
+}
+
+// executePortal runs the statement of the portal once, it returns false after an error.
+func (c *testSession) executePortal() (*testResult, bool) {
+	if c.portal.result == nil {
+		result, err := c.exec(c.portal.query, c.portal.params)
+		if err != nil {
+			c.failExtended(err)
+			return nil, false
+		}
+		c.portal.result = result
+	}
+	return c.portal.result, true
+}
+
+func (c *testSession) failExtended(err error) {
+	c.sendError(err)
+	c.portal = nil
+	c.skip = true
+}
+
+// exec runs query with params and returns its rows.
+func (c *testSession) exec(query string, params []interface{}) (*testResult, error) {
+	ctx := context.Background()
+	query = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(query), ";"))
+	fields := strings.Fields(query)
+	verb := ""
+	if len(fields) > 0 {
+		verb = strings.ToUpper(fields[0])
+	}
+
+	if c.failed && verb != "COMMIT" && verb != "ROLLBACK" {
+		return nil, errors.New("current transaction is aborted, commands ignored until end of transaction block")
+	}
+	switch {
+	case verb == "":
+		return &testResult{}, nil
+	case verb == "BEGIN":
+		if !c.inTxn {
+			if _, err := c.db.ExecContext(ctx, "BEGIN"); err != nil {
+				return nil, err
+			}
+			c.inTxn = true
+		}
+		return &testResult{tag: "BEGIN"}, nil
+	case verb == "COMMIT":
+		if c.failed {
+			c.endTxn()
+			return &testResult{tag: "ROLLBACK"}, nil
+		}
+		if c.inTxn {
+			if _, err := c.db.ExecContext(ctx, "COMMIT"); err != nil {
+				c.endTxn()
+				return nil, err
+			}
+			pending := c.pending
+			c.pending = nil
+			c.inTxn = false
+			c.unlockWrites()
+			for _, n := range pending {
+				c.server.notify(n)
+			}
+		}
+		return &testResult{tag: "COMMIT"}, nil
+	case verb == "ROLLBACK":
+		c.endTxn()
+		return &testResult{tag: "ROLLBACK"}, nil
+	case listenCommand.MatchString(query):
+		channel := listenCommand.FindStringSubmatch(query)[1]
+		c.server.lock.Lock()
+		c.listening[channel] = true
+		c.server.lock.Unlock()
+		return &testResult{tag: "LISTEN"}, nil
+	case advisoryLock.MatchString(query):
+		// a lock outside of a transaction is released right away
+		if c.inTxn {
+			c.lockWrites()
+		}
+		return &testResult{columns: []string{"pg_advisory_xact_lock"}, rows: [][][]byte{{nil}}, tag: "SELECT 1"}, nil
+	case notifyFunction.MatchString(query):
+		channel, _ := params[0].(string)
+		payload, _ := params[1].(string)
+		n := &pgproto3.NotificationResponse{PID: c.pid, Channel: channel, Payload: payload}
+		if c.inTxn {
+			c.pending = append(c.pending, n)
+		} else {
+			c.server.notify(n)
+		}
+		return &testResult{columns: []string{"pg_notify"}, rows: [][][]byte{{nil}}, tag: "SELECT 1"}, nil
+	}
+
+	result, err := c.run(ctx, verb, fields, translate(query), params)
+	if err != nil && c.inTxn {
+		c.failed = true
+	}
+	return result, err
+}
+
+// run runs a statement of the sqlite database.
+func (c *testSession) run(ctx context.Context, verb string, fields []string, query string, params []interface{}) (*testResult, error) {
+	if verb == "SELECT" {
+		return c.query(ctx, query, params)
+	}
+
+	if c.inTxn {
+		c.lockWrites()
+	} else {
+		c.server.writeLock.Lock()
+		defer c.server.writeLock.Unlock()
+	}
+	result, err := c.db.ExecContext(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	switch verb {
+	case "INSERT":
+		return &testResult{tag: fmt.Sprintf("INSERT 0 %d", n)}, nil
+	case "UPDATE", "DELETE":
+		return &testResult{tag: fmt.Sprintf("%s %d", verb, n)}, nil
+	}
+	if len(fields) > 1 {
+		verb += " " + strings.ToUpper(fields[1])
+	}
+	return &testResult{tag: verb}, nil
+}
+
+func (c *testSession) query(ctx context.Context, query string, params []interface{}) (*testResult, error) {
+	rows, err := c.db.QueryContext(ctx, query, params...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+	result := &testResult{columns: columns}
+	for rows.Next() {
+		values := make([]interface{}, len(columns))
+		pointers := make([]interface{}, len(columns))
+		for i := range values {
+			pointers[i] = &values[i]
+		}
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, err
+		}
+		row := make([][]byte, len(columns))
+		for i, value := range values {
+			row[i] = encodeValue(value)
+		}
+		result.rows = append(result.rows, row)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	result.tag = fmt.Sprintf("SELECT %d", len(result.rows))
+	return result, nil
+}
+
+// endTxn rolls the transaction back and drops its notifications.
+func (c *testSession) endTxn() {
+	if c.inTxn {
+		c.db.ExecContext(context.Background(), "ROLLBACK")
+	}
+	c.inTxn = false
+	c.failed = false
+	c.pending = nil
+	c.unlockWrites()
+}
+
+func (c *testSession) lockWrites() {
+	if !c.holdsWriteLock {
+		c.server.writeLock.Lock()
+		c.holdsWriteLock = true
+	}
+}
+
+func (c *testSession) unlockWrites() {
+	if c.holdsWriteLock {
+		c.holdsWriteLock = false
+		c.server.writeLock.Unlock()
+	}
+}
+
+func (c *testSession) close() {
+	c.endTxn()
+	c.server.lock.Lock()
+	delete(c.server.sessions, c)
+	c.server.lock.Unlock()
+	c.db.Close()
+}
+
+// notify sends n to every session listening on its channel.
+func (s *testServer) notify(n *pgproto3.NotificationResponse) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	for session := range s.sessions {
+		if session.listening[n.Channel] {
+			session.send(n)
+		}
+	}
+}
+
+func (c *testSession) txStatus() byte {
+	switch {
+	case c.failed:
+		return 'E'
+	case c.inTxn:
+		return 'T'
+	}
+	return 'I'
+}
+
+func (c *testSession) send(msg pgproto3.BackendMessage) {
+	c.sendLock.Lock()
+	defer c.sendLock.Unlock()
+	// a failed write ends the session on the next receive
+	c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+	c.backend.Send(msg)
+}
+
+func (c *testSession) sendError(err error) {
+	c.send(&pgproto3.ErrorResponse{Severity: "ERROR", Code: "XX000", Message: err.Error()})
+}
+
+// sendResult sends the rows and the command tag of result, the row description is only
+// part of the response to a simple query.
+func (c *testSession) sendResult(result *testResult, describe bool) {
+	if result.tag == "" {
+		c.send(&pgproto3.EmptyQueryResponse{})
+		return
+	}
+	if describe && result.columns != nil {
+		c.send(rowDescription(result.columns))
+	}
+	for _, row := range result.rows {
+		c.send(&pgproto3.DataRow{Values: row})
+	}
+	c.send(&pgproto3.CommandComplete{CommandTag: []byte(result.tag)})
+}
+
+// rowDescription describes every column as text, which the driver scans into any type.
+func rowDescription(columns []string) *pgproto3.RowDescription {
+	fields := make([]pgproto3.FieldDescription, 0, len(columns))
+	for _, name := range columns {
+		fields = append(fields, pgproto3.FieldDescription{Name: []byte(name), DataTypeOID: 25, DataTypeSize: -1, TypeModifier: -1})
+	}
+	return &pgproto3.RowDescription{Fields: fields}
+}
+
+// bindParameters returns the parameters of bind, those sent in binary are bytes.
+func bindParameters(bind *pgproto3.Bind) []interface{} {
+	params := make([]interface{}, len(bind.Parameters))
+	for i, p := range bind.Parameters {
+		if p == nil {
+			continue
+		}
+		var format int16
+		switch len(bind.ParameterFormatCodes) {
+		case 0:
+		case 1:
+			format = bind.ParameterFormatCodes[0]
+		default:
+			format = bind.ParameterFormatCodes[i]
+		}
+		if format == 1 {
+			params[i] = append([]byte{}, p...)
+		} else {
+			params[i] = string(p)
+		}
+	}
+	return params
+}
+
+// translate rewrites the postgres syntax of the backend for sqlite, whose default
+// collation already compares bytes.
+func translate(query string) string {
+	query = collateC.ReplaceAllString(query, "")
+	query = placeholder.ReplaceAllString(query, "?$1")
+	return greatest.ReplaceAllString(query, "MAX(")
+}
+
+// encodeValue returns the text format of a sqlite value.
+func encodeValue(value interface{}) []byte {
+	switch v := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return append([]byte{}, v...)
+	case string:
+		return []byte(v)
+	case int64:
+		return strconv.AppendInt(nil, v, 10)
+	case float64:
+		return strconv.AppendFloat(nil, v, 'g', -1, 64)
+	case bool:
+		if v {
+			return []byte("t")
+		}
+		return []byte("f")
+	case time.Time:
+		return []byte(v.Format(time.RFC3339Nano))
+	}
+	return []byte(fmt.Sprint(value))
+}
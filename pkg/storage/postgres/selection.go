@@ -0,0 +1,143 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+)
+
+// The kinds of the rows of apimaster_attributes.
+const (
+	// attributeIndexed marks a key whose attributes are stored, the other keys are
+	// selected by every selector.
+	attributeIndexed = iota
+	attributeLabel
+	attributeField
+)
+
+// maxAttributeLength keeps the rows small enough for the btree index.
+const maxAttributeLength = 1024
+
+// attribute is a row of apimaster_attributes.
+type attribute struct {
+	kind  int
+	name  string
+	value string
+}
+
+// attributeRows returns the rows storing attrs, led by the attributeIndexed row. It
+// returns nil if attrs is nil or does not fit the table, text can not hold NUL.
+func attributeRows(attrs *kvstore.Attributes) []attribute {
+	if attrs == nil {
+		return nil
+	}
+	rows := []attribute{{kind: attributeIndexed}}
+	for name, value := range attrs.Labels {
+		rows = append(rows, attribute{kind: attributeLabel, name: name, value: value})
+	}
+	for name, value := range attrs.Fields {
+		rows = append(rows, attribute{kind: attributeField, name: name, value: value})
+	}
+	for _, row := range rows {
+		if len(row.name) > maxAttributeLength || len(row.value) > maxAttributeLength ||
+			strings.ContainsRune(row.name+row.value, 0) {
+			return nil
+		}
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].kind != rows[j].kind {
+			return rows[i].kind < rows[j].kind
+		}
+		return rows[i].name < rows[j].name
+	})
+	return rows
+}
+
+// writeAttributes replaces the attributes of key with attrs.
+func writeAttributes(ctx context.Context, tx *sql.Tx, key string, attrs *kvstore.Attributes) error {
+	if _, err := tx.ExecContext(ctx, `DELETE FROM apimaster_attributes WHERE key = $1`, key); err != nil {
+		return err
+	}
+	rows := attributeRows(attrs)
+	if len(rows) == 0 {
+		return nil
+	}
+	placeholders := make([]string, 0, len(rows))
+	args := make([]interface{}, 0, 4*len(rows))
+	for _, row := range rows {
+		n := len(args)
+		placeholders = append(placeholders, fmt.Sprintf("($%d, $%d, $%d, $%d)", n+1, n+2, n+3, n+4))
+		args = append(args, key, row.kind, row.name, row.value)
+	}
+	_, err := tx.ExecContext(ctx, `INSERT INTO apimaster_attributes (key, kind, attr, value) VALUES `+
+		strings.Join(placeholders, ", "), args...)
+	return err
+}
+
+// selectionClause returns the condition selecting the rows of apimaster_kv by selector,
+// to be appended to a WHERE clause, and its arguments, which are numbered from firstArg.
+// Keys without attributes and fields that were not stored are never excluded.
+func selectionClause(selector kvstore.Selector, firstArg int) (string, []interface{}) {
+	if len(selector) == 0 {
+		return "", nil
+	}
+	var args []interface{}
+	arg := func(value interface{}) string {
+		args = append(args, value)
+		return fmt.Sprintf("$%d", firstArg+len(args)-1)
+	}
+	exists := func(kind int, attr string) string {
+		condition := `EXISTS (SELECT 1 FROM apimaster_attributes a WHERE a.key = apimaster_kv.key AND a.kind = ` + arg(kind)
+		if attr != "" {
+			condition += ` AND a.attr = ` + arg(attr)
+		}
+		return condition
+	}
+
+	clause := ` AND (NOT ` + exists(attributeIndexed, "") + `)`
+	conditions := make([]string, 0, len(selector))
+	for _, r := range selector {
+		kind := attributeLabel
+		if r.Field {
+			kind = attributeField
+		}
+		condition := exists(kind, r.Key)
+		if len(r.Values) > 0 {
+			values := make([]string, 0, len(r.Values))
+			for _, value := range r.Values {
+				values = append(values, arg(value))
+			}
+			condition += ` AND a.value IN (` + strings.Join(values, ", ") + `)`
+		}
+		condition += `)`
+
+		switch {
+		case r.Negate:
+			condition = `NOT ` + condition
+		case r.Field:
+			condition = `(` + condition + ` OR NOT ` + exists(kind, r.Key) + `))`
+		}
+		conditions = append(conditions, condition)
+	}
+	return clause + ` OR (` + strings.Join(conditions, " AND ") + `))`, args
+}
@@ -0,0 +1,107 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package postgres
+
+import (
+	"reflect"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+)
+
+func TestAttributeRows(t *testing.T) {
+	testCases := []struct {
+		name     string
+		attrs    *kvstore.Attributes
+		expected []attribute
+	}{
+		{
+			name: "no attributes",
+		},
+		{
+			name: "labels and fields",
+			attrs: &kvstore.Attributes{
+				Labels: labels.Set{"tier": "web"},
+				Fields: fields.Set{"metadata.name": "foo", "spec.nodeName": ""},
+			},
+			expected: []attribute{
+				{kind: attributeIndexed},
+				{kind: attributeLabel, name: "tier", value: "web"},
+				{kind: attributeField, name: "metadata.name", value: "foo"},
+				{kind: attributeField, name: "spec.nodeName", value: ""},
+			},
+		},
+		{
+			name: "value with NUL",
+			attrs: &kvstore.Attributes{
+				Fields: fields.Set{"spec.description": "a\x00b"},
+			},
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			if rows := attributeRows(testcase.attrs); !reflect.DeepEqual(rows, testcase.expected) {
+				t.Errorf("expected %v, got %v", testcase.expected, rows)
+			}
+		})
+	}
+}
+
+func TestSelectionClause(t *testing.T) {
+	const exists = `EXISTS (SELECT 1 FROM apimaster_attributes a WHERE a.key = apimaster_kv.key AND a.kind = `
+
+	testCases := []struct {
+		name         string
+		selector     kvstore.Selector
+		expected     string
+		expectedArgs []interface{}
+	}{
+		{
+			name: "no selector",
+		},
+		{
+			name:     "labels",
+			selector: kvstore.Selector{{Key: "env", Values: []string{"dev", "prod"}}, {Key: "legacy", Negate: true}},
+			expected: ` AND (NOT ` + exists + `$3) OR (` + exists + `$4 AND a.attr = $5 AND a.value IN ($6, $7)) AND NOT ` +
+				exists + `$8 AND a.attr = $9)))`,
+			expectedArgs: []interface{}{attributeIndexed, attributeLabel, "env", "dev", "prod", attributeLabel, "legacy"},
+		},
+		{
+			name:     "field",
+			selector: kvstore.Selector{{Field: true, Key: "metadata.name", Values: []string{"foo"}}},
+			expected: ` AND (NOT ` + exists + `$3) OR ((` + exists + `$4 AND a.attr = $5 AND a.value IN ($6)) OR NOT ` +
+				exists + `$7 AND a.attr = $8))))`,
+			expectedArgs: []interface{}{attributeIndexed, attributeField, "metadata.name", "foo", attributeField, "metadata.name"},
+		},
+	}
+
+	for _, testcase := range testCases {
+		t.Run(testcase.name, func(t *testing.T) {
+			clause, args := selectionClause(testcase.selector, 3)
+			if clause != testcase.expected {
+				t.Errorf("expected clause\n%s\ngot\n%s", testcase.expected, clause)
+			}
+			if !reflect.DeepEqual(args, testcase.expectedArgs) {
+				t.Errorf("expected args %v, got %v", testcase.expectedArgs, args)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package postgres
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"k8s.io/klog/v2"
+
+	"github.com/seanchann/apimaster/pkg/storage/kvstore"
+)
+
+const (
+	// pollInterval bounds the delay of events whose notification was lost, e.g.
+	// while the listener reconnects.
+	pollInterval = 5 * time.Second
+)
+
+// run reads new events whenever a notification arrives and hands them to the watchers.
+func (b *Backend) run(ctx context.Context) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-b.listener.Notify:
+			// a nil notification follows a reconnect, poll in any case
+		case <-b.progressRequested:
+		case <-ticker.C:
+		}
+		if err := b.poll(ctx); err != nil && ctx.Err() == nil {
+			klog.Warningf("Failed to read postgres events: %v", err)
+		}
+	}
+}
+
+// poll dispatches every event after dispatchedRev.
+func (b *Backend) poll(ctx context.Context) error {
+	for {
+		events, err := b.events(ctx, b.db, b.dispatchedRev, "", false, int64(b.config.ListDefaultLimit))
+		if err != nil {
+			return err
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		b.lock.Lock()
+		// revisions have no gaps, unless the events were compacted before they were read
+		if events[0].Revision != b.dispatchedRev+1 {
+			b.expireWatchers(kvstore.NewCompactedError(b.dispatchedRev, events[0].Revision-1))
+		}
+		for _, e := range events {
+			for id, w := range b.watchers {
+				if !w.Add(e) {
+					delete(b.watchers, id)
+				}
+			}
+		}
+		b.dispatchedRev = events[len(events)-1].Revision
+		b.lock.Unlock()
+	}
+}
+
+// RequestProgress implements kvstore.ProgressKV. The watchers are at the revision of the last
+// dispatched event, the events committed since are read by a poll right away, so a client
+// asking again soon sees the latest revision.
+func (b *Backend) RequestProgress(ctx context.Context) error {
+	select {
+	case b.progressRequested <- struct{}{}:
+	default:
+	}
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	for _, w := range b.watchers {
+		w.RequestProgress(b.dispatchedRev)
+	}
+	return nil
+}
+
+// events returns at most limit events after rev, only those of key, or of
+// every key with the prefix key when recursive, unless key is empty.
+func (b *Backend) events(ctx context.Context, q rowsQueryer, rev uint64, key string, recursive bool, limit int64) ([]*kvstore.Event, error) {
+	query := `SELECT revision, key, value, prev_value, deleted FROM apimaster_events WHERE revision > $1`
+	args := []interface{}{int64(rev)}
+	switch {
+	case key == "":
+	case recursive:
+		query += ` AND key >= $2 AND key < $3`
+		args = append(args, key, prefixEnd(key))
+	default:
+		query += ` AND key = $2`
+		args = append(args, key)
+	}
+	query += ` ORDER BY revision`
+	if limit > 0 {
+		args = append(args, limit)
+		query += fmt.Sprintf(` LIMIT $%d`, len(args))
+	}
+
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var events []*kvstore.Event
+	for rows.Next() {
+		e := &kvstore.Event{}
+		var eventRev int64
+		if err := rows.Scan(&eventRev, &e.Key, &e.Value, &e.PrevValue, &e.Deleted); err != nil {
+			return nil, err
+		}
+		e.Revision = uint64(eventRev)
+		events = append(events, e)
+	}
+	return events, rows.Err()
+}
+
+// Watch implements kvstore.KV. The watcher is registered before its initial
+// events are read, so no event committed in between is lost.
+func (b *Backend) Watch(ctx context.Context, key string, recursive bool, rev uint64) (<-chan *kvstore.Event, error) {
+	w := kvstore.NewKeyWatcher(ctx, key, recursive)
+
+	b.lock.Lock()
+	id := b.nextWatcherID
+	b.nextWatcherID++
+	b.watchers[id] = w
+	b.lock.Unlock()
+
+	go func() {
+		defer b.removeWatcher(id)
+		w.Run(func(w *kvstore.KeyWatcher) (uint64, error) { return b.sendInitial(w, key, recursive, rev) })
+	}()
+	return w.ResultChan(), nil
+}
+
+func (b *Backend) removeWatcher(id int) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	delete(b.watchers, id)
+}
+
+// expireWatchers stops every watcher with err, it is called with the backend lock held.
+func (b *Backend) expireWatchers(err error) {
+	klog.Warningf("Stopping %d postgres watchers: %v", len(b.watchers), err)
+	for id, w := range b.watchers {
+		w.Expire(err)
+		delete(b.watchers, id)
+	}
+}
+
+// sendInitial sends the current state as created events for a zero rev, and every
+// stored event after rev otherwise. It returns the revision of the last sent event.
+func (b *Backend) sendInitial(w *kvstore.KeyWatcher, key string, recursive bool, rev uint64) (uint64, error) {
+	ctx := w.Context()
+	// events removed by a compaction after the snapshot was taken are still read
+	tx, err := b.db.BeginTx(ctx, &sql.TxOptions{Isolation: sql.LevelRepeatableRead, ReadOnly: true})
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	if rev != 0 {
+		compactedRev, err := compactedRevision(ctx, tx)
+		if err != nil {
+			return 0, err
+		}
+		if rev < compactedRev {
+			return 0, kvstore.NewCompactedError(rev, compactedRev)
+		}
+		for {
+			events, err := b.events(ctx, tx, rev, key, recursive, int64(b.config.ListDefaultLimit))
+			if err != nil || len(events) == 0 {
+				return rev, err
+			}
+			for _, e := range events {
+				if !w.Send(e) {
+					return rev, ctx.Err()
+				}
+				rev = e.Revision
+			}
+		}
+	}
+
+	rev, err = currentRevision(ctx, tx)
+	if err != nil {
+		return 0, err
+	}
+	query := `SELECT key, value, revision FROM apimaster_kv WHERE key = $1 ORDER BY revision`
+	args := []interface{}{key}
+	if recursive {
+		query = `SELECT key, value, revision FROM apimaster_kv WHERE key >= $1 AND key < $2 ORDER BY revision`
+		args = append(args, prefixEnd(key))
+	}
+	kvs, err := queryKeyValues(ctx, tx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	for _, kv := range kvs {
+		if !w.Send(&kvstore.Event{Key: kv.Key, Revision: kv.Revision, Value: kv.Value}) {
+			return rev, ctx.Err()
+		}
+	}
+	return rev, nil
+}
@@ -0,0 +1,293 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package resourcestore
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apiserver/pkg/registry/generic"
+	serverstorage "k8s.io/apiserver/pkg/server/storage"
+	"k8s.io/apiserver/pkg/storage"
+	"k8s.io/apiserver/pkg/storage/storagebackend/factory"
+)
+
+// DefaultPageSize is the number of objects read per list request.
+const DefaultPageSize = 500
+
+// Store is the storage of one resource together with the types it stores.
+type Store struct {
+	storage.Interface
+
+	Resource schema.GroupResource
+	// Kind is the internal kind of the stored objects.
+	Kind        schema.GroupVersionKind
+	Prefix      string
+	NewFunc     func() runtime.Object
+	NewListFunc func() runtime.Object
+
+	destroy factory.DestroyFunc
+}
+
+// Open creates the storage of resource from getter, the stored types are the
+// internal types registered for resource in scheme.
+func Open(getter generic.RESTOptionsGetter, scheme *runtime.Scheme, resource schema.GroupResource) (*Store, error) {
+	gvk, err := internalKind(scheme, resource)
+	if err != nil {
+		return nil, err
+	}
+	newFunc := func() runtime.Object {
+		obj, _ := scheme.New(gvk)
+		return obj
+	}
+	newListFunc := func() runtime.Object {
+		obj, _ := scheme.New(gvk.GroupVersion().WithKind(gvk.Kind + "List"))
+		return obj
+	}
+	if newListFunc() == nil {
+		return nil, fmt.Errorf("no list kind registered for %v", resource)
+	}
+
+	opts, err := getter.GetRESTOptions(resource)
+	if err != nil {
+		return nil, err
+	}
+	prefix := "/" + opts.ResourcePrefix
+	keyFunc := func(obj runtime.Object) (string, error) {
+		return Key(prefix, obj)
+	}
+	s, destroy, err := opts.Decorator(opts.StorageConfig, prefix, keyFunc, newFunc, newListFunc,
+		storage.DefaultNamespaceScopedAttr, nil, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &Store{
+		Interface:   s,
+		Resource:    resource,
+		Kind:        gvk,
+		Prefix:      prefix,
+		NewFunc:     newFunc,
+		NewListFunc: newListFunc,
+		destroy:     destroy,
+	}, nil
+}
+
+// Resources returns the resources of scheme that are stored by a registry, i.e. whose
+// internal kind has a list kind and object metadata, and are enabled in resourceConfig.
+// A nil resourceConfig enables every resource.
+func Resources(scheme *runtime.Scheme, resourceConfig *serverstorage.ResourceConfig) []schema.GroupResource {
+	resources := sets.New[schema.GroupResource]()
+	for _, gv := range scheme.PrioritizedVersionsAllGroups() {
+		for kind := range scheme.KnownTypes(gv) {
+			internal := schema.GroupVersionKind{Group: gv.Group, Version: runtime.APIVersionInternal, Kind: kind}
+			if !scheme.Recognizes(gv.WithKind(kind+"List")) || !scheme.Recognizes(internal) {
+				continue
+			}
+			obj, err := scheme.New(internal)
+			if err != nil {
+				continue
+			}
+			if _, err := meta.Accessor(obj); err != nil {
+				continue
+			}
+			plural, _ := meta.UnsafeGuessKindToResource(gv.WithKind(kind))
+			if resourceConfig != nil && !resourceConfig.ResourceEnabled(plural) {
+				continue
+			}
+			resources.Insert(plural.GroupResource())
+		}
+	}
+
+	sorted := resources.UnsortedList()
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].String() < sorted[j].String() })
+	return sorted
+}
+
+// internalKind returns the internal kind stored for resource.
+func internalKind(scheme *runtime.Scheme, resource schema.GroupResource) (schema.GroupVersionKind, error) {
+	for gvk := range scheme.AllKnownTypes() {
+		if gvk.Group != resource.Group || gvk.Version != runtime.APIVersionInternal {
+			continue
+		}
+		plural, _ := meta.UnsafeGuessKindToResource(gvk)
+		if plural.Resource == resource.Resource {
+			return gvk, nil
+		}
+	}
+	return schema.GroupVersionKind{}, fmt.Errorf("no internal kind registered for %v", resource)
+}
+
+// Destroy releases the storage.
+func (s *Store) Destroy() {
+	if s.destroy != nil {
+		s.destroy()
+	}
+}
+
+// Key returns the key of obj below prefix, as the registries build it.
+func Key(prefix string, obj runtime.Object) (string, error) {
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return "", err
+	}
+	if len(accessor.GetNamespace()) > 0 {
+		return path.Join(prefix, accessor.GetNamespace(), accessor.GetName()), nil
+	}
+	return path.Join(prefix, accessor.GetName()), nil
+}
+
+// Each calls fn for every stored object, reading them page by page.
+func (s *Store) Each(ctx context.Context, fn func(obj runtime.Object) error) error {
+	_, err := s.EachAt(ctx, "", fn)
+	return err
+}
+
+// EachAt calls fn for every object stored at resourceVersion, reading them page by page.
+// An empty resourceVersion reads the latest objects. It returns the resourceVersion the
+// objects were read at, a storage that can not read at resourceVersion fails or returns
+// another one.
+func (s *Store) EachAt(ctx context.Context, resourceVersion string, fn func(obj runtime.Object) error) (string, error) {
+	readAt := ""
+	err := s.eachPage(ctx, resourceVersion, "", func(list runtime.Object, _ string) error {
+		if len(readAt) == 0 {
+			listMeta, err := meta.ListAccessor(list)
+			if err != nil {
+				return err
+			}
+			readAt = listMeta.GetResourceVersion()
+		}
+		return meta.EachListItem(list, fn)
+	})
+	return readAt, err
+}
+
+// ResourceVersion returns the latest resourceVersion of the storage.
+func (s *Store) ResourceVersion(ctx context.Context) (string, error) {
+	list := s.NewListFunc()
+	opts := storage.ListOptions{
+		Recursive: true,
+		Predicate: storage.SelectionPredicate{Label: labels.Everything(), Field: fields.Everything(), Limit: 1},
+	}
+	if err := s.GetList(ctx, s.Prefix, opts, list); err != nil {
+		return "", err
+	}
+	listMeta, err := meta.ListAccessor(list)
+	if err != nil {
+		return "", err
+	}
+	return listMeta.GetResourceVersion(), nil
+}
+
+// eachPage calls fn for every page of stored objects, starting with the page of the list
+// continue token continueKey, together with the continue token of the next page. The
+// token is empty for the last page. A resourceVersion reads the first page at exactly
+// that resourceVersion, the next pages are read at the resourceVersion of the first one.
+func (s *Store) eachPage(ctx context.Context, resourceVersion, continueKey string, fn func(list runtime.Object, continueKey string) error) error {
+	for {
+		list := s.NewListFunc()
+		opts := storage.ListOptions{
+			Recursive: true,
+			Predicate: storage.SelectionPredicate{
+				Label:    labels.Everything(),
+				Field:    fields.Everything(),
+				Limit:    DefaultPageSize,
+				Continue: continueKey,
+			},
+		}
+		if len(resourceVersion) > 0 && len(continueKey) == 0 {
+			opts.ResourceVersion = resourceVersion
+			opts.ResourceVersionMatch = metav1.ResourceVersionMatchExact
+		}
+		if err := s.GetList(ctx, s.Prefix, opts, list); err != nil {
+			return err
+		}
+		listMeta, err := meta.ListAccessor(list)
+		if err != nil {
+			return err
+		}
+		continueKey = listMeta.GetContinue()
+		if err := fn(list, continueKey); err != nil {
+			return err
+		}
+		if continueKey == "" {
+			return nil
+		}
+	}
+}
+
+// Rewrite writes every stored object back unchanged. The storage skips objects that
+// are already stored as it would write them, so only values that are stale, e.g.
+// encrypted with a key that is no longer the write key, are replaced. It returns the
+// number of visited objects.
+func (s *Store) Rewrite(ctx context.Context) (int, error) {
+	return s.RewriteFrom(ctx, "", nil)
+}
+
+// RewriteFrom rewrites the stored objects like Rewrite does, starting with the page of the
+// list continue token continueKey. progress is called after every page with the continue
+// token of the next page, which is empty after the last one, and the number of objects
+// visited so far; an error returned by progress stops the rewrite.
+func (s *Store) RewriteFrom(ctx context.Context, continueKey string, progress func(continueKey string, count int) error) (int, error) {
+	count := 0
+	err := s.eachPage(ctx, "", continueKey, func(list runtime.Object, next string) error {
+		if err := meta.EachListItem(list, func(obj runtime.Object) error {
+			if err := s.rewrite(ctx, obj); err != nil {
+				return err
+			}
+			count++
+			return nil
+		}); err != nil {
+			return err
+		}
+		if progress == nil {
+			return nil
+		}
+		return progress(next, count)
+	})
+	return count, err
+}
+
+// rewrite writes obj back unchanged, unless it has been deleted or recreated in the meantime.
+func (s *Store) rewrite(ctx context.Context, obj runtime.Object) error {
+	key, err := Key(s.Prefix, obj)
+	if err != nil {
+		return err
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return err
+	}
+	uid := accessor.GetUID()
+	err = s.GuaranteedUpdate(ctx, key, s.NewFunc(), false, &storage.Preconditions{UID: &uid},
+		func(existing runtime.Object, _ storage.ResponseMeta) (runtime.Object, *uint64, error) {
+			return existing, nil, nil
+		}, nil)
+	// objects deleted or recreated in the meantime have been written already
+	if err != nil && !storage.IsNotFound(err) && !storage.IsInvalidObj(err) {
+		return fmt.Errorf("failed to rewrite %s: %v", key, err)
+	}
+	return nil
+}
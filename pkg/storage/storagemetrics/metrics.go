@@ -0,0 +1,133 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package storagemetrics
+
+import (
+	"database/sql"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	compbasemetrics "k8s.io/component-base/metrics"
+	"k8s.io/component-base/metrics/legacyregistry"
+	"k8s.io/klog/v2"
+)
+
+var (
+	requestLatency = compbasemetrics.NewHistogramVec(
+		&compbasemetrics.HistogramOpts{
+			Subsystem: "apimaster",
+			Name:      "storage_request_duration_seconds",
+			Help:      "Storage request latency in seconds for each operation and resource.",
+			Buckets: []float64{0.005, 0.025, 0.05, 0.1, 0.2, 0.4, 0.6, 0.8, 1.0, 1.25, 1.5, 2, 3,
+				4, 5, 6, 8, 10, 15, 20, 30, 45, 60},
+			StabilityLevel: compbasemetrics.ALPHA,
+		},
+		[]string{"operation", "resource"},
+	)
+	requestErrors = compbasemetrics.NewCounterVec(
+		&compbasemetrics.CounterOpts{
+			Subsystem:      "apimaster",
+			Name:           "storage_request_errors_total",
+			Help:           "Storage failed request counts for each operation and resource.",
+			StabilityLevel: compbasemetrics.ALPHA,
+		},
+		[]string{"operation", "resource"},
+	)
+	compactionLatency = compbasemetrics.NewHistogramVec(
+		&compbasemetrics.HistogramOpts{
+			Subsystem:      "apimaster",
+			Name:           "storage_compaction_duration_seconds",
+			Help:           "Compaction latency in seconds of the event history for each storage backend.",
+			Buckets:        []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300},
+			StabilityLevel: compbasemetrics.ALPHA,
+		},
+		[]string{"backend"},
+	)
+	compactionErrors = compbasemetrics.NewCounterVec(
+		&compbasemetrics.CounterOpts{
+			Subsystem:      "apimaster",
+			Name:           "storage_compaction_errors_total",
+			Help:           "Failed compactions of the event history for each storage backend.",
+			StabilityLevel: compbasemetrics.ALPHA,
+		},
+		[]string{"backend"},
+	)
+	compactedEvents = compbasemetrics.NewCounterVec(
+		&compbasemetrics.CounterOpts{
+			Subsystem:      "apimaster",
+			Name:           "storage_compacted_events_total",
+			Help:           "Events removed from the event history by compactions for each storage backend.",
+			StabilityLevel: compbasemetrics.ALPHA,
+		},
+		[]string{"backend"},
+	)
+	compactedRevision = compbasemetrics.NewGaugeVec(
+		&compbasemetrics.GaugeOpts{
+			Subsystem:      "apimaster",
+			Name:           "storage_compacted_revision",
+			Help:           "The revision the event history was last compacted to for each storage backend.",
+			StabilityLevel: compbasemetrics.ALPHA,
+		},
+		[]string{"backend"},
+	)
+)
+
+var registerMetrics sync.Once
+
+// Register registers the storage metrics.
+func Register() {
+	registerMetrics.Do(func() {
+		legacyregistry.MustRegister(requestLatency)
+		legacyregistry.MustRegister(requestErrors)
+		legacyregistry.MustRegister(compactionLatency)
+		legacyregistry.MustRegister(compactionErrors)
+		legacyregistry.MustRegister(compactedEvents)
+		legacyregistry.MustRegister(compactedRevision)
+	})
+}
+
+// recordRequest records the latency of a request and whether it failed. Errors
+// that are part of the storage contract, like not found, are not counted.
+func recordRequest(operation, resource string, startTime time.Time, err error) {
+	requestLatency.WithLabelValues(operation, resource).Observe(time.Since(startTime).Seconds())
+	if err != nil && !isExpectedError(err) {
+		requestErrors.WithLabelValues(operation, resource).Inc()
+	}
+}
+
+// RecordCompaction records a compaction of the event history of backend to rev, which
+// removed deleted events.
+func RecordCompaction(backend string, startTime time.Time, rev uint64, deleted int64, err error) {
+	compactionLatency.WithLabelValues(backend).Observe(time.Since(startTime).Seconds())
+	compactedEvents.WithLabelValues(backend).Add(float64(deleted))
+	if err != nil {
+		compactionErrors.WithLabelValues(backend).Inc()
+		return
+	}
+	compactedRevision.WithLabelValues(backend).Set(float64(rev))
+}
+
+// RegisterDBStats exposes the connection pool statistics of db, labeled with name.
+func RegisterDBStats(name string, db *sql.DB) {
+	err := legacyregistry.Registerer().Register(collectors.NewDBStatsCollector(db, name))
+	if err != nil && !errors.As(err, &prometheus.AlreadyRegisteredError{}) {
+		klog.Warningf("Failed to register connection pool metrics of %s: %v", name, err)
+	}
+}
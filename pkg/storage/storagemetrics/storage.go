@@ -0,0 +1,113 @@
+/********************************************************************
+* Copyright (c) 2008 - 2024. Authors: seanchann <seandev@foxmail.com>
+*
+* Licensed under the Apache License, Version 2.0 (the "License");
+* you may not use this file except in compliance with the License.
+* You may obtain a copy of the License at
+*
+*         http://www.apache.org/licenses/LICENSE-2.0
+*
+* Unless required by applicable law or agreed to in writing, software
+* distributed under the License is distributed on an "AS IS" BASIS,
+* WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+* See the License for the specific language governing permissions and
+* limitations under the License.
+*******************************************************************/
+
+package storagemetrics
+
+import (
+	"context"
+	"time"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/apiserver/pkg/storage"
+)
+
+// instrumentedStorage records the latency of every request to the wrapped storage.
+type instrumentedStorage struct {
+	delegate storage.Interface
+	resource string
+}
+
+var _ storage.Interface = &instrumentedStorage{}
+
+// Instrument returns s with its requests recorded under resource.
+func Instrument(s storage.Interface, resource string) storage.Interface {
+	return &instrumentedStorage{delegate: s, resource: resource}
+}
+
+func isExpectedError(err error) bool {
+	return storage.IsNotFound(err) || storage.IsExist(err) || storage.IsConflict(err) || storage.IsInvalidObj(err)
+}
+
+// Versioner implements storage.Interface.Versioner.
+func (s *instrumentedStorage) Versioner() storage.Versioner {
+	return s.delegate.Versioner()
+}
+
+// Create implements storage.Interface.Create.
+func (s *instrumentedStorage) Create(ctx context.Context, key string, obj, out runtime.Object, ttl uint64) error {
+	startTime := time.Now()
+	err := s.delegate.Create(ctx, key, obj, out, ttl)
+	recordRequest("create", s.resource, startTime, err)
+	return err
+}
+
+// Delete implements storage.Interface.Delete.
+func (s *instrumentedStorage) Delete(
+	ctx context.Context, key string, out runtime.Object, preconditions *storage.Preconditions,
+	validateDeletion storage.ValidateObjectFunc, cachedExistingObject runtime.Object) error {
+	startTime := time.Now()
+	err := s.delegate.Delete(ctx, key, out, preconditions, validateDeletion, cachedExistingObject)
+	recordRequest("delete", s.resource, startTime, err)
+	return err
+}
+
+// Watch implements storage.Interface.Watch, only establishing the watch is recorded.
+func (s *instrumentedStorage) Watch(ctx context.Context, key string, opts storage.ListOptions) (watch.Interface, error) {
+	startTime := time.Now()
+	w, err := s.delegate.Watch(ctx, key, opts)
+	recordRequest("watch", s.resource, startTime, err)
+	return w, err
+}
+
+// Get implements storage.Interface.Get.
+func (s *instrumentedStorage) Get(ctx context.Context, key string, opts storage.GetOptions, objPtr runtime.Object) error {
+	startTime := time.Now()
+	err := s.delegate.Get(ctx, key, opts, objPtr)
+	recordRequest("get", s.resource, startTime, err)
+	return err
+}
+
+// GetList implements storage.Interface.GetList.
+func (s *instrumentedStorage) GetList(ctx context.Context, key string, opts storage.ListOptions, listObj runtime.Object) error {
+	startTime := time.Now()
+	err := s.delegate.GetList(ctx, key, opts, listObj)
+	recordRequest("list", s.resource, startTime, err)
+	return err
+}
+
+// GuaranteedUpdate implements storage.Interface.GuaranteedUpdate.
+func (s *instrumentedStorage) GuaranteedUpdate(
+	ctx context.Context, key string, destination runtime.Object, ignoreNotFound bool,
+	preconditions *storage.Preconditions, tryUpdate storage.UpdateFunc, cachedExistingObject runtime.Object) error {
+	startTime := time.Now()
+	err := s.delegate.GuaranteedUpdate(ctx, key, destination, ignoreNotFound, preconditions, tryUpdate, cachedExistingObject)
+	recordRequest("update", s.resource, startTime, err)
+	return err
+}
+
+// Count implements storage.Interface.Count.
+func (s *instrumentedStorage) Count(key string) (int64, error) {
+	startTime := time.Now()
+	count, err := s.delegate.Count(key)
+	recordRequest("count", s.resource, startTime, err)
+	return count, err
+}
+
+// RequestWatchProgress implements storage.Interface.RequestWatchProgress.
+func (s *instrumentedStorage) RequestWatchProgress(ctx context.Context) error {
+	return s.delegate.RequestWatchProgress(ctx)
+}